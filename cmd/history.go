@@ -0,0 +1,34 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"github.com/csfreak/minio-backup-sidecar/pkg/command"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Query the upload history",
+	Long:  `Print the uploads, skips and failures recorded in --history.file, newest first, filtered by path, file, status and time.  While the sidecar runs it holds the history open, so query it with --history.server instead.`,
+	Run:   command.History,
+}
+
+func init() {
+	command.InitHistory(historyCmd)
+	rootCmd.AddCommand(historyCmd)
+}
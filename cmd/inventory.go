@@ -0,0 +1,35 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/csfreak/minio-backup-sidecar/pkg/command"
+	"github.com/spf13/cobra"
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory [path...]",
+	Short: "Write an inventory of backed up objects",
+	Long:  `List every object under the configured destination prefixes and write a CSV or JSON lines inventory object to the bucket.`,
+	Run:   command.Inventory,
+}
+
+func init() {
+	command.InitInventory(inventoryCmd)
+	rootCmd.AddCommand(inventoryCmd)
+}
@@ -0,0 +1,36 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/csfreak/minio-backup-sidecar/pkg/command"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore prefix directory",
+	Short: "Restore backed up objects to a local directory",
+	Long:  `Download every object under prefix into directory, decrypting client-side encrypted objects and applying the file mode, ownership and mtime recorded by metadata-provenance.`,
+	Args:  cobra.ExactArgs(2),
+	Run:   command.Restore,
+}
+
+func init() {
+	command.InitRestore(restoreCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
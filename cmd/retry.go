@@ -0,0 +1,35 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package cmd
+
+import (
+	"github.com/csfreak/minio-backup-sidecar/pkg/command"
+	"github.com/spf13/cobra"
+)
+
+var retryFailedCmd = &cobra.Command{
+	Use:   "retry-failed [path]",
+	Short: "Retry uploads that failed after every retry",
+	Long:  `Ask the sidecar running at --retry-failed.server to upload again every file, path and source whose upload failed after every retry, or only those of the configured path or source given.  Failed uploads are kept in --state-file across restarts, so they can be retried once the underlying problem is fixed.  Use --retry-failed.list to see them first.`,
+	Args:  cobra.MaximumNArgs(1),
+	Run:   command.RetryFailed,
+}
+
+func init() {
+	command.InitRetryFailed(retryFailedCmd)
+	rootCmd.AddCommand(retryFailedCmd)
+}
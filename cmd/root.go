@@ -19,6 +19,7 @@ package cmd
 
 import (
 	"github.com/csfreak/minio-backup-sidecar/pkg/command"
+	"github.com/csfreak/minio-backup-sidecar/pkg/version"
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
 )
@@ -28,7 +29,12 @@ var rootCmd = &cobra.Command{
 	Use:   "minio-backup [path...]",
 	Short: "Upload Files to Minio",
 	Long:  `Upload Files to Minio.  Optionally, Watch files or paths to upload on change.`,
+	Args:  cobra.ArbitraryArgs,
 	Run:   command.Run,
+
+	Version: version.Get().String(),
+
+	PersistentPreRun: command.PreRun,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
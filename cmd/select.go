@@ -0,0 +1,36 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/csfreak/minio-backup-sidecar/pkg/command"
+	"github.com/spf13/cobra"
+)
+
+var selectCmd = &cobra.Command{
+	Use:   "select prefix query",
+	Short: "Query CSV or JSON backups with S3 Select",
+	Long:  `Run an S3 Select SQL query against every object under prefix and write the matching records to stdout, without downloading whole objects. The server must support S3 Select.`,
+	Args:  cobra.ExactArgs(2),
+	Run:   command.Select,
+}
+
+func init() {
+	command.InitSelect(selectCmd)
+	rootCmd.AddCommand(selectCmd)
+}
@@ -0,0 +1,318 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package audit appends a JSON record of every backup operation to a local
+// file, rotating it by size and optionally shipping it to the bucket, as a
+// record of what was backed up and when.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// Operations recorded in the audit log.
+const (
+	OpUpload    = "upload"
+	OpSkip      = "skip"
+	OpFail      = "fail"
+	OpDelete    = "delete"
+	OpTombstone = "tombstone"
+)
+
+const (
+	fileMode     = 0o600
+	rotateFormat = "20060102T150405.000000000Z"
+)
+
+// Record is one line of the audit log.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Pod    string    `json:"pod"`
+	Path   string    `json:"path,omitempty"` // Configured path or source
+	File   string    `json:"file,omitempty"`
+	Object string    `json:"object,omitempty"`
+	Size   int64     `json:"size,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Options configures the audit log.
+type Options struct {
+	File       string // File records are appended to (Defaults to none, disabled)
+	MaxSize    int64  // Bytes after which the file is rotated (Defaults to 0, never)
+	MaxBackups int    // Rotated files kept (Defaults to 0, all)
+	Ship       bool   // Keep rotated files until they are shipped
+}
+
+var log = struct {
+	sync.Mutex
+	opts    Options
+	f       *os.File
+	size    int64
+	shipped map[string]bool // Rotated files already shipped, by base name
+}{}
+
+// Init opens the audit log configured by opts. An empty file disables it.
+func Init(opts Options) error {
+	log.Lock()
+	defer log.Unlock()
+
+	log.opts = opts
+
+	if opts.File == "" {
+		return nil
+	}
+
+	shipped, err := readShipped()
+	if err != nil {
+		return err
+	}
+
+	log.shipped = shipped
+
+	f, size, err := open()
+	if err != nil {
+		return err
+	}
+
+	log.f, log.size = f, size
+
+	return nil
+}
+
+// Enabled reports whether records are written.
+func Enabled() bool {
+	log.Lock()
+	defer log.Unlock()
+
+	return log.f != nil
+}
+
+func open() (*os.File, int64, error) {
+	f, err := os.OpenFile(log.opts.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, fileMode)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to open audit log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("unable to open audit log: %w", err)
+	}
+
+	return f, info.Size(), nil
+}
+
+// Write appends r to the audit log, setting its time and pod.
+func Write(r Record) {
+	log.Lock()
+	defer log.Unlock()
+
+	if log.f == nil {
+		return
+	}
+
+	r.Time = time.Now().UTC()
+	r.Pod = config.PodName()
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		klog.ErrorS(err, "unable to write audit record", "op", r.Op, "file", r.File)
+		return
+	}
+
+	n, err := log.f.Write(append(line, '\n'))
+	log.size += int64(n)
+
+	if err != nil {
+		klog.ErrorS(err, "unable to write audit record", "op", r.Op, "file", r.File)
+		return
+	}
+
+	if log.opts.MaxSize > 0 && log.size >= log.opts.MaxSize {
+		if err := rotate(); err != nil {
+			klog.ErrorS(err, "unable to rotate audit log")
+		}
+	}
+}
+
+// Close closes the audit log.
+func Close() error {
+	log.Lock()
+	defer log.Unlock()
+
+	if log.f == nil {
+		return nil
+	}
+
+	err := log.f.Close()
+	log.f = nil
+
+	return err
+}
+
+// rotate renames the audit log with the current time appended, opens a new
+// one and removes rotated files beyond MaxBackups. Records keep going to the
+// old file until the new one is open.
+func rotate() error {
+	rotated := log.opts.File + "." + time.Now().UTC().Format(rotateFormat)
+	if err := os.Rename(log.opts.File, rotated); err != nil {
+		return fmt.Errorf("unable to rotate audit log: %w", err)
+	}
+
+	f, size, err := open()
+	if err != nil {
+		if rerr := os.Rename(rotated, log.opts.File); rerr != nil {
+			klog.ErrorS(rerr, "unable to restore audit log", "file", rotated)
+		}
+
+		return err
+	}
+
+	if err := log.f.Close(); err != nil {
+		klog.V(2).ErrorS(err, "unable to close rotated audit log", "file", rotated)
+	}
+
+	log.f, log.size = f, size
+
+	klog.V(2).InfoS("rotated audit log", "file", rotated)
+
+	return prune()
+}
+
+// prune removes the oldest rotated files beyond MaxBackups. When shipping,
+// files not yet shipped are kept regardless.
+func prune() error {
+	if log.opts.MaxBackups <= 0 {
+		return nil
+	}
+
+	files, err := rotatedFiles()
+	if err != nil {
+		return err
+	}
+
+	excess := len(files) - log.opts.MaxBackups
+	removed := false
+
+	for _, file := range files {
+		if excess <= 0 {
+			break
+		}
+
+		name := filepath.Base(file)
+		if log.opts.Ship && !log.shipped[name] {
+			continue
+		}
+
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("unable to remove rotated audit log: %w", err)
+		}
+
+		delete(log.shipped, name)
+
+		excess--
+		removed = true
+	}
+
+	if removed && log.opts.Ship {
+		return writeShipped()
+	}
+
+	return nil
+}
+
+// rotatedFiles returns the rotated audit logs, oldest first.
+func rotatedFiles() ([]string, error) {
+	files, err := filepath.Glob(log.opts.File + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list rotated audit logs: %w", err)
+	}
+
+	files = slices.DeleteFunc(files, func(file string) bool {
+		_, err := time.Parse(rotateFormat, strings.TrimPrefix(file, log.opts.File+"."))
+		return err != nil
+	})
+
+	slices.Sort(files)
+
+	return files, nil
+}
+
+// shippedFile records which rotated files were shipped, one base name per
+// line, so they are neither shipped again nor kept past MaxBackups after a
+// restart.
+func shippedFile() string {
+	return log.opts.File + ".shipped"
+}
+
+func readShipped() (map[string]bool, error) {
+	shipped := make(map[string]bool)
+
+	data, err := os.ReadFile(shippedFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return shipped, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read shipped audit logs: %w", err)
+	}
+
+	for _, name := range strings.Fields(string(data)) {
+		shipped[name] = true
+	}
+
+	return shipped, nil
+}
+
+func writeShipped() error {
+	names := make([]string, 0, len(log.shipped))
+	for name := range log.shipped {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	data := strings.Join(names, "\n")
+	if len(names) > 0 {
+		data += "\n"
+	}
+
+	tmp := shippedFile() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), fileMode); err != nil {
+		return fmt.Errorf("unable to record shipped audit logs: %w", err)
+	}
+
+	if err := os.Rename(tmp, shippedFile()); err != nil {
+		return fmt.Errorf("unable to record shipped audit logs: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+)
+
+func TestRotateKeepsUnshipped(t *testing.T) {
+	t.Setenv("POD_NAME", "pod-0")
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	opts := Options{File: file, MaxSize: 1, MaxBackups: 1, Ship: true}
+
+	if err := Init(opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close() })
+
+	for range 3 {
+		Write(Record{Op: OpUpload, File: "a"})
+	}
+
+	log.Lock()
+	rotated, _ := rotatedFiles()
+	log.Unlock()
+
+	if len(rotated) != 3 {
+		t.Fatalf("rotated = %d files before shipping, want all 3 kept", len(rotated))
+	}
+
+	client := minio.NewFake("audit")
+	ship(context.Background(), client, "audit")
+
+	for _, file := range rotated {
+		if _, _, ok := client.Object(path.Join("audit", "pod-0", filepath.Base(file))); !ok {
+			t.Errorf("%s was not shipped", filepath.Base(file))
+		}
+	}
+
+	log.Lock()
+	kept, _ := rotatedFiles()
+	log.Unlock()
+
+	if len(kept) != 1 || kept[0] != rotated[2] {
+		t.Fatalf("kept = %v after shipping, want [%s]", kept, rotated[2])
+	}
+
+	// A restart must not ship the kept file again.
+	Close()
+
+	if err := Init(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !log.shipped[filepath.Base(rotated[2])] {
+		t.Errorf("shipped marker of %s was lost on restart", filepath.Base(rotated[2]))
+	}
+}
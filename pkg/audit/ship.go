@@ -0,0 +1,139 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"k8s.io/klog/v2"
+)
+
+const shipTimeout = time.Minute
+
+// Ship uploads the audit log to prefix/<pod>/ every interval, starting after
+// the jitter of maxJitter, until ctx is done, and once more afterwards.
+// Rotated files are uploaded once under their own name; the current file
+// replaces its previous copy each time.
+func Ship(ctx context.Context, client minio.MinioClient, prefix string, interval, maxJitter time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(config.Jitter(min(maxJitter, interval))):
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shipTimeout)
+			ship(ctx, client, prefix)
+			cancel()
+
+			return
+		case <-t.C:
+			ship(ctx, client, prefix)
+		}
+	}
+}
+
+func ship(ctx context.Context, client minio.MinioClient, prefix string) {
+	prefix = path.Join(prefix, config.PodName())
+
+	log.Lock()
+	rotated, err := rotatedFiles()
+	rotated = slices.DeleteFunc(rotated, func(file string) bool { return log.shipped[filepath.Base(file)] })
+	current, readErr := os.ReadFile(log.opts.File)
+	log.Unlock()
+
+	if err != nil {
+		klog.ErrorS(err, "unable to ship audit log")
+	}
+
+	var shipped []string
+
+	for _, file := range rotated {
+		if err := shipFile(ctx, client, path.Join(prefix, filepath.Base(file)), file); err != nil {
+			klog.ErrorS(err, "unable to ship audit log", "file", file)
+			continue
+		}
+
+		shipped = append(shipped, filepath.Base(file))
+	}
+
+	if len(shipped) > 0 {
+		if err := markShipped(shipped); err != nil {
+			klog.ErrorS(err, "unable to ship audit log")
+		}
+	}
+
+	if readErr != nil {
+		klog.ErrorS(readErr, "unable to ship audit log", "file", log.opts.File)
+		return
+	}
+
+	key := path.Join(prefix, filepath.Base(log.opts.File))
+
+	if err := client.Upload(ctx, key, bytes.NewReader(current), int64(len(current)), "application/jsonl"); err != nil {
+		klog.ErrorS(err, "unable to ship audit log", "file", log.opts.File)
+		return
+	}
+
+	klog.V(3).InfoS("shipped audit log", "object", key, "size", len(current))
+}
+
+// markShipped records names as shipped and removes any rotated files their
+// shipping left beyond MaxBackups.
+func markShipped(names []string) error {
+	log.Lock()
+	defer log.Unlock()
+
+	for _, name := range names {
+		log.shipped[name] = true
+	}
+
+	if err := writeShipped(); err != nil {
+		return err
+	}
+
+	return prune()
+}
+
+func shipFile(ctx context.Context, client minio.MinioClient, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", file, err)
+	}
+
+	return client.Upload(ctx, key, f, info.Size(), "application/jsonl")
+}
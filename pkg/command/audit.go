@@ -0,0 +1,54 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/spf13/viper"
+)
+
+const auditPrefix = internalPrefix + "audit"
+
+// startAuditShipping uploads the audit log every audit.ship-interval, when
+// set, starting after the schedule jitter. The returned function stops
+// shipping after a final upload.
+func startAuditShipping(ctx context.Context, client minio.MinioClient) func() {
+	interval := viper.GetDuration("audit.ship-interval")
+	maxJitter := time.Duration(viper.GetInt("schedule-jitter")) * time.Second
+	if interval <= 0 || !audit.Enabled() {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		audit.Ship(ctx, client, viper.GetString("audit.prefix"), interval, maxJitter)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -19,21 +19,64 @@ package command
 
 import (
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+const (
+	defaultShutdownTimeout = 25 * time.Second
+	defaultMaxRetries      = 3
+	defaultMaxConcurrency  = 4
+	defaultBreakerFailures = 5
+	defaultBreakerProbe    = 30 * time.Second
+	defaultMultipartMaxAge = 24 * time.Hour
+	defaultScheduleJitter  = 300
+	defaultClusterBurst    = 10
+	defaultListPageSize    = 1000
+	defaultPollInterval    = 10
+	defaultWaitTime        = 5
+	defaultMaxPending      = 10000
+	defaultArchiveName     = `{{ .Name }}-{{ .Time.Format "20060102T150405Z" }}`
+	defaultAuditMaxSize    = 100 << 20
+	defaultAuditMaxBackups = 5
+	tracingFlushTimeout    = 5 * time.Second
+	envPrefix              = "conf"
+)
+
+// defaultTempPatterns match the temporary files common editors and atomic writers create.
+var defaultTempPatterns = []string{"*.tmp", "*.temp", "*.swp", "*~", ".#*"}
+
 func initConfig() {
 	// Setup Viper
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "__", "-", "_"))
-	viper.SetEnvPrefix("conf")
+	viper.SetEnvPrefix(envPrefix)
 	viper.AutomaticEnv()
 	viper.AllowEmptyEnv(true)
 
 	// Setup Global Defaults
 	viper.SetDefault("watch", true)
 	viper.SetDefault("recursive", false)
+	viper.SetDefault("initial-scan", true)
 	viper.SetDefault("watch-events", []string{"Create", "Write"})
 	viper.SetDefault("delete-on-success", false)
-	viper.SetDefault("wait-time", 5)
+	viper.SetDefault("wait-time", defaultWaitTime)
+	viper.SetDefault("shutdown-timeout", defaultShutdownTimeout)
+	viper.SetDefault("temp-patterns", defaultTempPatterns)
+	viper.SetDefault("watch-mode", "inotify")
+	viper.SetDefault("poll-interval", defaultPollInterval)
+	viper.SetDefault("log-format", "text")
+	viper.SetDefault("minio.max-retries", defaultMaxRetries)
+	viper.SetDefault("minio.max-concurrency", defaultMaxConcurrency)
+	viper.SetDefault("minio.circuit-breaker.failures", defaultBreakerFailures)
+	viper.SetDefault("minio.circuit-breaker.probe-interval", defaultBreakerProbe)
+	viper.SetDefault("minio.list-page-size", defaultListPageSize)
+	viper.SetDefault("minio.multipart-max-age", defaultMultipartMaxAge)
+	viper.SetDefault("schedule-jitter", defaultScheduleJitter)
+	viper.SetDefault("archive-name", defaultArchiveName)
+	viper.SetDefault("audit.max-size", defaultAuditMaxSize)
+	viper.SetDefault("audit.max-backups", defaultAuditMaxBackups)
+	viper.SetDefault("audit.prefix", auditPrefix)
+	viper.SetDefault("minio.cluster-rate.burst", defaultClusterBurst)
+	viper.SetDefault("minio.cluster-rate.key", ".minio-backup-sidecar/rate-limit.json")
 }
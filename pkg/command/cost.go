@@ -0,0 +1,112 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+const tabPadding = 2
+
+// Cost prints estimated monthly costs per path from the state file.
+func Cost(_ *cobra.Command, _ []string) {
+	if !viper.IsSet("state-file") {
+		klog.Fatal("state-file must be set to estimate costs")
+	}
+
+	if err := state.Init(viper.GetString("state-file")); err != nil {
+		klog.Fatalf("unable to load state: %v", err)
+	}
+
+	costs := state.Costs(costPrices(), pathRetention(pathOptions().Paths))
+
+	paths := make([]string, 0, len(costs))
+	for p := range costs {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, tabPadding, ' ', 0)
+	if _, err := fmt.Fprintln(w, "PATH\tSTORED GB\tREQUESTS/MONTH\tSTORAGE\tREQUESTS\tTOTAL/MONTH"); err != nil {
+		klog.Fatalf("unable to write report: %v", err)
+	}
+
+	var total float64
+
+	for _, p := range paths {
+		c := costs[p]
+		total += c.TotalCost
+
+		if _, err := fmt.Fprintf(w, "%s\t%.3f\t%.0f\t%.2f\t%.2f\t%.2f\n", p, c.StoredGB, c.RequestsMonthly, c.StorageCost, c.RequestCost, c.TotalCost); err != nil {
+			klog.Fatalf("unable to write report: %v", err)
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "TOTAL\t\t\t\t\t%.2f\n", total); err != nil {
+		klog.Fatalf("unable to write report: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		klog.Fatalf("unable to write report: %v", err)
+	}
+}
+
+// costPrices reads the prices costs are estimated with.
+func costPrices() state.Prices {
+	return state.Prices{
+		StoragePerGBMonth: viper.GetFloat64("cost.storage-per-gb-month"),
+		Per1000Requests:   viper.GetFloat64("cost.per-1000-requests"),
+	}
+}
+
+// pathRetention returns the retention in days of every target each enabled
+// path uploads to: minio and minio.targets for paths without a profile, or
+// the target of their profile.
+func pathRetention(paths []*fs.Path) map[string][]int {
+	byProfile := make(map[string][]int)
+
+	for _, prefix := range targetPrefixes() {
+		profile := ""
+		if strings.HasPrefix(prefix, "profiles.") {
+			profile = viper.GetString(strings.TrimSuffix(prefix, ".minio") + ".name")
+		}
+
+		byProfile[profile] = append(byProfile[profile], viper.GetInt(targetKey(prefix, "retention")))
+	}
+
+	retention := make(map[string][]int, len(paths))
+
+	for _, p := range paths {
+		if p.Enabled {
+			retention[p.Path] = byProfile[p.Profile]
+		}
+	}
+
+	return retention
+}
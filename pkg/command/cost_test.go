@@ -0,0 +1,66 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/spf13/viper"
+)
+
+func TestPathRetention(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("minio.retention", 7)
+	viper.Set("minio.targets.0.endpoint", "replica:9000")
+	viper.Set("minio.targets.0.retention", 90)
+	viper.Set("minio.targets.1.bucket", "disabled")
+	viper.Set("minio.targets.1.enabled", false)
+	viper.Set("profiles.0.name", "archive")
+	viper.Set("profiles.0.minio.retention", 365)
+	viper.Set("profiles.1.name", "inherited")
+
+	paths := []*fs.Path{
+		{Path: "/data/default", Enabled: true},
+		{Path: "/data/archive", Enabled: true, Profile: "archive"},
+		{Path: "/data/inherited", Enabled: true, Profile: "inherited"},
+		{Path: "/data/disabled", Profile: "archive"},
+	}
+
+	got := pathRetention(paths)
+
+	tests := []struct {
+		path string
+		want []int
+	}{
+		{"/data/default", []int{7, 90}},
+		{"/data/archive", []int{365}},
+		{"/data/inherited", []int{7}},
+		{"/data/disabled", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if !slices.Equal(got[tt.path], tt.want) {
+				t.Errorf("retention of %s = %v, want %v", tt.path, got[tt.path], tt.want)
+			}
+		})
+	}
+}
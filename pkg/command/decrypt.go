@@ -0,0 +1,74 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// Decrypt decrypts a client-side encrypted object downloaded from the bucket.
+func Decrypt(_ *cobra.Command, args []string) {
+	if err := decrypt(args[0], args[1]); err != nil {
+		klog.Fatalf("unable to decrypt: %v", err)
+	}
+}
+
+func decrypt(input, output string) error {
+	var (
+		in  io.Reader = os.Stdin
+		out io.Writer = os.Stdout
+	)
+
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("unable to open %s: %w", input, err)
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	r, err := crypt.DecryptReader(in, viper.GetString("encryption.type"))
+	if err != nil {
+		return fmt.Errorf("unable to decrypt %s: %w", input, err)
+	}
+
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", output, err)
+		}
+		defer f.Close()
+
+		out = f
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("unable to decrypt %s: %w", input, err)
+	}
+
+	return nil
+}
@@ -20,6 +20,7 @@ package command
 import (
 	"flag"
 
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"k8s.io/klog/v2"
@@ -29,24 +30,144 @@ var klogVisibleFlags = []string{"v"}
 
 func initFlags(flags *pflag.FlagSet) error {
 	flags.AddFlagSet(initKlogFlags())
+	flags.String("log-format", "text", "Log output format (text, json)")
+	flags.String("http.address", "", "Address to serve metrics and status on (disabled if empty)")
+	flags.String("grpc.address", "", "Address to serve the gRPC control and status API on, without authentication (disabled if empty)")
+	flags.String("state-file", "", "File to persist upload state in (kept in memory if empty)")
+	flags.Float64("cost.storage-per-gb-month", 0, "Storage price per GB-month used for cost estimates")
+	flags.Float64("cost.per-1000-requests", 0, "PUT request price per 1000 requests used for cost estimates")
 
 	flags.String("minio.endpoint", "", "Hostname of Minio Endpoint")
 	flags.String("minio.access-key-id", "", "Minio Access Key ID")
 	flags.String("minio.access-key-secret", "", "Minio Access Key Secret")
+	flags.String("minio.access-key-id-file", "", "File containing the access key id, reloaded when it changes")
+	flags.String("minio.access-key-secret-file", "", "File containing the access key secret, reloaded when it changes")
 	flags.String("minio.region", "", "Minio Region")
 	flags.String("minio.bucket", "", "Minio Bucket Name")
-	flags.Int("minio.retention", 0, "Set Minio Lifecycle In Days")
+	flags.Int("minio.retention", 0, "Expire objects under each destination prefix after this many days")
+	flags.Bool("minio.create-bucket", true, "Create the bucket if it does not exist")
+	flags.Bool("minio.check-bucket", true, "Verify the bucket exists when minio.create-bucket is disabled")
+	flags.Bool("minio.versioning", false, "Enable bucket versioning so overwritten objects keep their history")
+	flags.Bool("minio.object-lock.enabled", false, "Create the bucket with object locking enabled")
+	flags.String("minio.object-lock.mode", "", "Retention mode applied to every upload (governance, compliance)")
+	flags.Duration("minio.object-lock.duration", 0, "How long uploads are retained when minio.object-lock.mode is set")
+	flags.Bool("minio.manage-lifecycle", true, "Apply minio.retention as a bucket lifecycle policy, and expire old versions of the shared rate limit object")
+	flags.String("minio.replication.arn", "", "Remote target ARN to replicate each destination prefix to (requires minio.versioning)")
+	flags.String("minio.replication.storage-class", "", "Storage class of replicated objects on the remote target")
+	flags.Bool("minio.replication.delete-markers", false, "Replicate delete markers to the remote target")
+	flags.Bool("minio.replication.existing-objects", false, "Replicate objects written before the rule was added")
 	flags.Bool("minio.secure", true, "Use SSL/TLS for Minio Client")
+	flags.Int("minio.list-page-size", defaultListPageSize, "Objects requested per listing page")
+	flags.String("minio.transport.proxy", "", "Proxy URL for MinIO requests (defaults to HTTPS_PROXY/HTTP_PROXY, none to disable)")
+	flags.Duration("minio.transport.dial-timeout", 0, "Connection timeout (defaults to 30s)")
+	flags.Duration("minio.transport.keep-alive", 0, "TCP keep-alive interval (defaults to 30s)")
+	flags.Duration("minio.transport.response-header-timeout", 0, "Time to wait for response headers (defaults to 1m)")
+	flags.Duration("minio.transport.tls-handshake-timeout", 0, "TLS handshake timeout (defaults to 10s)")
+	flags.Duration("minio.transport.idle-conn-timeout", 0, "How long idle connections are kept (defaults to 1m)")
+	flags.Int("minio.transport.max-idle-conns", 0, "Maximum idle connections (defaults to 256)")
+	flags.Int("minio.transport.max-idle-conns-per-host", 0, "Maximum idle connections per host (defaults to 16)")
+	flags.String("minio.ca-cert", "", "PEM bundle of CAs trusted in addition to the system pool")
+	flags.String("minio.client-cert", "", "PEM client certificate for mTLS")
+	flags.String("minio.client-key", "", "PEM client key for mTLS")
+	flags.Bool("minio.insecure-skip-verify", false, "Disable TLS certificate verification")
+	flags.String("minio.auth-type", "static", "Credential source (static, assume-role, iam, web-identity, chain)")
+	flags.StringSlice("minio.credential-chain", minio.DefaultCredentialChain, "Credential sources tried in order with minio.auth-type chain (env, file, iam, static)")
+	flags.String("minio.assume-role.role-arn", "", "Role to assume with the static keys")
+	flags.String("minio.assume-role.external-id", "", "External ID required by the role trust policy")
+	flags.String("minio.assume-role.session-name", "", "Session name recorded for the assumed role")
+	flags.String("minio.assume-role.sts-endpoint", "", "STS endpoint for assume-role (defaults to minio.endpoint)")
+	flags.Int("minio.assume-role.duration", 0, "Session duration in seconds (defaults to 1 hour)")
+	flags.String("minio.iam.endpoint", "", "Custom metadata endpoint for iam credentials")
+	flags.String("minio.web-identity.token-file", "", "Web identity token file (defaults to AWS_WEB_IDENTITY_TOKEN_FILE)")
+	flags.String("minio.web-identity.role-arn", "", "Role to assume with the web identity token (defaults to AWS_ROLE_ARN)")
+	flags.String("minio.web-identity.sts-endpoint", "https://sts.amazonaws.com", "STS endpoint for web identity credentials")
+	flags.String("minio.storage-class", "", "Default storage class for uploaded objects (e.g. STANDARD, REDUCED_REDUNDANCY)")
+	flags.String("minio.chunk-prefix", minio.DefaultChunkPrefix, "Prefix holding the chunks of chunked files, shared by every path and never expired by retention")
+	flags.String("minio.sse.type", "", "Server-side encryption for uploads (sse-s3, sse-kms, sse-c)")
+	flags.String("minio.sse.kms-key-id", "", "KMS key ID used with sse-kms")
+	flags.Bool("minio.sse.preflight", true, "Check the sse-kms key can be used at startup with a small probe upload")
+	flags.String("minio.sse.key-file", "", "File containing the 256 bit customer key used with sse-c")
+	flags.Duration("minio.multipart-max-age", defaultMultipartMaxAge, "Abort incomplete multipart uploads under uploaded prefixes started this long ago at startup (0 never aborts them)")
+	flags.Int("minio.max-retries", defaultMaxRetries, "Times to retry a failed upload when the error is retryable")
+	flags.Int("minio.max-concurrency", defaultMaxConcurrency, "Maximum concurrent uploads (reduced automatically when throttled)")
+	flags.Int("minio.circuit-breaker.failures", defaultBreakerFailures, "Consecutive uploads failing to reach a target before uploads to it are paused (0 disables)")
+	flags.Duration("minio.circuit-breaker.probe-interval", defaultBreakerProbe, "How often a paused target is checked, uploading pending files once it is reachable")
+	flags.Float64("minio.cluster-rate.limit", 0, "Maximum uploads per second shared by all sidecars using the bucket (0 disables)")
+	flags.Int("minio.cluster-rate.burst", defaultClusterBurst, "Uploads allowed in a burst by the shared rate limit")
+	flags.String("minio.cluster-rate.key", ".minio-backup-sidecar/rate-limit.json", "Object used to coordinate the shared rate limit (old versions are expired after a day on versioned buckets)")
+
+	flags.String("encryption.type", "", "Client-side encryption applied before upload (age, aes)")
+	flags.StringArray("encryption.age-recipients", []string{}, "age public keys to encrypt to")
+	flags.String("encryption.age-recipients-file", "", "File containing age recipients to encrypt to")
+	flags.String("encryption.age-identity-file", "", "File containing age identities used to decrypt")
+	flags.String("encryption.key-file", "", "File containing a 256 bit AES key, raw or hex encoded")
+	flags.String("encryption.hash-key-file", "", "File containing a 256 bit key naming chunks, raw or hex encoded (derived from encryption.key-file with aes)")
 
 	flags.BoolP("watch", "w", true, "Watch path for changes")
-	flags.Int("wait-time", 1, "Time (in seconds) to wait for more changes before upload")
+	flags.Duration("shutdown-timeout", defaultShutdownTimeout, "Time to upload pending changes on shutdown (keep below terminationGracePeriodSeconds)")
+	flags.String("watch-mode", "inotify", "How changes are detected (inotify for the native watcher on any platform, poll for NFS, CIFS and FUSE mounts)")
+	flags.Int("poll-interval", defaultPollInterval, "Time (in seconds) between scans when watch-mode is poll")
+	flags.Int("wait-time", defaultWaitTime, "Time (in seconds) to wait for more changes before upload (0 uploads immediately)")
+	flags.Int("wait-time-max", 0, "Longest wait (in seconds) while a file keeps changing with adaptive debounce, doubling wait-time on each change (0 uses 300)")
+	flags.String("debounce", "fixed", "How the wait adapts to changes (fixed always waits wait-time, adaptive lengthens the wait of busy files up to wait-time-max and shortens it as they go quiet)")
+	flags.Int("max-pending", defaultMaxPending, "Changed files waiting for wait-time per path; changes to others are dropped and the path rescanned once the backlog clears")
 	flags.BoolP("recursive", "r", false, "Watch directory paths recursively")
+	flags.Int("max-depth", 0, "Levels of subdirectories watched and uploaded when recursive (0 is unlimited)")
+	flags.Bool("initial-scan", true, "When watching starts, upload existing files, skipping those unchanged since their last upload recorded in state-file")
+	flags.Bool("changed-only", false, "When not watching, skip files unchanged since their last upload recorded in state-file")
 	flags.Bool("delete-on-success", false, "Delete file after upload")
+	flags.String("skip-unchanged", "", "Skip uploads when the remote object matches by size-mtime or checksum")
+	flags.Int("max-failures", 0, "Failed files tolerated before a one-shot run exits non-zero")
+	flags.Bool("fail-fast", false, "Stop a one-shot run at the first failed file and exit non-zero")
+	flags.Bool("allow-read-only", false, "Skip delete-on-success instead of failing when a path is on a read-only filesystem")
+	flags.String("archive", "", "Upload directories as one archive per run (tar, tar.gz)")
+	flags.String("archive-name", defaultArchiveName, "Template for archive object names (fields: .Name, .Time)")
+	flags.String("remote-delete", "", "Delete the object of a watched file when it is removed, or first copy it to <destination>/.trash/<time>/ (delete, trash)")
+	flags.String("tombstone-suffix", "", "Write a tombstone object named with this suffix (e.g. .deleted) when a watched file is removed")
+	flags.String("override-suffix", "", "Read name, path, type, storage-class, tags and metadata overrides of a file from the YAML file named with this suffix (e.g. .backup.yaml)")
+	flags.String("snapshot", "", "Never overwrite objects, naming every upload with a timestamp or sequence before its extension (timestamp, sequence)")
+	flags.Bool("append", false, "For files that only grow, such as logs and WAL archives, upload only the bytes appended since the last upload as numbered segments")
+	flags.Bool("rotation", false, "Upload logs in the path once logrotate rotates them (e.g. app.log.1, app.log.2.gz), named after the log and rotation time, instead of the live log on every write")
+	flags.StringArray("group", []string{}, "Upload files matching pattern as one set, once every pattern matches a file, under a run prefix marked complete with a _SUCCESS object")
+	flags.Int("priority", 0, "Uploads of paths with a higher priority take free upload slots before those waiting with a lower one")
+	flags.String("collision", "overwrite", "What to do when a file's object was uploaded from another file, e.g. with destination.name on a directory (overwrite, skip, fail, rename)")
+	flags.Bool("manifests", false, "Upload a manifest of every file in each scan or scheduled run to <destination>/_manifests/<run-id>.json")
+	flags.String("audit.file", "", "Append a JSON record of every upload, skip, failure and delete to this file")
+	flags.Int64("audit.max-size", defaultAuditMaxSize, "Bytes after which the audit log is rotated (0 never rotates)")
+	flags.Int("audit.max-backups", defaultAuditMaxBackups, "Rotated audit logs kept (0 keeps all)")
+	flags.Duration("audit.ship-interval", 0, "How often the audit log is uploaded to audit.prefix (0 disables)")
+	flags.String("audit.prefix", auditPrefix, "Prefix the audit log is uploaded under, per pod")
+	flags.String("history.file", "", "Record every upload, skip and failure in this database, queried with the history command or GET /history")
+	flags.Duration("history.retention", 0, "Age after which history records are removed (0 keeps them forever)")
+	flags.String("tracing.endpoint", "", "OTLP/HTTP endpoint (host:port or URL) to export traces of watch events and uploads to")
+	flags.Bool("tracing.insecure", false, "Export traces to a host:port endpoint over plain HTTP")
+	flags.Float64("tracing.sample-ratio", 1, "Fraction of traces exported")
+	flags.Bool("kubernetes-events", false, "Record backup failures, stale paths and unreachable targets as events on the pod (needs create on events)")
+	flags.Duration("max-staleness", 0, "Report a path as stale after this long without a successful upload (0 disables)")
+	flags.Duration("local-retention", 0, "Remove unchanged files this long after their successful upload, e.g. 168h (0 disables)")
+	flags.Bool("staleness-fails-readiness", false, "Fail /readyz while any path is stale")
+	flags.Int("dedupe-window", 0, "Time (in seconds) to skip re-uploading identical content (0 disables)")
 	flags.StringArray("path", []string{}, "Path to watch")
+	flags.String("schedule", "", "Cron schedule for full backups of each path")
+	flags.Int("schedule-jitter", defaultScheduleJitter, "Maximum delay (in seconds) added to scheduled runs and to the first poll, staleness check, local-retention sweep and audit upload, derived from the pod name")
 	flags.StringArray("watch-events", []string{"Create", "Write"}, "Events to Watch")
+	flags.StringArray("include", []string{}, "Only upload files matching pattern")
+	flags.StringArray("exclude", []string{}, "Never upload files matching pattern")
+	flags.StringArray("temp-patterns", defaultTempPatterns, "Temporary files written before a rename into place, never uploaded")
 	flags.String("destination.name", "", "Object Name in bucket")
 	flags.String("destination.path", "", "Object Path in bucket")
 	flags.String("destination.type", "", "Object MIME type")
+	flags.String("destination.storage-class", "", "Object storage class (overrides minio.storage-class)")
+	flags.StringArray("filters", []string{}, "Shell command each file is piped through before upload, such as \"gzip -9\" (repeat to chain)")
+	flags.String("destination.compression", "", "Compress objects (gzip), skipping content that is already compressed")
+	flags.Int64("destination.chunk-size", 0, "Split files of at least this many bytes into content-defined chunks of about this size, uploading only chunks not already in the bucket (0 uploads whole files)")
+	flags.Int("destination.shard-width", 0, "Insert a hash-based subprefix of this many hex characters before object names")
+	flags.String("destination.partition", "", "Append a date partition to the object path, as a Go time layout (e.g. 2006/01/02) or hourly, daily or monthly")
+	flags.String("destination.partition-by", "upload", "Time the date partition is taken from (upload, mtime)")
+	flags.StringToString("tags", map[string]string{}, "Object tags added to every upload (key=value)")
+	flags.StringToString("metadata", map[string]string{}, "User metadata added to every upload (key=value)")
+	flags.Bool("metadata-provenance", false, "Record source path, mtime, mode, ownership, pod name and content hash as user metadata")
+	flags.Bool("metadata-attributes", true, "Record mtime, mode and ownership of files and their directory as user metadata, applied by restore")
 
 	return viper.BindPFlags(flags)
 }
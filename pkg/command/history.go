@@ -0,0 +1,170 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/history"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+const historyTimeout = 30 * time.Second
+
+// InitHistory adds flags used only by the history command.
+func InitHistory(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("history.query.path", "", "Only records of this configured path or source")
+	flags.String("history.query.file", "", "Only records of this file, or of files under it when it ends with /")
+	flags.String("history.query.status", "", "Only records with this status (uploaded, skipped, failed)")
+	flags.String("history.query.since", "", "Only records after this time, as RFC 3339 or a duration before now")
+	flags.String("history.query.until", "", "Only records before this time, as RFC 3339 or a duration before now")
+	flags.Int("history.query.limit", 0, "Records returned, newest first (defaults to 100)")
+	flags.String("history.format", "table", "Output format (table, json)")
+	flags.String("history.server", "", "URL of a running sidecar to query, such as http://localhost:8080, instead of history.file")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// History prints the upload history recorded in history.file, or served by a
+// running sidecar at history.server.
+func History(cmd *cobra.Command, _ []string) {
+	v := url.Values{}
+
+	for _, key := range []string{"path", "file", "status", "since", "until"} {
+		if s := viper.GetString("history.query." + key); s != "" {
+			v.Set(key, s)
+		}
+	}
+
+	if limit := viper.GetInt("history.query.limit"); limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+
+	var (
+		records []history.Record
+		err     error
+	)
+
+	if server := viper.GetString("history.server"); server != "" {
+		records, err = remoteHistory(server, v)
+	} else {
+		records, err = localHistory(v)
+	}
+
+	if err != nil {
+		klog.Fatalf("unable to query history: %v", err)
+	}
+
+	if err := writeHistory(cmd.OutOrStdout(), viper.GetString("history.format"), records); err != nil {
+		klog.Fatalf("unable to write history: %v", err)
+	}
+}
+
+func localHistory(v url.Values) ([]history.Record, error) {
+	file := viper.GetString("history.file")
+	if file == "" {
+		return nil, fmt.Errorf("history.file or history.server is required")
+	}
+
+	q, err := history.ParseQuery(v)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := history.Open(file, true)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return s.Query(q)
+}
+
+func remoteHistory(server string, v url.Values) ([]history.Record, error) {
+	client := http.Client{Timeout: historyTimeout}
+
+	resp, err := client.Get(strings.TrimSuffix(server, "/") + "/history?" + v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var records []history.Record
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("unable to decode history: %w", err)
+	}
+
+	return records, nil
+}
+
+func writeHistory(w io.Writer, format string, records []history.Record) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		if records == nil {
+			records = []history.Record{}
+		}
+
+		return enc.Encode(records)
+	case "table":
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "TIME\tSTATUS\tFILE\tOBJECT\tSIZE\tDETAIL"); err != nil {
+		return fmt.Errorf("unable to write header: %w", err)
+	}
+
+	for _, r := range records {
+		detail := r.Reason
+		if r.Error != "" {
+			detail = r.Error
+		}
+
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", r.Time.Local().Format(time.RFC3339), r.Status, r.File, r.Object, r.Size, detail); err != nil {
+			return fmt.Errorf("unable to write %s: %w", r.File, err)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to flush: %w", err)
+	}
+
+	return nil
+}
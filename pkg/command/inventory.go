@@ -0,0 +1,187 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+var inventoryHeader = []string{"key", "size", "lastModified", "etag", "storageClass", "tags"}
+
+type inventoryRecord struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"lastModified"`
+	ETag         string            `json:"etag"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+// InitInventory adds flags used only by the inventory command.
+func InitInventory(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringArray("inventory.prefix", []string{}, "Prefix to list (defaults to the destination of each path)")
+	flags.String("inventory.format", "csv", "Inventory format (csv, jsonl)")
+	flags.String("inventory.output", "", "Write the inventory to a local file instead of the bucket")
+	flags.String("inventory.prefix-path", ".minio-backup-sidecar/inventory", "Object path for uploaded inventories")
+	flags.Bool("inventory.tags", false, "Include object tags (requires a MinIO server)")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// Inventory lists every object under the configured prefixes and writes an
+// inventory to the bucket, or to a local file when --output is set.
+func Inventory(cmd *cobra.Command, args []string) {
+	viper.Set("path", append(viper.GetStringSlice("path"), args...))
+
+	prefixes := viper.GetStringSlice("inventory.prefix")
+	if len(prefixes) == 0 {
+		f, err := newPaths()
+		if err != nil {
+			klog.Fatalf("unable to determine prefixes: %v", err)
+		}
+
+		prefixes = f.Prefixes()
+	}
+
+	client, err := newMinio(cmd.Context(), minio.Connect)
+	if err != nil {
+		klog.Fatalf("unable to initialize minio: %v", err)
+	}
+
+	format := viper.GetString("inventory.format")
+	if format != "csv" && format != "jsonl" {
+		klog.Fatalf("unknown inventory format %s", format)
+	}
+
+	tmp, err := os.CreateTemp("", "inventory-*."+format)
+	if err != nil {
+		klog.Fatalf("unable to create inventory: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	count, err := writeInventory(cmd.Context(), client, prefixes, format, tmp)
+	if err != nil {
+		klog.Fatalf("unable to create inventory: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		klog.Fatalf("unable to create inventory: %v", err)
+	}
+
+	if output := viper.GetString("inventory.output"); output != "" {
+		if err := os.Rename(tmp.Name(), output); err != nil {
+			klog.Fatalf("unable to write inventory: %v", err)
+		}
+
+		klog.InfoS("wrote inventory", "objects", count, "file", output)
+
+		return
+	}
+
+	dest := config.Destination{
+		Path: viper.GetString("inventory.prefix-path"),
+		Name: time.Now().UTC().Format("20060102T150405Z") + "." + format,
+		Type: "text/csv",
+	}
+
+	if format == "jsonl" {
+		dest.Type = "application/x-ndjson"
+	}
+
+	if err := client.UploadFileWithDestination(tmp.Name(), dest, cmd.Context()); err != nil {
+		klog.Fatalf("unable to upload inventory: %v", err)
+	}
+
+	klog.InfoS("uploaded inventory", "objects", count, "object", path.Join(dest.Path, dest.Name))
+}
+
+func writeInventory(ctx context.Context, client minio.MinioClient, prefixes []string, format string, w io.Writer) (int, error) {
+	var (
+		count  int
+		cw     = csv.NewWriter(w)
+		enc    = json.NewEncoder(w)
+		tags   = viper.GetBool("inventory.tags")
+		record func(inventoryRecord) error
+	)
+
+	switch format {
+	case "jsonl":
+		record = func(r inventoryRecord) error { return enc.Encode(r) }
+	default:
+		if err := cw.Write(inventoryHeader); err != nil {
+			return 0, fmt.Errorf("unable to write inventory: %w", err)
+		}
+
+		record = func(r inventoryRecord) error {
+			t := url.Values{}
+			for k, v := range r.Tags {
+				t.Set(k, v)
+			}
+
+			return cw.Write([]string{r.Key, strconv.FormatInt(r.Size, 10), r.LastModified.UTC().Format(time.RFC3339), r.ETag, r.StorageClass, t.Encode()})
+		}
+	}
+
+	for _, prefix := range prefixes {
+		klog.V(2).InfoS("listing prefix", "prefix", prefix)
+
+		err := client.Walk(ctx, prefix, tags, func(obj mc.ObjectInfo) error {
+			count++
+
+			return record(inventoryRecord{
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				ETag:         obj.ETag,
+				StorageClass: obj.StorageClass,
+				Tags:         obj.UserTags,
+			})
+		})
+		if err != nil {
+			return count, fmt.Errorf("unable to list %s: %w", prefix, err)
+		}
+	}
+
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		return count, fmt.Errorf("unable to write inventory: %w", err)
+	}
+
+	return count, nil
+}
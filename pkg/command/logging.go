@@ -0,0 +1,53 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/zapr"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"k8s.io/klog/v2"
+)
+
+func initLogging() error {
+	switch strings.ToLower(viper.GetString("log-format")) {
+	case "text", "":
+		return nil
+	case "json":
+		// klog performs verbosity checks before handing records to the logger,
+		// and sampling would drop upload records during bursts.
+		zc := zap.NewProductionConfig()
+		zc.Sampling = nil
+
+		zl, err := zc.Build()
+		if err != nil {
+			return fmt.Errorf("unable to create json logger: %w", err)
+		}
+
+		klog.SetLoggerWithOptions(zapr.NewLogger(zl), klog.FlushLogger(func() {
+			_ = zl.Sync()
+		}))
+
+		return nil
+	default:
+		return fmt.Errorf("unknown log-format %s", viper.GetString("log-format"))
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// targetKey returns the viper key for a connection setting of the target
+// configured under prefix, falling back to minio.
+func targetKey(prefix, name string) string {
+	return overrideKey(prefix, name, "minio."+name)
+}
+
+// targetGlobalKeys are settings outside of minio that a target can override.
+var targetGlobalKeys = []string{"metadata-provenance", "metadata-attributes"}
+
+// overrideKey returns the viper key for the setting name of the target
+// configured under prefix, falling back to global.
+func overrideKey(prefix, name, global string) string {
+	if k := prefix + "." + name; viper.IsSet(k) {
+		return k
+	}
+
+	return global
+}
+
+// minioOptions reads the options of the target configured under prefix.
+// Settings not set under prefix are taken from minio, or for
+// targetGlobalKeys from the top level, except replication.arn, since an ARN
+// is only valid on the server it was registered with.
+func minioOptions(prefix string) (minio.Options, error) {
+	key := func(name string) string { return targetKey(prefix, name) }
+	global := func(name string) string { return overrideKey(prefix, name, name) }
+
+	encryptor, err := crypt.New()
+	if err != nil {
+		return minio.Options{}, fmt.Errorf("unable to configure encryption: %w", err)
+	}
+
+	// only chunked uploads need the hash key, paths using them check it is set
+	hashKey, err := crypt.HashKey()
+	if err != nil && !errors.Is(err, crypt.ErrNoHashKey) {
+		return minio.Options{}, fmt.Errorf("unable to configure encryption: %w", err)
+	}
+
+	return minio.Options{
+		Endpoint:     viper.GetString(key("endpoint")),
+		Bucket:       viper.GetString(key("bucket")),
+		Region:       viper.GetString(key("region")),
+		Secure:       viper.GetBool(key("secure")),
+		CreateBucket: viper.GetBool(key("create-bucket")),
+		CheckBucket:  viper.GetBool(key("check-bucket")),
+		Versioning:   viper.GetBool(key("versioning")),
+		Credentials: minio.CredentialOptions{
+			Type:                viper.GetString(key("auth-type")),
+			Chain:               viper.GetStringSlice(key("credential-chain")),
+			AccessKeyID:         viper.GetString(key("access-key-id")),
+			AccessKeySecret:     viper.GetString(key("access-key-secret")),
+			AccessKeyIDFile:     viper.GetString(key("access-key-id-file")),
+			AccessKeySecretFile: viper.GetString(key("access-key-secret-file")),
+			IAMEndpoint:         viper.GetString(key("iam.endpoint")),
+			AssumeRole: minio.AssumeRoleOptions{
+				RoleARN:     viper.GetString(key("assume-role.role-arn")),
+				ExternalID:  viper.GetString(key("assume-role.external-id")),
+				SessionName: viper.GetString(key("assume-role.session-name")),
+				STSEndpoint: viper.GetString(key("assume-role.sts-endpoint")),
+				Duration:    viper.GetInt(key("assume-role.duration")),
+			},
+			WebIdentity: minio.WebIdentityOptions{
+				TokenFile:   viper.GetString(key("web-identity.token-file")),
+				RoleARN:     viper.GetString(key("web-identity.role-arn")),
+				STSEndpoint: viper.GetString(key("web-identity.sts-endpoint")),
+			},
+		},
+		TLS: minio.TLSOptions{
+			CACert:             viper.GetString(key("ca-cert")),
+			ClientCert:         viper.GetString(key("client-cert")),
+			ClientKey:          viper.GetString(key("client-key")),
+			InsecureSkipVerify: viper.GetBool(key("insecure-skip-verify")),
+		},
+		Transport: minio.TransportOptions{
+			Proxy:                 viper.GetString(key("transport.proxy")),
+			DialTimeout:           viper.GetDuration(key("transport.dial-timeout")),
+			KeepAlive:             viper.GetDuration(key("transport.keep-alive")),
+			ResponseHeaderTimeout: viper.GetDuration(key("transport.response-header-timeout")),
+			TLSHandshakeTimeout:   viper.GetDuration(key("transport.tls-handshake-timeout")),
+			IdleConnTimeout:       viper.GetDuration(key("transport.idle-conn-timeout")),
+			MaxIdleConns:          viper.GetInt(key("transport.max-idle-conns")),
+			MaxIdleConnsPerHost:   viper.GetInt(key("transport.max-idle-conns-per-host")),
+		},
+		StorageClass:    viper.GetString(key("storage-class")),
+		RetentionDays:   viper.GetInt(key("retention")),
+		ManageLifecycle: viper.GetBool(key("manage-lifecycle")),
+		ObjectLock: minio.ObjectLockOptions{
+			Enabled:  viper.GetBool(key("object-lock.enabled")),
+			Mode:     viper.GetString(key("object-lock.mode")),
+			Duration: viper.GetDuration(key("object-lock.duration")),
+		},
+		Replication: minio.ReplicationOptions{
+			ARN:             viper.GetString(prefix + ".replication.arn"),
+			StorageClass:    viper.GetString(key("replication.storage-class")),
+			DeleteMarkers:   viper.GetBool(key("replication.delete-markers")),
+			ExistingObjects: viper.GetBool(key("replication.existing-objects")),
+		},
+		SSE: minio.SSEOptions{
+			Type:      viper.GetString(key("sse.type")),
+			KMSKeyID:  viper.GetString(key("sse.kms-key-id")),
+			KeyFile:   viper.GetString(key("sse.key-file")),
+			Preflight: viper.GetBool(key("sse.preflight")),
+		},
+		Encryptor:       encryptor,
+		HashKey:         hashKey,
+		Provenance:      viper.GetBool(global("metadata-provenance")),
+		Attributes:      viper.GetBool(global("metadata-attributes")),
+		ChunkPrefix:     viper.GetString(key("chunk-prefix")),
+		MultipartMaxAge: viper.GetDuration("minio.multipart-max-age"),
+		MaxRetries:      viper.GetInt(key("max-retries")),
+		MaxConcurrency:  viper.GetInt(key("max-concurrency")),
+		ListPageSize:    viper.GetInt("minio.list-page-size"),
+		ClusterRate: minio.ClusterRateOptions{
+			Key:   viper.GetString(key("cluster-rate.key")),
+			Limit: viper.GetFloat64(key("cluster-rate.limit")),
+			Burst: viper.GetInt(key("cluster-rate.burst")),
+		},
+		CircuitBreaker: minio.CircuitBreakerOptions{
+			Failures:      viper.GetInt("minio.circuit-breaker.failures"),
+			ProbeInterval: viper.GetDuration("minio.circuit-breaker.probe-interval"),
+		},
+	}, nil
+}
+
+// newClient builds a client from options: minio.NewWithOptions for the
+// sidecar, which may configure the bucket, or minio.Connect for commands that
+// must leave it as it is.
+type newClient func(ctx context.Context, opts minio.Options) (minio.MinioClient, error)
+
+// newTarget returns a client for the target configured under prefix.
+func newTarget(ctx context.Context, prefix string, newClient newClient) (minio.MinioClient, error) {
+	opts, err := minioOptions(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(ctx, opts)
+}
+
+// newMinio returns a client for the target configured under minio.
+func newMinio(ctx context.Context, newClient newClient) (minio.MinioClient, error) {
+	return newTarget(ctx, "minio", newClient)
+}
+
+// newTargets returns a client for each additional target configured under
+// minio.targets.N, which must set at least endpoint or bucket. Connection
+// settings not set for a target are taken from minio. Targets with enabled
+// set to false are skipped.
+func newTargets(ctx context.Context, newClient newClient) ([]minio.MinioClient, error) {
+	var targets []minio.MinioClient
+
+	for i := 0; viper.IsSet(fmt.Sprintf("minio.targets.%d.endpoint", i)) || viper.IsSet(fmt.Sprintf("minio.targets.%d.bucket", i)); i++ {
+		if viper.IsSet(fmt.Sprintf("minio.targets.%d.enabled", i)) && !viper.GetBool(fmt.Sprintf("minio.targets.%d.enabled", i)) {
+			klog.InfoS("target disabled", "target", fmt.Sprintf("minio.targets.%d", i))
+			continue
+		}
+
+		t, err := newTarget(ctx, fmt.Sprintf("minio.targets.%d", i), newClient)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure minio.targets.%d: %w", i, err)
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// newReplicated returns a client uploading to minio and every target under
+// minio.targets.
+func newReplicated(ctx context.Context, newClient newClient) (minio.MinioClient, error) {
+	primary, err := newMinio(ctx, newClient)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := newTargets(ctx, newClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return minio.NewReplicated(primary, targets...), nil
+}
+
+// newProfiles returns a client for each profile configured under profiles.N,
+// keyed by profiles.N.name. Connection settings not set under
+// profiles.N.minio are taken from minio.
+func newProfiles(ctx context.Context, newClient newClient) (map[string]minio.MinioClient, error) {
+	profiles := make(map[string]minio.MinioClient)
+
+	for i := 0; viper.IsSet(fmt.Sprintf("profiles.%d.name", i)); i++ {
+		name := viper.GetString(fmt.Sprintf("profiles.%d.name", i))
+		if _, ok := profiles[name]; ok {
+			return nil, fmt.Errorf("duplicate profile %s", name)
+		}
+
+		c, err := newTarget(ctx, fmt.Sprintf("profiles.%d.minio", i), newClient)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure profile %s: %w", name, err)
+		}
+
+		klog.V(2).InfoS("configured profile", "profile", name, "target", c.Name())
+
+		profiles[name] = c
+	}
+
+	return profiles, nil
+}
+
+// abortStaleUploads aborts the stale multipart uploads of c under prefixes.
+// Failures only leave parts in the bucket, so they do not stop the sidecar.
+func abortStaleUploads(ctx context.Context, c minio.MinioClient, prefixes []string) {
+	if err := c.AbortStaleUploads(ctx, prefixes); err != nil {
+		klog.Warningf("unable to abort stale uploads to %s: %v", c.Name(), err)
+	}
+}
+
+// chunkPrefix returns the prefix holding shared chunks, ending in a slash.
+func chunkPrefix() string {
+	chunks := viper.GetString("minio.chunk-prefix")
+	if chunks == "" {
+		chunks = minio.DefaultChunkPrefix
+	}
+
+	return strings.Trim(chunks, "/") + "/"
+}
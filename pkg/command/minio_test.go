@@ -0,0 +1,69 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestMinioOptionsTargetOverrides(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("minio.sse.type", "sse-s3")
+	viper.Set("minio.max-retries", 3)
+	viper.Set("minio.cluster-rate.key", "primary")
+	viper.Set("metadata-provenance", true)
+	viper.Set("minio.targets.0.sse.type", "sse-kms")
+	viper.Set("minio.targets.0.sse.kms-key-id", "backup")
+	viper.Set("minio.targets.0.max-retries", 7)
+	viper.Set("minio.targets.0.metadata-provenance", false)
+
+	primary, err := minioOptions("minio")
+	if err != nil {
+		t.Fatalf("minioOptions(minio): %v", err)
+	}
+
+	target, err := minioOptions("minio.targets.0")
+	if err != nil {
+		t.Fatalf("minioOptions(minio.targets.0): %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"primary sse", primary.SSE.Type, "sse-s3"},
+		{"primary retries", primary.MaxRetries, 3},
+		{"primary provenance", primary.Provenance, true},
+		{"target sse", target.SSE.Type, "sse-kms"},
+		{"target kms key", target.SSE.KMSKeyID, "backup"},
+		{"target retries", target.MaxRetries, 7},
+		{"target provenance", target.Provenance, false},
+		{"inherited cluster rate key", target.ClusterRate.Key, "primary"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// pathOptions reads the paths given by path and files.N, and the settings
+// that apply to all of them. Paths that cannot be read are logged and skipped.
+func pathOptions() fs.Options {
+	opts, errs := readPathOptions()
+	for _, err := range errs {
+		klog.ErrorS(err, "error processing path")
+	}
+
+	return opts
+}
+
+// readPathOptions is pathOptions, returning the errors of skipped paths.
+func readPathOptions() (fs.Options, []error) {
+	var (
+		paths []*fs.Path
+		errs  []error
+	)
+
+	if viper.IsSet("path") {
+		for _, p := range viper.GetStringSlice("path") {
+			fsp, err := newPath(p)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("path %s: %w", p, err))
+			} else {
+				if viper.IsSet("destination.name") {
+					if fsp.Destination.Name != "" {
+						klog.Warningf("setting destination.name for directory %s may result in files being overwritten unless collision is skip, fail or rename", fsp.Path)
+					}
+
+					fsp.Destination.Name = viper.GetString("destination.name")
+				}
+
+				if viper.IsSet("destination.path") {
+					fsp.Destination.Path = viper.GetString("destination.path")
+				}
+
+				if viper.IsSet("destination.type") {
+					fsp.Destination.Type = viper.GetString("destination.type")
+				}
+
+				if viper.IsSet("destination.storage-class") {
+					fsp.Destination.StorageClass = viper.GetString("destination.storage-class")
+				}
+
+				if viper.IsSet("destination.shard-width") {
+					fsp.Destination.ShardWidth = viper.GetInt("destination.shard-width")
+				}
+
+				if viper.IsSet("destination.partition") {
+					fsp.Destination.Partition = viper.GetString("destination.partition")
+				}
+
+				if viper.IsSet("destination.partition-by") {
+					fsp.Destination.PartitionBy = viper.GetString("destination.partition-by")
+				}
+
+				if viper.IsSet("destination.compression") {
+					fsp.Destination.Compression = viper.GetString("destination.compression")
+				}
+
+				if viper.IsSet("destination.chunk-size") {
+					fsp.Destination.ChunkSize = viper.GetInt64("destination.chunk-size")
+				}
+
+				if viper.IsSet("skip-unchanged") {
+					fsp.Destination.SkipUnchanged = viper.GetString("skip-unchanged")
+				}
+
+				paths = append(paths, fsp)
+			}
+		}
+	}
+
+	for i := 0; viper.IsSet(fmt.Sprintf("files.%d.path", i)); i++ {
+		if viper.IsSet(fmt.Sprintf("files.%d.enabled", i)) && !viper.GetBool(fmt.Sprintf("files.%d.enabled", i)) {
+			klog.InfoS("path disabled", "path", viper.GetString(fmt.Sprintf("files.%d.path", i)))
+			paths = append(paths, &fs.Path{Path: viper.GetString(fmt.Sprintf("files.%d.path", i)), Events: fs.NewEvents()})
+
+			continue
+		}
+
+		fsp, err := newPath(viper.GetString(fmt.Sprintf("files.%d.path", i)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("files.%d: %w", i, err))
+		} else {
+			if viper.IsSet(fmt.Sprintf("files.%d.watch", i)) {
+				fsp.Watch = viper.GetBool(fmt.Sprintf("files.%d.watch", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.watch-mode", i)) {
+				fsp.WatchMode = viper.GetString(fmt.Sprintf("files.%d.watch-mode", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.poll-interval", i)) {
+				fsp.PollInterval = viper.GetInt(fmt.Sprintf("files.%d.poll-interval", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.wait-time", i)) {
+				fsp.WaitTime = viper.GetInt(fmt.Sprintf("files.%d.wait-time", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.wait-time-max", i)) {
+				fsp.MaxWaitTime = viper.GetInt(fmt.Sprintf("files.%d.wait-time-max", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.debounce", i)) {
+				fsp.Debounce = viper.GetString(fmt.Sprintf("files.%d.debounce", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.max-pending", i)) {
+				fsp.MaxPending = viper.GetInt(fmt.Sprintf("files.%d.max-pending", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.recursive", i)) {
+				fsp.Recursive = viper.GetBool(fmt.Sprintf("files.%d.recursive", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.max-depth", i)) {
+				fsp.MaxDepth = viper.GetInt(fmt.Sprintf("files.%d.max-depth", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.max-staleness", i)) {
+				fsp.MaxStaleness = viper.GetDuration(fmt.Sprintf("files.%d.max-staleness", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.local-retention", i)) {
+				fsp.LocalRetention = viper.GetDuration(fmt.Sprintf("files.%d.local-retention", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.initial-scan", i)) {
+				fsp.InitialScan = viper.GetBool(fmt.Sprintf("files.%d.initial-scan", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.changed-only", i)) {
+				fsp.ChangedOnly = viper.GetBool(fmt.Sprintf("files.%d.changed-only", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.events", i)) {
+				events, err := fs.ParseEvents(viper.GetStringSlice(fmt.Sprintf("files.%d.events", i)))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("files.%d: %w", i, err))
+					continue
+				}
+
+				fsp.Events = events
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.include", i)) {
+				fsp.Include = viper.GetStringSlice(fmt.Sprintf("files.%d.include", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.exclude", i)) {
+				fsp.Exclude = viper.GetStringSlice(fmt.Sprintf("files.%d.exclude", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.temp-patterns", i)) {
+				fsp.TempPatterns = viper.GetStringSlice(fmt.Sprintf("files.%d.temp-patterns", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.schedule", i)) {
+				fsp.Schedule = viper.GetString(fmt.Sprintf("files.%d.schedule", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.archive", i)) {
+				fsp.Archive = viper.GetString(fmt.Sprintf("files.%d.archive", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.archive-name", i)) {
+				fsp.ArchiveName = viper.GetString(fmt.Sprintf("files.%d.archive-name", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.profile", i)) {
+				fsp.Profile = viper.GetString(fmt.Sprintf("files.%d.profile", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.tombstone-suffix", i)) {
+				fsp.TombstoneSuffix = viper.GetString(fmt.Sprintf("files.%d.tombstone-suffix", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.remote-delete", i)) {
+				fsp.RemoteDelete = viper.GetString(fmt.Sprintf("files.%d.remote-delete", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.manifests", i)) {
+				fsp.Manifests = viper.GetBool(fmt.Sprintf("files.%d.manifests", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.override-suffix", i)) {
+				fsp.OverrideSuffix = viper.GetString(fmt.Sprintf("files.%d.override-suffix", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.snapshot", i)) {
+				fsp.Snapshot = viper.GetString(fmt.Sprintf("files.%d.snapshot", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.append", i)) {
+				fsp.Append = viper.GetBool(fmt.Sprintf("files.%d.append", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.collision", i)) {
+				fsp.Collision = viper.GetString(fmt.Sprintf("files.%d.collision", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.priority", i)) {
+				fsp.Priority = viper.GetInt(fmt.Sprintf("files.%d.priority", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.group", i)) {
+				fsp.Group = viper.GetStringSlice(fmt.Sprintf("files.%d.group", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.rotation", i)) {
+				fsp.Rotation = viper.GetBool(fmt.Sprintf("files.%d.rotation", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.dedupe-window", i)) {
+				fsp.DedupeWindow = viper.GetInt(fmt.Sprintf("files.%d.dedupe-window", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.tags", i)) {
+				tags, err := parseTags(viper.Get(fmt.Sprintf("files.%d.tags", i)))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("files.%d: %w", i, err))
+					continue
+				}
+
+				fsp.Destination.Tags = fs.MergeTags(fsp.Destination.Tags, tags)
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.metadata", i)) {
+				metadata, err := parseTags(viper.Get(fmt.Sprintf("files.%d.metadata", i)))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("files.%d: %w", i, err))
+					continue
+				}
+
+				fsp.Destination.Metadata = fs.MergeTags(fsp.Destination.Metadata, metadata)
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.delete-on-success", i)) {
+				fsp.DeleteOnSuccess = viper.GetBool(fmt.Sprintf("files.%d.delete-on-success", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.name", i)) {
+				if fsp.Destination.Name != "" {
+					klog.Warningf("setting destination.name for directory %s may result in files being overwritten unless collision is skip, fail or rename", fsp.Path)
+				}
+
+				fsp.Destination.Name = viper.GetString(fmt.Sprintf("files.%d.destination.name", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.path", i)) {
+				fsp.Destination.Path = viper.GetString(fmt.Sprintf("files.%d.destination.path", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.type", i)) {
+				fsp.Destination.Type = viper.GetString(fmt.Sprintf("files.%d.destination.type", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.storage-class", i)) {
+				fsp.Destination.StorageClass = viper.GetString(fmt.Sprintf("files.%d.destination.storage-class", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.shard-width", i)) {
+				fsp.Destination.ShardWidth = viper.GetInt(fmt.Sprintf("files.%d.destination.shard-width", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.partition", i)) {
+				fsp.Destination.Partition = viper.GetString(fmt.Sprintf("files.%d.destination.partition", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.partition-by", i)) {
+				fsp.Destination.PartitionBy = viper.GetString(fmt.Sprintf("files.%d.destination.partition-by", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.compression", i)) {
+				fsp.Destination.Compression = viper.GetString(fmt.Sprintf("files.%d.destination.compression", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.destination.chunk-size", i)) {
+				fsp.Destination.ChunkSize = viper.GetInt64(fmt.Sprintf("files.%d.destination.chunk-size", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.skip-unchanged", i)) {
+				fsp.Destination.SkipUnchanged = viper.GetString(fmt.Sprintf("files.%d.skip-unchanged", i))
+			}
+
+			if viper.IsSet(fmt.Sprintf("files.%d.filters", i)) {
+				fsp.Destination.Filters = commandList(fmt.Sprintf("files.%d.filters", i))
+			}
+
+			paths = append(paths, fsp)
+		}
+	}
+
+	return fs.Options{
+		Paths:           paths,
+		ShutdownTimeout: viper.GetDuration("shutdown-timeout"),
+		ScheduleJitter:  viper.GetInt("schedule-jitter"),
+		AllowReadOnly:   viper.GetBool("allow-read-only"),
+		FailFast:        viper.GetBool("fail-fast"),
+	}, errs
+}
+
+// newPaths returns the configured paths.
+func newPaths() (*fs.Config, error) {
+	return fs.NewWithConfig(pathOptions())
+}
+
+// newPath returns the path p with the settings given for all paths.
+func newPath(p string) (*fs.Path, error) {
+	fsp, err := fs.NewPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := fs.ParseEvents(viper.GetStringSlice("watch-events"))
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := parseTags(viper.Get("tags"))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := parseTags(viper.Get("metadata"))
+	if err != nil {
+		return nil, err
+	}
+
+	fsp.Watch = viper.GetBool("watch")
+	fsp.WatchMode = viper.GetString("watch-mode")
+	fsp.PollInterval = viper.GetInt("poll-interval")
+	fsp.WaitTime = viper.GetInt("wait-time")
+	fsp.MaxWaitTime = viper.GetInt("wait-time-max")
+	fsp.Debounce = viper.GetString("debounce")
+	fsp.MaxPending = viper.GetInt("max-pending")
+	fsp.Recursive = viper.GetBool("recursive")
+	fsp.MaxDepth = viper.GetInt("max-depth")
+	fsp.InitialScan = viper.GetBool("initial-scan")
+	fsp.ChangedOnly = viper.GetBool("changed-only")
+	fsp.DeleteOnSuccess = viper.GetBool("delete-on-success")
+	fsp.Events = events
+	fsp.DedupeWindow = viper.GetInt("dedupe-window")
+	fsp.MaxStaleness = viper.GetDuration("max-staleness")
+	fsp.LocalRetention = viper.GetDuration("local-retention")
+	fsp.Schedule = viper.GetString("schedule")
+	fsp.Archive = viper.GetString("archive")
+	fsp.ArchiveName = viper.GetString("archive-name")
+	fsp.TombstoneSuffix = viper.GetString("tombstone-suffix")
+	fsp.RemoteDelete = viper.GetString("remote-delete")
+	fsp.Manifests = viper.GetBool("manifests")
+	fsp.Snapshot = viper.GetString("snapshot")
+	fsp.Append = viper.GetBool("append")
+	fsp.Collision = viper.GetString("collision")
+	fsp.Priority = viper.GetInt("priority")
+	fsp.Group = viper.GetStringSlice("group")
+	fsp.Rotation = viper.GetBool("rotation")
+	fsp.OverrideSuffix = viper.GetString("override-suffix")
+	fsp.Include = viper.GetStringSlice("include")
+	fsp.Exclude = viper.GetStringSlice("exclude")
+	fsp.TempPatterns = viper.GetStringSlice("temp-patterns")
+	fsp.Destination.Tags = tags
+	fsp.Destination.Metadata = metadata
+	fsp.Destination.Filters = commandList("filters")
+
+	return fsp, nil
+}
+
+// commandList reads a list of shell commands. A single string, which is how
+// they arrive from environment variables, is one command rather than being
+// split on spaces.
+func commandList(key string) []string {
+	if s, ok := viper.Get(key).(string); ok {
+		if strings.TrimSpace(s) == "" {
+			return nil
+		}
+
+		return []string{s}
+	}
+
+	return viper.GetStringSlice(key)
+}
+
+// parseTags accepts tags or metadata either as a map or as a
+// key=value,key=value string, which is how they arrive from environment variables.
+func parseTags(v any) (map[string]string, error) {
+	s, ok := v.(string)
+	if !ok {
+		t, err := cast.ToStringMapStringE(v)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse tags: %w", err)
+		}
+
+		return t, nil
+	}
+
+	s = strings.Trim(s, "[]")
+	t := map[string]string{}
+
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("unable to parse tag %q, expected key=value", pair)
+		}
+
+		t[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return t, nil
+}
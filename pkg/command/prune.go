@@ -0,0 +1,266 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// internalPrefix holds objects written by the sidecar itself, which are never pruned.
+const internalPrefix = minio.InternalPrefix
+
+// InitPrune adds flags used only by the prune command.
+func InitPrune(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringArray("prune.prefix", []string{}, "Prefix to prune (defaults to the destination of each path)")
+	flags.Duration("prune.older-than", 0, "Remove objects last modified longer ago than this")
+	flags.Bool("prune.dry-run", false, "List objects that would be removed without removing them")
+	flags.Bool("prune.chunks", false, "Also remove chunks older than prune.older-than that no chunk index lists")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// Prune removes objects under the configured prefixes that are older than
+// prune.older-than, streaming the listing into bulk delete requests. With
+// prune.chunks it then removes the chunks no remaining index lists.
+func Prune(cmd *cobra.Command, args []string) {
+	viper.Set("path", append(viper.GetStringSlice("path"), args...))
+
+	olderThan := viper.GetDuration("prune.older-than")
+	if olderThan <= 0 {
+		klog.Fatal("prune.older-than must be set")
+	}
+
+	// locked objects cannot be removed before their retention ends
+	if lock := viper.GetDuration("minio.object-lock.duration"); viper.GetString("minio.object-lock.mode") != "" && olderThan < lock {
+		klog.Fatalf("prune.older-than must be at least minio.object-lock.duration (%s)", lock)
+	}
+
+	// noncurrent versions of chunk indexes are not read and may list any chunk
+	if viper.GetBool("prune.chunks") && viper.GetBool("minio.versioning") {
+		klog.Fatal("prune.chunks cannot be used with minio.versioning")
+	}
+
+	prefixes := viper.GetStringSlice("prune.prefix")
+	explicit := len(prefixes) > 0
+
+	if !explicit {
+		f, err := newPaths()
+		if err != nil {
+			klog.Fatalf("unable to determine prefixes: %v", err)
+		}
+
+		prefixes = f.Prefixes()
+	}
+
+	prefixes, err := prunePrefixes(prefixes, explicit)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	client, err := newReplicated(cmd.Context(), minio.Connect)
+	if err != nil {
+		klog.Fatalf("unable to initialize minio: %v", err)
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+
+	for _, prefix := range prefixes {
+		removed, err := prunePrefix(cmd.Context(), client, prefix, cutoff)
+		if err != nil {
+			klog.Fatalf("unable to prune %s: %v", prefix, err)
+		}
+
+		klog.InfoS("pruned prefix", "prefix", prefix, "removed", removed, "dry-run", viper.GetBool("prune.dry-run"))
+	}
+
+	if viper.GetBool("prune.chunks") {
+		removed, err := pruneChunks(cmd.Context(), client, cutoff)
+		if err != nil {
+			klog.Fatalf("unable to prune chunks: %v", err)
+		}
+
+		klog.InfoS("pruned chunks", "removed", removed, "dry-run", viper.GetBool("prune.dry-run"))
+	}
+}
+
+// prunePrefixes returns prefixes as directories, ending in a slash, so a
+// prefix does not also match its siblings. An empty prefix would prune the
+// whole bucket and is refused.
+func prunePrefixes(prefixes []string, explicit bool) ([]string, error) {
+	dirs := make([]string, 0, len(prefixes))
+
+	for _, prefix := range prefixes {
+		dir := strings.Trim(prefix, "/")
+
+		switch {
+		case dir == "" && explicit:
+			return nil, fmt.Errorf("prune.prefix %q would prune the whole bucket", prefix)
+		case dir == "":
+			return nil, errors.New("a path uploads to the root of the bucket, set prune.prefix to choose what to prune")
+		}
+
+		dirs = append(dirs, dir+"/")
+	}
+
+	return dirs, nil
+}
+
+func prunePrefix(ctx context.Context, client minio.MinioClient, prefix string, cutoff time.Time) (int, error) {
+	return removeWhere(ctx, client, prefix, func(obj mc.ObjectInfo) bool {
+		return obj.LastModified.Before(cutoff) && !protectedKey(obj.Key)
+	})
+}
+
+// pruneChunks removes the chunks last modified before cutoff that no chunk
+// index in the bucket lists, marking the chunks of every index before
+// sweeping the chunk prefix. An upload reusing a chunk while it is swept
+// leaves an index missing that chunk, which verify reports, so chunks are
+// best pruned while chunked files are not being uploaded.
+func pruneChunks(ctx context.Context, client minio.MinioClient, cutoff time.Time) (int, error) {
+	chunks := chunkPrefix()
+	listed := make(map[string]bool)
+
+	var indexes []string
+
+	err := client.Walk(ctx, "", true, func(obj mc.ObjectInfo) error {
+		key := strings.TrimPrefix(obj.Key, "/")
+		if !strings.HasPrefix(key, chunks) && minio.ListedMetadata(obj.UserMetadata, minio.MetadataChunkSize) != "" {
+			indexes = append(indexes, obj.Key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to find chunk indexes: %w", err)
+	}
+
+	for _, key := range indexes {
+		index, err := readChunkIndex(ctx, client, key)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, chunk := range index.Chunks {
+			listed[strings.TrimPrefix(chunk.Key, "/")] = true
+		}
+	}
+
+	klog.V(2).InfoS("marked chunks", "indexes", len(indexes), "chunks", len(listed))
+
+	return removeWhere(ctx, client, chunks, func(obj mc.ObjectInfo) bool {
+		return obj.LastModified.Before(cutoff) && !listed[strings.TrimPrefix(obj.Key, "/")]
+	})
+}
+
+// readChunkIndex downloads and decodes the chunk index at key.
+func readChunkIndex(ctx context.Context, client minio.MinioClient, key string) (*minio.ChunkIndex, error) {
+	obj, info, err := client.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	r, closeIndex, err := decodeObject(key, obj, info.UserMetadata)
+	if err != nil {
+		return nil, err
+	}
+	defer closeIndex()
+
+	index, err := minio.ReadChunkIndex(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", key, err)
+	}
+
+	return index, nil
+}
+
+// removeWhere removes the objects under prefix that remove selects,
+// streaming the listing into bulk delete requests, or only counts them on a
+// dry run.
+func removeWhere(ctx context.Context, client minio.MinioClient, prefix string, remove func(mc.ObjectInfo) bool) (int, error) {
+	dryRun := viper.GetBool("prune.dry-run")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys := make(chan string)
+	walkErr := make(chan error, 1)
+
+	go func() {
+		defer close(keys)
+
+		walkErr <- client.Walk(ctx, prefix, false, func(obj mc.ObjectInfo) error {
+			if !remove(obj) {
+				return nil
+			}
+
+			klog.V(2).InfoS("pruning object", "object", obj.Key, "last-modified", obj.LastModified)
+
+			select {
+			case keys <- obj.Key:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	var (
+		removed int
+		err     error
+	)
+
+	if dryRun {
+		for range keys {
+			removed++
+		}
+	} else {
+		removed, err = client.RemoveObjects(ctx, keys)
+	}
+
+	// Stop the walk if removal ended early so it does not block on keys.
+	cancel()
+
+	if werr := <-walkErr; err == nil {
+		err = werr
+	}
+
+	return removed, err
+}
+
+// protectedKey reports whether key is written by the sidecar itself or is a
+// chunk, which may still be listed by a newer chunk index.
+func protectedKey(key string) bool {
+	key = strings.TrimPrefix(key, "/")
+
+	return strings.HasPrefix(key, internalPrefix) || strings.HasPrefix(key, chunkPrefix())
+}
@@ -0,0 +1,107 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+)
+
+func TestPrunePrefixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		explicit bool
+		want     []string
+		wantErr  bool
+	}{
+		{"directories", []string{"data/app", "/logs/"}, false, []string{"data/app/", "logs/"}, false},
+		{"explicit", []string{"/data/app"}, true, []string{"data/app/"}, false},
+		{"bucket root", []string{"data", ""}, false, nil, true},
+		{"slash", []string{"/"}, false, nil, true},
+		{"explicit bucket root", []string{"/"}, true, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prunePrefixes(tt.prefixes, tt.explicit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("prunePrefixes(%q) returned %v", tt.prefixes, err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("prunePrefixes(%q) = %q, want %q", tt.prefixes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneChunks(t *testing.T) {
+	ctx := context.Background()
+	client := minio.NewFake("fake")
+	kept := minio.ChunkKey(minio.DefaultChunkPrefix, "aa11")
+	orphan := minio.ChunkKey(minio.DefaultChunkPrefix, "bb22")
+
+	for _, key := range []string{kept, orphan} {
+		if err := client.Upload(ctx, key, strings.NewReader(key), -1, ""); err != nil {
+			t.Fatalf("Upload %s: %v", key, err)
+		}
+	}
+
+	index, err := json.Marshal(minio.ChunkIndex{Version: 1, Chunks: []minio.Chunk{{Key: kept, Size: int64(len(kept))}}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(file, index, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	dest := config.Destination{Path: "data", Metadata: map[string]string{minio.MetadataChunkSize: "65536"}}
+	if err := client.UploadFileWithDestination(file, dest, ctx); err != nil {
+		t.Fatalf("UploadFileWithDestination: %v", err)
+	}
+
+	removed, err := pruneChunks(ctx, client, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("pruneChunks: %v", err)
+	}
+
+	if removed != 1 {
+		t.Errorf("pruneChunks removed %d chunks, want 1", removed)
+	}
+
+	if _, _, ok := client.Object(orphan); ok {
+		t.Errorf("chunk %s listed by no index was kept", orphan)
+	}
+
+	for _, key := range []string{kept, "data/db.sql"} {
+		if _, _, ok := client.Object(key); !ok {
+			t.Errorf("%s was removed", key)
+		}
+	}
+}
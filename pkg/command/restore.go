@@ -0,0 +1,480 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// Policies for applying stored file mode and ownership on restore.
+const (
+	permissionsPreserve = "preserve" // apply stored permissions, warn when they cannot be applied
+	permissionsStrict   = "strict"   // apply stored permissions, fail when they cannot be applied
+	permissionsDefault  = "default"  // ignore stored permissions and use restore.default-mode
+)
+
+const restoreDirMode = 0o755
+
+// errTombstone is returned for tombstones, which record a removed file
+// instead of holding one.
+var errTombstone = errors.New("object is a tombstone")
+
+// InitRestore adds flags used only by the restore command.
+func InitRestore(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("restore.permissions", permissionsPreserve, "How to apply stored mode and ownership (preserve, strict, default)")
+	flags.String("restore.default-mode", "0644", "File mode used when no mode is stored or restore.permissions is default")
+	flags.Bool("restore.overwrite", false, "Overwrite existing local files")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// Restore downloads every object under a prefix into a local directory,
+// decrypting and decompressing objects and applying stored permissions.
+func Restore(cmd *cobra.Command, args []string) {
+	prefix, target := args[0], args[1]
+
+	policy := viper.GetString("restore.permissions")
+	if policy != permissionsPreserve && policy != permissionsStrict && policy != permissionsDefault {
+		klog.Fatalf("unknown restore.permissions %s", policy)
+	}
+
+	defaultMode, err := strconv.ParseUint(viper.GetString("restore.default-mode"), 8, 32)
+	if err != nil {
+		klog.Fatalf("invalid restore.default-mode: %v", err)
+	}
+
+	client, err := newMinio(cmd.Context(), minio.Connect)
+	if err != nil {
+		klog.Fatalf("unable to initialize minio: %v", err)
+	}
+
+	var (
+		restored, failed int
+		dirs             = map[string]map[string]string{}
+		appended         = map[string]int64{}
+	)
+
+	chunks := chunkPrefix()
+
+	err = client.Walk(cmd.Context(), prefix, false, func(obj mc.ObjectInfo) error {
+		// chunks are restored through the index of their files
+		if strings.HasPrefix(strings.TrimPrefix(obj.Key, "/"), chunks) {
+			return nil
+		}
+
+		if bookkeepingKey(prefix, obj.Key) {
+			klog.V(2).InfoS("skipping bookkeeping object", "object", obj.Key)
+			return nil
+		}
+
+		file, err := restoreObject(cmd.Context(), client, prefix, obj.Key, target, policy, os.FileMode(defaultMode), dirs, appended)
+		if errors.Is(err, errTombstone) {
+			klog.V(2).InfoS("skipping tombstone", "object", obj.Key)
+			return nil
+		}
+
+		if err != nil {
+			if policy == permissionsStrict {
+				return err
+			}
+
+			klog.ErrorS(err, "unable to restore object", "object", obj.Key, "file", file)
+			failed++
+
+			return nil
+		}
+
+		restored++
+
+		return nil
+	})
+	if err != nil {
+		klog.Fatalf("unable to restore %s: %v", prefix, err)
+	}
+
+	// directories are changed last, so a read-only mode does not stop files
+	// being written into them
+	if policy != permissionsDefault {
+		for dir, metadata := range dirs {
+			if err := applyDirPermissions(dir, metadata); err != nil {
+				if policy == permissionsStrict {
+					klog.Fatalf("unable to restore permissions of %s: %v", dir, err)
+				}
+
+				klog.Warningf("unable to restore permissions of %s: %v", dir, err)
+			}
+		}
+	}
+
+	klog.InfoS("restore complete", "prefix", prefix, "target", target, "restored", restored, "failed", failed)
+
+	if failed > 0 {
+		klog.Flush()
+		os.Exit(1)
+	}
+}
+
+// restorePath maps key to a file under target, never escaping it.
+func restorePath(prefix, key, target string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, "/"), strings.TrimPrefix(prefix, "/"))
+	if strings.Trim(rel, "/") == "" {
+		rel = path.Base(key)
+	}
+
+	return filepath.Join(target, filepath.Clean("/"+rel))
+}
+
+// bookkeepingKey reports whether key, relative to prefix, is an object the
+// sidecar keeps about files rather than a file, such as the trash of removed
+// files, manifests and group markers.
+func bookkeepingKey(prefix, key string) bool {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, "/"), strings.TrimPrefix(prefix, "/"))
+	parts := strings.Split(rel, "/")
+
+	if slices.Contains(parts, fs.TrashDir) || slices.Contains(parts, fs.ManifestPrefix) {
+		return true
+	}
+
+	return parts[len(parts)-1] == fs.GroupMarker
+}
+
+// restoreObject downloads key to its file under target, which is resolved
+// from the logical key for sharded objects and appended segments, and
+// returns the file. The metadata of the object is recorded in dirs by
+// directory when it has the directory's attributes. appended holds the size
+// of files restored from segments so far, which later segments extend.
+func restoreObject(ctx context.Context, client minio.MinioClient, prefix, key, target, policy string, defaultMode os.FileMode, dirs map[string]map[string]string, appended map[string]int64) (string, error) {
+	obj, info, err := client.Download(ctx, key)
+	if err != nil {
+		return restorePath(prefix, key, target), err
+	}
+	defer obj.Close()
+
+	file := restorePath(prefix, key, target)
+	if info.UserMetadata[minio.MetadataTombstoneFor] != "" {
+		return file, errTombstone
+	}
+
+	if logical := info.UserMetadata[minio.MetadataLogicalKey]; logical != "" {
+		file = restorePath(prefix, logical, target)
+	}
+
+	offset := int64(-1)
+	if v := info.UserMetadata[minio.MetadataAppendOffset]; v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return file, fmt.Errorf("invalid append offset %q of %s", v, key)
+		}
+	}
+
+	size, restoring := appended[file]
+
+	// segments are walked in sequence order, and a segment at offset 0
+	// starts the file again
+	if offset > 0 {
+		if !restoring || size != offset {
+			return file, fmt.Errorf("segment %s starts at %d but %s holds %d bytes: missing segment", key, offset, file, size)
+		}
+
+		return file, appendSegment(key, obj, info.UserMetadata, file, policy, defaultMode, appended)
+	}
+
+	if _, err := os.Stat(file); err == nil && !restoring && !viper.GetBool("restore.overwrite") {
+		return file, fmt.Errorf("%s already exists", file)
+	}
+
+	if filters := info.UserMetadata[minio.MetadataFilters]; filters != "" {
+		klog.Warningf("%s was uploaded through filters %q, which restore does not reverse", key, filters)
+	}
+
+	r, closeObj, err := decodeObject(key, obj, info.UserMetadata)
+	if err != nil {
+		return file, err
+	}
+	defer closeObj()
+
+	var index *minio.ChunkIndex
+
+	if info.UserMetadata[minio.MetadataChunkSize] != "" {
+		if index, err = minio.ReadChunkIndex(r); err != nil {
+			return file, fmt.Errorf("unable to read %s: %w", key, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), restoreDirMode); err != nil {
+		return file, fmt.Errorf("unable to create directory for %s: %w", file, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+"-*")
+	if err != nil {
+		return file, fmt.Errorf("unable to create %s: %w", file, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if index != nil {
+		err = restoreChunks(ctx, client, index, tmp)
+	} else {
+		_, err = io.Copy(tmp, r)
+	}
+
+	if err != nil {
+		tmp.Close()
+		return file, fmt.Errorf("unable to download %s: %w", key, err)
+	}
+
+	if offset == 0 {
+		st, err := tmp.Stat()
+		if err != nil {
+			tmp.Close()
+			return file, fmt.Errorf("unable to write %s: %w", file, err)
+		}
+
+		appended[file] = st.Size()
+	}
+
+	if err := tmp.Close(); err != nil {
+		return file, fmt.Errorf("unable to write %s: %w", file, err)
+	}
+
+	if err := applyPermissions(tmp.Name(), info.UserMetadata, policy, defaultMode); err != nil {
+		if policy == permissionsStrict {
+			return file, fmt.Errorf("unable to restore permissions of %s: %w", file, err)
+		}
+
+		klog.Warningf("unable to restore permissions of %s: %v", file, err)
+	}
+
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return file, fmt.Errorf("unable to write %s: %w", file, err)
+	}
+
+	if info.UserMetadata[minio.MetadataDirMode] != "" {
+		dirs[filepath.Dir(file)] = info.UserMetadata
+	}
+
+	klog.V(2).InfoS("restored object", "object", key, "file", file)
+
+	return file, nil
+}
+
+// appendSegment appends the content of the segment at key read from obj to
+// file, which already holds every earlier segment.
+func appendSegment(key string, obj io.Reader, metadata map[string]string, file, policy string, defaultMode os.FileMode, appended map[string]int64) error {
+	r, closeObj, err := decodeObject(key, obj, metadata)
+	if err != nil {
+		return err
+	}
+	defer closeObj()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", file, err)
+	}
+
+	n, err := io.Copy(f, r)
+	appended[file] += n
+
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to download %s: %w", key, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %w", file, err)
+	}
+
+	if err := applyPermissions(file, metadata, policy, defaultMode); err != nil {
+		if policy == permissionsStrict {
+			return fmt.Errorf("unable to restore permissions of %s: %w", file, err)
+		}
+
+		klog.Warningf("unable to restore permissions of %s: %v", file, err)
+	}
+
+	klog.V(2).InfoS("restored segment", "object", key, "file", file)
+
+	return nil
+}
+
+// decodeObject returns the content of the object at key read from r,
+// decrypting and decompressing it as its metadata records, and a function
+// releasing it.
+func decodeObject(key string, r io.Reader, metadata map[string]string) (io.Reader, func(), error) {
+	if typ := metadata[crypt.MetadataKey]; typ != "" {
+		dr, err := crypt.DecryptReader(r, typ)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to decrypt %s: %w", key, err)
+		}
+
+		r = dr
+	}
+
+	if metadata[minio.MetadataCompression] == "gzip" {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to decompress %s: %w", key, err)
+		}
+
+		return zr, func() { zr.Close() }, nil
+	}
+
+	return r, func() {}, nil
+}
+
+// restoreChunks writes the chunks listed by index to w in order, checking
+// the content matches the size and hash recorded when it was uploaded.
+func restoreChunks(ctx context.Context, client minio.MinioClient, index *minio.ChunkIndex, w io.Writer) error {
+	sum := sha256.New()
+	w = io.MultiWriter(w, sum)
+
+	var size int64
+
+	for _, chunk := range index.Chunks {
+		obj, info, err := client.Download(ctx, chunk.Key)
+		if err != nil {
+			return err
+		}
+
+		r, closeChunk, err := decodeObject(chunk.Key, obj, info.UserMetadata)
+		if err != nil {
+			obj.Close()
+			return err
+		}
+
+		n, err := io.Copy(w, r)
+
+		closeChunk()
+		obj.Close()
+
+		if err != nil {
+			return fmt.Errorf("unable to read chunk %s: %w", chunk.Key, err)
+		}
+
+		if n != chunk.Size {
+			return fmt.Errorf("chunk %s holds %d bytes, expected %d", chunk.Key, n, chunk.Size)
+		}
+
+		size += n
+	}
+
+	if hash := hex.EncodeToString(sum.Sum(nil)); size != index.Size || hash != index.SHA256 {
+		return fmt.Errorf("chunks hold %d bytes with sha256 %s, expected %d bytes with %s", size, hash, index.Size, index.SHA256)
+	}
+
+	return nil
+}
+
+// applyPermissions sets the mode, ownership and mtime recorded in metadata on
+// file, or only defaultMode under the default policy.
+func applyPermissions(file string, metadata map[string]string, policy string, defaultMode os.FileMode) error {
+	mode := defaultMode
+
+	if policy != permissionsDefault {
+		if m, err := strconv.ParseUint(metadata[minio.MetadataMode], 8, 32); err == nil {
+			mode = os.FileMode(m)
+		}
+	}
+
+	var errs []error
+
+	if err := os.Chmod(file, mode); err != nil {
+		errs = append(errs, fmt.Errorf("unable to set mode: %w", err))
+	}
+
+	if policy != permissionsDefault {
+		if err := chownFromMetadata(file, metadata[minio.MetadataUID], metadata[minio.MetadataGID]); err != nil {
+			errs = append(errs, err)
+		}
+
+		if mtime, err := time.Parse(time.RFC3339, metadata[minio.MetadataMtime]); err == nil {
+			if err := os.Chtimes(file, mtime, mtime); err != nil {
+				errs = append(errs, fmt.Errorf("unable to set mtime: %w", err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyDirPermissions sets the directory mode and ownership recorded in
+// metadata on dir.
+func applyDirPermissions(dir string, metadata map[string]string) error {
+	var errs []error
+
+	if m, err := strconv.ParseUint(metadata[minio.MetadataDirMode], 8, 32); err == nil {
+		if err := os.Chmod(dir, os.FileMode(m)); err != nil {
+			errs = append(errs, fmt.Errorf("unable to set mode: %w", err))
+		}
+	}
+
+	if err := chownFromMetadata(dir, metadata[minio.MetadataDirUID], metadata[minio.MetadataDirGID]); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// chownFromMetadata sets the owner of file to the recorded uid and gid, when
+// both are recorded and differ from the current user.
+func chownFromMetadata(file, uidValue, gidValue string) error {
+	uid, uidErr := strconv.Atoi(uidValue)
+	gid, gidErr := strconv.Atoi(gidValue)
+
+	// Getuid is -1 on Windows, which has no owners to restore
+	if os.Getuid() == -1 {
+		return nil
+	}
+
+	if uidErr != nil || gidErr != nil || (uid == os.Getuid() && gid == os.Getgid()) {
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("insufficient privileges to set owner %d:%d", uid, gid)
+	}
+
+	if err := os.Chown(file, uid, gid); err != nil {
+		return fmt.Errorf("unable to set owner: %w", err)
+	}
+
+	return nil
+}
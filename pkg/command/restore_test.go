@@ -0,0 +1,72 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRestorePath(t *testing.T) {
+	target := filepath.FromSlash("/restore")
+
+	tests := []struct {
+		name   string
+		prefix string
+		key    string
+		want   string
+	}{
+		{"under prefix", "backups", "backups/db/dump.sql", "/restore/db/dump.sql"},
+		{"leading slashes", "/backups", "/backups/dump.sql", "/restore/dump.sql"},
+		{"no prefix", "", "dump.sql", "/restore/dump.sql"},
+		{"key is prefix", "backups/dump.sql", "backups/dump.sql", "/restore/dump.sql"},
+		{"escaping target", "backups", "backups/../../etc/passwd", "/restore/etc/passwd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restorePath(tt.prefix, tt.key, target); got != filepath.FromSlash(tt.want) {
+				t.Errorf("restorePath(%q, %q) = %s, want %s", tt.prefix, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBookkeepingKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		key    string
+		want   bool
+	}{
+		{"file", "backups", "backups/db/dump.sql", false},
+		{"trash", "backups", "backups/.trash/20240101T000000Z/dump.sql", true},
+		{"nested trash", "", "app/.trash/20240101T000000Z/dump.sql", true},
+		{"trash-like name", "backups", "backups/.trash.sql", false},
+		{"manifest", "backups", "backups/_manifests/20240101T000000Z-abcd.json", true},
+		{"group marker", "backups", "backups/20240101T000000Z/_SUCCESS", true},
+		{"group member", "backups", "backups/20240101T000000Z/part-0001.csv", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bookkeepingKey(tt.prefix, tt.key); got != tt.want {
+				t.Errorf("bookkeepingKey(%q, %q) = %t, want %t", tt.prefix, tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// InitRetryFailed adds flags used only by the retry-failed command.
+func InitRetryFailed(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("retry-failed.server", "", "URL of the running sidecar, such as http://localhost:8080")
+	flags.Duration("retry-failed.timeout", 0, "Time to wait for the retries to complete (no limit if 0)")
+	flags.Bool("retry-failed.list", false, "Only list the failed uploads waiting to be retried")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// RetryFailed asks the sidecar running at retry-failed.server to upload its
+// dead letters again, only those of the path or source in args when given,
+// and exits non-zero if any of them fail again.
+func RetryFailed(cmd *cobra.Command, args []string) {
+	server := strings.TrimSuffix(viper.GetString("retry-failed.server"), "/")
+	if server == "" {
+		klog.Fatal("retry-failed.server is required")
+	}
+
+	if viper.GetBool("retry-failed.list") {
+		letters, err := remoteDeadLetters(server)
+		if err != nil {
+			klog.Fatalf("unable to list failed uploads: %v", err)
+		}
+
+		if err := writeDeadLetters(cmd.OutOrStdout(), letters); err != nil {
+			klog.Fatalf("unable to write failed uploads: %v", err)
+		}
+
+		return
+	}
+
+	v := url.Values{}
+	if len(args) > 0 {
+		v.Set("target", args[0])
+	}
+
+	client := http.Client{}
+
+	if timeout := viper.GetDuration("retry-failed.timeout"); timeout > 0 {
+		v.Set("timeout", timeout.String())
+		client.Timeout = timeout + historyTimeout
+	}
+
+	resp, err := client.Post(server+"/retry-failed?"+v.Encode(), "", nil)
+	if err != nil {
+		klog.Fatalf("unable to retry failed uploads: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		klog.Fatalf("unable to retry failed uploads: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var run fs.Results
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		klog.Fatalf("unable to decode retry result: %v", err)
+	}
+
+	if _, err := fmt.Fprintf(cmd.OutOrStdout(), "uploaded %d, skipped %d, failed %d\n", run.Uploaded, run.Skipped, run.Failed); err != nil {
+		klog.Fatalf("unable to write retry result: %v", err)
+	}
+
+	for _, file := range run.FailedFiles {
+		if _, err := fmt.Fprintf(cmd.OutOrStdout(), "failed: %s\n", file); err != nil {
+			klog.Fatalf("unable to write retry result: %v", err)
+		}
+	}
+
+	if run.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func remoteDeadLetters(server string) ([]state.DeadLetter, error) {
+	client := http.Client{Timeout: historyTimeout}
+
+	resp, err := client.Get(server + "/status")
+	if err != nil {
+		return nil, fmt.Errorf("unable to query %s: %w", server, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	var status struct {
+		DeadLetters []state.DeadLetter `json:"deadLetters"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("unable to decode status: %w", err)
+	}
+
+	return status.DeadLetters, nil
+}
+
+func writeDeadLetters(w io.Writer, letters []state.DeadLetter) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "LAST FAILURE\tATTEMPTS\tPATH\tFILE\tOBJECT\tERROR"); err != nil {
+		return fmt.Errorf("unable to write header: %w", err)
+	}
+
+	for _, d := range letters {
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", d.Last.Local().Format(time.RFC3339), d.Attempts, d.Path, d.File, d.Object, d.Error); err != nil {
+			return fmt.Errorf("unable to write %s: %w", d.File, err)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to flush: %w", err)
+	}
+
+	return nil
+}
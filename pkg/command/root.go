@@ -19,37 +19,230 @@ package command
 
 import (
 	"context"
+	"net/url"
+	"os"
+	"strings"
 
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
 	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
 	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/history"
 	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/server"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
+	"github.com/csfreak/minio-backup-sidecar/pkg/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"k8s.io/klog/v2"
 )
 
+// PreRun applies settings shared by every command.
+func PreRun(_ *cobra.Command, _ []string) {
+	if err := initLogging(); err != nil {
+		klog.Fatalf("unable to configure logging: %v", err)
+	}
+}
+
 func Run(cmd *cobra.Command, args []string) {
+	defer klog.Flush()
+
 	viper.Set("path", append(viper.GetStringSlice("path"), args...))
 
+	klog.InfoS("starting", "version", version.Get().String())
 	klog.V(4).InfoS("config values", viper.AllSettings())
 
-	mc, err := minio.New(cmd.Context())
+	stopTracing, err := tracing.Init(cmd.Context(), tracing.Options{
+		Endpoint:    viper.GetString("tracing.endpoint"),
+		Insecure:    viper.GetBool("tracing.insecure"),
+		SampleRatio: viper.GetFloat64("tracing.sample-ratio"),
+		ServiceName: cmd.Root().Name(),
+		PodName:     config.PodName(),
+	})
+	if err != nil {
+		klog.Fatalf("unable to configure tracing: %v", err)
+	}
+
+	mc, err := newReplicated(cmd.Context(), minio.NewWithOptions)
 	if err != nil {
 		klog.Fatalf("unable to initialize minio: %v", err)
 	}
 
-	f, err := fs.New()
+	profiles, err := newProfiles(cmd.Context(), minio.NewWithOptions)
+	if err != nil {
+		klog.Fatalf("unable to initialize profiles: %v", err)
+	}
+
+	sources, err := newSources()
+	if err != nil {
+		klog.Fatalf("unable to initialize sources: %v", err)
+	}
+
+	opts := pathOptions()
+	opts.Client = mc
+	opts.Profiles = profiles
+	opts.Sources = sources
+
+	f, err := fs.NewWithConfig(opts)
 	if err != nil {
 		klog.Fatalf("unable to initialize fs: %v", err)
 	}
 
-	f.Process(context.WithValue(cmd.Context(), config.MC, mc))
+	if err := mc.ApplyRetention(cmd.Context(), f.Prefixes()); err != nil {
+		klog.Fatalf("unable to set retention: %v", err)
+	}
+
+	if err := mc.ApplyReplication(cmd.Context(), f.Prefixes()); err != nil {
+		klog.Fatalf("unable to set replication: %v", err)
+	}
+
+	targets := minio.Summary(mc)
+
+	for _, name := range f.Profiles() {
+		p, ok := profiles[name]
+		if !ok {
+			klog.Fatalf("unknown profile %s", name)
+		}
+
+		if err := p.ApplyRetention(cmd.Context(), f.ProfilePrefixes(name)); err != nil {
+			klog.Fatalf("unable to set retention for profile %s: %v", name, err)
+		}
+
+		if err := p.ApplyReplication(cmd.Context(), f.ProfilePrefixes(name)); err != nil {
+			klog.Fatalf("unable to set replication for profile %s: %v", name, err)
+		}
+
+		targets = append(targets, minio.Summary(p)...)
+	}
+
+	klog.InfoS("starting", "pod", config.PodName(), "paths", f.Summary(), "targets", targets)
+
+	if err := state.Init(viper.GetString("state-file")); err != nil {
+		klog.Fatalf("unable to load state: %v", err)
+	}
+
+	// recorded uploads are resumed, so stale ones are only aborted once the state is loaded
+	abortStaleUploads(cmd.Context(), mc, f.Prefixes())
+
+	for _, name := range f.Profiles() {
+		abortStaleUploads(cmd.Context(), profiles[name], f.ProfilePrefixes(name))
+	}
+
+	go state.Run(cmd.Context())
+
+	if err := audit.Init(audit.Options{
+		File:       viper.GetString("audit.file"),
+		MaxSize:    viper.GetInt64("audit.max-size"),
+		MaxBackups: viper.GetInt("audit.max-backups"),
+		Ship:       viper.GetDuration("audit.ship-interval") > 0,
+	}); err != nil {
+		klog.Fatalf("unable to open audit log: %v", err)
+	}
+
+	shipAudit := startAuditShipping(cmd.Context(), mc)
+
+	if err := history.Init(history.Options{
+		File:      viper.GetString("history.file"),
+		Retention: viper.GetDuration("history.retention"),
+	}); err != nil {
+		klog.Fatalf("unable to open history: %v", err)
+	}
+
+	if history.Enabled() {
+		server.RegisterHistory(func(v url.Values) (any, error) {
+			q, err := history.ParseQuery(v)
+			if err != nil {
+				return nil, err
+			}
+
+			return history.Search(q)
+		})
+	}
+
+	server.RegisterStatus("usage", func() any { return state.UploadUsage() })
+	prices, retention := costPrices(), pathRetention(opts.Paths)
+	server.RegisterStatus("cost", func() any { return state.Costs(prices, retention) })
+	server.RegisterStatus("paths", func() any { return f.Status() })
+	server.RegisterStatus("circuits", func() any { return minio.Circuits() })
+	server.RegisterStatus("offlinePending", func() any { return fs.OfflinePending() })
+	server.RegisterStatus("deadLetters", func() any { return state.DeadLetters() })
+
+	server.RegisterStatus("paused", func() any { return fs.Paused() })
+
+	server.RegisterFlush(func(ctx context.Context) (any, error) { return f.Flush(ctx) })
+	server.RegisterRetry(func(ctx context.Context, target string) (any, error) { return f.RetryFailed(ctx, target) })
+	server.RegisterPause(
+		func() any { fs.Pause(); return fs.Paused() },
+		func() any { fs.Resume(); return fs.Paused() },
+	)
+
+	if viper.GetBool("staleness-fails-readiness") {
+		server.RegisterReadiness("staleness", f.Stale)
+	}
+
+	if minio.ReplicationStatus(mc) != nil {
+		server.RegisterStatus("targets", func() any { return minio.ReplicationStatus(mc) })
+	}
+
+	server.RegisterTrigger(func(ctx context.Context, target string, changedOnly bool) (any, error) {
+		return f.Backup(ctx, target, changedOnly)
+	})
+	server.RegisterEvents(fs.Subscribe)
+
+	server.Start(cmd.Context())
+
+	if err := server.StartGRPC(cmd.Context()); err != nil {
+		klog.Fatalf("unable to start grpc server: %v", err)
+	}
+
+	if viper.GetBool("kubernetes-events") {
+		if err := events.Init(cmd.Context()); err != nil {
+			klog.Fatalf("unable to record kubernetes events: %v", err)
+		}
+	}
+
+	results := f.Process(cmd.Context())
+
+	klog.InfoS("processing complete", "uploaded", results.Uploaded, "skipped", results.Skipped, "failed", results.Failed)
+
+	if err := state.Flush(); err != nil {
+		klog.ErrorS(err, "unable to save state")
+	}
+
+	shipAudit()
+
+	tctx, cancel := context.WithTimeout(context.WithoutCancel(cmd.Context()), tracingFlushTimeout)
+	if err := stopTracing(tctx); err != nil {
+		klog.ErrorS(err, "unable to export traces")
+	}
+
+	cancel()
+
+	if err := audit.Close(); err != nil {
+		klog.ErrorS(err, "unable to close audit log")
+	}
+
+	if err := history.Close(); err != nil {
+		klog.ErrorS(err, "unable to close history")
+	}
+
+	maxFailures := viper.GetInt64("max-failures")
+	if viper.GetBool("fail-fast") {
+		maxFailures = 0
+	}
+
+	if f.OneShot() && results.Failed > maxFailures {
+		klog.Errorf("%d files failed, more than max-failures %d: %s", results.Failed, maxFailures, strings.Join(results.FailedFiles, ", "))
+		klog.Flush()
+		os.Exit(1)
+	}
 }
 
 func Init(cmd *cobra.Command) {
 	initConfig()
 
-	if err := initFlags(cmd.Flags()); err != nil {
+	if err := initFlags(cmd.PersistentFlags()); err != nil {
 		klog.Fatalf("unable to configure: %v", err)
 	}
 }
@@ -0,0 +1,163 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// Record formats understood by S3 Select.
+const (
+	selectAuto = "auto"
+	selectCSV  = "csv"
+	selectJSON = "json"
+)
+
+// InitSelect adds flags used only by the select command.
+func InitSelect(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("select.input", selectAuto, "Format of the queried objects (auto, csv, json), auto uses the object extension")
+	flags.String("select.output", selectJSON, "Format of the returned records (csv, json)")
+	flags.String("select.csv-header", "use", "How the first line of CSV objects is treated (use, ignore, none)")
+	flags.String("select.csv-delimiter", ",", "Field delimiter of CSV objects")
+	flags.String("select.json-type", "lines", "Layout of JSON objects (lines, document)")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// Select runs an S3 Select query against every object under a prefix and
+// writes the matching records to stdout.
+func Select(cmd *cobra.Command, args []string) {
+	prefix, query := args[0], args[1]
+
+	output, err := selectOutput(viper.GetString("select.output"))
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	client, err := newMinio(cmd.Context(), minio.Connect)
+	if err != nil {
+		klog.Fatalf("unable to initialize minio: %v", err)
+	}
+
+	var queried, failed int
+
+	err = client.Walk(cmd.Context(), prefix, false, func(obj mc.ObjectInfo) error {
+		input, err := selectInput(viper.GetString("select.input"), obj.Key)
+		if err != nil {
+			klog.ErrorS(err, "unable to query object", "object", obj.Key)
+			failed++
+
+			return nil
+		}
+
+		res, err := client.Select(cmd.Context(), obj.Key, mc.SelectObjectOptions{
+			Expression:          query,
+			ExpressionType:      mc.QueryExpressionTypeSQL,
+			InputSerialization:  input,
+			OutputSerialization: output,
+		})
+		if err != nil {
+			klog.ErrorS(err, "unable to query object", "object", obj.Key)
+			failed++
+
+			return nil
+		}
+		defer res.Close()
+
+		if _, err := io.Copy(os.Stdout, res); err != nil {
+			klog.ErrorS(err, "unable to read query results", "object", obj.Key)
+			failed++
+
+			return nil
+		}
+
+		queried++
+
+		return nil
+	})
+	if err != nil {
+		klog.Fatalf("unable to query %s: %v", prefix, err)
+	}
+
+	klog.V(2).InfoS("select complete", "prefix", prefix, "queried", queried, "failed", failed)
+
+	if failed > 0 {
+		klog.Flush()
+		os.Exit(1)
+	}
+}
+
+// selectInput describes how key is parsed, detecting the format from its
+// extension when format is auto.
+func selectInput(format, key string) (mc.SelectObjectInputSerialization, error) {
+	if format == selectAuto {
+		switch path.Ext(strings.TrimSuffix(key, ".gz")) {
+		case ".csv", ".tsv":
+			format = selectCSV
+		case ".json", ".jsonl", ".ndjson":
+			format = selectJSON
+		default:
+			return mc.SelectObjectInputSerialization{}, fmt.Errorf("unable to detect format of %s, set select.input", key)
+		}
+	}
+
+	// .gz objects were compressed before upload, sidecar compression is detected by Select
+	in := mc.SelectObjectInputSerialization{CompressionType: mc.SelectCompressionNONE}
+	if strings.HasSuffix(key, ".gz") {
+		in.CompressionType = mc.SelectCompressionGZIP
+	}
+
+	switch format {
+	case selectCSV:
+		in.CSV = &mc.CSVInputOptions{}
+		in.CSV.SetFileHeaderInfo(mc.CSVFileHeaderInfo(strings.ToUpper(viper.GetString("select.csv-header"))))
+		in.CSV.SetFieldDelimiter(viper.GetString("select.csv-delimiter"))
+	case selectJSON:
+		in.JSON = &mc.JSONInputOptions{}
+		in.JSON.SetType(mc.JSONType(strings.ToUpper(viper.GetString("select.json-type"))))
+	default:
+		return in, fmt.Errorf("unknown select.input %s", format)
+	}
+
+	return in, nil
+}
+
+func selectOutput(format string) (mc.SelectObjectOutputSerialization, error) {
+	switch format {
+	case selectCSV:
+		return mc.SelectObjectOutputSerialization{CSV: &mc.CSVOutputOptions{}}, nil
+	case selectJSON:
+		return mc.SelectObjectOutputSerialization{JSON: &mc.JSONOutputOptions{}}, nil
+	default:
+		return mc.SelectObjectOutputSerialization{}, fmt.Errorf("unknown select.output %s", format)
+	}
+}
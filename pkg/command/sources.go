@@ -0,0 +1,112 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/source"
+	"github.com/spf13/viper"
+)
+
+const (
+	sourcePostgres   = "postgres"
+	sourceMySQL      = "mysql"
+	sourceKubernetes = "kubernetes"
+)
+
+// sourceKeys are the settings read from sources.N.
+var sourceKeys = []string{
+	"type", "name", "schedule", "path", "name-template", "compress",
+	"host", "port", "user", "database", "databases", "password-file",
+	"sslmode", "format", "args", "command", "resources", "namespaces",
+	"label-selector",
+}
+
+// newSources returns the sources configured by sources.N.
+func newSources() ([]fs.Source, error) {
+	var sources []fs.Source
+
+	for i := 0; viper.IsSet(fmt.Sprintf("sources.%d.type", i)); i++ {
+		key := func(k string) string { return fmt.Sprintf("sources.%d.%s", i, k) }
+
+		opts := source.Options{
+			Name:         viper.GetString(key("name")),
+			Schedule:     viper.GetString(key("schedule")),
+			Path:         viper.GetString(key("path")),
+			NameTemplate: viper.GetString(key("name-template")),
+			Compress:     viper.GetBool(key("compress")),
+		}
+
+		switch t := viper.GetString(key("type")); t {
+		case sourcePostgres:
+			s, err := source.NewPostgres(source.PostgresOptions{
+				Options:      opts,
+				Host:         viper.GetString(key("host")),
+				Port:         viper.GetInt(key("port")),
+				User:         viper.GetString(key("user")),
+				Database:     viper.GetString(key("database")),
+				PasswordFile: viper.GetString(key("password-file")),
+				SSLMode:      viper.GetString(key("sslmode")),
+				Format:       viper.GetString(key("format")),
+				Args:         viper.GetStringSlice(key("args")),
+				Command:      viper.GetString(key("command")),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("invalid source %d: %w", i, err)
+			}
+
+			sources = append(sources, s)
+		case sourceMySQL, "mariadb":
+			s, err := source.NewMySQL(source.MySQLOptions{
+				Options:      opts,
+				Host:         viper.GetString(key("host")),
+				Port:         viper.GetInt(key("port")),
+				User:         viper.GetString(key("user")),
+				Databases:    viper.GetStringSlice(key("databases")),
+				PasswordFile: viper.GetString(key("password-file")),
+				Args:         viper.GetStringSlice(key("args")),
+				Command:      viper.GetString(key("command")),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("invalid source %d: %w", i, err)
+			}
+
+			sources = append(sources, s)
+		case sourceKubernetes:
+			s, err := source.NewKubernetes(source.KubernetesOptions{
+				Options:       opts,
+				Resources:     viper.GetStringSlice(key("resources")),
+				Namespaces:    viper.GetStringSlice(key("namespaces")),
+				LabelSelector: viper.GetString(key("label-selector")),
+				Format:        viper.GetString(key("format")),
+				Encrypted:     viper.GetString("encryption.type") != "",
+			})
+			if err != nil {
+				return nil, fmt.Errorf("invalid source %d: %w", i, err)
+			}
+
+			sources = append(sources, s)
+		default:
+			return nil, fmt.Errorf("invalid source %d: unknown type %q", i, t)
+		}
+	}
+
+	return sources, nil
+}
@@ -0,0 +1,322 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/version"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultBundleLogLines = 1000
+	bundleFetchTimeout    = 10 * time.Second
+	bundleFileMode        = 0o600
+	bundlePrefix          = internalPrefix + "support-bundles"
+	redacted              = "REDACTED"
+)
+
+// sensitiveKeys are config key fragments whose values are never written to a bundle.
+var sensitiveKeys = []string{"secret", "password", "token", "access-key-id", "external-id"}
+
+// InitSupportBundle adds flags used only by the support-bundle command.
+func InitSupportBundle(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("support-bundle.output", "", "File to write the bundle to (defaults to support-bundle-<pod>-<time>.tar.gz)")
+	flags.String("support-bundle.address", "", "Address of a running sidecar to collect status and metrics from (defaults to http.address)")
+	flags.String("support-bundle.log-file", "", "Log file to include the end of")
+	flags.Int("support-bundle.log-lines", defaultBundleLogLines, "Lines from the end of support-bundle.log-file to include")
+	flags.Bool("support-bundle.upload", false, "Upload the bundle to the bucket under "+bundlePrefix)
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+type bundleInfo struct {
+	Pod     string       `json:"pod"`
+	Created time.Time    `json:"created"`
+	Build   version.Info `json:"build"`
+	Args    []string     `json:"args"`
+}
+
+// SupportBundle writes redacted config, path state, and the status, metrics
+// and logs of a running sidecar to a single archive for bug reports.
+// Sections that cannot be collected are recorded as errors in the bundle.
+func SupportBundle(cmd *cobra.Command, args []string) {
+	viper.Set("path", append(viper.GetStringSlice("path"), args...))
+
+	now := time.Now().UTC()
+
+	output := viper.GetString("support-bundle.output")
+	if output == "" {
+		output = fmt.Sprintf("support-bundle-%s-%s.tar.gz", config.PodName(), now.Format("20060102T150405Z"))
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, bundleFileMode)
+	if err != nil {
+		klog.Fatalf("unable to create bundle: %v", err)
+	}
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	b := &bundle{tw: tw, created: now}
+
+	b.addJSON("info.json", bundleInfo{
+		Pod:     config.PodName(),
+		Created: now,
+		Build:   version.Get(),
+		Args:    os.Args[1:],
+	})
+	b.addJSON("config.json", redact(viper.AllSettings()))
+
+	if paths, err := newPaths(); err != nil {
+		b.addError("paths.json", err)
+	} else {
+		b.addJSON("paths.json", paths.Summary())
+	}
+
+	address := viper.GetString("support-bundle.address")
+	if address == "" {
+		address = viper.GetString("http.address")
+	}
+
+	if address != "" {
+		b.addURL(cmd.Context(), "status.json", address, "/status")
+		b.addURL(cmd.Context(), "metrics.txt", address, "/metrics")
+	}
+
+	if logFile := viper.GetString("support-bundle.log-file"); logFile != "" {
+		b.addLogTail("log.txt", logFile, viper.GetInt("support-bundle.log-lines"))
+	}
+
+	if err := tw.Close(); err != nil {
+		klog.Fatalf("unable to write bundle: %v", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		klog.Fatalf("unable to write bundle: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		klog.Fatalf("unable to write bundle: %v", err)
+	}
+
+	klog.InfoS("wrote support bundle", "file", output, "errors", b.errors)
+
+	if viper.GetBool("support-bundle.upload") {
+		client, err := newMinio(cmd.Context(), minio.Connect)
+		if err != nil {
+			klog.Fatalf("unable to initialize minio: %v", err)
+		}
+
+		dest := config.Destination{Name: filepath.Base(output), Path: bundlePrefix, Type: "application/gzip"}
+		if err := client.UploadFileWithDestination(output, dest, cmd.Context()); err != nil {
+			klog.Fatalf("unable to upload bundle: %v", err)
+		}
+	}
+}
+
+// bundle writes sections to a tar archive. Failures to collect a section are
+// written in its place so the rest of the bundle is still useful.
+type bundle struct {
+	tw      *tar.Writer
+	created time.Time
+	errors  int
+}
+
+func (b *bundle) add(name string, data []byte) {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    bundleFileMode,
+		Size:    int64(len(data)),
+		ModTime: b.created,
+	}
+
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		klog.Fatalf("unable to write bundle: %v", err)
+	}
+
+	if _, err := b.tw.Write(data); err != nil {
+		klog.Fatalf("unable to write bundle: %v", err)
+	}
+}
+
+func (b *bundle) addError(name string, err error) {
+	klog.ErrorS(err, "unable to collect support bundle section", "section", name)
+	b.errors++
+	b.add(name+".error", []byte(err.Error()+"\n"))
+}
+
+func (b *bundle) addJSON(name string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.addError(name, fmt.Errorf("unable to encode %s: %w", name, err))
+		return
+	}
+
+	b.add(name, data)
+}
+
+func (b *bundle) addURL(ctx context.Context, name, address, endpoint string) {
+	ctx, cancel := context.WithTimeout(ctx, bundleFetchTimeout)
+	defer cancel()
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address+endpoint, http.NoBody)
+	if err != nil {
+		b.addError(name, fmt.Errorf("unable to fetch %s: %w", endpoint, err))
+		return
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		b.addError(name, fmt.Errorf("unable to fetch %s: %w", endpoint, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.addError(name, fmt.Errorf("unable to read %s: %w", endpoint, err))
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		b.addError(name, fmt.Errorf("unable to fetch %s: %s", endpoint, resp.Status))
+		return
+	}
+
+	b.add(name, data)
+}
+
+// addLogTail includes the last n lines of file.
+func (b *bundle) addLogTail(name, file string, n int) {
+	f, err := os.Open(file)
+	if err != nil {
+		b.addError(name, fmt.Errorf("unable to open log: %w", err))
+		return
+	}
+	defer f.Close()
+
+	lines := make([]string, 0, n)
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+
+		lines = append(lines, s.Text())
+	}
+
+	if err := s.Err(); err != nil {
+		b.addError(name, fmt.Errorf("unable to read log: %w", err))
+		return
+	}
+
+	b.add(name, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// redact replaces the values of sensitive settings, keeping file paths that
+// only point at secrets.
+func redact(settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+
+	for k, v := range settings {
+		switch value := v.(type) {
+		case map[string]any:
+			out[k] = redact(value)
+		case []any:
+			items := make([]any, 0, len(value))
+
+			for _, item := range value {
+				switch item := item.(type) {
+				case map[string]any:
+					items = append(items, redact(item))
+				case string:
+					items = append(items, redactURL(item))
+				default:
+					items = append(items, item)
+				}
+			}
+
+			out[k] = items
+		case string:
+			out[k] = redactURL(value)
+			if sensitive(k) && value != "" {
+				out[k] = redacted
+			}
+		default:
+			out[k] = v
+			if sensitive(k) {
+				out[k] = redacted
+			}
+		}
+	}
+
+	return out
+}
+
+// redactURL replaces the credentials of a URL, such as a proxy with a
+// password, so settings that are not sensitive themselves do not leak them.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil || u.Host == "" {
+		return value
+	}
+
+	u.User = url.User(redacted)
+
+	return u.String()
+}
+
+func sensitive(key string) bool {
+	if strings.HasSuffix(key, "-file") {
+		return false
+	}
+
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	return false
+}
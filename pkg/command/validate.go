@@ -0,0 +1,221 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// pathOnlyKeys are settings of files.N without a global flag of the same name.
+var pathOnlyKeys = []string{"enabled", "events", "profile"}
+
+// InitValidate adds flags used only by the validate command.
+func InitValidate(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.Bool("validate.online", false, "Also connect to every target, checking credentials and buckets")
+	flags.Bool("validate.strict", false, "Exit non-zero on warnings, such as unknown settings, as well as errors")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+type validation struct {
+	Valid    bool             `json:"valid"`
+	Errors   []string         `json:"errors,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+	Paths    []fs.PathSummary `json:"paths,omitempty"`
+	Sources  []string         `json:"sources,omitempty"`
+	Targets  []string         `json:"targets,omitempty"`
+	Settings map[string]any   `json:"settings"`
+}
+
+// Validate loads the configuration as the sidecar would, and prints it with
+// every problem found. Targets are only contacted with validate.online.
+// Settings in the environment that nothing reads are reported as warnings.
+func Validate(cmd *cobra.Command, args []string) {
+	viper.Set("path", append(viper.GetStringSlice("path"), args...))
+
+	v := validation{Settings: redact(viper.AllSettings())}
+
+	for _, key := range unknownKeys() {
+		v.Warnings = append(v.Warnings, "unknown setting "+key)
+	}
+
+	before := len(v.Errors)
+
+	for _, prefix := range targetPrefixes() {
+		opts, err := minioOptions(prefix)
+		if err == nil {
+			err = minio.Validate(opts)
+		}
+
+		if err != nil {
+			v.Errors = append(v.Errors, fmt.Sprintf("%s: %v", prefix, err))
+			continue
+		}
+
+		v.Targets = append(v.Targets, fmt.Sprintf("%s: %s/%s", prefix, opts.Endpoint, opts.Bucket))
+	}
+
+	targetsValid := len(v.Errors) == before
+
+	sources, err := newSources()
+	if err != nil {
+		v.Errors = append(v.Errors, err.Error())
+	}
+
+	for _, s := range sources {
+		v.Sources = append(v.Sources, strings.TrimSpace(s.Name()+" "+s.Schedule()))
+	}
+
+	opts, errs := readPathOptions()
+	for _, err := range errs {
+		v.Errors = append(v.Errors, err.Error())
+	}
+
+	opts.Sources = sources
+
+	if f, err := fs.NewWithConfig(opts); err != nil {
+		v.Errors = append(v.Errors, err.Error())
+	} else {
+		v.Paths = f.Summary()
+	}
+
+	// targets that cannot be configured are not contacted, their errors are already reported
+	if viper.GetBool("validate.online") && targetsValid {
+		if _, err := newReplicated(cmd.Context(), minio.Connect); err != nil {
+			v.Errors = append(v.Errors, err.Error())
+		}
+
+		if _, err := newProfiles(cmd.Context(), minio.Connect); err != nil {
+			v.Errors = append(v.Errors, err.Error())
+		}
+	}
+
+	v.Valid = len(v.Errors) == 0 && (len(v.Warnings) == 0 || !viper.GetBool("validate.strict"))
+
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		klog.Fatalf("unable to encode configuration: %v", err)
+	}
+
+	if _, err := fmt.Fprintln(cmd.OutOrStdout(), string(out)); err != nil {
+		klog.Fatalf("unable to write configuration: %v", err)
+	}
+
+	if !v.Valid {
+		klog.Flush()
+		os.Exit(1)
+	}
+}
+
+// targetPrefixes returns the prefix of every enabled target and profile.
+func targetPrefixes() []string {
+	prefixes := []string{"minio"}
+
+	for i := 0; viper.IsSet(fmt.Sprintf("minio.targets.%d.endpoint", i)) || viper.IsSet(fmt.Sprintf("minio.targets.%d.bucket", i)); i++ {
+		if !viper.IsSet(fmt.Sprintf("minio.targets.%d.enabled", i)) || viper.GetBool(fmt.Sprintf("minio.targets.%d.enabled", i)) {
+			prefixes = append(prefixes, fmt.Sprintf("minio.targets.%d", i))
+		}
+	}
+
+	for i := 0; viper.IsSet(fmt.Sprintf("profiles.%d.name", i)); i++ {
+		prefixes = append(prefixes, fmt.Sprintf("profiles.%d.minio", i))
+	}
+
+	return prefixes
+}
+
+// unknownKeys returns the settings given in the environment that are not
+// read by any flag, path, source, target or profile option.
+func unknownKeys() []string {
+	known := make(map[string]bool)
+	for _, k := range viper.AllKeys() {
+		known[k] = true
+	}
+
+	prefix := strings.ToUpper(envPrefix) + "_"
+
+	var unknown []string
+
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(name, prefix))
+		key = strings.ReplaceAll(strings.ReplaceAll(key, "__", "."), "_", "-")
+
+		if !knownKey(known, key) {
+			unknown = append(unknown, fmt.Sprintf("%s (%s)", key, name))
+		}
+	}
+
+	slices.Sort(unknown)
+
+	return unknown
+}
+
+// knownKey reports whether key is read, including the settings of indexed
+// paths, sources, targets and profiles.
+func knownKey(known map[string]bool, key string) bool {
+	if known[key] {
+		return true
+	}
+
+	group, rest, _ := strings.Cut(key, ".")
+	if r, ok := strings.CutPrefix(rest, "targets."); ok && group == "minio" {
+		group, rest = "minio.targets", r
+	}
+
+	index, setting, ok := strings.Cut(rest, ".")
+	if !ok {
+		return false
+	}
+
+	if _, err := strconv.Atoi(index); err != nil {
+		return false
+	}
+
+	switch group {
+	case "files":
+		return known[setting] || slices.Contains(pathOnlyKeys, setting)
+	case "sources":
+		return slices.Contains(sourceKeys, setting)
+	case "minio.targets":
+		return known["minio."+setting] || setting == "enabled" || slices.Contains(targetGlobalKeys, setting)
+	case "profiles":
+		target, ok := strings.CutPrefix(setting, "minio.")
+		return setting == "name" || (ok && (known["minio."+target] || slices.Contains(targetGlobalKeys, target)))
+	default:
+		return false
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+// InitVerify adds flags used only by the verify command.
+func InitVerify(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.StringArray("verify.prefix", []string{}, "Prefix to compare (defaults to the destination of each path)")
+	flags.Bool("verify.checksum", true, "Compare ETags as well as sizes where both objects have a single part ETag")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		klog.Fatalf("unable to configure: %v", err)
+	}
+}
+
+// Verify compares the objects under each prefix on the primary with every
+// configured target and exits non-zero if any of them diverge.
+func Verify(cmd *cobra.Command, args []string) {
+	viper.Set("path", append(viper.GetStringSlice("path"), args...))
+
+	prefixes := viper.GetStringSlice("verify.prefix")
+	if len(prefixes) == 0 {
+		f, err := newPaths()
+		if err != nil {
+			klog.Fatalf("unable to determine prefixes: %v", err)
+		}
+
+		prefixes = f.Prefixes()
+	}
+
+	primary, err := newMinio(cmd.Context(), minio.Connect)
+	if err != nil {
+		klog.Fatalf("unable to initialize minio: %v", err)
+	}
+
+	targets, err := newTargets(cmd.Context(), minio.Connect)
+	if err != nil {
+		klog.Fatalf("unable to initialize targets: %v", err)
+	}
+
+	if len(targets) == 0 {
+		klog.Fatal("no targets configured to verify against")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, tabPadding, ' ', 0)
+	if _, err := fmt.Fprintln(w, "TARGET\tSTATUS\tOBJECT"); err != nil {
+		klog.Fatalf("unable to write report: %v", err)
+	}
+
+	var diverged int
+
+	for _, t := range targets {
+		var objects, diffs int
+
+		for _, prefix := range prefixes {
+			n, d, err := compareListings(cmd.Context(), primary, t, prefix, func(key, status string) {
+				if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", t.Name(), status, key); err != nil {
+					klog.Fatalf("unable to write report: %v", err)
+				}
+			})
+			if err != nil {
+				klog.Fatalf("unable to compare %s with %s: %v", primary.Name(), t.Name(), err)
+			}
+
+			objects += n
+			diffs += d
+		}
+
+		klog.InfoS("verified target", "target", t.Name(), "objects", objects, "diverged", diffs)
+		diverged += diffs
+	}
+
+	if err := w.Flush(); err != nil {
+		klog.Fatalf("unable to write report: %v", err)
+	}
+
+	if diverged > 0 {
+		klog.Flush()
+		os.Exit(1)
+	}
+}
+
+// compareListings walks prefix on both clients in key order, reporting
+// objects that differ without holding either listing in memory. It returns
+// the number of objects on want and the number of differences.
+func compareListings(ctx context.Context, want, got minio.MinioClient, prefix string, report func(key, status string)) (int, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wantObjs, wantErr := streamObjects(ctx, want, prefix)
+	gotObjs, gotErr := streamObjects(ctx, got, prefix)
+
+	var objects, diffs int
+
+	w, wok := <-wantObjs
+	g, gok := <-gotObjs
+
+	for wok || gok {
+		switch {
+		case wok && (!gok || w.Key < g.Key):
+			report(w.Key, "missing")
+			objects++
+			diffs++
+
+			w, wok = <-wantObjs
+		case gok && (!wok || g.Key < w.Key):
+			report(g.Key, "extra")
+			diffs++
+
+			g, gok = <-gotObjs
+		default:
+			if status := compareObject(w, g); status != "" {
+				report(w.Key, status)
+				diffs++
+			}
+
+			objects++
+
+			w, wok = <-wantObjs
+			g, gok = <-gotObjs
+		}
+	}
+
+	if err := <-wantErr; err != nil {
+		return objects, diffs, fmt.Errorf("unable to list %s: %w", want.Name(), err)
+	}
+
+	if err := <-gotErr; err != nil {
+		return objects, diffs, fmt.Errorf("unable to list %s: %w", got.Name(), err)
+	}
+
+	return objects, diffs, nil
+}
+
+// streamObjects lists prefix in the background, closing the returned channel
+// once the listing ends. The error channel receives the result of the walk.
+func streamObjects(ctx context.Context, client minio.MinioClient, prefix string) (<-chan mc.ObjectInfo, <-chan error) {
+	objs := make(chan mc.ObjectInfo)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(objs)
+
+		errc <- client.Walk(ctx, prefix, false, func(obj mc.ObjectInfo) error {
+			select {
+			case objs <- obj:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return objs, errc
+}
+
+// compareObject returns why two objects with the same key differ, if they do.
+// Multipart ETags depend on the part size used, so they are never compared.
+func compareObject(want, got mc.ObjectInfo) string {
+	wantETag, gotETag := strings.Trim(want.ETag, `"`), strings.Trim(got.ETag, `"`)
+
+	switch {
+	case want.Size != got.Size:
+		return "size mismatch"
+	case viper.GetBool("verify.checksum") && !strings.Contains(wantETag, "-") && !strings.Contains(gotETag, "-") && wantETag != gotETag:
+		return "checksum mismatch"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,30 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package command
+
+import (
+	"fmt"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/version"
+	"github.com/spf13/cobra"
+)
+
+// Version prints the version, commit, build date, Go version and minio-go
+// version of the binary.
+func Version(cmd *cobra.Command, _ []string) {
+	fmt.Fprint(cmd.OutOrStdout(), version.Get().Details())
+}
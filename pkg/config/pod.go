@@ -0,0 +1,51 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"hash/fnv"
+	"os"
+	"time"
+)
+
+// PodName returns the name of the pod this sidecar runs in, from the POD_NAME
+// environment variable (downward API) or the hostname.
+func PodName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+
+	name, _ := os.Hostname()
+
+	return name
+}
+
+// Jitter returns a stable delay below limit, in whole seconds, derived from
+// the pod name so that many sidecars sharing a schedule or interval do not
+// all act at the same moment.
+func Jitter(limit time.Duration) time.Duration {
+	seconds := uint32(limit / time.Second)
+	if seconds == 0 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(PodName()))
+
+	return time.Duration(h.Sum32()%seconds) * time.Second
+}
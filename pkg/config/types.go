@@ -18,11 +18,31 @@
 package config
 
 type Destination struct {
-	Name string // Object Name (Defaults to file name)
-	Path string // Object Path Relative to Bucket (Defaults to path)
-	Type string // Object Mime Type (Defaults to auto discover by extension, )
+	Name          string            // Object Name (Defaults to file name)
+	Path          string            // Object Path Relative to Bucket (Defaults to path)
+	Type          string            // Object Mime Type (Defaults to auto discover by extension, )
+	Tags          map[string]string // Object Tags (Defaults to none)
+	Metadata      map[string]string // Object User Metadata (Defaults to none)
+	StorageClass  string            // Object Storage Class (Defaults to minio.storage-class)
+	ShardWidth    int               // Hex characters of a hash-based subprefix inserted before Name (Defaults to 0, disabled)
+	Partition     string            // Date layout appended to Path, e.g. 2006/01/02 or daily (Defaults to none)
+	PartitionBy   string            // Time the partition is taken from, upload or mtime (Defaults to upload)
+	Version       string            // Inserted before the extension of Name to keep every upload (Defaults to none)
+	Compression   string            // Compress objects with gzip unless already compressed (Defaults to none)
+	SkipUnchanged string            // Skip uploads matching the remote object by size-mtime or checksum (Defaults to none)
+	Filters       []string          // Shell commands the file is piped through, in order, before upload (Defaults to none)
+	ChunkSize     int64             // Average bytes of content-defined chunks files this large are split into, uploading only new chunks (Defaults to 0, whole files)
+	Append        bool              // Upload only Length bytes from Offset, a segment of a file that only grows (Defaults to false, whole file)
+	Offset        int64             // Start of the segment uploaded with Append
+	Length        int64             // Bytes of the segment uploaded with Append
 }
 
-type mc struct{} // Key for context
+type (
+	mc       struct{} // Key for context
+	profiles struct{} // Key for context
+)
 
-var MC = mc{}
+var (
+	MC       = mc{}
+	Profiles = profiles{} // map[string]minio.MinioClient of named profiles
+)
@@ -0,0 +1,253 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/minio/sio"
+	"github.com/spf13/viper"
+)
+
+const (
+	TypeAge = "age"
+	TypeAES = "aes"
+
+	// MetadataKey is the user metadata key recording how an object was encrypted.
+	MetadataKey = "Client-Encryption"
+
+	aesKeySize = 32
+
+	hashKeyLabel = "minio-backup-sidecar hash key"
+)
+
+// ErrNoHashKey is returned by HashKey when encryption.hash-key-file is needed.
+var ErrNoHashKey = errors.New("encryption.hash-key-file must be set")
+
+// Encryptor encrypts upload streams before they leave the sidecar.
+type Encryptor interface {
+	Type() string
+	EncryptReader(src io.Reader) (io.ReadCloser, error)
+}
+
+type ageEncryptor struct {
+	recipients []age.Recipient
+}
+
+type aesEncryptor struct {
+	key []byte
+}
+
+// New returns the Encryptor configured by encryption.type, or nil if client-side
+// encryption is disabled.
+func New() (Encryptor, error) {
+	switch strings.ToLower(viper.GetString("encryption.type")) {
+	case "", "none":
+		return nil, nil
+	case TypeAge:
+		recipients, err := ageRecipients()
+		if err != nil {
+			return nil, err
+		}
+
+		return &ageEncryptor{recipients: recipients}, nil
+	case TypeAES:
+		key, err := aesKey()
+		if err != nil {
+			return nil, err
+		}
+
+		return &aesEncryptor{key: key}, nil
+	default:
+		return nil, fmt.Errorf("unknown encryption.type %s", viper.GetString("encryption.type"))
+	}
+}
+
+// DecryptReader decrypts src which was encrypted with the given type, using
+// encryption.age-identity-file or encryption.key-file.
+func DecryptReader(src io.Reader, typ string) (io.Reader, error) {
+	switch strings.ToLower(typ) {
+	case TypeAge:
+		f, err := os.Open(viper.GetString("encryption.age-identity-file"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read age identity: %w", err)
+		}
+		defer f.Close()
+
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse age identity: %w", err)
+		}
+
+		r, err := age.Decrypt(src, identities...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt: %w", err)
+		}
+
+		return r, nil
+	case TypeAES:
+		key, err := aesKey()
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := sio.DecryptReader(src, sio.Config{Key: key})
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt: %w", err)
+		}
+
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unknown encryption type %s", typ)
+	}
+}
+
+// HashKey returns the key of the hash naming content-addressed objects, such
+// as chunks, so their names do not reveal the hash of their content. It is
+// read from encryption.hash-key-file, or derived from the aes key, and is nil
+// when client-side encryption is disabled. age has no secret to derive it
+// from, so it needs the file.
+func HashKey() ([]byte, error) {
+	if file := viper.GetString("encryption.hash-key-file"); file != "" {
+		return ReadKey(file)
+	}
+
+	switch strings.ToLower(viper.GetString("encryption.type")) {
+	case "", "none":
+		return nil, nil
+	case TypeAES:
+		key, err := aesKey()
+		if err != nil {
+			return nil, err
+		}
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(hashKeyLabel))
+
+		return mac.Sum(nil), nil
+	default:
+		return nil, fmt.Errorf("encryption.type %s: %w", viper.GetString("encryption.type"), ErrNoHashKey)
+	}
+}
+
+func ageRecipients() ([]age.Recipient, error) {
+	var recipients []age.Recipient
+
+	for _, r := range viper.GetStringSlice("encryption.age-recipients") {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %s: %w", r, err)
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	if file := viper.GetString("encryption.age-recipients-file"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read age recipients: %w", err)
+		}
+
+		r, err := age.ParseRecipients(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse age recipients: %w", err)
+		}
+
+		recipients = append(recipients, r...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("encryption.type age requires encryption.age-recipients or encryption.age-recipients-file")
+	}
+
+	return recipients, nil
+}
+
+func aesKey() ([]byte, error) {
+	file := viper.GetString("encryption.key-file")
+	if file == "" {
+		return nil, errors.New("encryption.type aes requires encryption.key-file")
+	}
+
+	return ReadKey(file)
+}
+
+// ReadKey reads a 256 bit key from file, either raw or hex encoded.
+func ReadKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read encryption key: %w", err)
+	}
+
+	if len(data) == aesKeySize {
+		return data, nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != aesKeySize {
+		return nil, fmt.Errorf("encryption key in %s must be 32 bytes, raw or hex encoded", file)
+	}
+
+	return key, nil
+}
+
+func (e *ageEncryptor) Type() string {
+	return TypeAge
+}
+
+func (e *ageEncryptor) EncryptReader(src io.Reader) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	// age writes its header on Encrypt, so it must run alongside the reader
+	go func() {
+		w, err := age.Encrypt(pw, e.recipients...)
+		if err == nil {
+			_, err = io.Copy(w, src)
+		}
+
+		if err == nil {
+			err = w.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func (e *aesEncryptor) Type() string {
+	return TypeAES
+}
+
+func (e *aesEncryptor) EncryptReader(src io.Reader) (io.ReadCloser, error) {
+	r, err := sio.EncryptReader(src, sio.Config{Key: e.key})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt: %w", err)
+	}
+
+	return io.NopCloser(r), nil
+}
@@ -0,0 +1,220 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package events records notable occurrences as Kubernetes Events on the pod
+// the sidecar runs in, so they show up in kubectl describe pod.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/kube"
+	"k8s.io/klog/v2"
+)
+
+const (
+	component      = "minio-backup-sidecar"
+	queueSize      = 100
+	maxMessage     = 1024
+	requestTimeout = 10 * time.Second
+
+	// repeatWindow suppresses identical events, which kubectl would otherwise show many times.
+	repeatWindow = 10 * time.Minute
+)
+
+// Reasons events are recorded for.
+const (
+	ReasonBackupCompleted   = "BackupCompleted"
+	ReasonBackupFailed      = "BackupFailed"
+	ReasonBackupStale       = "BackupStale"
+	ReasonWatchFailed       = "WatchFailed"
+	ReasonPathRemoved       = "WatchedPathRemoved"
+	ReasonTargetUnreachable = "TargetUnreachable"
+	ReasonTargetReachable   = "TargetReachable"
+	ReasonUploadsPaused     = "UploadsPaused"
+	ReasonUploadsResumed    = "UploadsResumed"
+)
+
+type objectReference struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type event struct {
+	Metadata struct {
+		GenerateName string `json:"generateName"`
+		Namespace    string `json:"namespace"`
+	} `json:"metadata"`
+	InvolvedObject objectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Source         struct {
+		Component string `json:"component"`
+		Host      string `json:"host,omitempty"`
+	} `json:"source"`
+	FirstTimestamp     time.Time `json:"firstTimestamp"`
+	LastTimestamp      time.Time `json:"lastTimestamp"`
+	Count              int       `json:"count"`
+	ReportingComponent string    `json:"reportingComponent"`
+	ReportingInstance  string    `json:"reportingInstance"`
+}
+
+type recorder struct {
+	path   string
+	pod    objectReference
+	client *kube.Client
+	queue  chan event
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+var rec *recorder
+
+// Init starts recording events on the pod named by POD_NAME in the namespace
+// from POD_NAMESPACE or the service account, using the in-cluster API
+// server. Events are sent in the background until ctx is done.
+func Init(ctx context.Context) error {
+	client, err := kube.InCluster(requestTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to record kubernetes events: %w", err)
+	}
+
+	namespace := client.Namespace()
+
+	r := &recorder{
+		path: fmt.Sprintf("/api/v1/namespaces/%s/events", namespace),
+		pod: objectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Name:       config.PodName(),
+			Namespace:  namespace,
+			UID:        os.Getenv("POD_UID"),
+		},
+		client: client,
+		queue:  make(chan event, queueSize),
+		sent:   make(map[string]time.Time),
+	}
+
+	go r.run(ctx)
+
+	rec = r
+
+	klog.V(2).InfoS("recording kubernetes events", "pod", r.pod.Name, "namespace", namespace)
+
+	return nil
+}
+
+// Normal records an informational event. It is a no-op unless Init succeeded.
+func Normal(reason, format string, args ...any) {
+	record("Normal", reason, fmt.Sprintf(format, args...))
+}
+
+// Warning records an event for a problem. It is a no-op unless Init succeeded.
+func Warning(reason, format string, args ...any) {
+	record("Warning", reason, fmt.Sprintf(format, args...))
+}
+
+func record(eventType, reason, message string) {
+	r := rec
+	if r == nil {
+		return
+	}
+
+	if len(message) > maxMessage {
+		message = message[:maxMessage]
+	}
+
+	now := time.Now()
+	key := reason + "\x00" + message
+
+	r.mu.Lock()
+	if last, ok := r.sent[key]; ok && now.Sub(last) < repeatWindow {
+		r.mu.Unlock()
+		return
+	}
+
+	r.sent[key] = now
+
+	for k, t := range r.sent {
+		if now.Sub(t) >= repeatWindow {
+			delete(r.sent, k)
+		}
+	}
+	r.mu.Unlock()
+
+	e := event{
+		InvolvedObject:     r.pod,
+		Reason:             reason,
+		Message:            message,
+		Type:               eventType,
+		FirstTimestamp:     now,
+		LastTimestamp:      now,
+		Count:              1,
+		ReportingComponent: component,
+		ReportingInstance:  r.pod.Name,
+	}
+	e.Metadata.GenerateName = r.pod.Name + "."
+	e.Metadata.Namespace = r.pod.Namespace
+	e.Source.Component = component
+	e.Source.Host = os.Getenv("NODE_NAME")
+
+	select {
+	case r.queue <- e:
+	default:
+		klog.V(2).InfoS("event queue full, dropping event", "reason", reason, "message", message)
+	}
+}
+
+func (r *recorder) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-r.queue:
+			if err := r.send(ctx, e); err != nil {
+				klog.V(2).ErrorS(err, "unable to record event", "reason", e.Reason)
+			}
+		}
+	}
+}
+
+func (r *recorder) send(ctx context.Context, e event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to encode event: %w", err)
+	}
+
+	resp, err := r.client.Do(ctx, http.MethodPost, r.path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to send event: %w", err)
+	}
+
+	return resp.Body.Close()
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	accessRead  = unix.R_OK
+	accessWrite = unix.W_OK
+	accessExec  = unix.X_OK
+)
+
+// checkAccess reports whether the current user has mode access to file.
+func checkAccess(file string, mode uint32) error {
+	return unix.Access(file, mode)
+}
+
+// owner returns the uid and gid owning info.
+func owner(info os.FileInfo) (uint32, uint32, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid, true
+	}
+
+	return 0, 0, false
+}
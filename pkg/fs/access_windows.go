@@ -0,0 +1,47 @@
+//go:build windows
+
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import "os"
+
+const (
+	accessRead uint32 = 1 << iota
+	accessWrite
+	accessExec
+)
+
+// checkAccess only checks read access by opening file, write access is
+// governed by ACLs and left to the upload or delete itself.
+func checkAccess(file string, mode uint32) error {
+	if mode&accessRead == 0 {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// owner does nothing, Windows files have no uid or gid.
+func owner(os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
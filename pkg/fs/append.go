@@ -0,0 +1,141 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+// headSize bounds the bytes at the start of an appended file hashed to tell
+// a file that grew from one that replaced it, such as after log rotation.
+const headSize = 4096
+
+// errNothingAppended skips uploads of appended files that did not grow.
+var errNothingAppended = errors.New("nothing appended")
+
+func validateAppend(p *Path) error {
+	switch {
+	case p.Snapshot != "":
+		return fmt.Errorf("cannot use append with snapshot: %s", p.Path)
+	case p.Archive != "":
+		return fmt.Errorf("cannot use append with archive: %s", p.Path)
+	case p.RemoteDelete != "":
+		return fmt.Errorf("cannot use append with remote-delete, segments are never deleted: %s", p.Path)
+	case p.DeleteOnSuccess:
+		return fmt.Errorf("cannot use append with delete-on-success: %s", p.Path)
+	case p.Destination.ChunkSize > 0:
+		return fmt.Errorf("cannot use append with destination.chunk-size: %s", p.Path)
+	case p.Destination.SkipUnchanged != "":
+		return fmt.Errorf("cannot use skip-unchanged with append, only appended bytes are uploaded: %s", p.Path)
+	}
+
+	return nil
+}
+
+// appendSegment sets dest to upload the bytes of file appended since its
+// last upload, size bytes long now, as the next numbered segment. A file
+// that shrank or whose first bytes changed was replaced, so it is uploaded
+// from the start, which restore takes as the start of a new file. Without a
+// recorded upload, the sequence continues after the last segment in the
+// bucket. It returns the state to record once the segment is uploaded, or
+// errNothingAppended when the file did not grow.
+func appendSegment(p *Path, file string, size int64, dest *config.Destination, ctx context.Context) (state.FileState, error) {
+	var (
+		offset int64
+		seq    int64
+	)
+
+	head, headLen, err := hashHead(file, min(size, headSize))
+	if err != nil {
+		return state.FileState{}, err
+	}
+
+	last, ok := state.LastUpload(p.Path, file)
+	if ok && last.Sequence > 0 {
+		seq = last.Sequence
+
+		switch {
+		case size < last.Size:
+			klog.InfoS("appended file shrank, uploading it from the start", "file", file, "size", size, "uploaded", last.Size)
+		case !sameHead(file, last, head, headLen):
+			klog.InfoS("appended file was replaced, uploading it from the start", "file", file)
+		case size == last.Size:
+			return state.FileState{}, errNothingAppended
+		default:
+			offset = last.Size
+		}
+	} else {
+		if seq, err = lastSequence(p, file, *dest, ctx); err != nil {
+			return state.FileState{}, err
+		}
+	}
+
+	seq++
+
+	dest.Append = true
+	dest.Offset = offset
+	dest.Length = size - offset
+	dest.Version = fmt.Sprintf("%08d", seq)
+
+	return state.FileState{Sequence: seq, Head: head, HeadSize: headLen}, nil
+}
+
+// sameHead reports whether file starts with the bytes hashed for last. head
+// covers the first headLen bytes of file now, which are hashed again when
+// last covered fewer of them.
+func sameHead(file string, last state.FileState, head string, headLen int64) bool {
+	if last.Head == "" {
+		return true
+	}
+
+	if last.HeadSize != headLen {
+		h, _, err := hashHead(file, last.HeadSize)
+		if err != nil {
+			return false
+		}
+
+		head = h
+	}
+
+	return head == last.Head
+}
+
+// hashHead returns the sha256 of the first n bytes of file, and n.
+func hashHead(file string, n int64) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", 0, fmt.Errorf("unable to read %s: %w", file, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,258 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+const (
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+type archiveNameData struct {
+	Name string    // Base name of the archived directory
+	Time time.Time // Start of the backup run (UTC)
+}
+
+func parseArchive(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", "none":
+		return "", nil
+	case "tar":
+		return archiveTar, nil
+	case "tar.gz", "tgz", "gzip":
+		return archiveTarGz, nil
+	default:
+		return "", fmt.Errorf("unknown archive format %s", format)
+	}
+}
+
+func archiveName(p *Path, t time.Time) (string, error) {
+	tmpl, err := template.New("archive-name").Parse(p.ArchiveName)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive-name template: %w", err)
+	}
+
+	var b bytes.Buffer
+
+	if err := tmpl.Execute(&b, archiveNameData{Name: filepath.Base(p.Path), Time: t.UTC()}); err != nil {
+		return "", fmt.Errorf("invalid archive-name template: %w", err)
+	}
+
+	return b.String() + "." + p.Archive, nil
+}
+
+// uploadArchive packages every included file under p into a single archive
+// and uploads it as one object.
+func uploadArchive(p *Path, ctx context.Context) {
+	if deferIfPaused("archive:"+p.Path, p.Path, p.Path, ctx, func(ctx context.Context) { uploadArchive(p, ctx) }) {
+		return
+	}
+
+	parent := ctx
+
+	ctx, cancel := uploadContext(minio.WithPriority(ctx, p.Priority))
+	defer cancel()
+
+	name, err := archiveName(p, time.Now())
+	if err != nil {
+		klog.ErrorS(err, "unable to name archive", "path", p.Path)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "minio-backup-*."+p.Archive)
+	if err != nil {
+		klog.ErrorS(err, "unable to create archive", "path", p.Path)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	defer os.Remove(tmp.Name())
+
+	files, err := writeArchive(p, tmp, ctx)
+	if err != nil {
+		klog.ErrorS(err, "unable to create archive", "path", p.Path)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	if len(files) == 0 {
+		klog.V(2).InfoS("no files to archive", "path", p.Path)
+		return
+	}
+
+	contentType := "application/x-tar"
+	if p.Archive == archiveTarGz {
+		contentType = "application/gzip"
+	}
+
+	dest := config.Destination{
+		Name:         name,
+		Path:         p.Destination.Path,
+		Type:         contentType,
+		Tags:         p.Destination.Tags,
+		StorageClass: p.Destination.StorageClass,
+		ShardWidth:   p.Destination.ShardWidth,
+		Partition:    p.Destination.Partition,
+		Compression:  p.Destination.Compression,
+		Metadata:     MergeTags(p.Destination.Metadata, map[string]string{minio.MetadataSourcePath: p.Path}),
+	}
+	dest = partitioned(p, dest, "")
+
+	klog.V(2).InfoS("uploading archive", "path", p.Path, "files", len(files), "object", name)
+
+	if err := clientFor(p, ctx).UploadFileWithDestination(tmp.Name(), dest, ctx); err != nil {
+		if errors.Is(err, minio.ErrCircuitOpen) {
+			deferUpload("archive:"+p.Path, p.Path, p.Path, minio.PendingTargets(parent, err), func(ctx context.Context) { uploadArchive(p, ctx) })
+			return
+		}
+
+		klog.V(4).ErrorS(err, "failed upload", "archive", name, "fsPath", p)
+		events.Warning(events.ReasonBackupFailed, "upload of archive %s for %s failed: %v", name, p.Path, err)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		klog.ErrorS(err, "unable to stat archive", "archive", name)
+		recordUploaded(p.Path, p.Path, minio.ObjectName(tmp.Name(), dest), 0, "", ctx)
+
+		return
+	}
+
+	recordUploaded(p.Path, p.Path, minio.ObjectName(tmp.Name(), dest), info.Size(), "", ctx)
+	state.RecordUpload(p.Path, info.Size())
+
+	if p.DeleteOnSuccess && verifiedUpload(p, tmp.Name(), dest, info.Size(), ctx) {
+		for file, archived := range files {
+			fi, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+
+			if fi.Size() != archived.Size() || !fi.ModTime().Equal(archived.ModTime()) {
+				klog.InfoS("file changed since archived, keeping it", "file", file)
+				continue
+			}
+
+			removeFile(p, file, fi.Size(), "delete-on-success")
+		}
+	}
+}
+
+// writeArchive writes the files under p to f and returns the archived paths
+// with their info when archived.
+func writeArchive(p *Path, f *os.File, ctx context.Context) (map[string]os.FileInfo, error) {
+	var w io.WriteCloser = f
+
+	files := map[string]os.FileInfo{}
+
+	if p.Archive == archiveTarGz {
+		w = gzip.NewWriter(f)
+	}
+
+	tw := tar.NewWriter(w)
+
+	err := walk(ctx, p, nil, func(file string, _ fs.DirEntry) error {
+		if !p.included(file) {
+			return nil
+		}
+
+		info, err := addToArchive(tw, p.Path, file)
+		if err != nil {
+			return err
+		}
+
+		files[file] = info
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to write archive: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("unable to write archive: %w", err)
+	}
+
+	return files, nil
+}
+
+func addToArchive(tw *tar.Writer, root, file string) (os.FileInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %w", file, err)
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to archive %s: %w", file, err)
+	}
+
+	if hdr.Name, err = filepath.Rel(root, file); err != nil {
+		hdr.Name = filepath.Base(file)
+	}
+
+	hdr.Name = filepath.ToSlash(hdr.Name)
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, fmt.Errorf("unable to archive %s: %w", file, err)
+	}
+
+	if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+		return nil, fmt.Errorf("unable to archive %s: %w", file, err)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,203 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+// Strategies for an object key already holding the upload of another file.
+const (
+	collisionOverwrite = "overwrite"
+	collisionSkip      = "skip"
+	collisionFail      = "fail"
+	collisionRename    = "rename"
+)
+
+// maxRenames bounds the suffixes tried for a renamed upload.
+const maxRenames = 1000
+
+// errCollision skips or fails uploads whose object is another file's.
+var errCollision = errors.New("object already exists")
+
+// claims records the file each object key checked for collisions was given
+// to, so files uploaded concurrently cannot both find the key free. Each
+// Process has its own; outside of one every key is free to claim.
+type claims struct {
+	mu    sync.Mutex
+	owner map[string]string
+}
+
+// claimsFrom returns the claims of the Process running ctx, or nil.
+func claimsFrom(ctx context.Context) *claims {
+	if p := processFrom(ctx); p != nil {
+		return p.claims
+	}
+
+	return nil
+}
+
+// ownerOf returns the file key was given to, if any.
+func (c *claims) ownerOf(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, ok := c.owner[key]
+
+	return file, ok
+}
+
+// claim gives key to file unless another file holds it.
+func (c *claims) claim(key, file string) bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if owner, ok := c.owner[key]; ok && owner != file {
+		return false
+	}
+
+	c.owner[key] = file
+
+	return true
+}
+
+// release gives up every key held by file, which no longer exists.
+func (c *claims) release(file string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, owner := range c.owner {
+		if owner == file {
+			delete(c.owner, key)
+		}
+	}
+}
+
+// forgetFile drops the state of file, which no longer exists, and the keys
+// it claimed, so they can be given to other files.
+func forgetFile(p *Path, file string, ctx context.Context) {
+	state.ForgetFile(p.Path, file)
+	claimsFrom(ctx).release(file)
+}
+
+func parseCollision(collision string) (string, error) {
+	switch strings.ToLower(collision) {
+	case "", collisionOverwrite:
+		return "", nil
+	case collisionSkip, collisionFail, collisionRename:
+		return strings.ToLower(collision), nil
+	default:
+		return "", fmt.Errorf("unknown collision %s", collision)
+	}
+}
+
+// resolveCollision checks the object dest names for file before it is
+// uploaded. An object last uploaded from file itself, by the recorded state
+// or its source path metadata, is replaced as usual. The object of another
+// file returns errCollision, or with rename sets dest to the first name
+// suffixed with a number that is free or already holds file. The source
+// path is recorded on the upload whether or not provenance is, so the
+// object is still known to be file's once the state is lost.
+func resolveCollision(p *Path, file string, dest *config.Destination, ctx context.Context) error {
+	if p.Collision == "" {
+		return nil
+	}
+
+	candidate := *dest
+
+	for n := 0; n <= maxRenames; n++ {
+		if n > 0 {
+			candidate.Version = strconv.Itoa(n)
+		}
+
+		free, err := ownedBy(p, file, minio.ObjectName(file, candidate), ctx)
+		if err != nil {
+			return err
+		}
+
+		if free {
+			candidate.Metadata = MergeTags(candidate.Metadata, map[string]string{minio.MetadataSourcePath: sourcePath(file)})
+			*dest = candidate
+
+			return nil
+		}
+
+		if p.Collision != collisionRename {
+			return fmt.Errorf("%w: %s", errCollision, minio.ObjectName(file, candidate))
+		}
+	}
+
+	return fmt.Errorf("%w: %s and %d renamed objects", errCollision, minio.ObjectName(file, *dest), maxRenames)
+}
+
+// ownedBy reports whether key may be written by an upload of file: no
+// object exists, or it was uploaded from file.
+func ownedBy(p *Path, file, key string, ctx context.Context) (bool, error) {
+	claimed := claimsFrom(ctx)
+
+	if owner, ok := claimed.ownerOf(key); ok {
+		return owner == file, nil
+	}
+
+	if last, ok := state.LastUpload(p.Path, file); ok && last.Object == key {
+		return claimed.claim(key, file), nil
+	}
+
+	info, err := clientFor(p, ctx).Stat(ctx, key)
+	if minio.IsNotFound(err) {
+		return claimed.claim(key, file), nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("unable to check for object %s: %w", key, err)
+	}
+
+	return info.UserMetadata[minio.MetadataSourcePath] == sourcePath(file) && claimed.claim(key, file), nil
+}
+
+// sourcePath returns the absolute path of file recorded as the source of its
+// upload.
+func sourcePath(file string) string {
+	source, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+
+	return source
+}
@@ -0,0 +1,160 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+func TestCollisionAfterRestart(t *testing.T) {
+	tests := []struct {
+		collision   string
+		wantOwn     string // version of the file's own upload after the restart
+		wantOther   string // version given to another file with the same name
+		wantOtherEr bool
+	}{
+		{collisionSkip, "", "", true},
+		{collisionFail, "", "", true},
+		{collisionRename, "", "1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.collision, func(t *testing.T) {
+			client := minio.NewFake("fake")
+			ctx := restart(t, context.WithValue(context.Background(), config.MC, minio.MinioClient(client)))
+
+			p := collisionPath(t, tt.collision)
+			file := filepath.Join(p.Path, "db.sql")
+
+			dest := p.Destination
+			if err := resolveCollision(p, file, &dest, ctx); err != nil {
+				t.Fatalf("resolveCollision before upload: %v", err)
+			}
+
+			if err := client.UploadFileWithDestination(file, dest, ctx); err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+
+			ctx = restart(t, ctx)
+
+			dest = p.Destination
+			if err := resolveCollision(p, file, &dest, ctx); err != nil || dest.Version != tt.wantOwn {
+				t.Errorf("own upload after restart resolved to version %q, %v, want %q", dest.Version, err, tt.wantOwn)
+			}
+
+			other := collisionPath(t, tt.collision)
+			other.Destination = p.Destination
+
+			dest = other.Destination
+			err := resolveCollision(other, filepath.Join(other.Path, "db.sql"), &dest, ctx)
+
+			if errors.Is(err, errCollision) != tt.wantOtherEr || dest.Version != tt.wantOther {
+				t.Errorf("another file resolved to version %q, %v, want %q", dest.Version, err, tt.wantOther)
+			}
+		})
+	}
+}
+
+// restart drops the in-memory state and returns ctx running in a new
+// process, without the claims of the previous one.
+func restart(t *testing.T, ctx context.Context) context.Context {
+	t.Helper()
+
+	if err := state.Init(""); err != nil {
+		t.Fatalf("state.Init: %v", err)
+	}
+
+	return context.WithValue(ctx, processKey{}, newProcess())
+}
+
+// collisionPath returns a path with collision for a new directory holding
+// db.sql.
+func collisionPath(t *testing.T, collision string) *Path {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db.sql"), []byte(dir), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	p, err := NewPath(dir)
+	if err != nil {
+		t.Fatalf("NewPath: %v", err)
+	}
+
+	p.Collision = collision
+	p.Destination.Path = "backups"
+
+	return p
+}
+
+func TestCollisionKeyReusedAfterRemoval(t *testing.T) {
+	tests := []struct {
+		name      string
+		remove    bool
+		wantOther bool // another file with the same name may take the key
+	}{
+		{"kept", false, false},
+		{"removed", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := minio.NewFake("fake")
+			ctx := restart(t, context.WithValue(context.Background(), config.MC, minio.MinioClient(client)))
+
+			p := collisionPath(t, collisionFail)
+			p.RemoteDelete = remoteDeleteObject
+			file := filepath.Join(p.Path, "db.sql")
+
+			dest := p.Destination
+			if err := resolveCollision(p, file, &dest, ctx); err != nil {
+				t.Fatalf("resolveCollision before upload: %v", err)
+			}
+
+			if err := client.UploadFileWithDestination(file, dest, ctx); err != nil {
+				t.Fatalf("upload: %v", err)
+			}
+
+			if tt.remove {
+				if err := os.Remove(file); err != nil {
+					t.Fatalf("Remove: %v", err)
+				}
+
+				callDelete(p, file, ctx)
+			}
+
+			other := collisionPath(t, collisionFail)
+			other.Destination = p.Destination
+
+			dest = other.Destination
+			err := resolveCollision(other, filepath.Join(other.Path, "db.sql"), &dest, ctx)
+
+			if (err == nil) != tt.wantOther {
+				t.Errorf("another file resolved with %v, want free = %v", err, tt.wantOther)
+			}
+		})
+	}
+}
@@ -18,166 +18,187 @@
 package fs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/csfreak/minio-backup-sidecar/pkg/config"
-	"github.com/spf13/viper"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/robfig/cron/v3"
 	"k8s.io/klog/v2"
 )
 
+// maxShardWidth bounds shard prefixes to 16^4 subprefixes.
+const maxShardWidth = 4
+
+// Config processes a set of paths.
 type Config struct {
-	Paths []*fsPath
+	Paths []*Path
+
+	opts      Options
+	ctx       atomic.Pointer[context.Context] // Set while processing, for Flush
+	ready     chan struct{}
+	started   atomic.Pointer[time.Time] // When processing began, the last success of paths that never uploaded
+	staleness staleness
 }
 
+// Events selects the changes a watched path acts on.
 type Events struct {
 	Create bool
 	Write  bool
 	Remove bool
 }
 
-type fsPath struct {
-	DeleteOnSuccess bool    // Delete files after successful upload
-	Watch           bool    // Watch Path or process once (Defaults to true)
-	WaitTime        int     // Tme in Seconds to wait for changes to file before action
-	Recursive       bool    // Watch Path Recursively (only applies if Path is a Directory) (Defaults to false)
-	Path            string  // Path of File or Directory
-	Events          *Events // What Events to Watch (Create, Write, Remove) (only applies if Watch = True)
+// Path configures how a file or directory is backed up.
+type Path struct {
+	Enabled         bool          // Process this path (Defaults to true)
+	DeleteOnSuccess bool          // Delete files after successful upload
+	Watch           bool          // Watch Path or process once (Defaults to true)
+	WatchMode       string        // How changes are detected (inotify, poll) (Defaults to inotify)
+	PollInterval    int           // Time in Seconds between scans when WatchMode is poll
+	WaitTime        int           // Time in Seconds to wait for changes to file before action (Defaults to 5)
+	MaxWaitTime     int           // Longest wait in Seconds while a file keeps changing with adaptive Debounce (Defaults to 300)
+	Debounce        string        // How the wait adapts to a file changing (fixed, adaptive) (Defaults to fixed)
+	MaxPending      int           // Changed files waiting for WaitTime before changes to others are dropped and Path rescanned (Defaults to 10000)
+	Recursive       bool          // Watch Path Recursively (only applies if Path is a Directory) (Defaults to false)
+	MaxDepth        int           // Levels of subdirectories processed when Recursive (Defaults to 0, unlimited)
+	InitialScan     bool          // Upload files changed since their last recorded upload when watching starts (Defaults to true)
+	ChangedOnly     bool          // Skip files unchanged since their last recorded upload in one-shot runs (Defaults to false)
+	Path            string        // Path of File or Directory
+	Events          *Events       // What Events to Watch (Create, Write, Remove) (only applies if Watch = True)
+	Include         []string      // Only process files matching one of these patterns (Defaults to all files)
+	Exclude         []string      // Never process files matching one of these patterns
+	TempPatterns    []string      // Files written before being renamed into place, which are never processed
+	DedupeWindow    int           // Time in Seconds during which identical content is not re-uploaded (Defaults to 0, disabled)
+	MaxStaleness    time.Duration // Longest time without a successful upload before Path is reported stale (Defaults to 0, disabled)
+	LocalRetention  time.Duration // Time after a successful upload that unchanged files are removed locally (Defaults to 0, disabled)
+	Schedule        string        // Cron schedule for full backups of Path (Defaults to none)
+	Archive         string        // Upload directories as a single archive per run (tar, tar.gz) (Defaults to none)
+	ArchiveName     string        // Template for archive object names, extension is appended
+	TombstoneSuffix string        // Write an object named after removed files with this suffix (Defaults to none)
+	RemoteDelete    string        // Delete the objects of removed files, or move them to trash (delete, trash) (Defaults to none)
+	Manifests       bool          // Upload a manifest listing every file of each scan (Defaults to false)
+	OverrideSuffix  string        // Read destination overrides of a file from the file named with this suffix (Defaults to none)
+	Snapshot        string        // Keep every upload under a new name suffixed with a timestamp or sequence (Defaults to none, overwrite)
+	Append          bool          // Upload only the bytes appended since the last upload of files that only grow, as numbered segments (Defaults to false)
+	Collision       string        // What to do when the object of a file holds another file (overwrite, skip, fail, rename) (Defaults to overwrite)
+	Profile         string        // Upload to the target of this profile (Defaults to none, the minio target)
+	Priority        int           // Uploads of paths with a higher priority take free upload slots first (Defaults to 0)
+	Group           []string      // Upload files matching these patterns as one set once each pattern matches a file (Defaults to none)
+	Rotation        bool          // Upload logs once when logrotate rotates them rather than on every write (Defaults to false)
 	Destination     config.Destination
 }
 
-func New() (*Config, error) {
-	c := &Config{}
+// Options configures processing of paths.
+type Options struct {
+	Paths           []*Path
+	ShutdownTimeout time.Duration // Time to upload pending changes once processing is asked to stop
+	ScheduleJitter  int           // Maximum delay in Seconds added to scheduled runs and the first tick of periodic work, derived from the pod name
+	AllowReadOnly   bool          // Skip delete-on-success instead of failing on read-only filesystems
+	FailFast        bool          // Stop a one-shot run at the first failed file
 
-	if viper.IsSet("path") {
-		for _, p := range viper.GetStringSlice("path") {
-			fsp, err := newPath(p)
-			if err != nil {
-				klog.ErrorS(err, "error processing path")
-			} else {
-				if viper.IsSet("destination.name") {
-					if fsp.Destination.Name != "" {
-						klog.Warningf("setting destination.name for directory %s may result in files being overwritten", fsp.Path)
-					}
-
-					fsp.Destination.Name = viper.GetString("destination.name")
-				}
+	Sources []Source // Backups produced by something other than files, such as database dumps
 
-				if viper.IsSet("destination.path") {
-					fsp.Destination.Path = viper.GetString("destination.path")
-				}
+	Client   minio.MinioClient            // Target of paths without a profile (Defaults to the one in the Process context)
+	Profiles map[string]minio.MinioClient // Targets by profile name (Defaults to those in the Process context)
+}
 
-				if viper.IsSet("destination.type") {
-					fsp.Destination.Path = viper.GetString("destination.type")
-				}
+// NewWithConfig validates opts and returns a Config processing its paths.
+// Paths may be adjusted to the settings that apply to them, e.g. watch
+// events are cleared for paths that are not watched.
+func NewWithConfig(opts Options) (*Config, error) {
+	c := &Config{Paths: opts.Paths, opts: opts, ready: make(chan struct{}), staleness: staleness{stale: make(map[string]bool)}}
+	c.setStarted(time.Now())
 
-				c.Paths = append(c.Paths, fsp)
-			}
-		}
+	if len(c.Paths) == 0 && len(opts.Sources) == 0 {
+		return nil, errors.New("no paths found")
 	}
 
-	for i := 0; viper.IsSet(fmt.Sprintf("files.%d.path", i)); i++ {
-		fsp, err := newPath(viper.GetString(fmt.Sprintf("files.%d.path", i)))
-		if err != nil {
-			klog.ErrorS(err, "error processing path")
-		} else {
-			if viper.IsSet(fmt.Sprintf("files.%d.watch", i)) {
-				fsp.Watch = viper.GetBool(fmt.Sprintf("files.%d.watch", i))
-			}
-
-			if viper.IsSet(fmt.Sprintf("files.%d.wait-time", i)) {
-				fsp.Watch = viper.GetBool(fmt.Sprintf("files.%d.wait-time", i))
-			}
-
-			if viper.IsSet(fmt.Sprintf("files.%d.recursive", i)) {
-				fsp.Recursive = viper.GetBool(fmt.Sprintf("files.%d.recursive", i))
-			}
-
-			if viper.IsSet(fmt.Sprintf("files.%d.events", i)) {
-				events, err := ParseEvents(viper.GetStringSlice(fmt.Sprintf("files.%d.events", i)))
-				if err != nil {
-					klog.ErrorS(err, "error processing path")
-					continue
-				}
-
-				fsp.Events = events
-			}
-
-			if viper.IsSet(fmt.Sprintf("files.%d.delete-on-success", i)) {
-				fsp.DeleteOnSuccess = viper.GetBool(fmt.Sprintf("files.%d.delete-on-success", i))
-			}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
 
-			if viper.IsSet("files.%d.destination.name") {
-				if fsp.Destination.Name != "" {
-					klog.Warningf("setting destination.name for directory %s may result in files being overwritten", fsp.Path)
-				}
+	if err := c.diagnose(); err != nil {
+		return nil, err
+	}
 
-				fsp.Destination.Name = viper.GetString(fmt.Sprintf("files.%d.destination.name", i))
-			}
+	return c, nil
+}
 
-			if viper.IsSet(fmt.Sprintf("files.%d.destination.path", i)) {
-				fsp.Destination.Path = viper.GetString(fmt.Sprintf("files.%d.destination.name", i))
-			}
+// Prefixes returns the destination prefix of every enabled path uploaded to
+// the minio target.
+func (c *Config) Prefixes() []string {
+	return c.ProfilePrefixes("")
+}
 
-			if viper.IsSet(fmt.Sprintf("files.%d.destination.type", i)) {
-				fsp.Destination.Type = viper.GetString(fmt.Sprintf("files.%d.destination.name", i))
-			}
+// ProfilePrefixes returns the destination prefix of every enabled path
+// uploaded to the target of profile.
+func (c *Config) ProfilePrefixes(profile string) []string {
+	prefixes := make([]string, 0, len(c.Paths))
 
-			c.Paths = append(c.Paths, fsp)
+	for _, p := range c.Paths {
+		if p.Enabled && p.Profile == profile && !slices.Contains(prefixes, p.Destination.Path) {
+			prefixes = append(prefixes, p.Destination.Path)
 		}
 	}
 
-	if len(c.Paths) == 0 {
-		return nil, errors.New("no paths found")
-	}
+	return prefixes
+}
 
-	if err := c.validate(); err != nil {
-		return nil, fmt.Errorf("invalid config: %v", err)
+// Profiles returns the profile of every enabled path that uploads to one.
+func (c *Config) Profiles() []string {
+	var profiles []string
+
+	for _, p := range c.Paths {
+		if p.Enabled && p.Profile != "" && !slices.Contains(profiles, p.Profile) {
+			profiles = append(profiles, p.Profile)
+		}
 	}
 
-	return c, nil
+	return profiles
 }
 
-func newPath(p string) (*fsPath, error) {
+// NewPath returns an enabled Path for the file or directory p, uploaded under
+// its own name or, for directories, under p. Other settings are left at their
+// zero value, so it is neither watched nor filtered.
+func NewPath(p string) (*Path, error) {
 	info, err := os.Stat(p)
 	if err != nil {
 		return nil, fmt.Errorf("unable to process path %s: %w", p, err)
 	}
 
-	var (
-		filename string
-		filepath string
-	)
+	var dir, filename string
 
 	if info.IsDir() {
-		filename = ""
-		filepath = p
+		dir = p
 	} else {
-		filepath, filename = path.Split(p)
-	}
-
-	events, err := ParseEvents(viper.GetStringSlice("watch-events"))
-	if err != nil {
-		return nil, err
+		dir, filename = filepath.Split(p)
 	}
 
-	return &fsPath{
-		Watch:           viper.GetBool("watch"),
-		WaitTime:        viper.GetInt("wait-time"),
-		Recursive:       viper.GetBool("recursive"),
-		DeleteOnSuccess: viper.GetBool("delete-on-success"),
-		Path:            p,
-		Events:          events,
+	return &Path{
+		Enabled: true,
+		Path:    p,
+		Events:  NewEvents(),
 		Destination: config.Destination{
 			Name: filename,
-			Path: filepath,
+			Path: keyPath(dir),
 		},
 	}, nil
 }
 
+// keyPath returns the local directory dir as an object key prefix, without
+// a volume name and with forward slashes.
+func keyPath(dir string) string {
+	return filepath.ToSlash(strings.TrimPrefix(dir, filepath.VolumeName(dir)))
+}
+
 func (e *Events) setEvent(name string) error {
 	switch strings.ToLower(name) {
 	case "create":
@@ -193,7 +214,8 @@ func (e *Events) setEvent(name string) error {
 	return nil
 }
 
-func newEvents() *Events {
+// NewEvents returns Events acting on no changes.
+func NewEvents() *Events {
 	return &Events{
 		Create: false,
 		Write:  false,
@@ -201,8 +223,10 @@ func newEvents() *Events {
 	}
 }
 
+// ParseEvents returns Events acting on the named changes (create, write,
+// remove, or their update and delete aliases).
 func ParseEvents(eventNames []string) (*Events, error) {
-	e := newEvents()
+	e := NewEvents()
 	for _, name := range eventNames {
 		err := e.setEvent(name)
 		if err != nil {
@@ -215,7 +239,48 @@ func ParseEvents(eventNames []string) (*Events, error) {
 
 func (c *Config) validate() error {
 	for _, p := range c.Paths {
+		if !p.Enabled {
+			continue
+		}
+
 		if p.Watch {
+			if p.WaitTime < 0 {
+				return fmt.Errorf("wait-time cannot be negative: %s", p.Path)
+			}
+
+			if p.MaxWaitTime != 0 && p.MaxWaitTime < p.WaitTime {
+				return fmt.Errorf("wait-time-max must not be less than wait-time: %s", p.Path)
+			}
+
+			if p.MaxPending < 0 {
+				return fmt.Errorf("max-pending cannot be negative: %s", p.Path)
+			}
+
+			debounce, err := parseDebounce(p.Debounce)
+			if err != nil {
+				return fmt.Errorf("invalid debounce for %s: %w", p.Path, err)
+			}
+
+			p.Debounce = debounce
+
+			switch {
+			case p.Debounce == DebounceAdaptive && p.MaxWaitTime == 0:
+				p.MaxWaitTime = max(defaultAdaptiveMaxWait, p.WaitTime)
+			case p.Debounce == DebounceFixed && p.MaxWaitTime != 0:
+				klog.Warningf("wait-time-max only applies with adaptive debounce, %s waits %ds", p.Path, p.WaitTime)
+			}
+
+			mode, err := parseWatchMode(p.WatchMode)
+			if err != nil {
+				return fmt.Errorf("invalid watch-mode for %s: %w", p.Path, err)
+			}
+
+			p.WatchMode = mode
+
+			if p.WatchMode == watchModePoll && p.PollInterval <= 0 {
+				return fmt.Errorf("poll-interval must be positive: %s", p.Path)
+			}
+
 			if err := checkDir(p.Path); err != nil {
 				if p.Recursive {
 					return fmt.Errorf("cannot recursively watch non-directory file: %s", p.Path)
@@ -230,14 +295,171 @@ func (c *Config) validate() error {
 				return fmt.Errorf("cannot set watch without any events: %s", p.Path)
 			}
 		} else {
-			p.Recursive = false
+			if p.Schedule == "" && p.Archive == "" {
+				p.Recursive = false
+			}
+
 			p.DeleteOnSuccess = false
-			p.Events = newEvents()
+			p.Events = NewEvents()
+		}
+
+		if p.MaxDepth < 0 {
+			return fmt.Errorf("max-depth cannot be negative: %s", p.Path)
+		}
+
+		if p.MaxStaleness < 0 {
+			return fmt.Errorf("max-staleness cannot be negative: %s", p.Path)
+		}
+
+		if p.LocalRetention < 0 {
+			return fmt.Errorf("local-retention cannot be negative: %s", p.Path)
+		}
+
+		archive, err := parseArchive(p.Archive)
+		if err != nil {
+			return fmt.Errorf("invalid archive for %s: %w", p.Path, err)
+		}
+
+		p.Archive = archive
+
+		if p.Archive != "" {
+			if err := checkDir(p.Path); err != nil {
+				return fmt.Errorf("cannot archive non-directory file: %s", p.Path)
+			}
+
+			if _, err := archiveName(p, time.Now()); err != nil {
+				return fmt.Errorf("invalid archive-name for %s: %w", p.Path, err)
+			}
+		}
+
+		if p.Schedule != "" {
+			if _, err := cron.ParseStandard(p.Schedule); err != nil {
+				return fmt.Errorf("invalid schedule %q for %s: %w", p.Schedule, p.Path, err)
+			}
+		}
+
+		if !minio.ValidCompression(p.Destination.Compression) {
+			return fmt.Errorf("unknown destination.compression %s for %s", p.Destination.Compression, p.Path)
+		}
+
+		if err := minio.ValidFilters(p.Destination.Filters); err != nil {
+			return fmt.Errorf("invalid filters for %s: %w", p.Path, err)
+		}
+
+		if p.Destination.ChunkSize != 0 && p.Destination.ChunkSize < minio.MinChunkSize {
+			return fmt.Errorf("destination.chunk-size must be at least %d for %s", minio.MinChunkSize, p.Path)
+		}
+
+		if !minio.ValidSkipUnchanged(p.Destination.SkipUnchanged) {
+			return fmt.Errorf("unknown skip-unchanged %s for %s", p.Destination.SkipUnchanged, p.Path)
+		}
+
+		if p.Destination.ShardWidth < 0 || p.Destination.ShardWidth > maxShardWidth {
+			return fmt.Errorf("destination.shard-width for %s must be between 0 and %d", p.Path, maxShardWidth)
+		}
+
+		if err := validatePartition(p.Destination.Partition, p.Destination.PartitionBy); err != nil {
+			return fmt.Errorf("invalid partition for %s: %w", p.Path, err)
+		}
+
+		snapshot, err := parseSnapshot(p.Snapshot)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot for %s: %w", p.Path, err)
+		}
+
+		p.Snapshot = snapshot
+
+		remoteDelete, err := parseRemoteDelete(p.RemoteDelete)
+		if err != nil {
+			return fmt.Errorf("invalid remote-delete for %s: %w", p.Path, err)
+		}
+
+		p.RemoteDelete = remoteDelete
+
+		if p.RemoteDelete != "" && !(p.Watch && p.Events.Remove) {
+			return fmt.Errorf("remote-delete requires watching remove/delete events: %s", p.Path)
+		}
+
+		if p.RemoteDelete != "" && p.Snapshot != "" {
+			return fmt.Errorf("cannot use remote-delete with snapshot, snapshots are never deleted: %s", p.Path)
+		}
+
+		if p.Snapshot != "" && p.Destination.SkipUnchanged != "" {
+			return fmt.Errorf("cannot use skip-unchanged with snapshot, every snapshot is a new object: %s", p.Path)
+		}
+
+		if p.Append {
+			if err := validateAppend(p); err != nil {
+				return err
+			}
+		}
+
+		if len(p.Group) > 0 {
+			if err := validateGroup(p); err != nil {
+				return err
+			}
+		}
+
+		if p.Rotation {
+			if err := validateRotation(p); err != nil {
+				return err
+			}
+		}
+
+		collision, err := parseCollision(p.Collision)
+		if err != nil {
+			return fmt.Errorf("invalid collision for %s: %w", p.Path, err)
+		}
+
+		p.Collision = collision
+
+		if p.Collision == collisionRename && (p.Snapshot != "" || p.Append) {
+			return fmt.Errorf("cannot use collision rename with snapshot or append, which already name each upload: %s", p.Path)
+		}
+
+		if _, err := tags.NewTags(p.Destination.Tags, true); err != nil {
+			return fmt.Errorf("invalid tags for %s: %w", p.Path, err)
+		}
+
+		if err := validatePatterns(p.Include); err != nil {
+			return fmt.Errorf("invalid include for %s: %w", p.Path, err)
+		}
+
+		if err := validatePatterns(p.Exclude); err != nil {
+			return fmt.Errorf("invalid exclude for %s: %w", p.Path, err)
+		}
+
+		if err := validatePatterns(p.TempPatterns); err != nil {
+			return fmt.Errorf("invalid temp-patterns for %s: %w", p.Path, err)
 		}
 
 		if p.DeleteOnSuccess && p.Events.Remove {
 			return fmt.Errorf("cannot watch remove/delete events with delete-on-success: %s", p.Path)
 		}
+
+		if p.LocalRetention > 0 && p.Events.Remove {
+			return fmt.Errorf("cannot watch remove/delete events with local-retention: %s", p.Path)
+		}
+
+		if p.DeleteOnSuccess && readOnly(p.Path) {
+			if !c.opts.AllowReadOnly {
+				return fmt.Errorf("cannot use delete-on-success on read-only filesystem: %s (set allow-read-only to skip deletes)", p.Path)
+			}
+
+			klog.Warningf("%s is on a read-only filesystem, files will not be deleted after upload", p.Path)
+
+			p.DeleteOnSuccess = false
+		}
+
+		if p.LocalRetention > 0 && readOnly(p.Path) {
+			if !c.opts.AllowReadOnly {
+				return fmt.Errorf("cannot use local-retention on read-only filesystem: %s (set allow-read-only to skip deletes)", p.Path)
+			}
+
+			klog.Warningf("%s is on a read-only filesystem, files will not be removed after local-retention", p.Path)
+
+			p.LocalRetention = 0
+		}
 	}
 
 	return nil
@@ -0,0 +1,128 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+// RetryFailed uploads every dead letter again, or only those of the path or
+// source named target when it is set, and returns the outcome once the
+// retries complete. Files of a group or archive are retried by uploading it
+// again as a whole. Dead letters of files that no longer exist, or of paths
+// and sources no longer enabled, are dropped. It stops early when ctx is
+// done.
+func (c *Config) RetryFailed(ctx context.Context, target string) (Results, error) {
+	pctx := c.processing()
+	if pctx == nil {
+		return Results{}, errors.New("paths are not being processed")
+	}
+
+	if Paused().Paused {
+		return Results{}, errors.New("uploads are paused")
+	}
+
+	letters := slices.DeleteFunc(state.DeadLetters(), func(d state.DeadLetter) bool {
+		return target != "" && d.Path != target
+	})
+
+	if target != "" && len(letters) == 0 {
+		return Results{}, fmt.Errorf("no failed uploads of %s: %w", target, fs.ErrNotExist)
+	}
+
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	rctx, cancel := uploadContext(pctx)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	klog.InfoS("retrying failed uploads", "target", target, "failed", len(letters))
+
+	rctx, r := withRun(rctx)
+	retried := make(map[string]bool) // Paths and sources run again as a whole
+
+	for _, d := range letters {
+		if rctx.Err() != nil {
+			break
+		}
+
+		c.retry(d, rctx, retried)
+	}
+
+	run := r.results()
+	klog.InfoS("retry complete", "target", target, "uploaded", run.Uploaded, "skipped", run.Skipped, "failed", run.Failed)
+
+	return run, ctx.Err()
+}
+
+// retry uploads the dead letter d again, unless retried shows the path or
+// source it belongs to was already run again as a whole.
+func (c *Config) retry(d state.DeadLetter, ctx context.Context, retried map[string]bool) {
+	if retried[d.Path] {
+		return
+	}
+
+	for _, s := range c.opts.Sources {
+		if s.Name() == d.Path {
+			retried[d.Path] = true
+			runSource(s, ctx)
+
+			return
+		}
+	}
+
+	i := slices.IndexFunc(c.Paths, func(p *Path) bool { return p.Enabled && p.Path == d.Path })
+	if i < 0 {
+		klog.InfoS("dropping failed upload of a path or source no longer enabled", "path", d.Path, "file", d.File)
+		state.ForgetDeadLetter(d.Path, d.File)
+
+		return
+	}
+
+	p := c.Paths[i]
+
+	switch {
+	case len(p.Group) > 0 && (d.File == p.Path || p.inGroup(d.File)):
+		retried[d.Path] = true
+		uploadGroup(p, ctx, false)
+
+	case p.Archive != "" || d.File == p.Path:
+		retried[d.Path] = true
+		uploadAll(p, ctx, true)
+
+	default:
+		if _, err := os.Stat(d.File); errors.Is(err, os.ErrNotExist) {
+			klog.InfoS("dropping failed upload of a file that no longer exists", "path", d.Path, "file", d.File)
+			state.ForgetDeadLetter(d.Path, d.File)
+
+			return
+		}
+
+		callUpload(p, d.File, ctx)
+	}
+}
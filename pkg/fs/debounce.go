@@ -0,0 +1,441 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
+	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// How the wait before acting on a change adapts to the file changing.
+const (
+	DebounceFixed    = "fixed"
+	DebounceAdaptive = "adaptive"
+)
+
+const (
+	defaultAdaptiveMaxWait = 300 // MaxWaitTime in seconds of adaptive debounce when not set
+	activityPruneInterval  = time.Minute
+)
+
+const (
+	defaultMaxPending = 10000                  // changed files held per path when MaxPending is not set
+	debounceWorkers   = 16                     // changes of one path acted on at once
+	minDebounceTick   = 100 * time.Millisecond // shortest interval between checks for quiet files
+	maxDebounceTick   = time.Second            // longest interval between checks for quiet files
+)
+
+// pendingChange is a change waiting for its file to be quiet for the wait.
+// A file has one pending change, acted on as its latest event decides.
+type pendingChange struct {
+	name   string
+	action string
+	run    func(p *Path, path string, ctx context.Context)
+	due    time.Time
+	wait   time.Duration
+	links  []trace.Link
+}
+
+// fileActivity is the wait learned for a file by adaptive debounce.
+type fileActivity struct {
+	wait time.Duration // wait of its latest change
+	last time.Time     // latest change
+}
+
+func parseDebounce(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", DebounceFixed:
+		return DebounceFixed, nil
+	case DebounceAdaptive:
+		return DebounceAdaptive, nil
+	default:
+		return "", fmt.Errorf("unknown debounce %s", mode)
+	}
+}
+
+// queue acts on e once no further events arrive for the same file within the
+// wait. Changes are held in one set per path, checked by a single ticker, so
+// a file changing many times costs one entry rather than a timer per event.
+// Once MaxPending files are waiting, changes to others are dropped and the
+// path is rescanned when the backlog clears. The span of every event handled
+// by one action is linked from it.
+func (w *watcher) queue(e fsnotify.Event, span trace.Span) {
+	var (
+		run    func(p *Path, path string, ctx context.Context)
+		id     string
+		action string
+	)
+
+	switch {
+	case w.p.Archive != "":
+		run = func(p *Path, _ string, ctx context.Context) { uploadArchive(p, ctx) }
+		id, action = "archive", "archive"
+	case w.p.inGroup(e.Name) && !e.Has(fsnotify.Remove):
+		run = func(p *Path, _ string, ctx context.Context) { uploadGroup(p, ctx, true) }
+		id, action = "group", "group"
+	case e.Has(fsnotify.Create):
+		run = callUpload
+		id, action = fmt.Sprintf("file-%s", e.Name), "upload"
+	case e.Has(fsnotify.Remove):
+		run = callDelete
+		id, action = fmt.Sprintf("file-%s", e.Name), "delete"
+	case e.Has(fsnotify.Write):
+		run = callUpload
+		id, action = fmt.Sprintf("file-%s", e.Name), "upload"
+	}
+
+	// live logs only change until rotated, when the rotated file is created
+	if w.p.Rotation && !isRotated(e.Name) && !e.Has(fsnotify.Remove) {
+		return
+	}
+
+	// Changes after shutdown started are picked up by the next run.
+	if w._ctx.Err() != nil {
+		return
+	}
+
+	w._mu.Lock()
+	defer w._mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+
+	c, again := w.pending[id]
+	if !again {
+		if len(w.pending) >= w.maxPending() {
+			w.overflow()
+			return
+		}
+
+		c = &pendingChange{name: e.Name}
+		w.pending[id] = c
+		metrics.PendingChanges.WithLabelValues(w.p.Path).Set(float64(len(w.pending)))
+	}
+
+	c.action, c.run = action, run
+
+	now := time.Now()
+
+	if w.p.Debounce == DebounceAdaptive {
+		c.wait = w.adaptiveWait(e.Name, c.wait, again, now)
+	} else {
+		c.wait = w.wait
+	}
+
+	c.due = now.Add(c.wait)
+
+	if len(c.links) < maxTimerLinks {
+		c.links = append(c.links, trace.Link{SpanContext: span.SpanContext()})
+	}
+
+	span.AddEvent("queued", trace.WithAttributes(attribute.String("timer", id), attribute.Stringer("wait", c.wait)))
+
+	klog.V(4).InfoS("change queued", "id", id, "action", action, "wait", c.wait)
+}
+
+// backoff returns wait doubled, up to the max wait, for a file changing
+// again before its change is acted on.
+func (w *watcher) backoff(wait time.Duration) time.Duration {
+	switch {
+	case w.maxWait <= w.wait:
+		return w.wait
+	case wait == 0:
+		return time.Second
+	default:
+		return min(wait*2, w.maxWait)
+	}
+}
+
+// adaptiveWait returns how long to wait before acting on a change to name
+// with adaptive debounce, with w._mu held. While the file keeps changing
+// before it is acted on, the wait doubles up to the max wait.
+// The wait reached is remembered instead of starting over: a file changing
+// again within twice that wait of its last change doubles it again, and it
+// halves for every such period the file was quiet, down to the base wait.
+func (w *watcher) adaptiveWait(name string, wait time.Duration, again bool, now time.Time) time.Duration {
+	a, known := w.activity[name]
+
+	switch {
+	case again:
+		wait = w.backoff(wait)
+	case !known:
+		wait = w.wait
+	case now.Sub(a.last) < a.period():
+		wait = w.backoff(a.wait)
+	default:
+		wait = a.decayed(now, w.wait)
+	}
+
+	switch {
+	case known:
+		a.wait, a.last = wait, now
+	case len(w.activity) < w.maxPending():
+		w.activity[name] = &fileActivity{wait: wait, last: now}
+	}
+
+	return wait
+}
+
+// period is how long a file must be quiet for its wait to halve.
+func (a *fileActivity) period() time.Duration {
+	return max(2*a.wait, time.Second)
+}
+
+// decayed returns the wait of a change at now, halved for every period the
+// file has been quiet, but not below base.
+func (a *fileActivity) decayed(now time.Time, base time.Duration) time.Duration {
+	quiet := now.Sub(a.last) / a.period()
+	if quiet >= 63 {
+		return base
+	}
+
+	return max(a.wait>>quiet, base)
+}
+
+// pruneActivity forgets files whose wait has decayed to the base wait, with
+// w._mu held.
+func (w *watcher) pruneActivity(now time.Time) {
+	if now.Sub(w.pruned) < activityPruneInterval {
+		return
+	}
+
+	w.pruned = now
+
+	for name, a := range w.activity {
+		if a.decayed(now, w.wait) <= w.wait {
+			delete(w.activity, name)
+		}
+	}
+}
+
+func (w *watcher) maxPending() int {
+	if w.p.MaxPending > 0 {
+		return w.p.MaxPending
+	}
+
+	return defaultMaxPending
+}
+
+// overflow drops a change that does not fit, with w._mu held.
+func (w *watcher) overflow() {
+	metrics.EventsDropped.WithLabelValues(w.p.Path).Inc()
+
+	if !w.overflowed {
+		klog.Warningf("max-pending %d changes are waiting under %s; dropping further changes and rescanning once the backlog clears", len(w.pending), w.p.Path)
+	}
+
+	w.overflowed = true
+}
+
+// startDebounce acts on quiet changes until the watcher stops.
+func (w *watcher) startDebounce() {
+	tick := min(max(w.wait/4, minDebounceTick), maxDebounceTick)
+
+	go func() {
+		t := time.NewTicker(tick)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-w._ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			w.dispatch(time.Now())
+		}
+	}()
+}
+
+// dispatch acts on every change due by now, up to debounceWorkers at once,
+// then rescans the path if changes were dropped and the backlog has cleared.
+func (w *watcher) dispatch(now time.Time) {
+	w._mu.Lock()
+
+	w.pruneActivity(now)
+
+	var due []string
+
+	for id, c := range w.pending {
+		if !c.due.After(now) {
+			due = append(due, id)
+		}
+	}
+	w._mu.Unlock()
+
+	for _, id := range due {
+		select {
+		case w.slots <- struct{}{}:
+		case <-w._ctx.Done():
+			return
+		}
+
+		w._mu.Lock()
+		c, ok := w.pending[id]
+		if ok && c.due.After(now) {
+			// changed again since it was found due
+			ok = false
+		}
+
+		if ok {
+			w.take(id)
+		}
+		w._mu.Unlock()
+
+		if !ok {
+			<-w.slots
+			continue
+		}
+
+		go func() {
+			defer func() { <-w.slots }()
+
+			w.run(id, c, w._ctx)
+		}()
+	}
+
+	w._mu.Lock()
+	rescan := w.overflowed && len(w.pending) <= w.maxPending()/2
+	if rescan {
+		w.overflowed = false
+		w.running++
+	}
+	w._mu.Unlock()
+
+	if rescan {
+		klog.InfoS("rescanning path for changes dropped while max-pending was exceeded", "path", w.p.Path)
+
+		go func() {
+			uploadAll(w.p, w._ctx, true)
+			w.finished()
+		}()
+	}
+}
+
+// take removes the change id to be acted on, with w._mu held.
+func (w *watcher) take(id string) *pendingChange {
+	c := w.pending[id]
+	delete(w.pending, id)
+	w.running++
+
+	metrics.PendingChanges.WithLabelValues(w.p.Path).Set(float64(len(w.pending)))
+
+	return c
+}
+
+// takeAll removes every pending change to be acted on now, with w._mu held.
+func (w *watcher) takeAll() map[string]*pendingChange {
+	pending := make(map[string]*pendingChange, len(w.pending))
+
+	for id := range w.pending {
+		pending[id] = w.take(id)
+	}
+
+	return pending
+}
+
+// run acts on a change taken from the pending set, once any earlier change
+// to the same file has been acted on.
+func (w *watcher) run(id string, c *pendingChange, ctx context.Context) {
+	w._mu.Lock()
+	for w.active[id] {
+		w.done.Wait()
+	}
+	w.active[id] = true
+	w._mu.Unlock()
+
+	ctx, span := tracing.StartLinked(ctx, "fs.debounced", c.links,
+		attribute.String("file", c.name), attribute.String("timer", id), attribute.String("action", c.action), attribute.Int("events", len(c.links)))
+	c.run(w.p, c.name, ctx)
+	span.End()
+
+	klog.V(4).InfoS("change complete", "id", id)
+
+	w._mu.Lock()
+	delete(w.active, id)
+	w._mu.Unlock()
+
+	w.finished()
+}
+
+func (w *watcher) finished() {
+	w._mu.Lock()
+	defer w._mu.Unlock()
+
+	w.running--
+	w.done.Broadcast()
+}
+
+// runAll acts on changes with ctx, up to debounceWorkers at once, and
+// returns once they complete.
+func (w *watcher) runAll(pending map[string]*pendingChange, ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for id, c := range pending {
+		w.slots <- struct{}{}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-w.slots }()
+
+			w.run(id, c, ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// drain acts on every pending change now instead of after its wait, and
+// returns once every change, including those already being acted on, has
+// completed. Uploads run on the drain context, so they are bounded by
+// shutdown-timeout.
+func (w *watcher) drain() {
+	w._mu.Lock()
+	w.stopped = true
+	pending := w.takeAll()
+	overflowed := w.overflowed
+	w._mu.Unlock()
+
+	if len(pending) > 0 {
+		klog.InfoS("uploading pending changes before shutdown", "path", w.p.Path, "pending", len(pending))
+	}
+
+	if overflowed {
+		klog.Warningf("changes under %s dropped while max-pending was exceeded are uploaded by the initial scan of the next run", w.p.Path)
+	}
+
+	w.runAll(pending, w._ctx)
+
+	w._mu.Lock()
+	for w.running > 0 {
+		w.done.Wait()
+	}
+	w._mu.Unlock()
+}
@@ -0,0 +1,166 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestDecayed(t *testing.T) {
+	tests := []struct {
+		name  string
+		wait  time.Duration
+		quiet time.Duration
+		base  time.Duration
+		want  time.Duration
+	}{
+		{"just changed", 8 * time.Second, 0, time.Second, 8 * time.Second},
+		{"within a period", 8 * time.Second, 15 * time.Second, time.Second, 8 * time.Second},
+		{"one period", 8 * time.Second, 16 * time.Second, time.Second, 4 * time.Second},
+		{"two periods", 8 * time.Second, 40 * time.Second, time.Second, 2 * time.Second},
+		{"down to base", 8 * time.Second, 64 * time.Second, 3 * time.Second, 3 * time.Second},
+		{"short wait period", 100 * time.Millisecond, time.Second, 0, 50 * time.Millisecond},
+		{"quiet for ages", time.Second, 10000 * time.Hour, 500 * time.Millisecond, 500 * time.Millisecond},
+	}
+
+	now := time.Now()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &fileActivity{wait: tt.wait, last: now.Add(-tt.quiet)}
+
+			if got := a.decayed(now, tt.base); got != tt.want {
+				t.Errorf("decayed after %v quiet = %v, want %v", tt.quiet, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveWait(t *testing.T) {
+	type change struct {
+		after time.Duration // since the previous change
+		again bool          // the previous change is still pending
+	}
+
+	tests := []struct {
+		name    string
+		changes []change
+		want    time.Duration
+	}{
+		{"first change", []change{{0, false}}, time.Second},
+		{"changing while pending", []change{{0, false}, {0, true}, {0, true}}, 4 * time.Second},
+		{"capped at max wait", []change{{0, false}, {0, true}, {0, true}, {0, true}, {0, true}}, 8 * time.Second},
+		{"remembered after acted on", []change{{0, false}, {0, true}, {3 * time.Second, false}}, 4 * time.Second},
+		{"decays when quiet", []change{{0, false}, {0, true}, {0, true}, {8 * time.Second, false}}, 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &watcher{
+				p:        &Path{},
+				activity: map[string]*fileActivity{},
+				wait:     time.Second,
+				maxWait:  8 * time.Second,
+			}
+
+			var wait time.Duration
+
+			now := time.Now()
+
+			for _, c := range tt.changes {
+				now = now.Add(c.after)
+				if !c.again {
+					wait = 0
+				}
+
+				wait = w.adaptiveWait("file", wait, c.again, now)
+			}
+
+			if wait != tt.want {
+				t.Errorf("wait = %v, want %v", wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueLastEventDecides(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []fsnotify.Op
+		want   string
+	}{
+		{"written then removed", []fsnotify.Op{fsnotify.Write, fsnotify.Remove}, "delete"},
+		{"removed then created", []fsnotify.Op{fsnotify.Remove, fsnotify.Create}, "upload"},
+		{"created then written", []fsnotify.Op{fsnotify.Create, fsnotify.Write}, "upload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &watcher{
+				p:        &Path{Path: "/data"},
+				pending:  map[string]*pendingChange{},
+				activity: map[string]*fileActivity{},
+				wait:     time.Second,
+				_ctx:     context.Background(),
+			}
+
+			_, span := noop.NewTracerProvider().Tracer("").Start(context.Background(), "event")
+
+			for _, op := range tt.events {
+				w.queue(fsnotify.Event{Name: "/data/a", Op: op}, span)
+			}
+
+			if len(w.pending) != 1 {
+				t.Fatalf("pending = %d changes, want one per file", len(w.pending))
+			}
+
+			for _, c := range w.pending {
+				if c.action != tt.want {
+					t.Errorf("action = %s, want %s", c.action, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueFixedWait(t *testing.T) {
+	w := &watcher{
+		p:        &Path{Path: "/data", Debounce: DebounceFixed},
+		pending:  map[string]*pendingChange{},
+		activity: map[string]*fileActivity{},
+		wait:     time.Second,
+		maxWait:  8 * time.Second,
+		_ctx:     context.Background(),
+	}
+
+	_, span := noop.NewTracerProvider().Tracer("").Start(context.Background(), "event")
+
+	for range 4 {
+		w.queue(fsnotify.Event{Name: "/data/a", Op: fsnotify.Write}, span)
+	}
+
+	for _, c := range w.pending {
+		if c.wait != w.wait {
+			t.Errorf("wait = %v after repeated changes, want fixed %v", c.wait, w.wait)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type uploadRecord struct {
+	hash   string
+	at     time.Time
+	window time.Duration
+}
+
+// dedupe remembers the content hash of the last upload to each object so
+// identical content uploaded again within a window can be skipped. Each
+// Process has its own; outside of one nothing is remembered.
+type dedupe struct {
+	mu   sync.Mutex
+	seen map[string]uploadRecord
+}
+
+// uploadsFrom returns the recent uploads of the Process running ctx, or nil.
+func uploadsFrom(ctx context.Context) *dedupe {
+	if p := processFrom(ctx); p != nil {
+		return p.uploads
+	}
+
+	return nil
+}
+
+func (d *dedupe) duplicate(key, hash string, window time.Duration) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r, ok := d.seen[key]
+	if !ok {
+		return false
+	}
+
+	if time.Since(r.at) > window {
+		delete(d.seen, key)
+		return false
+	}
+
+	return r.hash == hash
+}
+
+// record remembers hash for key for window, and forgets every record whose
+// own window has passed so objects never uploaded again do not pile up.
+func (d *dedupe) record(key, hash string, window time.Duration) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	for k, r := range d.seen {
+		if now.Sub(r.at) > r.window {
+			delete(d.seen, k)
+		}
+	}
+
+	d.seen[key] = uploadRecord{hash: hash, at: now, window: window}
+}
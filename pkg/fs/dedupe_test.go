@@ -0,0 +1,97 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+)
+
+func TestDedupeRecordPrunes(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration
+		window time.Duration
+		kept   bool
+	}{
+		{"within window", time.Second, time.Minute, true},
+		{"window passed", 2 * time.Minute, time.Minute, false},
+		{"own window kept", 2 * time.Minute, time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dedupe{seen: map[string]uploadRecord{
+				"old": {hash: "a", at: time.Now().Add(-tt.age), window: tt.window},
+			}}
+
+			d.record("new", "b", time.Second)
+
+			if _, ok := d.seen["old"]; ok != tt.kept {
+				t.Errorf("old record kept = %v, want %v", ok, tt.kept)
+			}
+
+			if !d.duplicate("new", "b", time.Second) {
+				t.Errorf("new record not found")
+			}
+		})
+	}
+}
+
+func TestDedupeRecordsOnlyWithWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		want   int // records kept after the upload
+	}{
+		{"disabled", 0, 0},
+		{"enabled", 60, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := restart(t, context.WithValue(context.Background(), config.MC, minio.MinioClient(minio.NewFake("fake"))))
+
+			dir := t.TempDir()
+			file := filepath.Join(dir, "a.txt")
+
+			if err := os.WriteFile(file, []byte("a"), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			p, err := NewPath(dir)
+			if err != nil {
+				t.Fatalf("NewPath: %v", err)
+			}
+
+			p.DedupeWindow = tt.window
+
+			callUpload(p, file, ctx)
+
+			if got := len(uploadsFrom(ctx).seen); got != tt.want {
+				t.Errorf("recorded %d uploads, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"os"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// verifiedUpload reports whether the object uploaded from file under dest
+// exists with the size file had when it was uploaded.
+func verifiedUpload(p *Path, file string, dest config.Destination, size int64, ctx context.Context) bool {
+	if err := clientFor(p, ctx).Verify(ctx, file, dest, size); err != nil {
+		klog.ErrorS(err, "upload not verified, keeping file", "file", file)
+		return false
+	}
+
+	return true
+}
+
+// deleteUploaded removes file after delete-on-success once its upload is
+// verified. Files changed since they were uploaded are kept for the next
+// upload.
+func deleteUploaded(p *Path, file string, dest config.Destination, uploaded os.FileInfo, ctx context.Context) {
+	if !verifiedUpload(p, file, dest, uploaded.Size(), ctx) {
+		return
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		klog.ErrorS(err, "unable to stat uploaded file", "file", file)
+		return
+	}
+
+	if info.Size() != uploaded.Size() || !info.ModTime().Equal(uploaded.ModTime()) {
+		klog.InfoS("file changed since upload, keeping it", "file", file)
+		return
+	}
+
+	if removeFile(p, file, info.Size(), "delete-on-success") {
+		forgetFile(p, file, ctx)
+	}
+}
+
+// removeFile deletes an uploaded file, for reason, and counts its size as
+// reclaimed.
+func removeFile(p *Path, file string, size int64, reason string) bool {
+	if err := os.Remove(file); err != nil {
+		klog.ErrorS(err, "failed to remove uploaded file", "file", file)
+		return false
+	}
+
+	metrics.ReclaimedBytes.Add(float64(size))
+	audit.Write(audit.Record{Op: audit.OpDelete, Path: p.Path, File: file, Size: size, Reason: reason})
+	klog.V(2).InfoS("removed uploaded file", "file", file, "size", size, "reason", reason)
+
+	return true
+}
@@ -0,0 +1,65 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// truncated remembers how many directories each path skipped on its last
+// walk, so the warning is only logged when that changes.
+var truncated = struct {
+	mu      sync.Mutex
+	skipped map[string]int
+}{skipped: make(map[string]int)}
+
+func reportTruncated(root string, maxDepth, skipped int) {
+	metrics.DirectoriesTruncated.WithLabelValues(root).Set(float64(skipped))
+
+	truncated.mu.Lock()
+	defer truncated.mu.Unlock()
+
+	if truncated.skipped[root] == skipped {
+		return
+	}
+
+	truncated.skipped[root] = skipped
+
+	if skipped > 0 {
+		klog.Warningf("%d directories under %s are deeper than max-depth %d and are not watched or uploaded", skipped, root, maxDepth)
+	}
+}
+
+// withinDepth reports whether dir is no deeper than MaxDepth below p.Path.
+func (p *Path) withinDepth(dir string) bool {
+	if p.MaxDepth <= 0 {
+		return true
+	}
+
+	rel, err := filepath.Rel(p.Path, dir)
+	if err != nil || rel == "." {
+		return true
+	}
+
+	return strings.Count(rel, string(filepath.Separator))+1 <= p.MaxDepth
+}
@@ -0,0 +1,128 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+)
+
+// maxReportedFiles limits how many unreadable files are named in a warning.
+const maxReportedFiles = 5
+
+// diagnose checks that the current user can read every path, and write to
+// directories files are deleted from, so problems are reported once at
+// startup instead of on every event.
+func (c *Config) diagnose() error {
+	var errs []error
+
+	for _, p := range c.Paths {
+		if !p.Enabled {
+			continue
+		}
+
+		if err := p.diagnose(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("permission check failed for uid %d gid %d: %w", os.Getuid(), os.Getgid(), errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func (p *Path) diagnose() error {
+	if err := checkDir(p.Path); err != nil {
+		return access(p.Path, accessRead, "readable")
+	}
+
+	if err := access(p.Path, accessRead|accessExec, "readable"); err != nil {
+		return err
+	}
+
+	if p.DeleteOnSuccess {
+		if err := access(p.Path, accessWrite|accessExec, "writable"); err != nil {
+			return fmt.Errorf("delete-on-success requires write access: %w", err)
+		}
+	}
+
+	p.reportUnreadable()
+
+	return nil
+}
+
+// reportUnreadable warns once about files under a directory path that will
+// fail to upload.
+func (p *Path) reportUnreadable() {
+	var unreadable []string
+
+	_ = filepath.WalkDir(p.Path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			unreadable = append(unreadable, file)
+			return nil
+		}
+
+		if d.IsDir() {
+			if file != p.Path && !p.Recursive {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.Type().IsRegular() && checkAccess(file, accessRead) != nil {
+			unreadable = append(unreadable, file)
+		}
+
+		return nil
+	})
+
+	if len(unreadable) == 0 {
+		return
+	}
+
+	klog.Warningf("%d files under %s are not readable by uid %d and will not be uploaded, including %v",
+		len(unreadable), p.Path, os.Getuid(), unreadable[:min(len(unreadable), maxReportedFiles)])
+}
+
+// access returns an error describing the owner and mode of file when the
+// current user does not have mode access to it.
+func access(file string, mode uint32, want string) error {
+	err := checkAccess(file, mode)
+	if err == nil {
+		return nil
+	}
+
+	info, statErr := os.Stat(file)
+	if statErr != nil {
+		return fmt.Errorf("%s is not %s: %w", file, want, err)
+	}
+
+	if uid, gid, ok := owner(info); ok {
+		return fmt.Errorf("%s is not %s (owner %d:%d, mode %s): %w", file, want, uid, gid, info.Mode().Perm(), err)
+	}
+
+	return fmt.Errorf("%s is not %s (mode %s): %w", file, want, info.Mode().Perm(), err)
+}
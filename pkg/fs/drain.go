@@ -0,0 +1,74 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+type drainKey struct{}
+
+// withDrain returns ctx carrying a new drain, which bounds the uploads
+// started with it, and the function ending the drain. The drain of a Process
+// is ended shutdown-timeout after processing is asked to stop, so pending
+// and in-flight uploads can finish first.
+func withDrain(ctx context.Context) (context.Context, context.CancelFunc) {
+	drain, stop := context.WithCancel(context.Background())
+
+	return context.WithValue(ctx, drainKey{}, drain), stop
+}
+
+// uploadContext returns a context with the values of ctx that is canceled
+// when the drain of ctx ends rather than when ctx is.
+func uploadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	drain, ok := ctx.Value(drainKey{}).(context.Context)
+	if !ok {
+		return ctx, cancel
+	}
+
+	stop := context.AfterFunc(drain, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// drainAfter ends the drain with stop timeout after ctx is done, unless done
+// is closed first.
+func drainAfter(ctx context.Context, done <-chan struct{}, timeout time.Duration, stop context.CancelFunc) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	klog.InfoS("draining uploads", "timeout", timeout)
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		klog.Warningf("shutdown-timeout %s reached, canceling remaining uploads", timeout)
+		stop()
+	}
+}
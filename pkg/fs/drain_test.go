@@ -0,0 +1,49 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainPerProcess(t *testing.T) {
+	first, stopFirst := withDrain(context.Background())
+	second, stopSecond := withDrain(context.Background())
+
+	defer stopSecond()
+
+	stopFirst()
+
+	ctx, cancel := uploadContext(first)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("upload not canceled after its drain ended")
+	}
+
+	ctx, cancel = uploadContext(second)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		t.Error("upload canceled by the drain of another process")
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/hooks"
+)
+
+// included reports whether file passes the include, exclude and temp
+// patterns for p and every registered filter hook. Patterns containing a
+// separator are matched against the path relative to p.Path, all others
+// against the file's base name.
+func (p *Path) included(file string) bool {
+	if p.isOverride(file) {
+		return false
+	}
+
+	if len(p.Include) > 0 && !matchAny(p.Include, p.Path, file) {
+		return false
+	}
+
+	return !matchAny(p.Exclude, p.Path, file) && !matchAny(p.TempPatterns, p.Path, file) && hooks.Included(file)
+}
+
+func matchAny(patterns []string, root, file string) bool {
+	name := filepath.Base(file)
+
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		rel = file
+	}
+
+	for _, pattern := range patterns {
+		target := name
+		if strings.ContainsRune(pattern, filepath.Separator) {
+			target = rel
+		}
+
+		if ok, _ := filepath.Match(pattern, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
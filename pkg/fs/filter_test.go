@@ -0,0 +1,112 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/fsnotify/fsnotify"
+)
+
+var filterTests = []struct {
+	name    string
+	include []string
+	exclude []string
+	file    string // relative to the path
+	want    bool
+}{
+	{"no patterns", nil, nil, "db.sql", true},
+	{"included name", []string{"*.sql"}, nil, "db.sql", true},
+	{"not included", []string{"*.sql"}, nil, "db.log", false},
+	{"excluded name", nil, []string{"*.tmp"}, "db.tmp", false},
+	{"exclude wins over include", []string{"db.*"}, []string{"*.tmp"}, "db.tmp", false},
+	{"included name in subdirectory", []string{"*.sql"}, nil, filepath.Join("nightly", "db.sql"), true},
+	{"included relative path", []string{filepath.Join("nightly", "*.sql")}, nil, filepath.Join("nightly", "db.sql"), true},
+	{"relative path of another directory", []string{filepath.Join("nightly", "*.sql")}, nil, filepath.Join("weekly", "db.sql"), false},
+	{"excluded relative path", nil, []string{filepath.Join("tmp", "*")}, filepath.Join("tmp", "db.sql"), false},
+}
+
+// filterPath returns a recursive path for a new directory holding file, with
+// include and exclude.
+func filterPath(t *testing.T, file string, include, exclude []string) *Path {
+	t.Helper()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, file)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	if err := os.WriteFile(name, []byte(file), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	p, err := NewPath(dir)
+	if err != nil {
+		t.Fatalf("NewPath: %v", err)
+	}
+
+	p.Recursive = true
+	p.Include = include
+	p.Exclude = exclude
+
+	return p
+}
+
+func TestFilterScan(t *testing.T) {
+	for _, tt := range filterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := minio.NewFake("fake")
+			ctx := restart(t, context.WithValue(context.Background(), config.MC, minio.MinioClient(client)))
+
+			uploadAll(filterPath(t, tt.file, tt.include, tt.exclude), ctx, false)
+
+			if got := client.Uploads() == 1; got != tt.want {
+				t.Errorf("uploaded %d files, want uploaded = %v", client.Uploads(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterEvent(t *testing.T) {
+	for _, tt := range filterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filterPath(t, tt.file, tt.include, tt.exclude)
+			p.Events.Write = true
+
+			w := &watcher{
+				p:        p,
+				pending:  map[string]*pendingChange{},
+				activity: map[string]*fileActivity{},
+				_ctx:     context.Background(),
+			}
+
+			w.handleEvent(fsnotify.Event{Name: filepath.Join(p.Path, tt.file), Op: fsnotify.Write})
+
+			if got := len(w.pending) == 1; got != tt.want {
+				t.Errorf("queued %d changes, want queued = %v", len(w.pending), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+)
+
+var flushMu sync.Mutex
+
+// watchers holds the watcher of every watched path, so pending changes can be
+// uploaded on demand.
+var watchers = struct {
+	sync.Mutex
+	byPath map[*Path]*watcher
+}{byPath: make(map[*Path]*watcher)}
+
+func addWatcher(w *watcher) {
+	watchers.Lock()
+	defer watchers.Unlock()
+
+	watchers.byPath[w.p] = w
+}
+
+func watcherFor(p *Path) *watcher {
+	watchers.Lock()
+	defer watchers.Unlock()
+
+	return watchers.byPath[p]
+}
+
+// Ready returns a channel closed once Process has started watching and
+// scheduling every enabled path.
+func (c *Config) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Notify handles e as if the watcher of the path containing e.Name had
+// received it, debouncing and filtering it like any other event. It fails
+// when no watched path contains e.Name.
+func (c *Config) Notify(e fsnotify.Event) error {
+	for _, p := range c.Paths {
+		rel, err := filepath.Rel(p.Path, e.Name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		if w := watcherFor(p); w != nil {
+			w.handleEvent(e)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not under a watched path", e.Name)
+}
+
+// Flush uploads pending changes, then every file changed since its last
+// recorded upload under each enabled path, and returns the outcome once all
+// uploads complete. It keeps running after processing is asked to stop, so it
+// can be called from a preStop hook, and stops early when ctx is done. Nothing
+// is flushed while uploads are paused.
+func (c *Config) Flush(ctx context.Context) (Results, error) {
+	pctx := c.processing()
+	if pctx == nil {
+		return Results{}, errors.New("paths are not being processed")
+	}
+
+	if Paused().Paused {
+		return Results{}, errors.New("uploads are paused")
+	}
+
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	fctx, cancel := uploadContext(pctx)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	klog.InfoS("flushing all paths")
+
+	fctx, r := withRun(fctx)
+
+	for _, p := range c.Paths {
+		if !p.Enabled {
+			continue
+		}
+
+		if w := watcherFor(p); w != nil {
+			w.flushPending(fctx)
+		}
+
+		uploadAll(p, fctx, true)
+	}
+
+	flushed := r.results()
+	klog.InfoS("flush complete", "uploaded", flushed.Uploaded, "skipped", flushed.Skipped, "failed", flushed.Failed)
+
+	return flushed, ctx.Err()
+}
+
+// flushPending acts on every pending change now with ctx instead of after
+// its wait, and returns once they complete.
+func (w *watcher) flushPending(ctx context.Context) {
+	w._mu.Lock()
+	pending := w.takeAll()
+	w._mu.Unlock()
+
+	w.runAll(pending, ctx)
+}
@@ -0,0 +1,223 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+// GroupMarker names the object written under the run prefix of a group once
+// every file of the set was uploaded.
+const GroupMarker = "_SUCCESS"
+
+// groupSet is the content of the marker of an uploaded group.
+type groupSet struct {
+	Path    string        `json:"path"`
+	Run     string        `json:"run"`
+	Objects []groupMember `json:"objects"`
+}
+
+type groupMember struct {
+	File   string `json:"file"`
+	Object string `json:"object"`
+	Size   int64  `json:"size"`
+}
+
+func validateGroup(p *Path) error {
+	switch {
+	case p.Archive != "":
+		return fmt.Errorf("cannot use group with archive: %s", p.Path)
+	case p.Append:
+		return fmt.Errorf("cannot use group with append: %s", p.Path)
+	case p.Snapshot != "":
+		return fmt.Errorf("cannot use group with snapshot, every set is uploaded under a new run prefix: %s", p.Path)
+	}
+
+	if err := validatePatterns(p.Group); err != nil {
+		return fmt.Errorf("invalid group for %s: %w", p.Path, err)
+	}
+
+	return nil
+}
+
+// inGroup reports whether file is uploaded with the group of p rather than
+// on its own.
+func (p *Path) inGroup(file string) bool {
+	return len(p.Group) > 0 && matchAny(p.Group, p.Path, file)
+}
+
+// groupFiles returns the included files under p matching a group pattern,
+// and the patterns no file matches.
+func groupFiles(p *Path, ctx context.Context) ([]string, []string, error) {
+	var files []string
+
+	matched := make(map[string]bool, len(p.Group))
+
+	err := walk(ctx, p, nil, func(file string, _ fs.DirEntry) error {
+		if !p.included(file) || !p.inGroup(file) {
+			return nil
+		}
+
+		files = append(files, file)
+
+		for _, pattern := range p.Group {
+			if matchAny([]string{pattern}, p.Path, file) {
+				matched[pattern] = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missing []string
+
+	for _, pattern := range p.Group {
+		if !matched[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+
+	return files, missing, nil
+}
+
+// uploadGroup uploads the files of the group of p as one set, once a file
+// matches every group pattern and none changed for the wait. The set is
+// uploaded under a prefix named for the run, and the marker is written under
+// it only after every file was uploaded, so readers take a prefix without
+// one as incomplete. With changedOnly, sets unchanged since their last
+// upload are skipped.
+func uploadGroup(p *Path, ctx context.Context, changedOnly bool) {
+	if deferIfPaused("group:"+p.Path, p.Path, p.Path, ctx, func(ctx context.Context) { uploadGroup(p, ctx, changedOnly) }) {
+		return
+	}
+
+	parent := ctx
+
+	ctx, cancel := uploadContext(minio.WithPriority(ctx, p.Priority))
+	defer cancel()
+
+	files, missing, err := groupFiles(p, ctx)
+	if err != nil {
+		klog.ErrorS(err, "unable to find group files", "path", p.Path)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	if len(missing) > 0 {
+		klog.V(2).InfoS("group incomplete, waiting for more files", "path", p.Path, "files", len(files), "missing", missing)
+		return
+	}
+
+	changed := func(file string) bool { return changedSinceUpload(p, file) }
+
+	if changedOnly && !slices.ContainsFunc(files, changed) {
+		klog.V(2).InfoS("skipping group unchanged since last upload", "path", p.Path)
+		metrics.UploadsSkipped.WithLabelValues("unchanged").Inc()
+		recordSkipped(p.Path, p.Path, "unchanged", ctx)
+
+		return
+	}
+
+	// every file of the set shares the run prefix, partitioned by upload time
+	run := time.Now().UTC().Format(snapshotTimeFormat)
+	dest := partitioned(p, p.Destination, "")
+	dest.Path = path.Join(dest.Path, run)
+	set := groupSet{Path: p.Path, Run: run}
+
+	klog.V(2).InfoS("uploading group", "path", p.Path, "files", len(files), "run", run)
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			klog.ErrorS(err, "unable to stat file", "file", file)
+			recordFailed(p.Path, file, err, ctx)
+
+			return
+		}
+
+		key := minio.ObjectName(file, dest)
+
+		if err := clientFor(p, ctx).UploadFileWithDestination(file, dest, ctx); err != nil {
+			if errors.Is(err, minio.ErrCircuitOpen) {
+				deferUpload("group:"+p.Path, p.Path, p.Path, parent, func(ctx context.Context) { uploadGroup(p, ctx, changedOnly) })
+				return
+			}
+
+			klog.V(4).ErrorS(err, "failed upload", "file", file, "fsPath", p)
+			events.Warning(events.ReasonBackupFailed, "upload of %s in group of %s failed, set left incomplete: %v", file, p.Path, err)
+			recordFailedUpload(p.Path, file, key, err, ctx)
+
+			return
+		}
+
+		uploaded := state.FileState{Size: info.Size(), Mtime: info.ModTime(), Object: key, Uploaded: time.Now()}
+
+		recordUploaded(p.Path, file, key, info.Size(), "", ctx)
+		state.RecordUpload(p.Path, info.Size())
+		state.RecordFile(p.Path, file, uploaded)
+		manifestFrom(ctx).addUploaded(file, key, uploaded)
+
+		set.Objects = append(set.Objects, groupMember{File: file, Object: key, Size: info.Size()})
+	}
+
+	body, err := json.Marshal(set)
+	if err != nil {
+		klog.ErrorS(err, "unable to encode group marker", "path", p.Path)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	marker := path.Join(dest.Path, GroupMarker)
+	if err := clientFor(p, ctx).Upload(ctx, marker, bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+		klog.ErrorS(err, "unable to mark group complete", "path", p.Path, "object", marker)
+		events.Warning(events.ReasonBackupFailed, "marking group of %s complete failed: %v", p.Path, err)
+		recordFailed(p.Path, p.Path, err, ctx)
+
+		return
+	}
+
+	klog.InfoS("uploaded group", "path", p.Path, "files", len(files), "marker", marker)
+	state.ForgetDeadLetter(p.Path, p.Path)
+
+	if p.DeleteOnSuccess {
+		for i, file := range files {
+			if size := set.Objects[i].Size; verifiedUpload(p, file, dest, size, ctx) {
+				removeFile(p, file, size, "delete-on-success")
+			}
+		}
+	}
+}
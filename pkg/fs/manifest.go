@@ -0,0 +1,175 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+// ManifestPrefix is the subprefix of a path's destination holding manifests.
+const ManifestPrefix = "_manifests"
+
+type manifestKey struct{}
+
+// manifest lists every file of a scan, so a complete backup set can be
+// restored or audited from one object.
+type manifest struct {
+	mu sync.Mutex
+
+	RunID    string         `json:"runId"`
+	Pod      string         `json:"pod"`
+	Path     string         `json:"path"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Complete bool           `json:"complete"` // Every file is listed, none failed
+	Failed   int64          `json:"failed"`
+	Files    []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Path      string     `json:"path"`
+	Object    string     `json:"object"`
+	Size      int64      `json:"size"`
+	SHA256    string     `json:"sha256,omitempty"`
+	Mtime     time.Time  `json:"mtime"`
+	Uploaded  *time.Time `json:"uploaded,omitempty"`  // Unset for files unchanged since an earlier run
+	Unchanged bool       `json:"unchanged,omitempty"` // Uploaded by an earlier run
+}
+
+// withManifest returns ctx collecting the files of a scan of p into a
+// manifest, when p writes manifests.
+func withManifest(p *Path, ctx context.Context) (context.Context, *manifest) {
+	if !p.Manifests {
+		return ctx, nil
+	}
+
+	now := time.Now().UTC()
+
+	m := &manifest{
+		RunID:   now.Format("20060102T150405.000Z") + "-" + config.PodName(),
+		Pod:     config.PodName(),
+		Path:    p.Path,
+		Started: now,
+		Files:   []manifestFile{},
+	}
+
+	return context.WithValue(ctx, manifestKey{}, m), m
+}
+
+func manifestFrom(ctx context.Context) *manifest {
+	m, _ := ctx.Value(manifestKey{}).(*manifest)
+	return m
+}
+
+func (m *manifest) add(f manifestFile) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Files = append(m.Files, f)
+}
+
+// addUploaded lists file as uploaded to key by this run.
+func (m *manifest) addUploaded(file, key string, fs state.FileState) {
+	now := time.Now().UTC()
+
+	m.add(manifestFile{Path: file, Object: key, Size: fs.Size, SHA256: fs.Hash, Mtime: fs.Mtime.UTC(), Uploaded: &now})
+}
+
+// addFailed counts a file of the scan that failed.
+func (m *manifest) addFailed() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Failed++
+}
+
+// addUnchanged lists file as uploaded by an earlier run.
+func (m *manifest) addUnchanged(p *Path, file string) {
+	last, ok := state.LastUpload(p.Path, file)
+	if !ok {
+		return
+	}
+
+	object := last.Object
+	if object == "" {
+		object = minio.ObjectName(file, p.Destination)
+	}
+
+	m.add(manifestFile{
+		Path:      file,
+		Object:    object,
+		Size:      last.Size,
+		SHA256:    last.Hash,
+		Mtime:     last.Mtime.UTC(),
+		Unchanged: true,
+	})
+}
+
+// upload writes the manifest to <destination>/_manifests/<run-id>.json.
+// Manifests of scans that found no files are not written.
+func (m *manifest) upload(p *Path, ctx context.Context) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	if len(m.Files) == 0 && m.Failed == 0 {
+		m.mu.Unlock()
+		return
+	}
+
+	m.Finished = time.Now().UTC()
+	m.Complete = m.Failed == 0
+	body, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+
+	ctx, cancel := uploadContext(ctx)
+	defer cancel()
+
+	if err != nil {
+		klog.ErrorS(err, "unable to create manifest", "path", p.Path)
+		return
+	}
+
+	key := path.Join(p.Destination.Path, ManifestPrefix, m.RunID+".json")
+
+	if err := clientFor(p, ctx).Upload(ctx, key, bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+		klog.ErrorS(err, "unable to upload manifest", "path", p.Path, "object", key)
+		return
+	}
+
+	klog.V(2).InfoS("uploaded manifest", "path", p.Path, "object", key, "files", len(m.Files))
+}
@@ -0,0 +1,62 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManifestCountsOwnFailures(t *testing.T) {
+	p := &Path{Path: "/data", Manifests: true}
+	other := &Path{Path: "/other", Manifests: true}
+
+	scan, m := withManifest(p, context.Background())
+	otherScan, _ := withManifest(other, context.Background())
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want int64
+	}{
+		{"failure of the scan", scan, 1},
+		{"failure of another scan", otherScan, 1},
+		{"failure outside a scan", context.Background(), 1},
+		{"another failure of the scan", scan, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordFailed(p.Path, "/data/file", errors.New("failed"), tt.ctx)
+
+			if m.Failed != tt.want {
+				t.Errorf("manifest counted %d failures, want %d", m.Failed, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithManifestDisabled(t *testing.T) {
+	ctx, m := withManifest(&Path{Path: "/data"}, context.Background())
+	if m != nil || manifestFrom(ctx) != nil {
+		t.Fatal("manifest created for a path without manifests")
+	}
+
+	// counting a failure without a manifest does nothing
+	manifestFrom(ctx).addFailed()
+}
@@ -0,0 +1,151 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// errDeferredDropped fails work deferred while paused or while a target was
+// unreachable, when processing stops before it ran.
+var errDeferredDropped = errors.New("deferred upload was not run before processing stopped")
+
+type offlineUpload struct {
+	key    string
+	path   string // Configured path or source the work belongs to
+	file   string
+	ctx    context.Context
+	upload func(ctx context.Context)
+}
+
+// offlineQueue holds uploads rejected while a target's circuit is open or
+// uploads are paused, in the order they were last deferred. Work is keyed so
+// repeated changes to the same file run once, as the latest change decides.
+// Only the work is kept; the files themselves stay on disk until they are
+// uploaded.
+type offlineQueue struct {
+	sync.Mutex
+	pending []offlineUpload
+}
+
+// deferUpload queues upload of file under path to run with ctx once the
+// target is reachable, replacing any work already queued under key. Work
+// deferred outside of Process fails, as nothing would run it.
+func deferUpload(key, path, file string, ctx context.Context, upload func(ctx context.Context)) {
+	proc := processFrom(ctx)
+	if proc == nil {
+		failDeferred([]offlineUpload{{key: key, path: path, file: file, ctx: ctx}})
+		return
+	}
+
+	proc.offline.Lock()
+	proc.offline.pending = slices.DeleteFunc(proc.offline.pending, func(u offlineUpload) bool { return u.key == key })
+	proc.offline.pending = append(proc.offline.pending, offlineUpload{key: key, path: path, file: file, ctx: ctx, upload: upload})
+	pending := len(proc.offline.pending)
+	proc.offline.Unlock()
+
+	metrics.OfflinePending.Set(float64(OfflinePending()))
+	klog.V(2).InfoS("target unreachable, upload deferred", "upload", key, "pending", pending)
+}
+
+// take returns and clears the deferred work.
+func (q *offlineQueue) take() []offlineUpload {
+	q.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.Unlock()
+
+	metrics.OfflinePending.Set(float64(OfflinePending()))
+
+	return pending
+}
+
+// len returns the number of uploads deferred.
+func (q *offlineQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.pending)
+}
+
+// flushOffline runs every upload deferred by p in order. Uploads whose
+// processing has stopped fail, and their files are picked up by the next
+// initial scan.
+func (p *process) flushOffline() {
+	pending := p.offline.take()
+	if len(pending) == 0 {
+		return
+	}
+
+	if !p.wg.TryAdd() {
+		klog.InfoS("processing stopped, failing deferred uploads", "pending", len(pending))
+		failDeferred(pending)
+
+		return
+	}
+
+	klog.InfoS("uploading deferred files", "pending", len(pending))
+
+	go func() {
+		defer p.wg.Done()
+
+		for _, u := range pending {
+			if u.ctx.Err() != nil {
+				failDeferred([]offlineUpload{u})
+				continue
+			}
+
+			u.upload(u.ctx)
+		}
+	}()
+}
+
+// failOffline fails the work p still deferred once processing stopped, so a
+// one-shot run does not succeed with changes left behind.
+func (p *process) failOffline() {
+	pending := p.offline.take()
+	if len(pending) == 0 {
+		return
+	}
+
+	klog.InfoS("processing stopped with deferred uploads", "pending", len(pending))
+	failDeferred(pending)
+}
+
+func failDeferred(pending []offlineUpload) {
+	for _, u := range pending {
+		recordFailed(u.path, u.file, errDeferredDropped, u.ctx)
+	}
+}
+
+// OfflinePending returns the number of uploads waiting for a target to
+// become reachable or uploads to resume, across every running Process.
+func OfflinePending() int {
+	n := 0
+	for _, p := range running() {
+		n += p.offline.len()
+	}
+
+	return n
+}
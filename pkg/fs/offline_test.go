@@ -0,0 +1,105 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+func TestPausedWorkOrder(t *testing.T) {
+	if err := state.Init(""); err != nil {
+		t.Fatalf("state.Init: %v", err)
+	}
+
+	proc := newProcess()
+	t.Cleanup(proc.register())
+
+	var (
+		mu  sync.Mutex
+		ran []string
+	)
+
+	op := func(name string) func(ctx context.Context) {
+		return func(context.Context) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			ran = append(ran, name)
+		}
+	}
+
+	Pause()
+
+	ctx := context.WithValue(context.Background(), processKey{}, proc)
+	for _, w := range []struct{ key, file, op string }{
+		{"file:/data/a", "/data/a", "upload a"},
+		{"file:/data/b", "/data/b", "upload b"},
+		{"file:/data/a", "/data/a", "delete a"},
+		{"file:/data/c", "/data/c", "upload c"},
+		{"file:/data/b", "/data/b", "upload b again"},
+	} {
+		if !deferIfPaused(w.key, "/data", w.file, ctx, op(w.op)) {
+			t.Fatalf("%s was not deferred while paused", w.op)
+		}
+	}
+
+	if got := OfflinePending(); got != 3 {
+		t.Fatalf("OfflinePending() = %d, want one entry per file", got)
+	}
+
+	Resume()
+	proc.wg.Wait()
+
+	if want := []string{"delete a", "upload c", "upload b again"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestDeferredFailAtShutdown(t *testing.T) {
+	if err := state.Init(""); err != nil {
+		t.Fatalf("state.Init: %v", err)
+	}
+
+	proc := newProcess()
+	t.Cleanup(proc.register())
+
+	ctx, r := withRun(context.WithValue(context.Background(), processKey{}, proc))
+
+	ran := false
+	deferUpload("file:/data/a", "/data", "/data/a", ctx, func(context.Context) { ran = true })
+
+	proc.wg.Wait()
+	proc.failOffline()
+
+	// a recovery after the wait must not run work either
+	deferUpload("file:/data/b", "/data", "/data/b", ctx, func(context.Context) { ran = true })
+	proc.flushOffline()
+
+	if ran {
+		t.Error("deferred work ran after processing stopped")
+	}
+
+	if got := r.results(); got.Failed != 2 || !slices.Equal(got.FailedFiles, []string{"/data/a", "/data/b"}) {
+		t.Errorf("results = %+v, want both deferred files failed", got)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// maxOverrideSize bounds the override files read, which are expected to hold
+// a few settings.
+const maxOverrideSize = 64 << 10
+
+// override is a destination override read from the companion file of a file,
+// written by the application producing it.
+type override struct {
+	Name         string            `yaml:"name"`
+	Path         string            `yaml:"path"`
+	Type         string            `yaml:"type"`
+	StorageClass string            `yaml:"storage-class"`
+	Tags         map[string]string `yaml:"tags"`
+	Metadata     map[string]string `yaml:"metadata"`
+}
+
+// isOverride reports whether file is the companion override file of another.
+func (p *Path) isOverride(file string) bool {
+	return p.OverrideSuffix != "" && strings.HasSuffix(file, p.OverrideSuffix)
+}
+
+// applyOverride updates dest with the companion override file of file, when
+// one exists. An override path is relative to the configured one. Tags and
+// metadata are added to those configured. Overrides are
+// read when file is uploaded, so applications write them before the file.
+func applyOverride(p *Path, file string, dest *config.Destination) error {
+	if p.OverrideSuffix == "" {
+		return nil
+	}
+
+	name := file + p.OverrideSuffix
+
+	info, err := os.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to read override %s: %w", name, err)
+	}
+
+	if info.Size() > maxOverrideSize {
+		return fmt.Errorf("override %s is larger than %d bytes", name, maxOverrideSize)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("unable to read override %s: %w", name, err)
+	}
+
+	var o override
+
+	d := yaml.NewDecoder(bytes.NewReader(data))
+	d.KnownFields(true)
+
+	if err := d.Decode(&o); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return fmt.Errorf("invalid override %s: %w", name, err)
+	}
+
+	if slices.Contains(strings.Split(o.Path+"/"+o.Name, "/"), "..") || strings.Contains(o.Name, "/") {
+		return fmt.Errorf("invalid override %s: name and path must not leave the bucket prefix", name)
+	}
+
+	if o.Name != "" {
+		dest.Name = o.Name
+	}
+
+	if o.Path != "" {
+		dest.Path = path.Join(dest.Path, o.Path)
+	}
+
+	if key := strings.TrimPrefix(path.Join(dest.Path, dest.Name), "/"); (o.Path != "" || o.Name != "") && strings.HasPrefix(key+"/", minio.InternalPrefix) {
+		return fmt.Errorf("invalid override %s: %s is reserved for the sidecar", name, minio.InternalPrefix)
+	}
+
+	if o.Type != "" {
+		dest.Type = o.Type
+	}
+
+	if o.StorageClass != "" {
+		dest.StorageClass = o.StorageClass
+	}
+
+	dest.Tags = MergeTags(dest.Tags, o.Tags)
+	dest.Metadata = MergeTags(dest.Metadata, o.Metadata)
+
+	if _, err := tags.NewTags(dest.Tags, true); err != nil {
+		return fmt.Errorf("invalid tags in override %s: %w", name, err)
+	}
+
+	klog.V(2).InfoS("applied destination override", "file", file, "override", name)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+func TestApplyOverridePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		override string
+		want     string
+		wantErr  bool
+	}{
+		{name: "relative to configured path", path: "backups", override: "path: daily\n", want: "backups/daily"},
+		{name: "leading slash stays under configured path", path: "backups", override: "path: /daily\n", want: "backups/daily"},
+		{name: "parent directory", path: "backups", override: "path: ../other\n", wantErr: true},
+		{name: "internal prefix", override: "path: .minio-backup-sidecar/chunks\n", wantErr: true},
+		{name: "internal prefix name", override: "name: .minio-backup-sidecar\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "db.sql")
+			if err := os.WriteFile(file+".dest.yaml", []byte(tt.override), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			p := &Path{OverrideSuffix: ".dest.yaml"}
+			dest := config.Destination{Path: tt.path}
+
+			err := applyOverride(p, file, &dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyOverride() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && dest.Path != tt.want {
+				t.Errorf("dest.Path = %q, want %q", dest.Path, tt.want)
+			}
+		})
+	}
+}
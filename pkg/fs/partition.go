@@ -0,0 +1,93 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+const (
+	partitionByUpload = "upload"
+	partitionByMtime  = "mtime"
+)
+
+// partitionLayouts are shorthands for common destination.partition layouts.
+var partitionLayouts = map[string]string{
+	"hourly":  "2006/01/02/15",
+	"daily":   "2006/01/02",
+	"monthly": "2006/01",
+}
+
+// partitionLayout returns the time layout for a destination.partition value.
+func partitionLayout(partition string) string {
+	if layout, ok := partitionLayouts[strings.ToLower(partition)]; ok {
+		return layout
+	}
+
+	return partition
+}
+
+func validatePartition(partition, by string) error {
+	switch strings.ToLower(by) {
+	case "", partitionByUpload, partitionByMtime:
+	default:
+		return fmt.Errorf("unknown destination.partition-by %s", by)
+	}
+
+	if partition == "" {
+		return nil
+	}
+
+	layout := partitionLayout(partition)
+	if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(layout) == layout {
+		return fmt.Errorf("destination.partition %q has no date or time elements", partition)
+	}
+
+	return nil
+}
+
+// partitioned returns dest with its date partition appended to the path,
+// formatted in UTC from the current time or, with partition-by mtime, from
+// the modification time of file. Files that cannot be stat'd, or no file,
+// use the current time.
+func partitioned(p *Path, dest config.Destination, file string) config.Destination {
+	if dest.Partition == "" {
+		return dest
+	}
+
+	t := time.Now()
+
+	if file != "" && strings.EqualFold(dest.PartitionBy, partitionByMtime) {
+		if info, err := os.Stat(file); err == nil {
+			t = info.ModTime()
+		} else {
+			klog.V(2).ErrorS(err, "unable to stat file for partition, using upload time", "file", file, "path", p.Path)
+		}
+	}
+
+	dest.Path = path.Join(dest.Path, t.UTC().Format(partitionLayout(dest.Partition)))
+
+	return dest
+}
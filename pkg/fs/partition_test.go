@@ -0,0 +1,106 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+func TestPartitioned(t *testing.T) {
+	mtime := time.Date(2024, 3, 9, 17, 30, 0, 0, time.FixedZone("east", 3*60*60))
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "db.sql")
+
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		partition string
+		by        string
+		file      string
+		nowLayout string // layout of the current time, when it is used
+		want      string
+	}{
+		{"no partition", "", partitionByMtime, file, "", "backups"},
+		{"daily by mtime", "daily", partitionByMtime, file, "", "backups/2024/03/09"},
+		{"hourly by mtime in UTC", "hourly", partitionByMtime, file, "", "backups/2024/03/09/14"},
+		{"monthly by mtime", "Monthly", partitionByMtime, file, "", "backups/2024/03"},
+		{"custom layout by mtime", "year=2006/month=01", partitionByMtime, file, "", "backups/year=2024/month=03"},
+		{"daily by upload", "daily", partitionByUpload, file, "2006/01/02", ""},
+		{"default by upload", "daily", "", file, "2006/01/02", ""},
+		{"missing file falls back to upload", "daily", partitionByMtime, filepath.Join(dir, "missing"), "2006/01/02", ""},
+		{"no file falls back to upload", "hourly", partitionByMtime, "", "2006/01/02/15", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now().UTC()
+			got := partitioned(&Path{Path: dir}, config.Destination{Path: "backups", Partition: tt.partition, PartitionBy: tt.by}, tt.file)
+			after := time.Now().UTC()
+
+			if tt.nowLayout == "" {
+				if got.Path != tt.want {
+					t.Errorf("path = %s, want %s", got.Path, tt.want)
+				}
+
+				return
+			}
+
+			// the clock may move to the next partition during the call
+			if first, last := "backups/"+before.Format(tt.nowLayout), "backups/"+after.Format(tt.nowLayout); got.Path != first && got.Path != last {
+				t.Errorf("path = %s, want %s", got.Path, first)
+			}
+		})
+	}
+}
+
+func TestValidatePartition(t *testing.T) {
+	tests := []struct {
+		name      string
+		partition string
+		by        string
+		wantErr   bool
+	}{
+		{"none", "", "", false},
+		{"shorthand", "daily", "", false},
+		{"layout", "2006/01/02", partitionByMtime, false},
+		{"upper case by", "daily", "MTIME", false},
+		{"no time elements", "backups", "", true},
+		{"unknown by", "daily", "ctime", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePartition(tt.partition, tt.by); (err != nil) != tt.wantErr {
+				t.Errorf("validatePartition(%q, %q) = %v, want error %v", tt.partition, tt.by, err, tt.wantErr)
+			}
+		})
+	}
+}
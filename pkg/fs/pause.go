@@ -0,0 +1,104 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// PauseStatus describes whether uploads are paused.
+type PauseStatus struct {
+	Paused  bool       `json:"paused"`
+	Since   *time.Time `json:"since,omitempty"`
+	Pending int        `json:"pending"`
+}
+
+// pause holds whether uploads are paused. While paused, uploads are queued
+// with those waiting for an unreachable target.
+var pause = struct {
+	sync.Mutex
+	since *time.Time
+}{}
+
+// Pause stops uploads until Resume is called. Changes keep being detected
+// and are uploaded on resume.
+func Pause() {
+	pause.Lock()
+	defer pause.Unlock()
+
+	if pause.since != nil {
+		return
+	}
+
+	now := time.Now()
+	pause.since = &now
+
+	metrics.Paused.Set(1)
+	klog.InfoS("uploads paused")
+	events.Normal(events.ReasonUploadsPaused, "uploads paused")
+}
+
+// Resume uploads every change queued while paused and stops pausing uploads.
+func Resume() {
+	pause.Lock()
+
+	if pause.since == nil {
+		pause.Unlock()
+		return
+	}
+
+	since := *pause.since
+	pause.since = nil
+	pause.Unlock()
+
+	metrics.Paused.Set(0)
+	klog.InfoS("uploads resumed", "paused", time.Since(since), "pending", OfflinePending())
+	events.Normal(events.ReasonUploadsResumed, "uploads resumed after %s, %d pending", time.Since(since).Round(time.Second), OfflinePending())
+
+	for _, p := range running() {
+		p.flushOffline()
+	}
+}
+
+// Paused returns whether uploads are paused, and since when.
+func Paused() PauseStatus {
+	pause.Lock()
+	defer pause.Unlock()
+
+	return PauseStatus{Paused: pause.since != nil, Since: pause.since, Pending: OfflinePending()}
+}
+
+// deferIfPaused queues upload like deferUpload while uploads are paused, and
+// reports whether it did.
+func deferIfPaused(key, path, file string, ctx context.Context, upload func(ctx context.Context)) bool {
+	pause.Lock()
+	defer pause.Unlock()
+
+	if pause.since == nil {
+		return false
+	}
+
+	deferUpload(key, path, file, ctx, upload)
+
+	return true
+}
@@ -0,0 +1,134 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+)
+
+const (
+	watchModeInotify = "inotify"
+	watchModePoll    = "poll"
+)
+
+// WatchModeManual detects no changes itself. Events only reach the path
+// through Config.Notify, so tests can drive the pipeline deterministically.
+const WatchModeManual = "manual"
+
+type polledFile struct {
+	size  int64
+	mtime int64
+}
+
+func parseWatchMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", watchModeInotify, "fsnotify":
+		return watchModeInotify, nil
+	case watchModePoll:
+		return watchModePoll, nil
+	case WatchModeManual:
+		return WatchModeManual, nil
+	default:
+		return "", fmt.Errorf("unknown watch-mode %s", mode)
+	}
+}
+
+// startPollLoop scans the path every poll interval and turns differences in
+// size and mtime into the events fsnotify would have sent, for filesystems
+// that do not deliver inotify events. Polls start after the schedule jitter
+// so sidecars started together do not scan at once.
+func (w *watcher) startPollLoop() {
+	go func() {
+		interval := time.Duration(w.p.PollInterval) * time.Second
+
+		seen, err := w.scan()
+		if err != nil {
+			klog.ErrorS(err, "unable to scan path", "path", w.p.Path)
+		}
+
+		if !waitJitter(w._ctx, w.maxJitter, interval) {
+			return
+		}
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-w._ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			current, err := w.scan()
+			if err != nil {
+				// keep the last scan so a transient failure is not seen as every file being removed
+				klog.ErrorS(err, "unable to scan path", "path", w.p.Path)
+				continue
+			}
+
+			for file, f := range current {
+				old, ok := seen[file]
+
+				switch {
+				case !ok && w.p.Events.Create:
+					w.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Create})
+				case !ok || old != f:
+					w.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Write})
+				}
+			}
+
+			for file := range seen {
+				if _, ok := current[file]; !ok {
+					w.handleEvent(fsnotify.Event{Name: file, Op: fsnotify.Remove})
+				}
+			}
+
+			seen = current
+		}
+	}()
+}
+
+// scan returns the size and mtime of every file under the path.
+func (w *watcher) scan() (map[string]polledFile, error) {
+	files := make(map[string]polledFile)
+
+	err := walk(w._ctx, w.p, nil, func(file string, _ fs.DirEntry) error {
+		info, err := os.Stat(file)
+		if err != nil {
+			// removed since the directory was read
+			return nil
+		}
+
+		files[file] = polledFile{size: info.Size(), mtime: info.ModTime().UnixNano()}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
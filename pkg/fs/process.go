@@ -19,45 +19,299 @@ package fs
 
 import (
 	"context"
+	"io/fs"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
-var waitGroup sync.WaitGroup
+type processKey struct{}
 
-func (c *Config) Process(ctx context.Context) {
+// process holds the state of one Process call, carried in the context of
+// everything it runs so concurrent calls, e.g. of several Configs, do not
+// share goroutines, deferred work or outcomes.
+type process struct {
+	wg        tracker // Goroutines Process waits for
+	offline   offlineQueue
+	scheduler *cron.Cron
+	failFast  atomic.Pointer[context.CancelFunc] // Cancels processing at the first failure, when set
+	uploads   *dedupe                            // Content recently uploaded, for DedupeWindow
+	claims    *claims                            // Object keys checked for collisions
+}
+
+// processes holds every running process, so deferred work of each is run
+// when uploads resume.
+var processes = struct {
+	sync.Mutex
+	running map[*process]struct{}
+}{running: make(map[*process]struct{})}
+
+func newProcess() *process {
+	return &process{
+		scheduler: cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DiscardLogger))),
+		uploads:   &dedupe{seen: make(map[string]uploadRecord)},
+		claims:    &claims{owner: make(map[string]string)},
+	}
+}
+
+func processFrom(ctx context.Context) *process {
+	p, _ := ctx.Value(processKey{}).(*process)
+	return p
+}
+
+// register adds p to the running processes until the returned function is
+// called.
+func (p *process) register() func() {
+	processes.Lock()
+	processes.running[p] = struct{}{}
+	processes.Unlock()
+
+	return func() {
+		processes.Lock()
+		delete(processes.running, p)
+		processes.Unlock()
+	}
+}
+
+// running returns every running process.
+func running() []*process {
+	processes.Lock()
+	defer processes.Unlock()
+
+	running := make([]*process, 0, len(processes.running))
+	for p := range processes.running {
+		running = append(running, p)
+	}
+
+	return running
+}
+
+// processing returns the context paths are processed in, or nil before
+// Process starts.
+func (c *Config) processing() context.Context {
+	if ctx := c.ctx.Load(); ctx != nil {
+		return *ctx
+	}
+
+	return nil
+}
+
+// Process handles every enabled path until processing completes or ctx is
+// canceled, returning the outcome of every file processed by this call.
+// Pending changes are uploaded before returning, for up to ShutdownTimeout.
+// Configs can be processed concurrently, each in its own call.
+func (c *Config) Process(ctx context.Context) Results {
+	if c.opts.Client != nil {
+		ctx = context.WithValue(ctx, config.MC, c.opts.Client)
+	}
+
+	if c.opts.Profiles != nil {
+		ctx = context.WithValue(ctx, config.Profiles, c.opts.Profiles)
+	}
+
+	proc := newProcess()
+	defer proc.register()()
+
+	ctx = context.WithValue(ctx, processKey{}, proc)
+	ctx, r := withRun(ctx)
+
+	ctx, stopDrain := withDrain(ctx)
 	ctx, cancel := context.WithCancel(ctx)
+	c.ctx.Store(&ctx)
+
+	defer cancel()
+
+	if c.opts.FailFast && c.OneShot() {
+		proc.failFast.Store(&cancel)
+	}
 
-	go setupSignalNotify(cancel)
+	go setupSignalNotify(ctx, cancel)
+	go setupPauseSignals(ctx)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go drainAfter(ctx, done, c.opts.ShutdownTimeout, stopDrain)
+
+	defer minio.OnRecovered(proc.flushOffline)()
+
+	c.setStarted(time.Now())
+	go c.watchStaleness(ctx)
+	go c.watchLocalRetention(ctx)
 
 	for _, p := range c.Paths {
-		doConfigPath(p, ctx)
+		if p.Enabled {
+			c.doConfigPath(p, ctx)
+		}
+	}
+
+	for _, s := range c.opts.Sources {
+		c.startSource(s, ctx)
 	}
 
-	waitGroup.Wait()
+	startScheduler(ctx)
+	close(c.ready)
+
+	proc.wg.Wait()
+	proc.failOffline()
+
+	return r.results()
 }
 
-func doConfigPath(p *fsPath, ctx context.Context) {
+func (c *Config) doConfigPath(p *Path, ctx context.Context) {
 	klog.V(4).InfoS("processing path", "fsPath", p)
 
-	if p.Watch {
-		startNewWatcher(p, ctx, &waitGroup)
-	} else {
-		waitGroup.Add(1)
+	if p.Schedule != "" {
+		if err := schedulePath(p, ctx, c.opts.ScheduleJitter); err != nil {
+			klog.ErrorS(err, "unable to schedule path", "path", p.Path)
+		}
+	}
 
-		go func() {
-			f, err := fileList(p.Path)
-			if err != nil {
-				klog.ErrorS(err, "unable to process path", "path", p.Path)
-				return
-			}
+	wg := &processFrom(ctx).wg
 
-			for _, file := range *f {
-				callUpload(p, file, ctx)
-			}
+	switch {
+	case p.Watch:
+		startNewWatcher(p, ctx, wg, c.opts.ScheduleJitter)
 
-			waitGroup.Done()
+		if p.InitialScan {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				uploadAll(p, ctx, true)
+			}()
+		}
+	case p.Schedule == "":
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			backupRun(p, ctx, p.ChangedOnly)
 		}()
 	}
 }
+
+// backupRun uploads every file under p like uploadAll, recording an event
+// with the outcome of the run.
+func backupRun(p *Path, ctx context.Context, changedOnly bool) {
+	ctx, r := withRun(ctx)
+
+	uploadAll(p, ctx, changedOnly)
+
+	run := r.results()
+
+	if run.Failed > 0 {
+		events.Warning(events.ReasonBackupFailed, "backup of %s finished with %d failed and %d uploaded", p.Path, run.Failed, run.Uploaded)
+		return
+	}
+
+	events.Normal(events.ReasonBackupCompleted, "backup of %s completed, %d uploaded", p.Path, run.Uploaded)
+}
+
+// uploadAll uploads every file currently under p. With changedOnly, files
+// unchanged since their last recorded upload are skipped. With manifests, the
+// files of the scan are listed in a manifest uploaded once it completes.
+func uploadAll(p *Path, ctx context.Context, changedOnly bool) {
+	ctx, span := tracing.Start(ctx, "fs.scan", attribute.String("path", p.Path), attribute.Bool("changed-only", changedOnly))
+	defer span.End()
+
+	if p.Archive != "" {
+		uploadArchive(p, ctx)
+		return
+	}
+
+	ctx, m := withManifest(p, ctx)
+	group := false
+
+	defer m.upload(p, ctx)
+
+	err := walk(ctx, p, nil, func(file string, _ fs.DirEntry) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !p.included(file) {
+			return nil
+		}
+
+		// the set is uploaded once every file was seen
+		if p.inGroup(file) {
+			group = true
+			return nil
+		}
+
+		if changedOnly && !changedSinceUpload(p, file) {
+			klog.V(2).InfoS("skipping file unchanged since last upload", "file", file)
+			metrics.UploadsSkipped.WithLabelValues("unchanged").Inc()
+			recordSkipped(p.Path, file, "unchanged", ctx)
+			m.addUnchanged(p, file)
+
+			return nil
+		}
+
+		callUpload(p, file, ctx)
+
+		return nil
+	})
+	switch {
+	case err == nil:
+		state.ForgetDeadLetter(p.Path, p.Path)
+	case ctx.Err() == nil:
+		klog.ErrorS(err, "unable to process path", "path", p.Path)
+		recordFailed(p.Path, p.Path, err, ctx)
+	}
+
+	if group && ctx.Err() == nil {
+		uploadGroup(p, ctx, changedOnly)
+	}
+}
+
+// PathStatus describes how a configured path is processed.
+type PathStatus struct {
+	Path     string `json:"path"`
+	Enabled  bool   `json:"enabled"`
+	Watch    bool   `json:"watch,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+	Archive  string `json:"archive,omitempty"`
+
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Stale       bool       `json:"stale,omitempty"`
+}
+
+// Status returns the state of every configured path, including disabled ones.
+func (c *Config) Status() []PathStatus {
+	status := make([]PathStatus, 0, len(c.Paths))
+
+	now, started := time.Now(), *c.started.Load()
+
+	for _, p := range c.Paths {
+		s := PathStatus{
+			Path:     p.Path,
+			Enabled:  p.Enabled,
+			Watch:    p.Watch,
+			Schedule: p.Schedule,
+			Archive:  p.Archive,
+			Stale:    p.isStale(now, started),
+		}
+
+		if last, ok := p.lastSuccess(started); ok {
+			s.LastSuccess = &last
+		}
+
+		status = append(status, s)
+	}
+
+	return status
+}
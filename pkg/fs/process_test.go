@@ -0,0 +1,79 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+func TestOneShotChangedOnly(t *testing.T) {
+	tests := []struct {
+		name        string
+		changedOnly bool
+		want        Results // Outcome of the second run over unchanged files
+	}{
+		{"uploads everything by default", false, Results{Uploaded: 1}},
+		{"skips unchanged files when asked", true, Results{Skipped: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := state.Init(""); err != nil {
+				t.Fatalf("state.Init: %v", err)
+			}
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			client := minio.NewFake("fake")
+
+			var got Results
+
+			for range 2 {
+				p, err := NewPath(dir)
+				if err != nil {
+					t.Fatalf("NewPath: %v", err)
+				}
+
+				// the default of initial-scan must not change one-shot runs
+				p.InitialScan = true
+				p.ChangedOnly = tt.changedOnly
+
+				c, err := NewWithConfig(Options{Paths: []*Path{p}, ShutdownTimeout: time.Second, Client: client})
+				if err != nil {
+					t.Fatalf("NewWithConfig: %v", err)
+				}
+
+				got = c.Process(context.Background())
+			}
+
+			if got.Uploaded != tt.want.Uploaded || got.Skipped != tt.want.Skipped || got.Failed != tt.want.Failed {
+				t.Errorf("second run = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"k8s.io/klog/v2"
+)
+
+// TrashDir holds objects of removed files under the destination path of
+// paths with remote-delete trash.
+const TrashDir = ".trash"
+
+const (
+	remoteDeleteObject = "delete"
+	remoteDeleteTrash  = "trash"
+
+	trashTimeFormat = "20060102T150405Z"
+)
+
+func parseRemoteDelete(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		return "", nil
+	case remoteDeleteObject:
+		return remoteDeleteObject, nil
+	case remoteDeleteTrash:
+		return remoteDeleteTrash, nil
+	default:
+		return "", fmt.Errorf("unknown remote-delete %s", mode)
+	}
+}
+
+// deleteRemote removes the object file was last uploaded to, or would be
+// uploaded to when object is not known. With remote-delete trash, the object
+// is first copied under <destination>/.trash/<timestamp>/ and only removed
+// once the copy succeeded.
+func deleteRemote(p *Path, file, object string, ctx context.Context) {
+	if object == "" {
+		object = minio.ObjectName(file, p.Destination)
+	}
+
+	client := clientFor(p, ctx)
+	reason := "remote-delete"
+
+	if p.RemoteDelete == remoteDeleteTrash {
+		trash := trashKey(p, object, time.Now())
+
+		if err := client.Copy(ctx, object, trash); err != nil {
+			if minio.IsNotFound(err) {
+				klog.V(2).InfoS("no object to delete for removed file", "file", file, "object", object)
+				return
+			}
+
+			klog.ErrorS(err, "unable to move object to trash, keeping it", "file", file, "object", object)
+
+			return
+		}
+
+		klog.V(2).InfoS("moved object to trash", "object", object, "trash", trash)
+
+		reason = "trash:" + trash
+	}
+
+	if err := client.Delete(ctx, object); err != nil {
+		klog.ErrorS(err, "unable to delete object of removed file", "file", file, "object", object)
+		return
+	}
+
+	audit.Write(audit.Record{Op: audit.OpDelete, Path: p.Path, File: file, Object: object, Reason: reason})
+	klog.V(2).InfoS("deleted object of removed file", "file", file, "object", object)
+}
+
+// trashKey returns the key object is kept under in the trash of p, keeping
+// its name relative to the destination path.
+func trashKey(p *Path, object string, t time.Time) string {
+	prefix := strings.Trim(p.Destination.Path, "/")
+	key := strings.TrimPrefix(object, "/")
+
+	if prefix != "" {
+		key = strings.TrimPrefix(key, prefix+"/")
+	}
+
+	return path.Join(prefix, TrashDir, t.UTC().Format(trashTimeFormat), key)
+}
@@ -0,0 +1,179 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
+	"github.com/csfreak/minio-backup-sidecar/pkg/history"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+// maxFailedFiles bounds the failed files listed in Results.
+const maxFailedFiles = 20
+
+// Results counts the outcome of every file processed by a run.
+type Results struct {
+	Uploaded int64 `json:"uploaded"`
+	Skipped  int64 `json:"skipped"`
+	Failed   int64 `json:"failed"`
+
+	FailedFiles []string `json:"failedFiles,omitempty"` // The first files that failed
+}
+
+// AnyFailed reports whether any file failed.
+func (r Results) AnyFailed() bool {
+	return r.Failed > 0
+}
+
+type runKey struct{}
+
+// run counts the outcome of the files processed by one backup, flush or
+// retry. It is carried in the context of the run, so files processed by
+// other runs at the same time are not counted. A run started within another
+// is also counted by it.
+type run struct {
+	parent   *run
+	uploaded atomic.Int64
+	skipped  atomic.Int64
+	failed   atomic.Int64
+
+	mu          sync.Mutex
+	failedFiles []string
+}
+
+// withRun returns ctx counting the files processed with it in a new run.
+func withRun(ctx context.Context) (context.Context, *run) {
+	r := &run{parent: runFrom(ctx)}
+
+	return context.WithValue(ctx, runKey{}, r), r
+}
+
+func runFrom(ctx context.Context) *run {
+	r, _ := ctx.Value(runKey{}).(*run)
+	return r
+}
+
+func (r *run) addUploaded() {
+	for ; r != nil; r = r.parent {
+		r.uploaded.Add(1)
+	}
+}
+
+func (r *run) addSkipped() {
+	for ; r != nil; r = r.parent {
+		r.skipped.Add(1)
+	}
+}
+
+func (r *run) addFailed(file string) {
+	for ; r != nil; r = r.parent {
+		r.failed.Add(1)
+
+		r.mu.Lock()
+		if len(r.failedFiles) < maxFailedFiles {
+			r.failedFiles = append(r.failedFiles, file)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// results returns the outcomes counted by r so far.
+func (r *run) results() Results {
+	r.mu.Lock()
+	failedFiles := append([]string(nil), r.failedFiles...)
+	r.mu.Unlock()
+
+	return Results{
+		Uploaded:    r.uploaded.Load(),
+		Skipped:     r.skipped.Load(),
+		Failed:      r.failed.Load(),
+		FailedFiles: failedFiles,
+	}
+}
+
+// recordUploaded counts file, under the configured path or source p, as
+// uploaded to object and records it in the audit log.
+func recordUploaded(p, file, object string, size int64, hash string, ctx context.Context) {
+	runFrom(ctx).addUploaded()
+	audit.Write(audit.Record{Op: audit.OpUpload, Path: p, File: file, Object: object, Size: size})
+	record(history.Record{Status: history.StatusUploaded, Path: p, File: file, Object: object, Size: size, Hash: hash})
+	state.ForgetDeadLetter(p, file)
+}
+
+// recordSkipped counts file as skipped for reason.
+func recordSkipped(p, file, reason string, ctx context.Context) {
+	runFrom(ctx).addSkipped()
+	audit.Write(audit.Record{Op: audit.OpSkip, Path: p, File: file, Reason: reason})
+	record(history.Record{Status: history.StatusSkipped, Path: p, File: file, Reason: reason})
+	state.ForgetDeadLetter(p, file)
+}
+
+// recordFailed counts file as failed with err, also in the manifest of the
+// scan running in ctx.
+func recordFailed(p, file string, err error, ctx context.Context) {
+	recordFailedUpload(p, file, "", err, ctx)
+}
+
+// recordFailedUpload counts file as failed with err while uploading it to
+// object, and keeps it as a dead letter until it is retried.
+func recordFailedUpload(p, file, object string, err error, ctx context.Context) {
+	runFrom(ctx).addFailed(file)
+	manifestFrom(ctx).addFailed()
+	audit.Write(audit.Record{Op: audit.OpFail, Path: p, File: file, Object: object, Error: err.Error()})
+	record(history.Record{Status: history.StatusFailed, Path: p, File: file, Object: object, Error: err.Error()})
+	state.RecordDeadLetter(p, file, object, err.Error())
+
+	if proc := processFrom(ctx); proc != nil {
+		if cancel := proc.failFast.Swap(nil); cancel != nil {
+			klog.ErrorS(err, "stopping at first failure with fail-fast", "file", file)
+			(*cancel)()
+		}
+	}
+}
+
+// record adds r to the history and sends it to subscribers.
+func record(r history.Record) {
+	r.Time = time.Now().UTC()
+
+	history.Write(r)
+	publish(r)
+}
+
+// OneShot reports whether every enabled path and source is processed once,
+// so the process exits when processing completes.
+func (c *Config) OneShot() bool {
+	for _, p := range c.Paths {
+		if p.Enabled && (p.Watch || p.Schedule != "") {
+			return false
+		}
+	}
+
+	for _, s := range c.opts.Sources {
+		if s.Schedule() != "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,63 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+func TestRunResults(t *testing.T) {
+	if err := state.Init(""); err != nil {
+		t.Fatalf("state.Init: %v", err)
+	}
+
+	ctx := context.Background()
+
+	backup, b := withRun(ctx)
+	flush, f := withRun(ctx)
+	path, p := withRun(backup)
+
+	recordUploaded("/data", "/data/a", "a", 1, "", path)
+	recordSkipped("/data", "/data/b", "unchanged", backup)
+	recordFailed("/data", "/data/c", errors.New("denied"), flush)
+	recordUploaded("/data", "/data/d", "d", 1, "", ctx)
+
+	tests := []struct {
+		name string
+		run  *run
+		want Results
+	}{
+		{"nested run", p, Results{Uploaded: 1}},
+		{"outer run", b, Results{Uploaded: 1, Skipped: 1}},
+		{"concurrent run", f, Results{Failed: 1, FailedFiles: []string{"/data/c"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.run.results()
+			if got.Uploaded != tt.want.Uploaded || got.Skipped != tt.want.Skipped || got.Failed != tt.want.Failed || !slices.Equal(got.FailedFiles, tt.want.FailedFiles) {
+				t.Errorf("results = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
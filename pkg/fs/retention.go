@@ -0,0 +1,115 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+const localRetentionInterval = 10 * time.Minute
+
+// watchLocalRetention removes files uploaded longer than local-retention ago
+// from paths that set it, until ctx is canceled. The first sweep is delayed
+// by the schedule jitter so sidecars started together do not check their
+// objects at once.
+func (c *Config) watchLocalRetention(ctx context.Context) {
+	interval := time.Duration(0)
+
+	for _, p := range c.Paths {
+		if p.Enabled && p.LocalRetention > 0 && (interval == 0 || p.LocalRetention < interval) {
+			interval = p.LocalRetention
+		}
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	interval = min(interval, localRetentionInterval)
+
+	if !waitJitter(ctx, c.opts.ScheduleJitter, interval) {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		for _, p := range c.Paths {
+			if p.Enabled && p.LocalRetention > 0 {
+				expireLocal(p, ctx, time.Now())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// expireLocal removes files under p whose last upload is older than its
+// local-retention. Files changed since that upload, or whose object can no
+// longer be found, are kept.
+func expireLocal(p *Path, ctx context.Context, now time.Time) {
+	for file, last := range state.Files(p.Path) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if last.Uploaded.IsZero() || now.Sub(last.Uploaded) < p.LocalRetention {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			forgetFile(p, file, ctx)
+			continue
+		}
+
+		if err != nil {
+			klog.ErrorS(err, "unable to stat file for local retention", "file", file)
+			continue
+		}
+
+		if info.Size() != last.Size || !info.ModTime().Equal(last.Mtime) {
+			klog.V(2).InfoS("file changed since upload, keeping it", "file", file)
+			continue
+		}
+
+		object := last.Object
+		if object == "" {
+			object = minio.ObjectName(file, p.Destination)
+		}
+
+		if _, err := clientFor(p, ctx).Stat(ctx, object); err != nil {
+			klog.ErrorS(err, "uploaded object not found, keeping file past local retention", "file", file, "object", object)
+			continue
+		}
+
+		if removeFile(p, file, info.Size(), "local-retention") {
+			forgetFile(p, file, ctx)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+func TestExpireLocal(t *testing.T) {
+	const retention = 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		recorded    bool          // the file has a recorded state
+		uploadedAgo time.Duration // zero for a state without an upload
+		remote      bool          // the object still exists
+		changed     bool          // the file changed since its upload
+		wantRemoved bool
+	}{
+		{"never recorded", false, 0, true, false, false},
+		{"never uploaded", true, 0, true, false, false},
+		{"within retention", true, time.Hour, true, false, false},
+		{"past retention", true, 2 * retention, true, false, true},
+		{"missing remotely", true, 2 * retention, false, false, false},
+		{"changed since upload", true, 2 * retention, true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := state.Init(""); err != nil {
+				t.Fatalf("state.Init: %v", err)
+			}
+
+			client := minio.NewFake("fake")
+			ctx := context.WithValue(context.Background(), config.MC, minio.MinioClient(client))
+
+			dir := t.TempDir()
+			file := filepath.Join(dir, "db.sql")
+
+			if err := os.WriteFile(file, []byte("db"), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			p, err := NewPath(dir)
+			if err != nil {
+				t.Fatalf("NewPath: %v", err)
+			}
+
+			p.LocalRetention = retention
+			p.Destination.Path = "backups"
+
+			if tt.remote {
+				if err := client.UploadFileWithDestination(file, p.Destination, ctx); err != nil {
+					t.Fatalf("upload: %v", err)
+				}
+			}
+
+			info, err := os.Stat(file)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+
+			if tt.recorded {
+				last := state.FileState{Size: info.Size(), Mtime: info.ModTime(), Object: minio.ObjectName(file, p.Destination)}
+				if tt.uploadedAgo > 0 {
+					last.Uploaded = time.Now().Add(-tt.uploadedAgo)
+				}
+
+				state.RecordFile(p.Path, file, last)
+			}
+
+			if tt.changed {
+				if err := os.WriteFile(file, []byte(strings.Repeat("db", 2)), 0o600); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			expireLocal(p, ctx, time.Now())
+
+			if _, err := os.Stat(file); os.IsNotExist(err) != tt.wantRemoved {
+				t.Errorf("removed = %v, want %v", os.IsNotExist(err), tt.wantRemoved)
+			}
+
+			if _, ok := state.LastUpload(p.Path, file); tt.wantRemoved && ok {
+				t.Errorf("state of removed file kept")
+			}
+		})
+	}
+}
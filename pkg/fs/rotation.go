@@ -0,0 +1,131 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+// rotatedName matches the names logrotate gives rotated logs: a number or a
+// dateext date after the name of the log, optionally compressed with one of
+// the formats rotatedHash can decompress.
+var rotatedName = regexp.MustCompile(`^(.+?)(\.\d+|-\d{8}(\d{2})?)(\.(gz|bz2))?$`)
+
+func validateRotation(p *Path) error {
+	switch {
+	case p.Append:
+		return fmt.Errorf("cannot use rotation with append: %s", p.Path)
+	case p.Archive != "":
+		return fmt.Errorf("cannot use rotation with archive: %s", p.Path)
+	case len(p.Group) > 0:
+		return fmt.Errorf("cannot use rotation with group: %s", p.Path)
+	case p.Snapshot != "":
+		return fmt.Errorf("cannot use rotation with snapshot, rotated logs are already named by their mtime: %s", p.Path)
+	}
+
+	if info, err := os.Stat(p.Path); err == nil && !info.IsDir() {
+		return fmt.Errorf("rotation requires the directory holding the log and its rotations, not %s", p.Path)
+	}
+
+	return nil
+}
+
+// isRotated reports whether file is a rotated log rather than a live one.
+func isRotated(file string) bool {
+	return rotatedName.MatchString(filepath.Base(file))
+}
+
+// rotatedObjectName returns the name of the live log file was rotated from,
+// keeping its compression extension, so every rotation of a log is uploaded
+// under one name versioned by the rotation.
+func rotatedObjectName(file string) string {
+	m := rotatedName.FindStringSubmatch(filepath.Base(file))
+	if m == nil {
+		return filepath.Base(file)
+	}
+
+	return m[1] + m[4]
+}
+
+// rotatedUpload names the upload of the rotated log file in dest after its
+// live log and the time it was rotated, and returns the hash of its content.
+// Logs keep the same content when rotated further, e.g. from .1 to .2 or
+// compressed to .2.gz, so content already uploaded under another name is
+// reported as uploaded rather than uploaded again.
+func rotatedUpload(p *Path, file string, info os.FileInfo, dest *config.Destination) (string, bool, error) {
+	hash, err := rotatedHash(file)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, fs := range state.Files(p.Path) {
+		if fs.Rotated == hash {
+			return hash, true, nil
+		}
+	}
+
+	if dest.Name == "" {
+		dest.Name = rotatedObjectName(file)
+	}
+
+	dest.Version = info.ModTime().UTC().Format(snapshotTimeFormat)
+
+	return hash, false, nil
+}
+
+// rotatedHash returns the hex encoded sha256 of the content of file,
+// decompressed when it is compressed with gzip or bzip2.
+func rotatedHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+
+	switch filepath.Ext(file) {
+	case ".gz":
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return "", fmt.Errorf("unable to decompress %s: %w", file, err)
+		}
+		defer zr.Close()
+
+		r = zr
+	case ".bz2":
+		r = bzip2.NewReader(f)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("unable to read %s: %w", file, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
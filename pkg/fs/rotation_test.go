@@ -0,0 +1,91 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatedObjectName(t *testing.T) {
+	tests := []struct {
+		file    string
+		rotated bool
+		want    string
+	}{
+		{"/var/log/app.log", false, "app.log"},
+		{"/var/log/app.log.1", true, "app.log"},
+		{"/var/log/app.log.12.gz", true, "app.log.gz"},
+		{"/var/log/app.log.2.bz2", true, "app.log.bz2"},
+		{"/var/log/app.log-20230102", true, "app.log"},
+		{"/var/log/app.log-2023010215.gz", true, "app.log.gz"},
+		{"/var/log/app.log-202301.gz", false, "app.log-202301.gz"},
+		{"/var/log/app.log.3.xz", false, "app.log.3.xz"},
+		{"/var/log/app.log.3.zst", false, "app.log.3.zst"},
+	}
+
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.file), func(t *testing.T) {
+			if got := isRotated(tt.file); got != tt.rotated {
+				t.Errorf("isRotated(%q) = %v, want %v", tt.file, got, tt.rotated)
+			}
+
+			if got := rotatedObjectName(tt.file); got != tt.want {
+				t.Errorf("rotatedObjectName(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatedHashDecompresses(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("line one\nline two\n")
+
+	var gz bytes.Buffer
+
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(content); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	plain := filepath.Join(dir, "app.log.1")
+	compressed := filepath.Join(dir, "app.log.2.gz")
+
+	if err := os.WriteFile(plain, content, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if err := os.WriteFile(compressed, gz.Bytes(), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	want, err := rotatedHash(plain)
+	if err != nil {
+		t.Fatalf("rotatedHash(%s): %v", plain, err)
+	}
+
+	if got, err := rotatedHash(compressed); err != nil || got != want {
+		t.Errorf("rotatedHash(%s) = %q, %v, want %q", compressed, got, err, want)
+	}
+}
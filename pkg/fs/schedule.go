@@ -0,0 +1,84 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// jitter returns the delay added to the runs of a path, at most limit
+// seconds.
+func jitter(limit int) time.Duration {
+	return config.Jitter(time.Duration(limit) * time.Second)
+}
+
+// waitJitter delays the first tick of work repeated every interval by the
+// jitter of maxJitter seconds, at most interval. It returns false if ctx is
+// done first.
+func waitJitter(ctx context.Context, maxJitter int, interval time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(config.Jitter(min(time.Duration(maxJitter)*time.Second, interval))):
+		return true
+	}
+}
+
+func schedulePath(p *Path, ctx context.Context, maxJitter int) error {
+	delay := jitter(maxJitter)
+
+	_, err := processFrom(ctx).scheduler.AddFunc(p.Schedule, func() {
+		klog.V(3).InfoS("scheduled backup triggered", "path", p.Path, "jitter", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		backupRun(p, ctx, false)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for %s: %w", p.Schedule, p.Path, err)
+	}
+
+	klog.V(2).InfoS("scheduled backup", "path", p.Path, "schedule", p.Schedule, "jitter", delay)
+
+	return nil
+}
+
+func startScheduler(ctx context.Context) {
+	proc := processFrom(ctx)
+	if len(proc.scheduler.Entries()) == 0 {
+		return
+	}
+
+	proc.scheduler.Start()
+	proc.wg.Add(1)
+
+	go func() {
+		<-ctx.Done()
+		<-proc.scheduler.Stop().Done()
+		proc.wg.Done()
+	}()
+}
@@ -21,16 +21,22 @@ import (
 	"context"
 	"os"
 	"os/signal"
-	"syscall"
 
 	"k8s.io/klog/v2"
 )
 
-func setupSignalNotify(cancel context.CancelFunc) {
+// setupSignalNotify calls cancel on the first of shutdownSignals received
+// before ctx is done.
+func setupSignalNotify(ctx context.Context, cancel context.CancelFunc) {
 	cancelChan := make(chan os.Signal, 1)
-	signal.Notify(cancelChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(cancelChan, shutdownSignals...)
 
-	sig := <-cancelChan
-	klog.InfoS("shutting down", "signal", sig)
-	cancel()
+	defer signal.Stop(cancelChan)
+
+	select {
+	case <-ctx.Done():
+	case sig := <-cancelChan:
+		klog.InfoS("shutting down", "signal", sig)
+		cancel()
+	}
 }
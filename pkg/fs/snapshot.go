@@ -0,0 +1,143 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	mc "github.com/minio/minio-go/v7"
+)
+
+const (
+	snapshotTimestamp = "timestamp"
+	snapshotSequence  = "sequence"
+
+	snapshotTimeFormat      = "20060102T150405Z"
+	snapshotTimeFormatMilli = "20060102T150405.000Z"
+)
+
+func parseSnapshot(snapshot string) (string, error) {
+	switch strings.ToLower(snapshot) {
+	case "", "none":
+		return "", nil
+	case snapshotTimestamp, "time":
+		return snapshotTimestamp, nil
+	case snapshotSequence, "seq":
+		return snapshotSequence, nil
+	default:
+		return "", fmt.Errorf("unknown snapshot %s", snapshot)
+	}
+}
+
+// snapshotVersion returns the version the next upload of file is named with.
+// Sequences continue from the last recorded upload of file, or from the
+// highest sequence found in the bucket when none is recorded.
+func snapshotVersion(p *Path, file string, dest config.Destination, ctx context.Context) (string, error) {
+	if p.Snapshot == snapshotTimestamp {
+		now := time.Now().UTC()
+		version := now.Format(snapshotTimeFormat)
+
+		// a second upload within the same second must not overwrite the first
+		if last, ok := state.LastUpload(p.Path, file); ok {
+			dest.Version = version
+			if last.Object == minio.ObjectName(file, dest) {
+				version = now.Format(snapshotTimeFormatMilli)
+			}
+		}
+
+		return version, nil
+	}
+
+	if last, ok := state.LastUpload(p.Path, file); ok && last.Sequence > 0 {
+		return strconv.FormatInt(last.Sequence+1, 10), nil
+	}
+
+	seq, err := lastSequence(p, file, dest, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(seq+1, 10), nil
+}
+
+// lastSequence lists the snapshots of file in the bucket and returns the
+// highest sequence among them, or 0 when there are none. Sharded snapshots
+// are spread over every shard under the destination path, so each candidate
+// is only counted when its version is sharded to its key.
+func lastSequence(p *Path, file string, dest config.Destination, ctx context.Context) (int64, error) {
+	dest.Version = "*"
+	pattern := minio.LogicalName(file, dest)
+	prefix, suffix, _ := strings.Cut(strings.TrimPrefix(pattern, "/"), "*")
+
+	dir, name := path.Split(prefix)
+	sharded := dest.ShardWidth > 0
+
+	if sharded {
+		dir, prefix = strings.TrimPrefix(dest.Path, "/"), name
+	}
+
+	var last int64
+
+	err := clientFor(p, ctx).Walk(ctx, dir, false, func(o mc.ObjectInfo) error {
+		key := strings.TrimPrefix(o.Key, "/")
+
+		candidate := key
+		if sharded {
+			candidate = path.Base(key)
+		}
+
+		if !strings.HasPrefix(candidate, prefix) || !strings.HasSuffix(candidate, suffix) {
+			return nil
+		}
+
+		version := strings.TrimSuffix(strings.TrimPrefix(candidate, prefix), suffix)
+
+		seq, err := strconv.ParseInt(version, 10, 64)
+		if err != nil || seq <= last {
+			return nil
+		}
+
+		if sharded {
+			if dest.Version = version; minio.ObjectName(file, dest) != key {
+				return nil
+			}
+		}
+
+		last = seq
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to find last snapshot of %s: %w", file, err)
+	}
+
+	return last, nil
+}
+
+// sequenceOf returns the sequence number of a snapshot version, or 0.
+func sequenceOf(version string) int64 {
+	seq, _ := strconv.ParseInt(version, 10, 64)
+	return seq
+}
@@ -0,0 +1,86 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+)
+
+func TestLastSequence(t *testing.T) {
+	tests := []struct {
+		name       string
+		shardWidth int
+	}{
+		{"unsharded", 0},
+		{"sharded", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := minio.NewFake("fake")
+			ctx := context.WithValue(context.Background(), config.MC, minio.MinioClient(client))
+
+			dir := t.TempDir()
+			file := filepath.Join(dir, "db.sql")
+			other := filepath.Join(dir, "db.sql.gz")
+
+			for _, f := range []string{file, other} {
+				if err := os.WriteFile(f, []byte(f), 0o600); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			p, err := NewPath(dir)
+			if err != nil {
+				t.Fatalf("NewPath: %v", err)
+			}
+
+			dest := config.Destination{Path: "backups", ShardWidth: tt.shardWidth}
+
+			for _, upload := range []struct {
+				file    string
+				version int
+			}{{file, 1}, {file, 3}, {file, 2}, {other, 9}} {
+				d := dest
+				d.Version = strconv.Itoa(upload.version)
+
+				if err := client.UploadFileWithDestination(upload.file, d, ctx); err != nil {
+					t.Fatalf("upload: %v", err)
+				}
+			}
+
+			// a later sequence in a shard it does not belong to
+			if err := client.Upload(ctx, "backups/zz/db-7.sql", strings.NewReader("stray"), -1, ""); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+
+			got, err := lastSequence(p, file, dest, ctx)
+			if err != nil || got != 3 {
+				t.Errorf("lastSequence = %d, %v, want 3", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+)
+
+// Source produces backups itself instead of reading them from files under a
+// path, e.g. a database dump. Sources with a schedule run on it, others run
+// once.
+type Source interface {
+	Name() string
+	Schedule() string
+	Backup(ctx context.Context, client minio.MinioClient) error
+}
+
+func (c *Config) startSource(s Source, ctx context.Context) {
+	proc := processFrom(ctx)
+
+	if s.Schedule() == "" {
+		proc.wg.Add(1)
+
+		go func() {
+			defer proc.wg.Done()
+
+			runSource(s, ctx)
+		}()
+
+		return
+	}
+
+	delay := jitter(c.opts.ScheduleJitter)
+
+	_, err := proc.scheduler.AddFunc(s.Schedule(), func() {
+		klog.V(3).InfoS("scheduled backup triggered", "source", s.Name(), "jitter", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		runSource(s, ctx)
+	})
+	if err != nil {
+		klog.ErrorS(fmt.Errorf("invalid schedule %q for %s: %w", s.Schedule(), s.Name(), err), "unable to schedule source", "source", s.Name())
+		return
+	}
+
+	klog.V(2).InfoS("scheduled backup", "source", s.Name(), "schedule", s.Schedule(), "jitter", delay)
+}
+
+// runSource runs one backup of s, recording its outcome like that of a file.
+// Backups started before shutdown get shutdown-timeout to complete.
+func runSource(s Source, ctx context.Context) {
+	if deferIfPaused("source:"+s.Name(), s.Name(), "", ctx, func(ctx context.Context) { runSource(s, ctx) }) {
+		return
+	}
+
+	parent := ctx
+
+	ctx, cancel := uploadContext(ctx)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "source.backup", attribute.String("source", s.Name()))
+	defer span.End()
+
+	start := time.Now()
+
+	err := s.Backup(ctx, ctx.Value(config.MC).(minio.MinioClient))
+	if errors.Is(err, minio.ErrCircuitOpen) {
+		deferUpload("source:"+s.Name(), s.Name(), "", parent, func(ctx context.Context) { runSource(s, ctx) })
+		return
+	}
+
+	metrics.SourceDuration.WithLabelValues(s.Name()).Set(time.Since(start).Seconds())
+
+	if err != nil {
+		tracing.Fail(span, err)
+		klog.ErrorS(err, "backup failed", "source", s.Name(), "duration", time.Since(start))
+		metrics.SourceBackups.WithLabelValues(s.Name(), "failed").Inc()
+		events.Warning(events.ReasonBackupFailed, "backup of %s failed: %v", s.Name(), err)
+		recordFailed(s.Name(), "", err, ctx)
+
+		return
+	}
+
+	klog.InfoS("backup complete", "source", s.Name(), "duration", time.Since(start))
+	events.Normal(events.ReasonBackupCompleted, "backup of %s completed", s.Name())
+	metrics.SourceBackups.WithLabelValues(s.Name(), "succeeded").Inc()
+	metrics.SourceLastSuccess.WithLabelValues(s.Name()).SetToCurrentTime()
+	recordUploaded(s.Name(), "", "", 0, "", ctx)
+}
@@ -0,0 +1,162 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"k8s.io/klog/v2"
+)
+
+const stalenessInterval = time.Minute
+
+// staleness holds which paths of a Config were last found stale.
+type staleness struct {
+	sync.Mutex
+	stale map[string]bool
+}
+
+// setStarted records t as when processing began.
+func (c *Config) setStarted(t time.Time) {
+	c.started.Store(&t)
+}
+
+// lastSuccess returns when p last uploaded successfully, and whether it ever
+// has. Paths that never have use started, when processing began.
+func (p *Path) lastSuccess(started time.Time) (time.Time, bool) {
+	last := state.LastSuccess(p.Path)
+	if last.IsZero() {
+		return started, false
+	}
+
+	return last, true
+}
+
+// isStale reports whether p has gone longer than MaxStaleness without a
+// successful upload since processing began at started.
+func (p *Path) isStale(now, started time.Time) bool {
+	if !p.Enabled || p.MaxStaleness <= 0 {
+		return false
+	}
+
+	last, _ := p.lastSuccess(started)
+
+	return now.Sub(last) > p.MaxStaleness
+}
+
+// checkStaleness updates the stale metric of every path, logging an error
+// when a path becomes stale and when it recovers.
+func (c *Config) checkStaleness(now time.Time) {
+	c.staleness.Lock()
+	defer c.staleness.Unlock()
+
+	started := *c.started.Load()
+
+	for _, p := range c.Paths {
+		if !p.Enabled || p.MaxStaleness <= 0 {
+			continue
+		}
+
+		stale := p.isStale(now, started)
+		last, ok := p.lastSuccess(started)
+
+		switch {
+		case stale && !c.staleness.stale[p.Path]:
+			events.Warning(events.ReasonBackupStale, "no successful upload of %s within %s", p.Path, p.MaxStaleness)
+
+			if ok {
+				klog.ErrorS(nil, "no successful upload within max-staleness", "path", p.Path, "max-staleness", p.MaxStaleness, "last-success", last)
+			} else {
+				klog.ErrorS(nil, "no successful upload within max-staleness since startup", "path", p.Path, "max-staleness", p.MaxStaleness)
+			}
+		case !stale && c.staleness.stale[p.Path]:
+			klog.InfoS("path no longer stale", "path", p.Path, "last-success", last)
+		}
+
+		c.staleness.stale[p.Path] = stale
+
+		if stale {
+			metrics.PathStale.WithLabelValues(p.Path).Set(1)
+		} else {
+			metrics.PathStale.WithLabelValues(p.Path).Set(0)
+		}
+	}
+}
+
+// watchStaleness checks every path with max-staleness until ctx is done,
+// at least once a minute and more often for shorter max-staleness. The first
+// check is delayed by the schedule jitter.
+func (c *Config) watchStaleness(ctx context.Context) {
+	interval := time.Duration(0)
+
+	for _, p := range c.Paths {
+		if p.Enabled && p.MaxStaleness > 0 && (interval == 0 || p.MaxStaleness < interval) {
+			interval = p.MaxStaleness
+		}
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	interval = min(interval, stalenessInterval)
+
+	if !waitJitter(ctx, c.opts.ScheduleJitter, interval) {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		c.checkStaleness(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Stale returns an error naming every path that has gone longer than its
+// max-staleness without a successful upload.
+func (c *Config) Stale() error {
+	var stale []string
+
+	now, started := time.Now(), *c.started.Load()
+
+	for _, p := range c.Paths {
+		if p.isStale(now, started) {
+			stale = append(stale, p.Path)
+		}
+	}
+
+	if len(stale) > 0 {
+		return fmt.Errorf("no successful upload within max-staleness: %s", strings.Join(stale, ", "))
+	}
+
+	return nil
+}
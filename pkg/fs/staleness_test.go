@@ -0,0 +1,67 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+)
+
+func TestStalenessPerConfig(t *testing.T) {
+	if err := state.Init(""); err != nil {
+		t.Fatalf("state.Init: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		started time.Duration // How long ago processing of the Config began
+		want    bool
+	}{
+		{"started long ago", 2 * time.Hour, true},
+		{"started just now", 0, false},
+	}
+
+	configs := make([]*Config, len(tests))
+
+	for i, tt := range tests {
+		p, err := NewPath(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewPath: %v", err)
+		}
+
+		p.MaxStaleness = time.Hour
+
+		c, err := NewWithConfig(Options{Paths: []*Path{p}})
+		if err != nil {
+			t.Fatalf("NewWithConfig: %v", err)
+		}
+
+		c.setStarted(time.Now().Add(-tt.started))
+		configs[i] = c
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configs[i].Stale() != nil; got != tt.want {
+				t.Errorf("stale = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/history"
+	"k8s.io/klog/v2"
+)
+
+// subscribers receive the outcome of every file and source processed.
+var subscribers = struct {
+	sync.Mutex
+	chans map[chan history.Record]struct{}
+}{chans: make(map[chan history.Record]struct{})}
+
+// Subscribe returns a channel receiving the outcome of every file and source
+// processed until ctx is done, when it is closed. Outcomes are dropped while
+// buffer outcomes are waiting to be received, so a slow subscriber never holds
+// up uploads.
+func Subscribe(ctx context.Context, buffer int) <-chan history.Record {
+	ch := make(chan history.Record, buffer)
+
+	subscribers.Lock()
+	subscribers.chans[ch] = struct{}{}
+	subscribers.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		subscribers.Lock()
+		defer subscribers.Unlock()
+
+		delete(subscribers.chans, ch)
+		close(ch)
+	})
+
+	return ch
+}
+
+// publish sends r to every subscriber with room for it.
+func publish(r history.Record) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+
+	for ch := range subscribers.chans {
+		select {
+		case ch <- r:
+		default:
+			klog.V(2).InfoS("subscriber is behind, dropping outcome", "path", r.Path, "file", r.File)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+// PathSummary describes what the sidecar does with a path.
+type PathSummary struct {
+	Path            string   `json:"path"`
+	Mode            string   `json:"mode"`
+	Recursive       bool     `json:"recursive,omitempty"`
+	Events          []string `json:"events,omitempty"`
+	Wait            string   `json:"wait,omitempty"`
+	InitialScan     bool     `json:"initialScan,omitempty"`
+	ChangedOnly     bool     `json:"changedOnly,omitempty"`
+	Include         []string `json:"include,omitempty"`
+	Exclude         []string `json:"exclude,omitempty"`
+	Destination     string   `json:"destination"`
+	Profile         string   `json:"profile,omitempty"`
+	Priority        int      `json:"priority,omitempty"`
+	Transforms      []string `json:"transforms,omitempty"`
+	DeleteOnSuccess bool     `json:"deleteOnSuccess,omitempty"`
+	MaxStaleness    string   `json:"maxStaleness,omitempty"`
+	LocalRetention  string   `json:"localRetention,omitempty"`
+}
+
+// Summary describes every configured path, including disabled ones.
+func (c *Config) Summary() []PathSummary {
+	summary := make([]PathSummary, 0, len(c.Paths))
+
+	for _, p := range c.Paths {
+		summary = append(summary, p.summary())
+	}
+
+	return summary
+}
+
+func (p *Path) summary() PathSummary {
+	s := PathSummary{
+		Path:            p.Path,
+		Mode:            p.mode(),
+		Recursive:       p.Recursive,
+		Include:         p.Include,
+		Exclude:         p.Exclude,
+		Destination:     destinationTemplate(p.Destination),
+		Profile:         p.Profile,
+		Priority:        p.Priority,
+		DeleteOnSuccess: p.DeleteOnSuccess,
+	}
+
+	if p.MaxStaleness > 0 {
+		s.MaxStaleness = p.MaxStaleness.String()
+	}
+
+	if p.LocalRetention > 0 {
+		s.LocalRetention = p.LocalRetention.String()
+	}
+
+	if p.Watch {
+		s.InitialScan = p.InitialScan
+		s.Wait = (time.Duration(p.WaitTime) * time.Second).String()
+
+		if p.Debounce == DebounceAdaptive {
+			if p.MaxWaitTime > p.WaitTime {
+				s.Wait += "-" + (time.Duration(p.MaxWaitTime) * time.Second).String()
+			}
+
+			s.Wait += " " + DebounceAdaptive
+		}
+	}
+
+	if !p.Watch && p.Schedule == "" {
+		s.ChangedOnly = p.ChangedOnly
+	}
+
+	if p.Watch && p.Events != nil {
+		if p.Events.Create {
+			s.Events = append(s.Events, "create")
+		}
+
+		if p.Events.Write {
+			s.Events = append(s.Events, "write")
+		}
+
+		if p.Events.Remove {
+			s.Events = append(s.Events, "remove")
+		}
+	}
+
+	if p.Archive != "" {
+		s.Transforms = append(s.Transforms, "archive:"+p.Archive)
+	}
+
+	for _, f := range p.Destination.Filters {
+		s.Transforms = append(s.Transforms, "filter:"+f)
+	}
+
+	if p.Destination.Compression != "" {
+		s.Transforms = append(s.Transforms, "compress:"+p.Destination.Compression)
+	}
+
+	if p.Destination.ChunkSize > 0 {
+		s.Transforms = append(s.Transforms, fmt.Sprintf("chunk:%d", p.Destination.ChunkSize))
+	}
+
+	if p.Destination.ShardWidth > 0 {
+		s.Transforms = append(s.Transforms, "shard")
+	}
+
+	if p.Destination.Partition != "" {
+		s.Transforms = append(s.Transforms, "partition:"+partitionLayout(p.Destination.Partition))
+	}
+
+	if p.OverrideSuffix != "" {
+		s.Transforms = append(s.Transforms, "override:*"+p.OverrideSuffix)
+	}
+
+	if p.Snapshot != "" {
+		s.Transforms = append(s.Transforms, "snapshot:"+p.Snapshot)
+	}
+
+	if p.Append {
+		s.Transforms = append(s.Transforms, "append")
+	}
+
+	if p.Rotation {
+		s.Transforms = append(s.Transforms, "rotation")
+	}
+
+	if len(p.Group) > 0 {
+		s.Transforms = append(s.Transforms, "group:"+strings.Join(p.Group, ","))
+	}
+
+	if p.Collision != "" {
+		s.Transforms = append(s.Transforms, "collision:"+p.Collision)
+	}
+
+	if p.RemoteDelete != "" {
+		s.Transforms = append(s.Transforms, "remote-delete:"+p.RemoteDelete)
+	}
+
+	if p.TombstoneSuffix != "" {
+		s.Transforms = append(s.Transforms, "tombstone:"+p.TombstoneSuffix)
+	}
+
+	if p.Manifests {
+		s.Transforms = append(s.Transforms, "manifests")
+	}
+
+	return s
+}
+
+func (p *Path) mode() string {
+	switch {
+	case !p.Enabled:
+		return "disabled"
+	case p.Schedule != "" && p.Watch:
+		return "watch+schedule " + p.Schedule
+	case p.Schedule != "":
+		return "schedule " + p.Schedule
+	case p.Watch && p.WatchMode == watchModePoll:
+		return fmt.Sprintf("poll %ds", p.PollInterval)
+	case p.Watch && p.WatchMode == WatchModeManual:
+		return "manual"
+	case p.Watch:
+		return "watch"
+	default:
+		return "one-shot"
+	}
+}
+
+// destinationTemplate shows where objects land, with {file} standing in for
+// names taken from the source file.
+func destinationTemplate(d config.Destination) string {
+	name := d.Name
+	if name == "" {
+		name = "{file}"
+	}
+
+	return path.Join(d.Path, name)
+}
@@ -0,0 +1,29 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import "maps"
+
+// MergeTags returns base with the tags in override added or replaced.
+func MergeTags(base, override map[string]string) map[string]string {
+	t := make(map[string]string, len(base)+len(override))
+	maps.Copy(t, base)
+	maps.Copy(t, override)
+
+	return t
+}
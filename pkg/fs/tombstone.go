@@ -0,0 +1,95 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"k8s.io/klog/v2"
+)
+
+type tombstone struct {
+	Path      string    `json:"path"`
+	Object    string    `json:"object"`
+	DeletedAt time.Time `json:"deletedAt"`
+	Pod       string    `json:"pod"`
+}
+
+// writeTombstone uploads a small JSON object recording that file was removed,
+// named after the object file was uploaded to plus the tombstone suffix.
+// object is the key of the last upload of file, when it is known. Tombstones
+// of partitioned paths are written to the partition of their deletion.
+func writeTombstone(p *Path, file, object string, ctx context.Context) {
+	if object == "" {
+		object = minio.LogicalName(file, p.Destination)
+	}
+
+	body, err := json.Marshal(tombstone{
+		Path:      file,
+		Object:    object,
+		DeletedAt: time.Now().UTC(),
+		Pod:       config.PodName(),
+	})
+	if err != nil {
+		klog.ErrorS(err, "unable to create tombstone", "file", file)
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "minio-backup-tombstone-*")
+	if err != nil {
+		klog.ErrorS(err, "unable to create tombstone", "file", file)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		klog.ErrorS(err, "unable to create tombstone", "file", file)
+
+		return
+	}
+
+	if err := tmp.Close(); err != nil {
+		klog.ErrorS(err, "unable to create tombstone", "file", file)
+		return
+	}
+
+	dest := partitioned(p, p.Destination, "")
+	dest.Name = path.Base(object) + p.TombstoneSuffix
+	dest.Type = "application/json"
+	dest.Compression = ""
+	dest.Metadata = MergeTags(dest.Metadata, map[string]string{
+		minio.MetadataTombstoneFor: object,
+		minio.MetadataSourcePath:   file,
+	})
+
+	if err := clientFor(p, ctx).UploadFileWithDestination(tmp.Name(), dest, ctx); err != nil {
+		klog.V(4).ErrorS(err, "failed tombstone upload", "file", file, "fsPath", p)
+		return
+	}
+
+	audit.Write(audit.Record{Op: audit.OpTombstone, Path: p.Path, File: file, Object: minio.ObjectName(tmp.Name(), dest)})
+	klog.V(2).InfoS("wrote tombstone", "file", file, "object", object)
+}
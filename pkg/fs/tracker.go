@@ -0,0 +1,91 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import "sync"
+
+// tracker counts the goroutines a Process waits for, like a sync.WaitGroup
+// that refuses work offered once the wait ended. Work started outside
+// Process, such as deferred uploads run when a target recovers, cannot race
+// with the wait. Each Process uses its own tracker.
+type tracker struct {
+	mu      sync.Mutex
+	n       int
+	idle    chan struct{} // Closed when n drops to zero while waited on
+	stopped bool
+}
+
+// Add adds delta to the count of running goroutines.
+func (t *tracker) Add(delta int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.n += delta
+
+	if t.n < 0 {
+		panic("fs: negative tracker count")
+	}
+
+	if t.n == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// Done marks a goroutine as finished.
+func (t *tracker) Done() {
+	t.Add(-1)
+}
+
+// TryAdd counts one more goroutine unless the wait already ended, and
+// reports whether it did.
+func (t *tracker) TryAdd() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return false
+	}
+
+	t.n++
+
+	return true
+}
+
+// Wait blocks until no goroutine runs, then refuses further TryAdd calls.
+func (t *tracker) Wait() {
+	for {
+		t.mu.Lock()
+
+		if t.n == 0 {
+			t.stopped = true
+			t.mu.Unlock()
+
+			return
+		}
+
+		if t.idle == nil {
+			t.idle = make(chan struct{})
+		}
+
+		idle := t.idle
+		t.mu.Unlock()
+
+		<-idle
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	var tr tracker
+
+	tr.Add(1)
+
+	waited := make(chan struct{})
+
+	go func() {
+		tr.Wait()
+		close(waited)
+	}()
+
+	if !tr.TryAdd() {
+		t.Fatal("TryAdd refused work while goroutines run")
+	}
+
+	tr.Done()
+
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while a goroutine runs")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	tr.Done()
+	<-waited
+
+	if tr.TryAdd() {
+		t.Error("TryAdd accepted work after Wait returned")
+	}
+}
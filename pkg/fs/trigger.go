@@ -0,0 +1,89 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// Backup uploads every file under the enabled path named target, or runs the
+// source named target, now instead of waiting for changes or its schedule.
+// Every enabled path and source is backed up when target is empty. With
+// changedOnly, files unchanged since their last recorded upload are skipped.
+// It returns the outcome once the backup completes, and stops early when ctx
+// is done.
+func (c *Config) Backup(ctx context.Context, target string, changedOnly bool) (Results, error) {
+	pctx := c.processing()
+	if pctx == nil {
+		return Results{}, errors.New("paths are not being processed")
+	}
+
+	if Paused().Paused {
+		return Results{}, errors.New("uploads are paused")
+	}
+
+	var (
+		paths   []*Path
+		sources []Source
+	)
+
+	for _, p := range c.Paths {
+		if p.Enabled && (target == "" || p.Path == target) {
+			paths = append(paths, p)
+		}
+	}
+
+	for _, s := range c.opts.Sources {
+		if target == "" || s.Name() == target {
+			sources = append(sources, s)
+		}
+	}
+
+	if len(paths) == 0 && len(sources) == 0 {
+		return Results{}, fmt.Errorf("no enabled path or source %s", target)
+	}
+
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	bctx, cancel := uploadContext(pctx)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	klog.InfoS("backup triggered", "target", target, "changedOnly", changedOnly)
+
+	bctx, r := withRun(bctx)
+
+	for _, p := range paths {
+		backupRun(p, bctx, changedOnly)
+	}
+
+	for _, s := range sources {
+		runSource(s, bctx)
+	}
+
+	run := r.results()
+	klog.InfoS("triggered backup complete", "target", target, "uploaded", run.Uploaded, "skipped", run.Skipped, "failed", run.Failed)
+
+	return run, ctx.Err()
+}
@@ -19,12 +19,21 @@ package fs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"time"
 
+	"github.com/csfreak/minio-backup-sidecar/pkg/audit"
 	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/history"
+	"github.com/csfreak/minio-backup-sidecar/pkg/hooks"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
 	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
@@ -41,79 +50,296 @@ func checkDir(p string) error {
 	return nil
 }
 
-func recursiveDirList(p string) (*[]string, error) {
-	if err := checkDir(p); err != nil {
-		klog.V(3).ErrorS(err, "unable to process path", "path", "p")
+// clientFor returns the client that uploads files under p.
+func clientFor(p *Path, ctx context.Context) minio.MinioClient {
+	if p.Profile != "" {
+		return ctx.Value(config.Profiles).(map[string]minio.MinioClient)[p.Profile]
+	}
+
+	return ctx.Value(config.MC).(minio.MinioClient)
+}
 
-		return nil, err
+func callUpload(p *Path, file string, ctx context.Context) {
+	if deferIfPaused("file:"+file, p.Path, file, ctx, func(ctx context.Context) { callUpload(p, file, ctx) }) {
+		klog.V(2).InfoS("uploads paused, upload deferred", "file", file)
+		return
 	}
 
-	dirs := []string{p}
+	// live logs are uploaded once rotated
+	if p.Rotation && !isRotated(file) {
+		klog.V(4).InfoS("not uploading live log", "file", file)
+		return
+	}
 
-	fs, err := os.ReadDir(p)
-	if err != nil {
-		klog.V(3).ErrorS(err, "unable to process dir", "path", "p")
-		return nil, fmt.Errorf("unable to process dir %s: %w", p, err)
+	klog.V(2).InfoS("uploading file", "file", file)
+
+	ctx, span := tracing.Start(ctx, "fs.upload", attribute.String("file", file), attribute.String("path", p.Path))
+	defer span.End()
+
+	parent := ctx
+
+	ctx, cancel := uploadContext(minio.WithPriority(ctx, p.Priority))
+	defer cancel()
+
+	var hash string
+
+	dest := p.Destination
+	if err := applyOverride(p, file, &dest); err != nil {
+		klog.ErrorS(err, "unable to apply destination override", "file", file)
+		recordFailed(p.Path, file, err, ctx)
+
+		return
 	}
 
-	for _, f := range fs {
-		if f.IsDir() {
-			d, err := recursiveDirList(path.Join(p, f.Name()))
-			if err != nil {
-				klog.V(3).ErrorS(err, "unable to process dir", "path", "p", "directory", f.Name())
-				return &dirs, err
-			}
+	if err := hooks.RunPreUpload(ctx, file, &dest); err != nil {
+		klog.InfoS("skipping upload", "file", file, "reason", err)
+		metrics.UploadsSkipped.WithLabelValues("hook").Inc()
+		recordSkipped(p.Path, file, "hook: "+err.Error(), ctx)
+
+		return
+	}
 
-			dirs = append(dirs, *d...)
+	dest = partitioned(p, dest, file)
+
+	var rotated string
+
+	if p.Rotation {
+		info, err := os.Stat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			klog.V(2).InfoS("rotated log compressed or removed before upload", "file", file)
+			return
 		}
+
+		if err != nil {
+			klog.ErrorS(err, "unable to stat file", "file", file)
+			recordFailed(p.Path, file, err, ctx)
+
+			return
+		}
+
+		hash, uploaded, err := rotatedUpload(p, file, info, &dest)
+		if err != nil {
+			klog.ErrorS(err, "unable to identify rotated log", "file", file)
+			recordFailed(p.Path, file, err, ctx)
+
+			return
+		}
+
+		if uploaded {
+			klog.V(2).InfoS("skipping rotated log already uploaded under another name", "file", file)
+			metrics.UploadsSkipped.WithLabelValues("duplicate").Inc()
+			recordSkipped(p.Path, file, "duplicate", ctx)
+			state.RecordFile(p.Path, file, state.FileState{Size: info.Size(), Mtime: info.ModTime(), Rotated: hash})
+
+			return
+		}
+
+		rotated = hash
 	}
 
-	return &dirs, nil
-}
+	key := minio.ObjectName(file, dest)
 
-func fileList(p string) (*[]string, error) {
-	info, err := os.Stat(p)
+	if p.DedupeWindow > 0 {
+		h, err := minio.HashFile(file)
+		if err != nil {
+			klog.ErrorS(err, "unable to hash file", "file", file)
+			recordFailed(p.Path, file, err, ctx)
+
+			return
+		}
+
+		if uploadsFrom(ctx).duplicate(key, h, time.Duration(p.DedupeWindow)*time.Second) {
+			klog.V(2).InfoS("skipping upload of unchanged content", "file", file, "object", key)
+			metrics.UploadsSkipped.WithLabelValues("duplicate").Inc()
+			recordSkipped(p.Path, file, "duplicate", ctx)
+
+			return
+		}
+
+		hash = h
+	}
+
+	// duplicates are detected by the name snapshots are taken of
+	dedupeKey := key
+
+	if p.Snapshot != "" {
+		version, err := snapshotVersion(p, file, dest, ctx)
+		if err != nil {
+			klog.ErrorS(err, "unable to name snapshot", "file", file)
+			recordFailed(p.Path, file, err, ctx)
+
+			return
+		}
+
+		dest.Version = version
+		key = minio.ObjectName(file, dest)
+	}
+
+	info, err := os.Stat(file)
 	if err != nil {
-		klog.V(3).ErrorS(err, "unable to process path", "path", "p")
-		return nil, fmt.Errorf("unable to process path %s: %w", p, err)
+		klog.ErrorS(err, "unable to stat file", "file", file)
+		recordFailed(p.Path, file, err, ctx)
+
+		return
 	}
 
-	if !info.IsDir() {
-		return &[]string{p}, nil
+	var appended state.FileState
+
+	sent := info.Size()
+
+	if p.Append {
+		appended, err = appendSegment(p, file, info.Size(), &dest, ctx)
+		if errors.Is(err, errNothingAppended) {
+			klog.V(2).InfoS("skipping upload of file that did not grow", "file", file)
+			metrics.UploadsSkipped.WithLabelValues("unchanged").Inc()
+			recordSkipped(p.Path, file, "unchanged", ctx)
+
+			return
+		}
+
+		if err != nil {
+			klog.ErrorS(err, "unable to find appended bytes", "file", file)
+			recordFailed(p.Path, file, err, ctx)
+
+			return
+		}
+
+		key = minio.ObjectName(file, dest)
+		sent = dest.Length
 	}
 
-	files := []string{}
+	if err := resolveCollision(p, file, &dest, ctx); err != nil {
+		if errors.Is(err, errCollision) && p.Collision == collisionSkip {
+			klog.InfoS("skipping upload, object belongs to another file", "file", file, "reason", err)
+			metrics.UploadsSkipped.WithLabelValues("collision").Inc()
+			recordSkipped(p.Path, file, "collision", ctx)
 
-	fs, err := os.ReadDir(p)
-	if err != nil {
-		klog.V(3).ErrorS(err, "unable to process dir", "path", "p")
-		return nil, fmt.Errorf("unable to process dir %s: %w", p, err)
+			return
+		}
+
+		klog.ErrorS(err, "unable to upload file", "file", file)
+		events.Warning(events.ReasonBackupFailed, "upload of %s failed: %v", file, err)
+		recordFailed(p.Path, file, err, ctx)
+
+		return
 	}
 
-	for _, f := range fs {
-		if !f.IsDir() {
-			files = append(files, path.Join(p, f.Name()))
+	key = minio.ObjectName(file, dest)
+
+	if dest.SkipUnchanged != "" {
+		unchanged, err := clientFor(p, ctx).Unchanged(ctx, file, dest)
+		if err != nil {
+			klog.V(2).ErrorS(err, "unable to compare with remote object, uploading", "file", file)
+		}
+
+		if unchanged {
+			klog.V(2).InfoS("skipping upload of unchanged file", "file", file, "object", key)
+			metrics.UploadsSkipped.WithLabelValues("unchanged").Inc()
+			recordSkipped(p.Path, file, "unchanged", ctx)
+
+			return
 		}
 	}
 
-	return &files, nil
-}
+	err = clientFor(p, ctx).UploadFileWithDestination(file, dest, ctx)
+	if errors.Is(err, minio.ErrCircuitOpen) {
+		deferUpload("file:"+file, p.Path, file, minio.PendingTargets(parent, err), func(ctx context.Context) { callUpload(p, file, ctx) })
+		return
+	}
 
-func callUpload(p *fsPath, file string, ctx context.Context) {
-	klog.V(2).InfoS("uploading file", "file", file)
+	hooks.RunPostUpload(ctx, file, dest, err)
+
+	if err != nil {
+		tracing.Fail(span, err)
+		klog.V(4).ErrorS(err, "failed upload", "file", file, "fsPath", p)
+		events.Warning(events.ReasonBackupFailed, "upload of %s failed: %v", file, err)
+		recordFailedUpload(p.Path, file, key, err, ctx)
 
-	if err := ctx.Value(config.MC).(minio.MinioClient).UploadFileWithDestination(file, p.Destination, ctx); err != nil {
-		klog.ErrorS(err, "failed upload", "file", file, "fsPath", p)
 		return
 	}
 
-	if p.DeleteOnSuccess {
-		if err := os.Remove(file); err != nil {
-			klog.ErrorS(err, "failed to remove uploaded file", "file", file)
+	if hash == "" && (manifestFrom(ctx) != nil || history.Enabled()) {
+		if h, err := minio.HashFile(file); err == nil {
+			hash = h
 		}
 	}
+
+	uploaded := state.FileState{Size: info.Size(), Mtime: info.ModTime(), Hash: hash, Object: key, Uploaded: time.Now()}
+	if p.Snapshot == snapshotSequence {
+		uploaded.Sequence = sequenceOf(dest.Version)
+	}
+
+	if p.Append {
+		uploaded.Sequence, uploaded.Head, uploaded.HeadSize = appended.Sequence, appended.Head, appended.HeadSize
+	}
+
+	uploaded.Rotated = rotated
+
+	recordUploaded(p.Path, file, key, sent, hash, ctx)
+	state.RecordUpload(p.Path, sent)
+	state.RecordFile(p.Path, file, uploaded)
+	manifestFrom(ctx).addUploaded(file, key, uploaded)
+
+	if p.DedupeWindow > 0 && hash != "" {
+		uploadsFrom(ctx).record(dedupeKey, hash, time.Duration(p.DedupeWindow)*time.Second)
+	}
+
+	if p.DeleteOnSuccess {
+		deleteUploaded(p, file, dest, info, ctx)
+	}
+}
+
+// changedSinceUpload reports whether file differs from its last recorded
+// upload. Files of the same size are compared by mtime, then by content when
+// a hash was recorded.
+func changedSinceUpload(p *Path, file string) bool {
+	last, ok := state.LastUpload(p.Path, file)
+	if !ok {
+		return true
+	}
+
+	info, err := os.Stat(file)
+	if err != nil || info.Size() != last.Size {
+		return true
+	}
+
+	if info.ModTime().Equal(last.Mtime) {
+		return false
+	}
+
+	if last.Hash == "" {
+		return true
+	}
+
+	hash, err := minio.HashFile(file)
+
+	return err != nil || hash != last.Hash
 }
 
-func callDelete(_ *fsPath, file string, _ context.Context) {
-	klog.Info("delete called but not yet implemented", "file", file)
+// callDelete handles a removed file. Remote objects are only deleted with
+// remote-delete, and a tombstone can be written so consumers learn about the
+// removal.
+func callDelete(p *Path, file string, ctx context.Context) {
+	if deferIfPaused("file:"+file, p.Path, file, ctx, func(ctx context.Context) { callDelete(p, file, ctx) }) {
+		return
+	}
+
+	ctx, cancel := uploadContext(minio.WithPriority(ctx, p.Priority))
+	defer cancel()
+
+	last, _ := state.LastUpload(p.Path, file)
+
+	forgetFile(p, file, ctx)
+	audit.Write(audit.Record{Op: audit.OpDelete, Path: p.Path, File: file, Reason: "removed"})
+
+	if p.RemoteDelete != "" {
+		deleteRemote(p, file, last.Object, ctx)
+	}
+
+	if p.TombstoneSuffix == "" {
+		klog.V(2).InfoS("no tombstone for removed file, tombstones disabled", "file", file)
+		return
+	}
+
+	writeTombstone(p, file, last.Object, ctx)
 }
@@ -0,0 +1,245 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// walkWorkers bounds the directories read at once by walk. Reads mostly wait
+// on the filesystem, so more run than there are CPUs.
+var walkWorkers = max(4, 2*runtime.GOMAXPROCS(0))
+
+// walkBuffer bounds the directories read ahead of the callbacks.
+const walkBuffer = 64
+
+// walk calls dir, when set, with p.Path and, when p is recursive, every
+// directory below it down to MaxDepth, and file, when set, with every file in
+// them. Directories are read by up to walkWorkers goroutines while the
+// callbacks run in the calling goroutine as each one is read, so processing
+// starts before the whole tree is listed. A directory is always passed to dir
+// before those below it, but files are in no particular order. The first
+// error reading a directory or returned by a callback stops the walk.
+func walk(ctx context.Context, p *Path, dir func(string) error, file func(string, fs.DirEntry) error) error {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		klog.V(3).ErrorS(err, "unable to process path", "path", p.Path)
+		return fmt.Errorf("unable to process path %s: %w", p.Path, err)
+	}
+
+	if !info.IsDir() {
+		if p.Recursive {
+			return fmt.Errorf("not a directory: %s", p.Path)
+		}
+
+		if file == nil {
+			return nil
+		}
+
+		return file(p.Path, fs.FileInfoToDirEntry(info))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	q := &walkQueue{dirs: []queuedDir{{dir: p.Path}}, pending: 1, recursive: p.Recursive, maxDepth: p.MaxDepth}
+	q.cond = sync.NewCond(&q.mu)
+	context.AfterFunc(ctx, q.stop)
+
+	var (
+		wg      sync.WaitGroup
+		results = make(chan walkedDir, walkBuffer)
+	)
+
+	for range walkWorkers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			q.work(ctx, results)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		if r.err != nil {
+			return r.err
+		}
+
+		if dir != nil {
+			if err := dir(r.dir); err != nil {
+				return err
+			}
+		}
+
+		if file == nil {
+			continue
+		}
+
+		for _, e := range r.entries {
+			if e.IsDir() {
+				continue
+			}
+
+			if err := file(filepath.Join(r.dir, e.Name()), e); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if p.Recursive {
+		reportTruncated(p.Path, p.MaxDepth, int(q.skipped.Load()))
+	}
+
+	return nil
+}
+
+type queuedDir struct {
+	dir   string
+	depth int
+}
+
+type walkedDir struct {
+	dir     string
+	entries []fs.DirEntry
+	err     error
+}
+
+// walkQueue holds the directories waiting to be read by a walk.
+type walkQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []queuedDir
+	pending int // Directories queued or being read
+	stopped bool
+
+	recursive bool
+	maxDepth  int
+	skipped   atomic.Int64 // Directories deeper than maxDepth
+}
+
+// work reads queued directories into results until none are left or ctx is
+// done.
+func (q *walkQueue) work(ctx context.Context, results chan<- walkedDir) {
+	for {
+		d, ok := q.next()
+		if !ok {
+			return
+		}
+
+		entries, err := readDir(d.dir)
+		if err != nil {
+			klog.V(3).ErrorS(err, "unable to process dir", "path", d.dir)
+			err = fmt.Errorf("unable to process dir %s: %w", d.dir, err)
+		}
+
+		var subdirs []queuedDir
+
+		for _, e := range entries {
+			if !q.recursive || !e.IsDir() {
+				continue
+			}
+
+			if q.maxDepth > 0 && d.depth >= q.maxDepth {
+				q.skipped.Add(1)
+				continue
+			}
+
+			subdirs = append(subdirs, queuedDir{dir: filepath.Join(d.dir, e.Name()), depth: d.depth + 1})
+		}
+
+		select {
+		case results <- walkedDir{dir: d.dir, entries: entries, err: err}:
+		case <-ctx.Done():
+			return
+		}
+
+		// queued only once d is sent, so it is seen before them
+		q.done(subdirs)
+	}
+}
+
+// next returns the next directory to read, waiting while others are read
+// that may add more.
+func (q *walkQueue) next() (queuedDir, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.dirs) == 0 && q.pending > 0 && !q.stopped {
+		q.cond.Wait()
+	}
+
+	if len(q.dirs) == 0 || q.stopped {
+		return queuedDir{}, false
+	}
+
+	// depth first, so the queue holds the siblings along one branch rather
+	// than a whole level of the tree
+	d := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+
+	return d, true
+}
+
+// done queues the subdirectories of a directory once it is read.
+func (q *walkQueue) done(subdirs []queuedDir) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dirs = append(q.dirs, subdirs...)
+	q.pending += len(subdirs) - 1
+
+	if q.pending == 0 || len(subdirs) > 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *walkQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopped = true
+	q.cond.Broadcast()
+}
+
+// readDir returns the entries of dir unsorted, which os.ReadDir would sort.
+func readDir(dir string) ([]fs.DirEntry, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.ReadDir(-1)
+}
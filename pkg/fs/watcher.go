@@ -20,27 +20,49 @@ package fs
 import (
 	"context"
 	"errors"
-	"fmt"
-	"math"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
 	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
+const (
+	renameWindow     = time.Second      // how long after a rename a Create is taken as its new name
+	rewatchBaseDelay = time.Second      // first wait for a removed path to reappear
+	rewatchMaxDelay  = 30 * time.Second // longest wait between checks for a removed path
+	maxTimerLinks    = 32               // watch events linked from the span of the upload they cause
+)
+
 type watcher struct {
-	p        *fsPath
-	timers   map[string]*time.Timer
-	wait     time.Duration
-	_ctx     context.Context
-	_cancel  context.CancelFunc
-	_mu      sync.Mutex
-	_wg      *sync.WaitGroup
-	_watcher *fsnotify.Watcher
+	p          *Path
+	pending    map[string]*pendingChange
+	active     map[string]bool          // changes being acted on
+	activity   map[string]*fileActivity // waits learned by adaptive debounce
+	pruned     time.Time                // activity last pruned
+	running    int                      // changes being acted on, and rescans
+	slots      chan struct{}            // limits changes acted on at once
+	overflowed bool                     // changes were dropped since the last rescan
+	wait       time.Duration
+	maxWait    time.Duration
+	maxJitter  int // seconds the first poll may be delayed by
+	renamed    time.Time
+	rewatching bool
+	stopped    bool
+	done       *sync.Cond
+	_ctx       context.Context
+	_cancel    context.CancelFunc
+	_mu        sync.Mutex
+	_wg        *tracker
+	_watcher   *fsnotify.Watcher
 }
 
-func startNewWatcher(p *fsPath, ctx context.Context, wg *sync.WaitGroup) {
+func startNewWatcher(p *Path, ctx context.Context, wg *tracker, maxJitter int) {
 	klog.V(3).InfoS("start watching path", "path", p.Path)
 
 	if !p.Watch {
@@ -49,17 +71,42 @@ func startNewWatcher(p *fsPath, ctx context.Context, wg *sync.WaitGroup) {
 	}
 
 	w := &watcher{
-		p:      p,
-		wait:   time.Duration(p.WaitTime) * time.Second,
-		timers: make(map[string]*time.Timer),
-		_wg:    wg,
+		p:         p,
+		wait:      time.Duration(p.WaitTime) * time.Second,
+		maxWait:   time.Duration(p.MaxWaitTime) * time.Second,
+		maxJitter: maxJitter,
+		pending:   make(map[string]*pendingChange),
+		active:    make(map[string]bool),
+		activity:  make(map[string]*fileActivity),
+		slots:     make(chan struct{}, debounceWorkers),
+		_wg:       wg,
 	}
 
+	w.done = sync.NewCond(&w._mu)
+
 	w._ctx, w._cancel = context.WithCancel(ctx)
 
+	addWatcher(w)
+	w.startDebounce()
+
+	if p.WatchMode == watchModePoll {
+		klog.V(4).InfoS("polling path", "path", w.p.Path, "interval", p.PollInterval)
+		w.startWatcher()
+
+		return
+	}
+
+	if p.WatchMode == WatchModeManual {
+		klog.V(4).InfoS("path only receives notified events", "path", w.p.Path)
+		w.startWatcher()
+
+		return
+	}
+
 	_watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		klog.ErrorS(err, "unable to setup watcher")
+		events.Warning(events.ReasonWatchFailed, "unable to watch %s: %v", p.Path, err)
 		w._cancel()
 	}
 
@@ -67,89 +114,51 @@ func startNewWatcher(p *fsPath, ctx context.Context, wg *sync.WaitGroup) {
 
 	w.startWatcher()
 
-	watchPaths := []string{w.p.Path}
+	w.watchTree()
+	w.checkWatcher()
+}
 
-	if w.p.Recursive {
-		klog.V(4).InfoS("watching path recursively", "path", w.p.Path)
+// watchTree watches the path and, when recursive, every directory below it
+// as each is found.
+func (w *watcher) watchTree() {
+	if !w.p.Recursive {
+		w.addDir(w.p.Path)
+		return
+	}
 
-		dirs, err := recursiveDirList(w.p.Path)
-		if err != nil {
-			klog.ErrorS(err, "unable to recurse path", "path", w.p.Path)
-		}
+	klog.V(4).InfoS("watching path recursively", "path", w.p.Path)
 
-		if dirs != nil {
-			watchPaths = *dirs
-		} else {
-			klog.Warning("no paths found to watch", "path", w.p.Path)
-		}
+	err := walk(w._ctx, w.p, func(dir string) error {
+		w.addDir(dir)
+		return nil
+	}, nil)
+	if err != nil && w._ctx.Err() == nil {
+		klog.ErrorS(err, "unable to recurse path", "path", w.p.Path)
 	}
-
-	w.addDir(watchPaths...)
-	w.checkWatcher()
 }
 
 func (w *watcher) startWatcher() {
 	w._wg.Add(1)
 
 	go func() {
-		w.startWatchLoop()
+		switch {
+		case w._watcher != nil:
+			w.startWatchLoop()
+		case w.p.WatchMode == watchModePoll:
+			w.startPollLoop()
+		}
 
 		<-w._ctx.Done()
 		klog.V(2).InfoS("context canceled", "fsPath", w.p)
-		w._watcher.Close()
 
-		for _, t := range w.timers {
-			t.Stop()
+		if w._watcher != nil {
+			w._watcher.Close()
 		}
 
-		waitGroup.Done()
-	}()
-}
-
-func (w *watcher) setTimer(e fsnotify.Event) {
-	var (
-		timer_func func(p *fsPath, path string, ctx context.Context)
-		timer_id   string
-	)
-
-	switch {
-	case e.Has(fsnotify.Create):
-		timer_func = callUpload
-		timer_id = fmt.Sprintf("upload-%s", e.Name)
-	case e.Has(fsnotify.Remove):
-		timer_func = callDelete
-		timer_id = fmt.Sprintf("delete-%s", e.Name)
-	case e.Has(fsnotify.Write):
-		timer_func = callUpload
-		timer_id = fmt.Sprintf("upload-%s", e.Name)
-	}
-
-	// Get timer.
-	w._mu.Lock()
-	t, ok := w.timers[timer_id]
-	w._mu.Unlock()
-
-	// No timer yet, so create one.
-	if !ok {
-		klog.V(4).InfoS("created timer", "id", timer_id)
+		w.drain()
 
-		t = time.AfterFunc(math.MaxInt64, func() {
-			timer_func(w.p, e.Name, w._ctx)
-
-			klog.V(4).InfoS("timer complete", "id", timer_id)
-			w._mu.Lock()
-			delete(w.timers, timer_id)
-			w._mu.Unlock()
-		})
-		t.Stop()
-
-		w._mu.Lock()
-		w.timers[timer_id] = t
-		w._mu.Unlock()
-	}
-
-	klog.V(4).InfoS("timer set", "id", timer_id)
-	t.Reset(w.wait)
+		w._wg.Done()
+	}()
 }
 
 func (w *watcher) startWatchLoop() {
@@ -165,34 +174,18 @@ func (w *watcher) startWatchLoop() {
 				}
 
 				klog.V(4).InfoS("watcher received event", "event", event, "path", w.p.Path)
-
-				switch {
-				case event.Has(fsnotify.Create):
-					if err := checkDir(event.Name); err == nil {
-						klog.V(4).InfoS("adding new directory", "dir", event.Name, "path", w.p.Path)
-						w.addDir(event.Name)
-					} else if w.p.Events.Create {
-						w.setTimer(event)
-					}
-
-				case event.Has(fsnotify.Write):
-					if w.p.Events.Write {
-						w.setTimer(event)
-					}
-
-				case event.Has(fsnotify.Remove):
-					if w.p.Events.Remove {
-						w.setTimer(event)
-					}
-
-					w.checkWatcher()
-				}
+				w.handleEvent(event)
 
 			case err, ok := <-w._watcher.Errors:
 				klog.V(2).ErrorS(err, "watch error")
 
 				if !ok {
+					if w._ctx.Err() == nil {
+						events.Warning(events.ReasonWatchFailed, "watcher for %s stopped", w.p.Path)
+					}
+
 					w._cancel()
+
 					return
 				}
 			}
@@ -200,25 +193,171 @@ func (w *watcher) startWatchLoop() {
 	}()
 }
 
+// handleEvent acts on an event from fsnotify or the poller.
+func (w *watcher) handleEvent(event fsnotify.Event) {
+	_, span := tracing.Start(w._ctx, "fs.event",
+		attribute.String("file", event.Name), attribute.String("op", event.Op.String()), attribute.String("path", w.p.Path))
+	defer span.End()
+
+	// Renames are recorded before filtering, since the old name is usually a temp file
+	if event.Has(fsnotify.Rename) {
+		w.renamed = time.Now()
+	}
+
+	// The watched path itself may be excluded by the filters
+	if event.Has(fsnotify.Remove|fsnotify.Rename) && filepath.Clean(event.Name) == filepath.Clean(w.p.Path) {
+		defer w.checkWatcher()
+	}
+
+	// New directories are still watched, filters only apply to files
+	newDir := event.Has(fsnotify.Create) && checkDir(event.Name) == nil
+	if !newDir && !w.p.included(event.Name) {
+		klog.V(4).InfoS("ignoring filtered file", "file", event.Name, "path", w.p.Path)
+		return
+	}
+
+	switch {
+	case event.Has(fsnotify.Create):
+		if newDir {
+			if !w.p.withinDepth(event.Name) {
+				klog.V(2).InfoS("not watching directory beyond max-depth", "dir", event.Name, "path", w.p.Path)
+				return
+			}
+
+			klog.V(4).InfoS("adding new directory", "dir", event.Name, "path", w.p.Path)
+			w.addDir(event.Name)
+		} else if w.p.Events.Create || (w.p.Events.Write && w.renamedInto()) {
+			w.queue(event, span)
+		}
+
+	case event.Has(fsnotify.Write):
+		if w.p.Events.Write {
+			w.queue(event, span)
+		}
+
+	case event.Has(fsnotify.Remove):
+		if w.p.Events.Remove {
+			w.queue(event, span)
+		}
+
+		w.checkWatcher()
+
+	case event.Has(fsnotify.Rename):
+		// The old name is gone, the new one arrives as a Create
+		if w.p.Events.Remove {
+			w.queue(fsnotify.Event{Name: event.Name, Op: fsnotify.Remove}, span)
+		}
+	}
+}
+
+// renamedInto reports whether a Create follows a rename closely enough to be
+// a file renamed into place, which is complete and handled like a Write.
+func (w *watcher) renamedInto() bool {
+	return time.Since(w.renamed) < renameWindow
+}
+
 func (w *watcher) addDir(paths ...string) {
+	// the poller finds new directories on its next scan
+	if w._watcher == nil {
+		return
+	}
+
 	for _, p := range paths {
 		klog.V(4).InfoS("add inotify watcher", "path", w.p.Path, "new", p)
 
 		err := w._watcher.Add(p)
 		if err != nil {
+			if hint := watchLimitHint(err); hint != "" {
+				klog.ErrorS(err, "unable to setup watcher", "path", w.p.Path, "new", p, "hint", hint)
+				continue
+			}
+
 			klog.ErrorS(err, "unable to setup watcher", "path", w.p.Path, "new", p)
 		}
 	}
 }
 
+// checkWatcher starts watching the path again when it was removed or
+// replaced, or no watch could be set up.
 func (w *watcher) checkWatcher() {
+	if w._watcher == nil {
+		return
+	}
+
 	klog.V(4).InfoS("check watcher", w._watcher.WatchList())
 
 	watch_count := len(w._watcher.WatchList())
 	klog.V(4).InfoS("check watcher", "count", watch_count)
 
-	if watch_count == 0 {
-		klog.V(2).Info("no watchers running")
-		w._cancel()
+	if _, err := os.Stat(w.p.Path); err == nil && watch_count > 0 {
+		return
 	}
+
+	klog.V(2).Info("no watchers running")
+	w.rewatch()
+}
+
+// rewatch drops any remaining watches and waits, with backoff, for the path to
+// reappear. Files written while it was not watched are then uploaded.
+func (w *watcher) rewatch() {
+	w._mu.Lock()
+	if w.rewatching {
+		w._mu.Unlock()
+		return
+	}
+
+	w.rewatching = true
+	w._mu.Unlock()
+
+	for _, p := range w._watcher.WatchList() {
+		_ = w._watcher.Remove(p)
+	}
+
+	klog.InfoS("watched path removed, waiting for it to reappear", "path", w.p.Path)
+	events.Warning(events.ReasonPathRemoved, "watched path %s removed, waiting for it to reappear", w.p.Path)
+
+	w._wg.Add(1)
+
+	go func() {
+		defer w._wg.Done()
+
+		defer func() {
+			w._mu.Lock()
+			w.rewatching = false
+			w._mu.Unlock()
+		}()
+
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-w._ctx.Done():
+				return
+			case <-time.After(rewatchDelay(attempt)):
+			}
+
+			if _, err := os.Stat(w.p.Path); err != nil {
+				continue
+			}
+
+			w.watchTree()
+
+			if len(w._watcher.WatchList()) == 0 {
+				continue
+			}
+
+			klog.InfoS("watching path again", "path", w.p.Path, "attempts", attempt+1)
+			uploadAll(w.p, w._ctx, true)
+
+			return
+		}
+	}()
+}
+
+// rewatchDelay returns the wait before attempt n to watch the path again.
+func rewatchDelay(n int) time.Duration {
+	d := rewatchBaseDelay << n
+	if d > rewatchMaxDelay || d <= 0 {
+		return rewatchMaxDelay
+	}
+
+	return d
 }
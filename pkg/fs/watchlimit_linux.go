@@ -0,0 +1,34 @@
+//go:build linux
+
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package fs
+
+import (
+	"errors"
+	"syscall"
+)
+
+// watchLimitHint explains an error adding a watch that is caused by running
+// out of inotify watches.
+func watchLimitHint(err error) string {
+	if errors.Is(err, syscall.ENOSPC) {
+		return "inotify watch limit reached, raise fs.inotify.max_user_watches or use watch-mode poll"
+	}
+
+	return ""
+}
@@ -0,0 +1,160 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package harness runs the watch and upload pipeline of a directory against
+// an in-memory client, with file events delivered synthetically instead of by
+// inotify. It lets tests drive watch to upload end to end without a MinIO
+// server or timing on filesystem notifications.
+package harness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	fileMode        = 0o644
+	dirMode         = 0o755
+	shutdownTimeout = 30 * time.Second
+)
+
+// Harness watches Dir and uploads changes to Client.
+type Harness struct {
+	Dir    string
+	Path   *fs.Path
+	Client *minio.Fake
+	Config *fs.Config
+
+	cancel context.CancelFunc
+	done   chan fs.Results
+}
+
+// New returns a harness for the directory dir. The path acts on creates and
+// writes immediately, without an initial scan; configure may change any of
+// its settings, such as filters, events or the destination, before it is
+// validated.
+func New(dir string, configure ...func(*fs.Path)) (*Harness, error) {
+	p, err := fs.NewPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Watch = true
+	p.WatchMode = fs.WatchModeManual
+	p.Events = &fs.Events{Create: true, Write: true}
+
+	for _, fn := range configure {
+		fn(p)
+	}
+
+	client := minio.NewFake("fake")
+
+	c, err := fs.NewWithConfig(fs.Options{
+		Paths:           []*fs.Path{p},
+		ShutdownTimeout: shutdownTimeout,
+		Client:          client,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Harness{Dir: dir, Path: p, Client: client, Config: c}, nil
+}
+
+// Start processes the path until Stop is called or ctx is canceled, and
+// returns once it is watched.
+func (h *Harness) Start(ctx context.Context) {
+	ctx, h.cancel = context.WithCancel(ctx)
+	h.done = make(chan fs.Results, 1)
+
+	go func() {
+		h.done <- h.Config.Process(ctx)
+	}()
+
+	<-h.Config.Ready()
+}
+
+// Stop cancels processing and returns the outcome of every file processed
+// once pending uploads complete.
+func (h *Harness) Stop() fs.Results {
+	if h.cancel == nil {
+		return fs.Results{}
+	}
+
+	h.cancel()
+
+	return <-h.done
+}
+
+// WriteFile writes data to name under Dir, creating parent directories, and
+// delivers a Create event for new files or a Write event otherwise.
+func (h *Harness) WriteFile(name string, data []byte) error {
+	file := h.File(name)
+
+	op := fsnotify.Write
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+		op = fsnotify.Create
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), dirMode); err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(file), err)
+	}
+
+	if err := os.WriteFile(file, data, fileMode); err != nil {
+		return fmt.Errorf("unable to write %s: %w", file, err)
+	}
+
+	return h.Event(name, op)
+}
+
+// Remove removes name under Dir and delivers a Remove event.
+func (h *Harness) Remove(name string) error {
+	if err := os.Remove(h.File(name)); err != nil {
+		return fmt.Errorf("unable to remove %s: %w", name, err)
+	}
+
+	return h.Event(name, fsnotify.Remove)
+}
+
+// Event delivers op for name under Dir without touching the file.
+func (h *Harness) Event(name string, op fsnotify.Op) error {
+	return h.Config.Notify(fsnotify.Event{Name: h.File(name), Op: op})
+}
+
+// Flush uploads pending changes now instead of after their wait, and returns
+// once they complete.
+func (h *Harness) Flush(ctx context.Context) (fs.Results, error) {
+	return h.Config.Flush(ctx)
+}
+
+// File returns the path of name under Dir.
+func (h *Harness) File(name string) string {
+	return filepath.Join(h.Dir, name)
+}
+
+// Key returns the object key name under Dir is uploaded to.
+func (h *Harness) Key(name string) string {
+	return minio.ObjectName(h.File(name), h.Path.Destination)
+}
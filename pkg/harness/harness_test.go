@@ -0,0 +1,225 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package harness_test
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"testing"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"github.com/csfreak/minio-backup-sidecar/pkg/harness"
+)
+
+const testTimeout = 10 * time.Second
+
+type write struct {
+	name string
+	data string
+}
+
+func TestWatchUpload(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*fs.Path)
+		fail      error
+		writes    []write
+		want      map[string]string // Content by file name of every object
+		failed    bool              // Whether any upload fails
+	}{
+		{
+			name:   "created file",
+			writes: []write{{"a.txt", "hello"}},
+			want:   map[string]string{"a.txt": "hello"},
+		},
+		{
+			name:   "file written again",
+			writes: []write{{"a.txt", "first"}, {"a.txt", "second"}},
+			want:   map[string]string{"a.txt": "second"},
+		},
+		{
+			name:      "excluded file",
+			configure: func(p *fs.Path) { p.Exclude = []string{"*.tmp"} },
+			writes:    []write{{"a.txt", "kept"}, {"b.tmp", "ignored"}},
+			want:      map[string]string{"a.txt": "kept"},
+		},
+		{
+			name:   "failed upload",
+			fail:   errors.New("unavailable"),
+			writes: []write{{"a.txt", "lost"}},
+			want:   map[string]string{},
+			failed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(*fs.Path)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+
+			h, err := harness.New(t.TempDir(), configure...)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			h.Client.FailUploads(tt.fail)
+
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			h.Start(ctx)
+			defer h.Stop()
+
+			for _, w := range tt.writes {
+				if err := h.WriteFile(w.name, []byte(w.data)); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+
+			results, err := h.Flush(ctx)
+			if err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+
+			if failed := results.Failed > 0; failed != tt.failed {
+				t.Errorf("%d uploads failed, want failures %t", results.Failed, tt.failed)
+			}
+
+			if keys := h.Client.Keys(); len(keys) != len(tt.want) {
+				t.Errorf("uploaded %v, want %d objects", keys, len(tt.want))
+			}
+
+			for name, want := range tt.want {
+				data, _, ok := h.Client.Object(h.Key(name))
+				if !ok {
+					t.Errorf("%s was not uploaded to %s", name, h.Key(name))
+					continue
+				}
+
+				if string(data) != want {
+					t.Errorf("%s uploaded as %q, want %q", name, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	h, err := harness.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	h.Client.FailUploads(errors.New("unavailable"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	h.Start(ctx)
+	defer h.Stop()
+
+	if err := h.WriteFile("a.txt", []byte("retried")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := h.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	h.Client.FailUploads(nil)
+
+	results, err := h.Config.RetryFailed(ctx, h.Path.Path)
+	if err != nil {
+		t.Fatalf("RetryFailed: %v", err)
+	}
+
+	if results.Uploaded != 1 || results.Failed != 0 {
+		t.Errorf("retry uploaded %d and failed %d, want 1 uploaded", results.Uploaded, results.Failed)
+	}
+
+	if data, _, ok := h.Client.Object(h.Key("a.txt")); !ok || string(data) != "retried" {
+		t.Errorf("a.txt uploaded as %q after retry, want %q", data, "retried")
+	}
+
+	if _, err := h.Config.RetryFailed(ctx, h.Path.Path); !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("retry after a successful retry returned %v, want %v", err, iofs.ErrNotExist)
+	}
+}
+
+func TestConcurrentProcesses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	tests := []struct {
+		name   string
+		fail   error
+		writes []write
+		upload int64 // Files uploaded by the process
+		failed bool  // Whether any upload of the process fails
+	}{
+		{
+			name:   "uploading",
+			writes: []write{{"a.txt", "a"}, {"b.txt", "b"}},
+			upload: 2,
+		},
+		{
+			name:   "failing",
+			fail:   errors.New("unavailable"),
+			writes: []write{{"c.txt", "c"}},
+			failed: true,
+		},
+	}
+
+	harnesses := make([]*harness.Harness, len(tests))
+
+	for i, tt := range tests {
+		h, err := harness.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+
+		h.Client.FailUploads(tt.fail)
+		h.Start(ctx)
+
+		harnesses[i] = h
+	}
+
+	for i, tt := range tests {
+		h := harnesses[i]
+
+		for _, w := range tt.writes {
+			if err := h.WriteFile(w.name, []byte(w.data)); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+
+		if _, err := h.Flush(ctx); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+
+	for i, tt := range tests {
+		got := harnesses[i].Stop()
+		if got.Uploaded != tt.upload || got.AnyFailed() != tt.failed {
+			t.Errorf("%s process uploaded %d and failed %d, want %d uploaded and failures %t", tt.name, got.Uploaded, got.Failed, tt.upload, tt.failed)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package history keeps a queryable record of every upload, skip and failure
+// in an embedded database, so when a file was last backed up can be answered
+// after the logs of the time are gone.
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/klog/v2"
+)
+
+// Outcomes recorded in the history.
+const (
+	StatusUploaded = "uploaded"
+	StatusSkipped  = "skipped"
+	StatusFailed   = "failed"
+)
+
+const (
+	fileMode      = 0o600
+	openTimeout   = time.Second
+	pruneInterval = time.Hour
+	defaultLimit  = 100
+)
+
+var bucket = []byte("uploads")
+
+// Record is the outcome of one file.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Path   string    `json:"path,omitempty"` // Configured path or source
+	File   string    `json:"file,omitempty"`
+	Object string    `json:"object,omitempty"`
+	Size   int64     `json:"size,omitempty"`
+	Hash   string    `json:"hash,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Query selects records, newest first. Empty fields match every record.
+type Query struct {
+	Path   string
+	File   string // Exact file, or a prefix when it ends with /
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // Records returned (Defaults to 100)
+}
+
+// Options configures the history store.
+type Options struct {
+	File      string        // Database file (Defaults to none, disabled)
+	Retention time.Duration // Age after which records are removed (Defaults to 0, kept forever)
+}
+
+// Store is an open history database.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the database in file, creating it unless readOnly. Only one
+// process can open a database for writing; readers wait for up to a second.
+func Open(file string, readOnly bool) (*Store, error) {
+	db, err := bolt.Open(file, fileMode, &bolt.Options{Timeout: openTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("unable to open history %s: in use by another process, query its HTTP server instead", file)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to open history %s: %w", file, err)
+	}
+
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			return err
+		})
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to open history %s: %w", file, err)
+		}
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Write adds r, setting its time when it is not set. Concurrent writes are
+// committed together.
+func (s *Store) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+
+	value, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("unable to encode history record: %w", err)
+	}
+
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		return b.Put(key(r.Time, seq), value)
+	})
+}
+
+// key orders records by time, with a sequence keeping records of the same
+// time apart.
+func key(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+
+	return k
+}
+
+// Query returns the records matching q, newest first.
+func (s *Store) Query(q Query) ([]Record, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+
+	var records []Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		var k, v []byte
+
+		if q.Until.IsZero() {
+			k, v = c.Last()
+		} else {
+			// seek past every record of Until, then step back to the last one before it
+			k, v = c.Seek(key(q.Until, 0))
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		since := key(q.Since, 0)
+
+		for ; k != nil && len(records) < q.Limit; k, v = c.Prev() {
+			if !q.Since.IsZero() && bytes.Compare(k, since) < 0 {
+				break
+			}
+
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				klog.V(2).ErrorS(err, "skipping unreadable history record")
+				continue
+			}
+
+			if q.matches(r) {
+				records = append(records, r)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to query history: %w", err)
+	}
+
+	return records, nil
+}
+
+func (q Query) matches(r Record) bool {
+	switch {
+	case q.Path != "" && r.Path != q.Path:
+		return false
+	case q.Status != "" && r.Status != q.Status:
+		return false
+	case strings.HasSuffix(q.File, "/"):
+		return strings.HasPrefix(r.File, q.File)
+	default:
+		return q.File == "" || r.File == q.File
+	}
+}
+
+// Prune removes the records older than before, returning how many it removed.
+func (s *Store) Prune(before time.Time) (int, error) {
+	removed := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		end := key(before, 0)
+
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+
+			removed++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("unable to prune history: %w", err)
+	}
+
+	return removed, nil
+}
+
+// ParseQuery reads a query from path, file, status, since, until and limit.
+// Times are RFC 3339 or durations before now, such as 24h.
+func ParseQuery(v url.Values) (Query, error) {
+	q := Query{Path: v.Get("path"), File: v.Get("file"), Status: v.Get("status")}
+
+	switch q.Status {
+	case "", StatusUploaded, StatusSkipped, StatusFailed:
+	default:
+		return q, fmt.Errorf("unknown status %s", q.Status)
+	}
+
+	var err error
+
+	if q.Since, err = parseTime(v.Get("since")); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+
+	if q.Until, err = parseTime(v.Get("until")); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+var history = struct {
+	sync.Mutex
+	store *Store
+	done  chan struct{}
+}{}
+
+// Init opens the history configured by opts, removing records older than
+// its retention every hour. An empty file disables it.
+func Init(opts Options) error {
+	history.Lock()
+	defer history.Unlock()
+
+	if opts.File == "" {
+		return nil
+	}
+
+	s, err := Open(opts.File, false)
+	if err != nil {
+		return err
+	}
+
+	history.store = s
+	history.done = make(chan struct{})
+
+	if opts.Retention > 0 {
+		go prune(s, opts.Retention, history.done)
+	}
+
+	return nil
+}
+
+func prune(s *Store, retention time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+
+	for {
+		if removed, err := s.Prune(time.Now().Add(-retention)); err != nil {
+			klog.ErrorS(err, "unable to prune history")
+		} else if removed > 0 {
+			klog.V(2).InfoS("pruned history", "removed", removed)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Enabled reports whether records are written.
+func Enabled() bool {
+	history.Lock()
+	defer history.Unlock()
+
+	return history.store != nil
+}
+
+// Write adds r to the history, when it is enabled.
+func Write(r Record) {
+	history.Lock()
+	s := history.store
+	history.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	if err := s.Write(r); err != nil {
+		klog.ErrorS(err, "unable to write history record", "file", r.File)
+	}
+}
+
+// Search returns the records matching q from the open history.
+func Search(q Query) ([]Record, error) {
+	history.Lock()
+	s := history.store
+	history.Unlock()
+
+	if s == nil {
+		return nil, errors.New("history is not enabled")
+	}
+
+	return s.Query(q)
+}
+
+// Close closes the history.
+func Close() error {
+	history.Lock()
+	defer history.Unlock()
+
+	if history.store == nil {
+		return nil
+	}
+
+	close(history.done)
+
+	err := history.store.Close()
+	history.store = nil
+
+	return err
+}
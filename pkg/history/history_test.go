@@ -0,0 +1,82 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package history
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), false)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []Record{
+		{Time: start, Status: StatusUploaded, Path: "/data", File: "/data/a"},
+		{Time: start.Add(time.Hour), Status: StatusFailed, Path: "/data", File: "/data/b"},
+		{Time: start.Add(2 * time.Hour), Status: StatusUploaded, Path: "/logs", File: "/logs/app/c"},
+		{Time: start.Add(3 * time.Hour), Status: StatusSkipped, Path: "/data", File: "/data/a"},
+	}
+
+	for _, r := range records {
+		if err := s.Write(r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string // files, newest first
+	}{
+		{"everything", Query{}, []string{"/data/a", "/logs/app/c", "/data/b", "/data/a"}},
+		{"by path", Query{Path: "/logs"}, []string{"/logs/app/c"}},
+		{"by status", Query{Status: StatusFailed}, []string{"/data/b"}},
+		{"by file", Query{File: "/data/a"}, []string{"/data/a", "/data/a"}},
+		{"by file prefix", Query{File: "/logs/"}, []string{"/logs/app/c"}},
+		{"since", Query{Since: start.Add(2 * time.Hour)}, []string{"/data/a", "/logs/app/c"}},
+		{"until", Query{Until: start.Add(time.Hour)}, []string{"/data/a"}},
+		{"time range", Query{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, []string{"/logs/app/c", "/data/b"}},
+		{"limit", Query{Limit: 1}, []string{"/data/a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Query(tt.q)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			files := make([]string, 0, len(got))
+			for _, r := range got {
+				files = append(files, r.File)
+			}
+
+			if !slices.Equal(files, tt.want) {
+				t.Errorf("Query(%+v) = %v, want %v", tt.q, files, tt.want)
+			}
+		})
+	}
+}
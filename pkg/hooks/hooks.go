@@ -0,0 +1,147 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package hooks lets downstream builds extend the sidecar without patching
+// core files. Implementations are registered from an init function in a
+// package imported by main, and are called in registration order.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+// PreUpload is called before a file is uploaded and may change its
+// destination. Returning an error skips the upload.
+type PreUpload interface {
+	PreUpload(ctx context.Context, file string, dest *config.Destination) error
+}
+
+// PostUpload is called after every upload attempt with its result.
+type PostUpload interface {
+	PostUpload(ctx context.Context, file string, dest config.Destination, err error)
+}
+
+// NameResolver chooses the object key for a file. Returning false falls back
+// to the next resolver, and finally to the destination settings.
+type NameResolver interface {
+	ResolveName(file string, dest config.Destination) (string, bool)
+}
+
+// Filter decides whether a file is processed at all, in addition to the
+// include and exclude patterns.
+type Filter interface {
+	Include(file string) bool
+}
+
+var registry struct {
+	mu        sync.RWMutex
+	pre       []PreUpload
+	post      []PostUpload
+	resolvers []NameResolver
+	filters   []Filter
+}
+
+// Register adds h for every hook interface it implements. It fails if h
+// implements none of them.
+func Register(h any) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	found := false
+
+	if v, ok := h.(PreUpload); ok {
+		registry.pre = append(registry.pre, v)
+		found = true
+	}
+
+	if v, ok := h.(PostUpload); ok {
+		registry.post = append(registry.post, v)
+		found = true
+	}
+
+	if v, ok := h.(NameResolver); ok {
+		registry.resolvers = append(registry.resolvers, v)
+		found = true
+	}
+
+	if v, ok := h.(Filter); ok {
+		registry.filters = append(registry.filters, v)
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("%T implements no hook interface", h)
+	}
+
+	return nil
+}
+
+// RunPreUpload calls every PreUpload hook, stopping at the first error.
+func RunPreUpload(ctx context.Context, file string, dest *config.Destination) error {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, h := range registry.pre {
+		if err := h.PreUpload(ctx, file, dest); err != nil {
+			return fmt.Errorf("rejected by %T: %w", h, err)
+		}
+	}
+
+	return nil
+}
+
+// RunPostUpload calls every PostUpload hook.
+func RunPostUpload(ctx context.Context, file string, dest config.Destination, err error) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, h := range registry.post {
+		h.PostUpload(ctx, file, dest, err)
+	}
+}
+
+// ResolveName returns the name chosen by the first resolver that handles file.
+func ResolveName(file string, dest config.Destination) (string, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, h := range registry.resolvers {
+		if name, ok := h.ResolveName(file, dest); ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// Included reports whether every Filter accepts file.
+func Included(file string) bool {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, h := range registry.filters {
+		if !h.Include(file) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,126 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package kube talks to the API server of the cluster the sidecar runs in,
+// authenticating with the pod's service account.
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	maxErrorBody   = 512 // Bytes of a failed response included in its error
+)
+
+// Client sends requests to the in-cluster API server.
+type Client struct {
+	host      string
+	namespace string
+	client    *http.Client
+}
+
+// InCluster returns a client for the API server from KUBERNETES_SERVICE_HOST
+// and KUBERNETES_SERVICE_PORT, trusting the service account CA. Each request
+// is limited to timeout.
+func InCluster(timeout time.Duration) (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster")
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccount + "namespace")
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine pod namespace, set POD_NAMESPACE: %w", err)
+		}
+
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	ca, err := os.ReadFile(serviceAccount + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cluster ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in cluster ca")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &Client{
+		host:      "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		client:    &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+// Namespace returns the namespace of the pod.
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Do sends a request for path, such as /api/v1/namespaces/default/configmaps,
+// with a JSON body when body is not nil. Responses other than 2xx are
+// returned as errors, with the body closed.
+func (c *Client) Do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	// Projected service account tokens are rotated, so read it for every request
+	token, err := os.ReadFile(serviceAccount + "token")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
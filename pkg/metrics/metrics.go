@@ -0,0 +1,189 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "minio_backup"
+
+var (
+	ClockSkewErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clock_skew_errors_total",
+		Help:      "Requests rejected by the server with RequestTimeTooSkewed",
+	})
+
+	UploadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_errors_total",
+		Help:      "Failed upload attempts by error class",
+	}, []string{"class"})
+
+	TargetUploadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "target_upload_errors_total",
+		Help:      "Failed uploads by replication target",
+	}, []string{"target"})
+
+	VersionedUploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "versioned_uploads_total",
+		Help:      "Uploads that created a new object version by target",
+	}, []string{"target"})
+
+	UploadsSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_skipped_total",
+		Help:      "Uploads skipped by reason",
+	}, []string{"reason"})
+
+	ChunksUploaded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chunks_uploaded_total",
+		Help:      "Chunks of chunked files uploaded because the bucket did not hold them",
+	})
+
+	ChunksReused = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chunks_reused_total",
+		Help:      "Chunks of chunked files already held by the bucket, not uploaded again",
+	})
+
+	MultipartResumed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "multipart_uploads_resumed_total",
+		Help:      "Multipart uploads continued from the parts of an interrupted attempt",
+	})
+
+	MultipartAborted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "multipart_uploads_aborted_total",
+		Help:      "Incomplete multipart uploads aborted because they were stale or their file changed",
+	})
+
+	ReclaimedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reclaimed_bytes_total",
+		Help:      "Bytes of local files removed by delete-on-success after their upload was verified",
+	})
+
+	DirectoriesTruncated = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "directories_beyond_max_depth",
+		Help:      "Directories not walked on the last scan because they are deeper than max-depth, by configured path",
+	}, []string{"path"})
+
+	PathBytesToday = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "path_uploaded_bytes_today",
+		Help:      "Bytes uploaded today by configured path",
+	}, []string{"path"})
+
+	PathBytesAverage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "path_uploaded_bytes_daily_average",
+		Help:      "Average bytes uploaded per day over the last 7 days by configured path",
+	}, []string{"path"})
+
+	PathLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "path_last_success_timestamp_seconds",
+		Help:      "Time of the last successful upload by configured path",
+	}, []string{"path"})
+
+	PathStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "path_stale",
+		Help:      "1 while a configured path has gone longer than max-staleness without a successful upload",
+	}, []string{"path"})
+
+	UploadConcurrencyLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upload_concurrency_limit",
+		Help:      "Current number of uploads allowed to run concurrently",
+	})
+
+	UploadQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upload_queue_depth",
+		Help:      "Uploads waiting for a concurrent upload slot by priority",
+	}, []string{"priority"})
+
+	CircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_open",
+		Help:      "1 while uploads to a target are paused because it is unreachable, by target",
+	}, []string{"target"})
+
+	OfflinePending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "offline_pending",
+		Help:      "Uploads waiting for an unreachable target to become reachable or uploads to resume",
+	})
+
+	Paused = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "paused",
+		Help:      "1 while uploads are paused",
+	})
+
+	SourceBackups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_backups_total",
+		Help:      "Backup runs of sources such as database dumps, by source and result",
+	}, []string{"source", "result"})
+
+	SourceLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_last_success_timestamp_seconds",
+		Help:      "Time of the last successful backup by source",
+	}, []string{"source"})
+
+	SourceDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_last_duration_seconds",
+		Help:      "Duration of the last backup run by source",
+	}, []string{"source"})
+
+	DeadLetters = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dead_letters",
+		Help:      "Files, paths and sources whose upload failed after every retry and waits to be retried, by configured path or source",
+	}, []string{"path"})
+
+	PendingChanges = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_changes",
+		Help:      "Changed files waiting for wait-time to pass before they are acted on, by configured path",
+	}, []string{"path"})
+
+	EventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Changes not queued because max-pending files were already waiting, by configured path",
+	}, []string{"path"})
+
+	ClockOffset = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "clock_offset_seconds",
+		Help:      "Offset between the server Date header and the local clock",
+	})
+)
@@ -0,0 +1,120 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+// MetadataAppendOffset records where in its file a segment of an appended
+// file starts. Segments are named with their sequence as version and carry
+// the key of the whole file as their logical key, so restore writes each
+// segment at its offset of the same file.
+const MetadataAppendOffset = "Append-Offset"
+
+// source is the content of an upload: file, or the segment of length bytes
+// from offset when segment is set, piped through filters. Content not read
+// from a file is streamed from stream instead, with length -1 when unknown.
+type source struct {
+	file    string
+	filters []string
+	segment bool
+	offset  int64
+	length  int64
+	stream  io.Reader
+}
+
+func sourceOf(file string, dest config.Destination) source {
+	return source{file: file, filters: dest.Filters, segment: dest.Append, offset: dest.Offset, length: dest.Length}
+}
+
+// streamOf returns the source of an upload of size bytes read from r, named
+// key in logs. The offset of r is kept, so a seekable r can be read again.
+func streamOf(key string, r io.Reader, size int64) source {
+	s := source{file: key, stream: r, length: size, offset: -1}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			s.offset = offset
+		}
+	}
+
+	return s
+}
+
+// rewind prepares the source to be read again for another attempt. It
+// reports false for a stream that has been read and cannot be.
+func (s source) rewind() bool {
+	if s.stream == nil {
+		return true
+	}
+
+	seeker, ok := s.stream.(io.Seeker)
+	if !ok || s.offset < 0 {
+		return false
+	}
+
+	_, err := seeker.Seek(s.offset, io.SeekStart)
+
+	return err == nil
+}
+
+// open returns a reader of the content and a function closing it.
+func (s source) open(ctx context.Context) (io.Reader, func(), error) {
+	if s.stream != nil {
+		return s.stream, func() {}, nil
+	}
+
+	f, err := os.Open(s.file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open %s: %w", s.file, err)
+	}
+
+	var r io.Reader = f
+	if s.segment {
+		r = io.NewSectionReader(f, s.offset, s.length)
+	}
+
+	if len(s.filters) == 0 {
+		return r, func() { f.Close() }, nil
+	}
+
+	fr, err := newFilterReader(ctx, r, s.filters)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return fr, func() { fr.Close(); f.Close() }, nil
+}
+
+// addSegmentMetadata records the offset and whole file key of the segment
+// of file uploaded for dest, and its size for Verify.
+func addSegmentMetadata(metadata map[string]string, file string, dest config.Destination) {
+	whole := dest
+	whole.Version = ""
+
+	metadata[MetadataAppendOffset] = strconv.FormatInt(dest.Offset, 10)
+	metadata[MetadataLogicalKey] = LogicalName(file, whole)
+	metadata[MetadataSize] = strconv.FormatInt(dest.Length, 10)
+}
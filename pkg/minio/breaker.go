@@ -0,0 +1,230 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/events"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	mc "github.com/minio/minio-go/v7"
+	"k8s.io/klog/v2"
+)
+
+// ErrCircuitOpen is returned for uploads attempted while a target is
+// considered unreachable.
+var ErrCircuitOpen = errors.New("circuit open, endpoint unreachable")
+
+// CircuitStatus describes the circuit breaker of one target.
+type CircuitStatus struct {
+	Open     bool       `json:"open"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+// breaker stops upload attempts to a target after consecutive uploads fail
+// to reach it, and probes the target until it is reachable again.
+type breaker struct {
+	ctx       context.Context // Probing stops once it is done
+	c         *minioConfig
+	threshold int
+	interval  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt *time.Time
+}
+
+var circuits = struct {
+	mu        sync.Mutex
+	breakers  []*breaker
+	recovered []*func()
+}{}
+
+// validateBreaker checks that opts can configure a breaker.
+func validateBreaker(opts CircuitBreakerOptions) error {
+	if opts.Failures > 0 && opts.ProbeInterval <= 0 {
+		return fmt.Errorf("minio.circuit-breaker.probe-interval must be positive, got %s", opts.ProbeInterval)
+	}
+
+	return nil
+}
+
+// newBreaker returns a breaker opening after opts.Failures consecutive
+// failures and probing the target until ctx is done, or nil when
+// opts.Failures is not positive.
+func newBreaker(ctx context.Context, c *minioConfig, opts CircuitBreakerOptions) (*breaker, error) {
+	if opts.Failures <= 0 {
+		return nil, nil
+	}
+
+	if err := validateBreaker(opts); err != nil {
+		return nil, err
+	}
+
+	b := &breaker{ctx: ctx, c: c, threshold: opts.Failures, interval: opts.ProbeInterval}
+
+	circuits.mu.Lock()
+	circuits.breakers = append(circuits.breakers, b)
+	circuits.mu.Unlock()
+
+	return b, nil
+}
+
+// OnRecovered registers fn to be called whenever a target becomes reachable
+// again after its circuit opened, until the returned function is called.
+func OnRecovered(fn func()) (unregister func()) {
+	circuits.mu.Lock()
+	defer circuits.mu.Unlock()
+
+	registered := &fn
+	circuits.recovered = append(circuits.recovered, registered)
+
+	return func() {
+		circuits.mu.Lock()
+		defer circuits.mu.Unlock()
+
+		circuits.recovered = slices.DeleteFunc(circuits.recovered, func(fn *func()) bool { return fn == registered })
+	}
+}
+
+// Circuits returns the circuit breaker state of every target using one.
+func Circuits() map[string]CircuitStatus {
+	circuits.mu.Lock()
+	defer circuits.mu.Unlock()
+
+	status := make(map[string]CircuitStatus, len(circuits.breakers))
+
+	for _, b := range circuits.breakers {
+		b.mu.Lock()
+		status[b.c.Name()] = CircuitStatus{Open: b.openedAt != nil, Failures: b.failures, OpenedAt: b.openedAt}
+		b.mu.Unlock()
+	}
+
+	return status
+}
+
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt != nil {
+		return ErrCircuitOpen
+	}
+
+	return nil
+}
+
+// record counts the result of an upload. Only failures to reach the target
+// count towards opening the circuit.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !unreachable(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.failures < b.threshold || b.openedAt != nil {
+		return
+	}
+
+	now := time.Now()
+	b.openedAt = &now
+
+	metrics.CircuitOpen.WithLabelValues(b.c.Name()).Set(1)
+	klog.ErrorS(err, "target unreachable, pausing uploads", "target", b.c.Name(), "failures", b.failures, "probe-interval", b.interval)
+
+	events.Warning(events.ReasonTargetUnreachable, "uploads to %s paused after %d failures: %v", b.c.Name(), b.failures, err)
+
+	go b.probe()
+}
+
+// probe checks the target every interval until it responds, then closes the
+// circuit and calls every recovery function. It gives up once the context
+// of the breaker is done, leaving the circuit open.
+func (b *breaker) probe() {
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			klog.V(2).InfoS("stopped probing unreachable target", "target", b.c.Name())
+			return
+		case <-t.C:
+		}
+
+		ctx, cancel := context.WithTimeout(b.ctx, b.interval)
+		_, err := b.c.client.BucketExists(ctx, b.c.bucket)
+		cancel()
+
+		if unreachable(err) {
+			klog.V(2).ErrorS(err, "target still unreachable", "target", b.c.Name())
+			continue
+		}
+
+		b.mu.Lock()
+		opened := *b.openedAt
+		b.openedAt = nil
+		b.failures = 0
+		b.mu.Unlock()
+
+		metrics.CircuitOpen.WithLabelValues(b.c.Name()).Set(0)
+		klog.InfoS("target reachable again, resuming uploads", "target", b.c.Name(), "down", time.Since(opened))
+		events.Normal(events.ReasonTargetReachable, "%s reachable again after %s, resuming uploads", b.c.Name(), time.Since(opened).Round(time.Second))
+
+		circuits.mu.Lock()
+		recovered := slices.Clone(circuits.recovered)
+		circuits.mu.Unlock()
+
+		for _, fn := range recovered {
+			(*fn)()
+		}
+
+		return
+	}
+}
+
+// unreachable reports whether err means the target could not be reached, as
+// opposed to the target responding with an error.
+func unreachable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var resp mc.ErrorResponse
+
+	return !errors.As(err, &resp) && Classify(err) == ErrorRetryable
+}
@@ -0,0 +1,66 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidateBreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    CircuitBreakerOptions
+		wantErr bool
+	}{
+		{"disabled", CircuitBreakerOptions{}, false},
+		{"disabled without interval", CircuitBreakerOptions{Failures: 0, ProbeInterval: -time.Second}, false},
+		{"enabled", CircuitBreakerOptions{Failures: 5, ProbeInterval: time.Second}, false},
+		{"zero interval", CircuitBreakerOptions{Failures: 5}, true},
+		{"negative interval", CircuitBreakerOptions{Failures: 5, ProbeInterval: -time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateBreaker(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("validateBreaker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &breaker{ctx: ctx, c: &minioConfig{}, threshold: 1, interval: time.Hour}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		b.probe()
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("probe still running after its context was canceled")
+	}
+}
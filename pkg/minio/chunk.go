@@ -0,0 +1,501 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	mc "github.com/minio/minio-go/v7"
+	"k8s.io/klog/v2"
+)
+
+// MetadataChunkSize records the average chunk size a file was split into.
+// Objects with it hold a ChunkIndex instead of the file's content.
+const MetadataChunkSize = "Chunk-Size"
+
+const (
+	// MinChunkSize is the smallest average chunk size accepted.
+	MinChunkSize = 64 << 10
+
+	// DefaultChunkPrefix holds the chunks of every chunked file, shared so
+	// identical content is stored once. It is kept under InternalPrefix so
+	// chunks still listed by an index are neither pruned nor expired.
+	DefaultChunkPrefix = InternalPrefix + "chunks"
+
+	chunkIndexType    = "application/vnd.minio-backup-sidecar.chunks+json"
+	chunkIndexVersion = 1
+	maxKnownChunks    = 1 << 20          // Chunk hashes remembered as present in the bucket
+	knownChunkTTL     = 10 * time.Minute // How long a chunk is trusted to be present before it is checked again
+)
+
+var errTooManyChunks = errors.New("too many chunks to remember")
+
+// ChunkIndex lists the chunks of a file in order. Each chunk is an object
+// under the chunk prefix named by the SHA-256 of its content, keyed by the
+// hash key when encrypted, compressed and encrypted like files and described
+// by its own metadata. SHA256 is the plain hash of the whole file.
+type ChunkIndex struct {
+	Version   int     `json:"version"`
+	Size      int64   `json:"size"`
+	SHA256    string  `json:"sha256"`
+	ChunkSize int64   `json:"chunkSize"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// Chunk is a piece of a file.
+type Chunk struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// ReadChunkIndex decodes the index held by a chunked object.
+func ReadChunkIndex(r io.Reader) (*ChunkIndex, error) {
+	index := &ChunkIndex{}
+	if err := json.NewDecoder(r).Decode(index); err != nil {
+		return nil, fmt.Errorf("invalid chunk index: %w", err)
+	}
+
+	if index.Version != chunkIndexVersion {
+		return nil, fmt.Errorf("unsupported chunk index version %d", index.Version)
+	}
+
+	return index, nil
+}
+
+// ChunkKey returns the key of the chunk with hash under prefix, spread over
+// subprefixes by its first byte.
+func ChunkKey(prefix, hash string) string {
+	return path.Join(prefix, hash[:2], hash)
+}
+
+// knownChunks remembers chunks found in the bucket, so unchanged chunks of a
+// file uploaded again soon after cost no request. Chunks are checked again
+// once knownChunkTTL passes, in case they were removed from the bucket. While
+// a listing of every chunk is fresh, chunks missing from it are known to be
+// missing from the bucket too.
+type knownChunks struct {
+	mu       sync.Mutex
+	hashes   map[string]time.Time // When each chunk was last seen in the bucket
+	listed   time.Time            // When the chunk prefix was last listed
+	complete bool                 // Whether hashes holds every chunk listed
+}
+
+func (k *knownChunks) has(hash string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	seen, ok := k.hashes[hash]
+	if ok && time.Since(seen) >= knownChunkTTL {
+		delete(k.hashes, hash)
+		return false
+	}
+
+	return ok
+}
+
+func (k *knownChunks) add(hash string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.hashes == nil || len(k.hashes) >= maxKnownChunks {
+		k.hashes = make(map[string]time.Time)
+		k.complete = false
+	}
+
+	k.hashes[hash] = time.Now()
+}
+
+// claimListing reports whether the chunk prefix is due to be listed, and if
+// so records it as listed now so concurrent uploads do not list it again.
+func (k *knownChunks) claimListing() (time.Time, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.listed) < knownChunkTTL {
+		return time.Time{}, false
+	}
+
+	k.listed = time.Now()
+	k.complete = false
+
+	return k.listed, true
+}
+
+// addListed records the chunks found by a listing started at seen, complete
+// unless it stopped early.
+func (k *knownChunks) addListed(hashes []string, seen time.Time, complete bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.hashes == nil || len(k.hashes)+len(hashes) > maxKnownChunks {
+		k.hashes = make(map[string]time.Time, len(hashes))
+	}
+
+	for _, hash := range hashes {
+		k.hashes[hash] = seen
+	}
+
+	k.complete = complete && k.listed.Equal(seen)
+}
+
+// missing reports whether a chunk not found by has is known to be missing
+// from the bucket, without a stat.
+func (k *knownChunks) missing() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.complete && time.Since(k.listed) < knownChunkTTL
+}
+
+// listChunks lists the chunk prefix at most once per knownChunkTTL, so the
+// chunks of a file cost one listing rather than a stat each. A failed
+// listing only means chunks are checked one by one.
+func (c *minioConfig) listChunks(ctx context.Context) {
+	seen, ok := c.chunks.claimListing()
+	if !ok {
+		return
+	}
+
+	var hashes []string
+
+	err := c.Walk(ctx, c.chunkPrefix(), false, func(obj mc.ObjectInfo) error {
+		if len(hashes) >= maxKnownChunks {
+			return errTooManyChunks
+		}
+
+		hashes = append(hashes, path.Base(obj.Key))
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errTooManyChunks) {
+		klog.Warningf("unable to list chunks, checking them one by one: %v", err)
+	}
+
+	c.chunks.addListed(hashes, seen, err == nil)
+}
+
+// chunkHash names a chunk by the SHA-256 of data, keyed by the hash key when
+// set so the names of encrypted chunks do not reveal their content.
+func (c *minioConfig) chunkHash(data []byte) string {
+	if c.opts.HashKey == nil {
+		h := sha256.Sum256(data)
+		return hex.EncodeToString(h[:])
+	}
+
+	mac := hmac.New(sha256.New, c.opts.HashKey)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// putChunked splits src into content-defined chunks, uploads those not
+// already in the bucket and then the index listing them to objName. A retried
+// upload skips the chunks uploaded by earlier attempts.
+func (c *minioConfig) putChunked(ctx context.Context, objName string, src source, opts mc.PutObjectOptions) (mc.UploadInfo, error) {
+	avg, err := strconv.ParseInt(opts.UserMetadata[MetadataChunkSize], 10, 64)
+	if err != nil || avg < MinChunkSize {
+		return mc.UploadInfo{}, fmt.Errorf("invalid %s %q", MetadataChunkSize, opts.UserMetadata[MetadataChunkSize])
+	}
+
+	if c.encryptor != nil && c.opts.HashKey == nil {
+		return mc.UploadInfo{}, fmt.Errorf("chunking %s with encryption.type %s: %w", src.file, c.encryptor.Type(), crypt.ErrNoHashKey)
+	}
+
+	r, closeSrc, err := src.open(ctx)
+	if err != nil {
+		return mc.UploadInfo{}, err
+	}
+	defer closeSrc()
+
+	compress := opts.UserMetadata[MetadataCompression] == compressionGzip
+	index := &ChunkIndex{Version: chunkIndexVersion, ChunkSize: avg}
+	sum := sha256.New()
+	chunks := newChunker(r, avg)
+
+	var uploaded, reused int
+
+	c.listChunks(ctx)
+
+	for {
+		data, err := chunks.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return mc.UploadInfo{}, fmt.Errorf("unable to read %s: %w", src.file, err)
+		}
+
+		hash := c.chunkHash(data)
+		key := ChunkKey(c.chunkPrefix(), hash)
+
+		sum.Write(data)
+
+		index.Size += int64(len(data))
+		index.Chunks = append(index.Chunks, Chunk{Key: key, Size: int64(len(data))})
+
+		stored, err := c.putChunk(ctx, key, hash, data, compress, opts)
+		if err != nil {
+			return mc.UploadInfo{}, err
+		}
+
+		if stored {
+			uploaded++
+		} else {
+			reused++
+		}
+	}
+
+	index.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	metrics.ChunksUploaded.Add(float64(uploaded))
+	metrics.ChunksReused.Add(float64(reused))
+	klog.V(2).InfoS("chunks uploaded", "file", src.file, "object", objName, "chunks", len(index.Chunks), "uploaded", uploaded, "reused", reused)
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		return mc.UploadInfo{}, fmt.Errorf("unable to encode chunk index: %w", err)
+	}
+
+	// chunks record their own compression, the index is stored as is
+	metadata := make(map[string]string, len(opts.UserMetadata))
+	for k, v := range opts.UserMetadata {
+		if k != MetadataCompression {
+			metadata[k] = v
+		}
+	}
+
+	opts.UserMetadata = metadata
+	opts.ContentType = chunkIndexType
+
+	info, err := c.putData(ctx, objName, body, opts)
+	if err != nil {
+		return info, err
+	}
+
+	info.Size = index.Size
+
+	return info, nil
+}
+
+// putChunk uploads data to key unless the chunk is already in the bucket,
+// and reports whether it did. The bucket is only asked when the last listing
+// of chunks is stale.
+func (c *minioConfig) putChunk(ctx context.Context, key, hash string, data []byte, compress bool, opts mc.PutObjectOptions) (bool, error) {
+	if c.chunks.has(hash) {
+		return false, nil
+	}
+
+	if !c.chunks.missing() {
+		_, err := c.client.StatObject(ctx, c.bucket, key, mc.StatObjectOptions{ServerSideEncryption: c.readSSE()})
+		if err == nil {
+			c.chunks.add(hash)
+			return false, nil
+		}
+
+		if mc.ToErrorResponse(err).StatusCode != 404 {
+			return false, fmt.Errorf("unable to stat chunk %s: %w", key, err)
+		}
+	}
+
+	metadata := map[string]string{}
+
+	if compress {
+		var buf bytes.Buffer
+
+		zr := gzipReader(bytes.NewReader(data))
+		_, err := io.Copy(&buf, zr)
+		zr.Close()
+
+		if err != nil {
+			return false, fmt.Errorf("unable to compress chunk %s: %w", key, err)
+		}
+
+		data = buf.Bytes()
+		metadata[MetadataCompression] = compressionGzip
+	}
+
+	chunkOpts := mc.PutObjectOptions{
+		ContentType:          "application/octet-stream",
+		UserMetadata:         metadata,
+		StorageClass:         opts.StorageClass,
+		ServerSideEncryption: c.sse,
+	}
+
+	c.lock.apply(&chunkOpts)
+
+	if _, err := c.putData(ctx, key, data, chunkOpts); err != nil {
+		return false, err
+	}
+
+	c.chunks.add(hash)
+
+	return true, nil
+}
+
+// putData uploads data to key, encrypted by the encryptor when configured.
+func (c *minioConfig) putData(ctx context.Context, key string, data []byte, opts mc.PutObjectOptions) (mc.UploadInfo, error) {
+	var (
+		r    io.Reader = bytes.NewReader(data)
+		size           = int64(len(data))
+	)
+
+	if c.encryptor != nil {
+		er, err := c.encryptor.EncryptReader(r)
+		if err != nil {
+			return mc.UploadInfo{}, fmt.Errorf("unable to encrypt %s: %w", key, err)
+		}
+		defer er.Close()
+
+		metadata := make(map[string]string, len(opts.UserMetadata)+1)
+		for k, v := range opts.UserMetadata {
+			metadata[k] = v
+		}
+
+		metadata[crypt.MetadataKey] = c.encryptor.Type()
+		opts.UserMetadata = metadata
+		r, size = er, -1
+		opts.PartSize = streamPartSize
+	}
+
+	info, err := c.client.PutObject(ctx, c.bucket, key, r, size, opts)
+	if err != nil {
+		return info, fmt.Errorf("put %s failed: %w", key, err)
+	}
+
+	return info, nil
+}
+
+func (c *minioConfig) chunkPrefix() string {
+	if c.opts.ChunkPrefix != "" {
+		return c.opts.ChunkPrefix
+	}
+
+	return DefaultChunkPrefix
+}
+
+// gear maps bytes to the random values rolled into the chunker's hash. The
+// table is fixed: changing it moves every chunk boundary, so no chunk of
+// earlier uploads would be reused.
+var gear = func() (t [256]uint64) {
+	seed := uint64(0x6d696e696f2d6263) // splitmix64
+
+	for i := range t {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+
+	return t
+}()
+
+// chunker splits a stream into content-defined chunks with a gear hash, so
+// an insertion or removal only changes the chunks around it. Chunks are
+// between a quarter and four times the average size.
+type chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+	min, max   int
+	mask       uint64
+}
+
+func newChunker(r io.Reader, avg int64) *chunker {
+	minSize, maxSize := int(avg/4), int(avg*4)
+
+	return &chunker{
+		r:    r,
+		buf:  make([]byte, 2*maxSize),
+		min:  minSize,
+		max:  maxSize,
+		mask: ^uint64(0) << (64 - (bits.Len64(uint64(int(avg)-minSize)) - 1)),
+	}
+}
+
+// next returns the next chunk, valid until the following call, or io.EOF.
+func (c *chunker) next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	n := c.end - c.start
+	if n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := min(n, c.max)
+
+	if n > c.min {
+		var h uint64
+
+		for i := c.start + c.min; i < c.start+cut; i++ {
+			h = h<<1 + gear[c.buf[i]]
+			if h&c.mask == 0 {
+				cut = i + 1 - c.start
+				break
+			}
+		}
+	}
+
+	chunk := c.buf[c.start : c.start+cut]
+	c.start += cut
+
+	return chunk, nil
+}
+
+// fill reads until max bytes are buffered or the stream ends.
+func (c *chunker) fill() error {
+	if c.end-c.start >= c.max || c.eof {
+		return nil
+	}
+
+	if c.start > 0 {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+	}
+
+	for c.end < c.max && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
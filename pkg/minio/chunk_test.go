@@ -0,0 +1,186 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+
+	return data
+}
+
+func chunksOf(t *testing.T, data []byte, avg int64) [][]byte {
+	t.Helper()
+
+	var chunks [][]byte
+
+	c := newChunker(bytes.NewReader(data), avg)
+
+	for {
+		chunk, err := c.next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	const avg = MinChunkSize
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"smaller than min", avg / 8},
+		{"one average chunk", avg},
+		{"many chunks", 64 * avg},
+		{"uncut max", 4*avg + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := randomData(1, tt.size)
+			chunks := chunksOf(t, data, avg)
+
+			if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+				t.Fatalf("chunks joined to %d bytes, want the %d input bytes", len(got), len(data))
+			}
+
+			for i, chunk := range chunks {
+				if len(chunk) > 4*avg {
+					t.Errorf("chunk %d is %d bytes, more than max %d", i, len(chunk), 4*avg)
+				}
+
+				if i < len(chunks)-1 && len(chunk) < avg/4 {
+					t.Errorf("chunk %d is %d bytes, less than min %d", i, len(chunk), avg/4)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerContentDefined(t *testing.T) {
+	const avg = MinChunkSize
+
+	data := randomData(2, 64*avg)
+	shifted := append([]byte("inserted"), data...)
+
+	seen := make(map[string]bool)
+	for _, chunk := range chunksOf(t, data, avg) {
+		seen[string(chunk)] = true
+	}
+
+	chunks := chunksOf(t, shifted, avg)
+
+	var shared int
+
+	for _, chunk := range chunks {
+		if seen[string(chunk)] {
+			shared++
+		}
+	}
+
+	// only the chunks around the insertion change
+	if shared < len(chunks)-2 {
+		t.Errorf("%d of %d chunks reused after inserting at the start, want all but 2", shared, len(chunks))
+	}
+}
+
+func TestKnownChunks(t *testing.T) {
+	tests := []struct {
+		name string
+		seen time.Duration // How long ago the chunk was seen, none if 0
+		want bool
+	}{
+		{"unknown", 0, false},
+		{"seen recently", time.Second, true},
+		{"seen before ttl", knownChunkTTL - time.Minute, true},
+		{"expired", knownChunkTTL + time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var k knownChunks
+
+			if tt.seen > 0 {
+				k.add("hash")
+				k.hashes["hash"] = time.Now().Add(-tt.seen)
+			}
+
+			if got := k.has("hash"); got != tt.want {
+				t.Errorf("has = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownChunksListing(t *testing.T) {
+	tests := []struct {
+		name     string
+		complete bool
+		age      time.Duration // How long ago the listing started
+		want     bool
+	}{
+		{"complete", true, time.Second, true},
+		{"incomplete", false, time.Second, false},
+		{"stale", true, knownChunkTTL + time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var k knownChunks
+
+			seen, ok := k.claimListing()
+			if !ok {
+				t.Fatal("claimListing refused the first listing")
+			}
+
+			if _, ok := k.claimListing(); ok {
+				t.Error("claimListing allowed a second listing")
+			}
+
+			k.addListed([]string{"listed"}, seen, tt.complete)
+			k.listed = k.listed.Add(-tt.age)
+
+			if got := k.missing(); got != tt.want {
+				t.Errorf("missing = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkKey(t *testing.T) {
+	if got, want := ChunkKey(DefaultChunkPrefix, "abcdef"), ".minio-backup-sidecar/chunks/ab/abcdef"; got != want {
+		t.Errorf("ChunkKey = %s, want %s", got, want)
+	}
+}
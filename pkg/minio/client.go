@@ -19,37 +19,138 @@ package minio
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	"github.com/csfreak/minio-backup-sidecar/pkg/hooks"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/tracing"
 	mc "github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
-	"github.com/minio/minio-go/v7/pkg/lifecycle"
-	"github.com/spf13/viper"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 )
 
+// streamPartSize bounds memory used by uploads of unknown size.
+const streamPartSize = 64 << 20
+
+// InternalPrefix holds objects written by the sidecar itself, such as shared
+// chunks, which are never pruned or expired.
+const InternalPrefix = ".minio-backup-sidecar/"
+
+// MinioClient uploads files to and reads objects from a bucket. Fake is an
+// in-memory implementation for tests.
 type MinioClient interface {
-	newClient() error
-	makeBucket(ctx context.Context) error
 	UploadFile(file string, ctx context.Context) error
 	UploadFileWithDestination(file string, dest config.Destination, ctx context.Context) error
+	Walk(ctx context.Context, prefix string, withMetadata bool, fn func(mc.ObjectInfo) error) error
+	Download(ctx context.Context, key string) (io.ReadCloser, mc.ObjectInfo, error)
+	RemoveObjects(ctx context.Context, keys <-chan string) (int, error)
+	ApplyRetention(ctx context.Context, prefixes []string) error
+	ApplyReplication(ctx context.Context, prefixes []string) error
+	AbortStaleUploads(ctx context.Context, prefixes []string) error
+	Unchanged(ctx context.Context, file string, dest config.Destination) (bool, error)
+	Verify(ctx context.Context, file string, dest config.Destination, size int64) error
+	Select(ctx context.Context, key string, opts mc.SelectObjectOptions) (io.ReadCloser, error)
+	Upload(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Delete(ctx context.Context, key string) error
+	Copy(ctx context.Context, src, dst string) error
+	Stat(ctx context.Context, key string) (mc.ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]mc.ObjectInfo, error)
+	Name() string
 }
 
 type minioConfig struct {
-	client *mc.Client
-	bucket string
+	opts        Options
+	client      *mc.Client
+	creds       *credentials.Credentials
+	refreshable bool // Whether creds are fetched again once expired
+	bucket      string
+	skew        *skewTransport
+	limiter     *limiter
+	rate        *coordinator
+	encryptor   crypt.Encryptor
+	sse         encrypt.ServerSide
+	lock        *objectLock
+	breaker     *breaker
+	chunks      knownChunks
+}
+
+// NewWithOptions returns a client for the target configured by opts,
+// creating or checking its bucket as configured, enabling versioning,
+// expiring old versions of the rate limit object and probing the KMS key.
+// It is meant for the sidecar itself.
+func NewWithOptions(ctx context.Context, opts Options) (MinioClient, error) {
+	return newTarget(ctx, opts)
+}
+
+// Connect returns a client for the target configured by opts that never
+// changes the bucket: it is not created, nor are its versioning, encryption
+// or lifecycle configured, and no probe object is written. It only checks
+// that the bucket exists when it would be checked or created. Commands that
+// read or prune the bucket use it.
+func Connect(ctx context.Context, opts Options) (MinioClient, error) {
+	c, err := connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CreateBucket || opts.CheckBucket {
+		exists, err := c.client.BucketExists(ctx, c.bucket)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check bucket %s: %w", c.bucket, err)
+		}
+
+		if !exists {
+			return nil, fmt.Errorf("bucket %s does not exist", c.bucket)
+		}
+	}
+
+	return c, nil
 }
 
-func New(ctx context.Context) (MinioClient, error) {
-	klog.V(3).Info("configuring minio")
+// Validate checks opts as NewWithOptions does, without contacting the target.
+func Validate(opts Options) error {
+	c := &minioConfig{opts: opts}
+
+	if err := c.newClient(); err != nil {
+		return fmt.Errorf("unable to initialize minio client: %w", err)
+	}
+
+	if _, err := newSSE(opts.SSE, opts.Secure); err != nil {
+		return fmt.Errorf("unable to configure server-side encryption: %w", err)
+	}
+
+	if _, err := newObjectLock(opts.ObjectLock); err != nil {
+		return err
+	}
+
+	if err := validateBreaker(opts.CircuitBreaker); err != nil {
+		return err
+	}
 
-	c := &minioConfig{}
+	if opts.Bucket == "" {
+		return fmt.Errorf("minio.bucket must be set")
+	}
+
+	return nil
+}
 
-	err := c.newClient()
+func newTarget(ctx context.Context, opts Options) (*minioConfig, error) {
+	c, err := connect(ctx, opts)
 	if err != nil {
-		return nil, fmt.Errorf("unable to initialize minio client: %w", err)
+		return nil, err
 	}
 
 	err = c.makeBucket(ctx)
@@ -57,30 +158,99 @@ func New(ctx context.Context) (MinioClient, error) {
 		return nil, fmt.Errorf("unable to find or create minio bucket: %w", err)
 	}
 
+	if err := c.probeKMS(ctx); err != nil {
+		return nil, fmt.Errorf("kms pre-flight failed: %w", err)
+	}
+
+	if err := c.expireCoordinatorVersions(ctx); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
+// connect returns a client for the target configured by opts without
+// contacting it. A target found unreachable is probed until ctx is done.
+func connect(ctx context.Context, opts Options) (*minioConfig, error) {
+	klog.V(3).InfoS("configuring minio", "endpoint", opts.Endpoint, "bucket", opts.Bucket)
+
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("minio.bucket must be set")
+	}
+
+	c := &minioConfig{
+		opts:      opts,
+		limiter:   newLimiter(opts.MaxConcurrency),
+		encryptor: opts.Encryptor,
+	}
+
+	breaker, err := newBreaker(ctx, c, opts.CircuitBreaker)
+	if err != nil {
+		return nil, err
+	}
+
+	c.breaker = breaker
+
+	err = c.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize minio client: %w", err)
+	}
+
+	c.sse, err = newSSE(opts.SSE, opts.Secure)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure server-side encryption: %w", err)
+	}
+
+	c.lock, err = newObjectLock(opts.ObjectLock)
+	if err != nil {
+		return nil, err
+	}
+
+	c.bucket = opts.Bucket
+	c.rate = newCoordinator(c, opts.ClusterRate.Key, opts.ClusterRate.Limit, opts.ClusterRate.Burst)
+
+	return c, nil
+}
+
+// Name identifies the target in logs and reports.
+func (c *minioConfig) Name() string {
+	return c.opts.Endpoint + "/" + c.bucket
+}
+
 func (c *minioConfig) newClient() error {
 	klog.V(4).Info("creating new client")
 
-	if !viper.IsSet("minio.endpoint") {
+	if c.opts.Endpoint == "" {
 		klog.V(3).Info("minio.endpoint not set")
 		return fmt.Errorf("minio.endpoint must be set")
 	}
 
-	if !viper.IsSet("minio.access-key-id") {
-		klog.V(3).Info("minio.access-key-id not set")
-		return fmt.Errorf("minio.access-key-id must be set")
+	transport, err := mc.DefaultTransport(c.opts.Secure)
+	if err != nil {
+		return fmt.Errorf("unable to create minio transport: %w", err)
+	}
+
+	if err := c.configureTransport(transport); err != nil {
+		return err
+	}
+
+	if err := c.configureTLS(transport); err != nil {
+		return err
 	}
 
-	if !viper.IsSet("minio.access-key-secret") {
-		klog.V(3).Info("minio.access-key-secret not set")
-		return fmt.Errorf("minio.access-key-secret must be set")
+	creds, err := c.newCredentials(transport)
+	if err != nil {
+		return err
 	}
 
-	client, err := mc.New(viper.GetString("minio.endpoint"), &mc.Options{
-		Creds:  credentials.NewStaticV4(viper.GetString("minio.access-key-id"), viper.GetString("minio.access-key-secret"), ""),
-		Secure: viper.GetBool("minio.secure"),
+	c.creds = creds
+	c.refreshable = !c.staticCredentials()
+	c.skew = newSkewTransport(transport, creds)
+
+	client, err := mc.New(c.opts.Endpoint, &mc.Options{
+		Creds:     creds,
+		Secure:    c.opts.Secure,
+		Transport: c.skew,
 	})
 	if err != nil {
 		klog.V(3).ErrorS(err, "unable to create minio client")
@@ -97,17 +267,48 @@ func (c *minioConfig) newClient() error {
 func (c *minioConfig) makeBucket(ctx context.Context) error {
 	klog.V(3).Info("making bucket")
 
-	if !viper.IsSet("minio.bucket") {
-		return fmt.Errorf("minio.bucket must be set")
+	bucket := c.bucket
+
+	switch {
+	case c.opts.CreateBucket:
+		if err := c.createBucket(ctx, bucket); err != nil {
+			return err
+		}
+	case c.opts.CheckBucket:
+		exists, err := c.client.BucketExists(ctx, bucket)
+		if err != nil {
+			return fmt.Errorf("unable to check bucket %s: %w", bucket, err)
+		}
+
+		if !exists {
+			return fmt.Errorf("bucket %s does not exist and minio.create-bucket is disabled", bucket)
+		}
+
+		klog.Infof("bucket %s exists, using it", bucket)
+	default:
+		klog.Infof("using bucket %s without checking it", bucket)
+	}
+
+	if c.opts.CreateBucket || c.opts.CheckBucket {
+		if err := c.checkObjectLock(ctx); err != nil {
+			return err
+		}
 	}
 
-	bucket := viper.GetString("minio.bucket")
-	o := mc.MakeBucketOptions{}
+	if c.opts.Versioning {
+		if err := c.client.EnableVersioning(ctx, bucket); err != nil {
+			return fmt.Errorf("unable to enable versioning on %s: %w", bucket, err)
+		}
 
-	if viper.IsSet("minio.region") {
-		o.Region = viper.GetString("minio.region")
+		klog.Infof("enabled versioning on %s", bucket)
 	}
 
+	return nil
+}
+
+func (c *minioConfig) createBucket(ctx context.Context, bucket string) error {
+	o := mc.MakeBucketOptions{ObjectLocking: c.opts.ObjectLock.Enabled, Region: c.opts.Region}
+
 	klog.V(4).InfoS("bucket params", "name", bucket, "options", o)
 
 	err := c.client.MakeBucket(ctx, bucket, o)
@@ -123,56 +324,351 @@ func (c *minioConfig) makeBucket(ctx context.Context) error {
 		}
 	} else {
 		klog.Infof("Successfully created %s", bucket)
-	}
-
-	c.bucket = bucket
 
-	if viper.IsSet("minio.retention") {
-		klog.V(3).Info("setting bucket retention")
+		// uploads carry their own encryption headers, so a missing bucket default is not fatal
+		if err := c.setBucketEncryption(ctx, bucket); err != nil {
+			klog.Warning(err)
+		}
+	}
 
-		lc := lifecycle.NewConfiguration()
-		lc.Rules = append(lc.Rules, lifecycle.Rule{Status: "Enabled", Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(viper.GetInt("minio.retention"))}})
+	return nil
+}
 
-		klog.V(4).InfoS("bucket lifecycle", "lifecycle.Configuration", lc)
+func (c *minioConfig) UploadFile(file string, ctx context.Context) error {
+	filename := filepath.Base(file)
+	return c.UploadFileWithDestination(file, config.Destination{Name: filename}, ctx)
+}
 
-		err = c.client.SetBucketLifecycle(ctx, bucket, lc)
-		if err != nil {
-			return fmt.Errorf("unable to set retention policy: %w", err)
+// Walk calls fn for every object under prefix in key order, fetching the
+// listing one page at a time. Metadata and tags are only included when
+// withMetadata is set, which requires a MinIO server.
+func (c *minioConfig) Walk(ctx context.Context, prefix string, withMetadata bool, fn func(mc.ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for obj := range c.client.ListObjects(ctx, c.bucket, mc.ListObjectsOptions{
+		Prefix:       strings.TrimPrefix(prefix, "/"),
+		Recursive:    true,
+		WithMetadata: withMetadata,
+		MaxKeys:      c.opts.ListPageSize,
+	}) {
+		if obj.Err != nil {
+			return fmt.Errorf("unable to list %s: %w", prefix, obj.Err)
 		}
 
-		klog.Infof("Set bucket retention policy to %d days", viper.GetInt("minio.retention"))
+		if err := fn(obj); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (c *minioConfig) UploadFile(file string, ctx context.Context) error {
-	_, filename := path.Split(file)
-	return c.UploadFileWithDestination(file, config.Destination{Name: filename}, ctx)
+// Download opens the object at key for reading along with its metadata.
+func (c *minioConfig) Download(ctx context.Context, key string) (io.ReadCloser, mc.ObjectInfo, error) {
+	obj, err := c.client.GetObject(ctx, c.bucket, key, mc.GetObjectOptions{ServerSideEncryption: c.readSSE()})
+	if err != nil {
+		return nil, mc.ObjectInfo{}, fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, info, fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	return obj, info, nil
 }
 
-func (c *minioConfig) UploadFileWithDestination(file string, dest config.Destination, ctx context.Context) error {
-	var objName string
+// ObjectName returns the object key file will be uploaded to for dest.
+func ObjectName(file string, dest config.Destination) string {
+	logical := LogicalName(file, dest)
+	if dest.ShardWidth <= 0 {
+		return logical
+	}
+
+	return path.Join(dest.Path, shard(logical, dest.ShardWidth), path.Base(logical))
+}
+
+// LogicalName returns the object key for file without any shard prefix.
+// Registered name resolvers take precedence over dest. A version is inserted
+// before the extension of the name, however it was chosen.
+func LogicalName(file string, dest config.Destination) string {
+	if name, ok := hooks.ResolveName(file, dest); ok {
+		return Versioned(name, dest.Version)
+	}
 
 	if dest.Name == "" {
-		_, filename := path.Split(file)
+		filename := filepath.Base(file)
 		dest.Name = filename
 	}
 
 	if dest.Path != "" {
-		objName = path.Join(dest.Path, dest.Name)
-	} else {
-		objName = dest.Name
+		return Versioned(path.Join(dest.Path, dest.Name), dest.Version)
+	}
+
+	return Versioned(dest.Name, dest.Version)
+}
+
+// Versioned inserts "-version" before the extensions of the last element of
+// key, so file.sql.gz becomes file-version.sql.gz.
+func Versioned(key, version string) string {
+	if version == "" {
+		return key
+	}
+
+	dir, name := path.Split(key)
+
+	// a leading dot names a hidden file rather than starting an extension
+	stem, ext := name, ""
+	if i := strings.Index(strings.TrimPrefix(name, "."), "."); i > 0 {
+		i += len(name) - len(strings.TrimPrefix(name, "."))
+		stem, ext = name[:i], name[i:]
 	}
 
+	return dir + stem + "-" + version + ext
+}
+
+func (c *minioConfig) UploadFileWithDestination(file string, dest config.Destination, ctx context.Context) error {
+	objName := ObjectName(file, dest)
+
+	ctx, span := tracing.Start(ctx, "minio.upload",
+		attribute.String("target", c.Name()), attribute.String("bucket", c.bucket), attribute.String("object", objName))
+	defer span.End()
+
 	klog.V(2).InfoS("uploading file", "file", file, "destination", objName, "content-type", dest.Type)
 
-	info, err := c.client.FPutObject(ctx, c.bucket, objName, file, mc.PutObjectOptions{ContentType: dest.Type})
+	start := time.Now()
+
+	st, err := os.Stat(file)
+	if err != nil {
+		c.logUploadFailed(err, file, objName, 0, start)
+		return fmt.Errorf("unable to put %s: %w", objName, err)
+	}
+
+	metadata, err := objectMetadata(file, dest, c.opts.Provenance, c.opts.Attributes)
+	if err != nil {
+		c.logUploadFailed(err, file, objName, st.Size(), start)
+		return fmt.Errorf("unable to put %s: %w", objName, err)
+	}
+
+	if dest.ShardWidth > 0 {
+		metadata[MetadataLogicalKey] = LogicalName(file, dest)
+	}
+
+	if dest.Append {
+		addSegmentMetadata(metadata, file, dest)
+	}
+
+	if dest.Compression == compressionGzip {
+		reason, err := incompressible(file)
+		if err != nil {
+			c.logUploadFailed(err, file, objName, st.Size(), start)
+			return fmt.Errorf("unable to put %s: %w", objName, err)
+		}
+
+		if reason != "" {
+			klog.V(3).InfoS("skipping compression", "file", file, "reason", reason)
+			metadata[MetadataCompressionSkipped] = reason
+		} else {
+			metadata[MetadataCompression] = compressionGzip
+		}
+	}
+
+	if len(dest.Filters) > 0 {
+		metadata[MetadataFilters] = strings.Join(dest.Filters, " | ")
+	}
+
+	if dest.ChunkSize > 0 && st.Size() >= dest.ChunkSize {
+		metadata[MetadataChunkSize] = strconv.FormatInt(dest.ChunkSize, 10)
+	}
+
+	// the object size no longer matches the file, so Verify needs the source size
+	if _, ok := metadata[MetadataSize]; !ok && (metadata[MetadataCompression] != "" || metadata[MetadataFilters] != "" || metadata[MetadataChunkSize] != "" || c.encryptor != nil) {
+		metadata[MetadataSize] = strconv.FormatInt(st.Size(), 10)
+	}
+
+	opts := mc.PutObjectOptions{
+		ContentType:          dest.Type,
+		UserTags:             dest.Tags,
+		UserMetadata:         metadata,
+		StorageClass:         dest.StorageClass,
+		ServerSideEncryption: c.sse,
+	}
+
+	if opts.StorageClass == "" {
+		opts.StorageClass = c.opts.StorageClass
+	}
+
+	c.lock.apply(&opts)
+
+	info, err := c.putObject(ctx, objName, sourceOf(file, dest), opts)
+	if errors.Is(err, ErrCircuitOpen) {
+		c.logUploadFailed(err, file, objName, st.Size(), start)
+		return err
+	}
+
 	if err != nil {
+		tracing.Fail(span, err)
+		c.logUploadFailed(err, file, objName, st.Size(), start)
 		return fmt.Errorf("unable to put %s: %w", objName, err)
 	}
 
-	klog.Infof("successfully uploaded %s of size %d to %s", objName, info.Size, c.bucket)
+	if info.VersionID != "" {
+		metrics.VersionedUploads.WithLabelValues(c.Name()).Inc()
+		klog.InfoS("upload succeeded", "path", file, "object", objName, "bucket", c.bucket, "version", info.VersionID, "size", info.Size, "duration", time.Since(start))
+
+		return nil
+	}
+
+	klog.InfoS("upload succeeded", "path", file, "object", objName, "bucket", c.bucket, "size", info.Size, "duration", time.Since(start))
 
 	return nil
 }
+
+// logUploadFailed logs the structured record of a failed upload of file, of
+// size bytes, to objName.
+func (c *minioConfig) logUploadFailed(err error, file, objName string, size int64, start time.Time) {
+	klog.ErrorS(err, "upload failed", "path", file, "object", objName, "bucket", c.bucket, "size", size, "duration", time.Since(start))
+}
+
+func (c *minioConfig) putObject(ctx context.Context, objName string, src source, opts mc.PutObjectOptions) (mc.UploadInfo, error) {
+	if err := c.breaker.allow(); err != nil {
+		return mc.UploadInfo{}, err
+	}
+
+	info, err := c.retryPut(ctx, objName, src, opts)
+	c.breaker.record(err)
+
+	return info, err
+}
+
+func (c *minioConfig) retryPut(ctx context.Context, objName string, src source, opts mc.PutObjectOptions) (mc.UploadInfo, error) {
+	retries := c.opts.MaxRetries
+
+	for attempt := 0; ; attempt++ {
+		if err := c.rate.acquire(ctx); err != nil {
+			return mc.UploadInfo{}, err
+		}
+
+		if err := c.limiter.acquire(ctx); err != nil {
+			return mc.UploadInfo{}, err
+		}
+
+		pctx, span := tracing.Start(ctx, "minio.put", attribute.Int("attempt", attempt+1))
+		info, err := c.put(pctx, objName, src, opts)
+		class := c.classify(err)
+
+		if err != nil {
+			span.SetAttributes(attribute.String("error.class", class.String()))
+			tracing.Fail(span, err)
+		}
+
+		span.SetAttributes(attribute.Int64("size", info.Size))
+		span.End()
+
+		c.limiter.release(class, err != nil)
+
+		if err == nil {
+			return info, nil
+		}
+
+		metrics.UploadErrors.WithLabelValues(class.String()).Inc()
+
+		if class == ErrorPermission {
+			klog.ErrorS(err, "permission denied by server, check credentials and bucket policy", "object", objName, "bucket", c.bucket)
+		}
+
+		c.skew.handle(err)
+
+		if !class.Retryable() || attempt >= retries || !src.rewind() {
+			return info, err
+		}
+
+		wait := class.Backoff(attempt)
+		klog.V(2).InfoS("retrying upload", "object", objName, "class", class, "attempt", attempt+1, "wait", wait)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1), attribute.String("error.class", class.String()), attribute.Stringer("wait", wait)))
+
+		select {
+		case <-ctx.Done():
+			return info, fmt.Errorf("upload canceled: %w", err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// put uploads src once, streaming it through filters, compression and the
+// encryptor when they are configured, or as chunks when it is chunked.
+func (c *minioConfig) put(ctx context.Context, objName string, src source, opts mc.PutObjectOptions) (mc.UploadInfo, error) {
+	if opts.UserMetadata[MetadataChunkSize] != "" {
+		return c.putChunked(ctx, objName, src, opts)
+	}
+
+	compress := opts.UserMetadata[MetadataCompression] == compressionGzip
+
+	if c.encryptor == nil && !compress && len(src.filters) == 0 && !src.segment && src.stream == nil {
+		st, err := os.Stat(src.file)
+		if err != nil {
+			return mc.UploadInfo{}, fmt.Errorf("unable to stat %s: %w", src.file, err)
+		}
+
+		if st.Size() > resumablePartSize {
+			return c.putResumable(ctx, objName, src.file, st, opts)
+		}
+
+		info, err := c.client.FPutObject(ctx, c.bucket, objName, src.file, opts)
+		if err != nil {
+			return info, fmt.Errorf("put failed: %w", err)
+		}
+
+		return info, nil
+	}
+
+	r, closeSrc, err := src.open(ctx)
+	if err != nil {
+		return mc.UploadInfo{}, err
+	}
+	defer closeSrc()
+
+	if compress {
+		zr := gzipReader(r)
+		defer zr.Close()
+
+		r = zr
+	}
+
+	if c.encryptor != nil {
+		er, err := c.encryptor.EncryptReader(r)
+		if err != nil {
+			return mc.UploadInfo{}, fmt.Errorf("unable to encrypt %s: %w", src.file, err)
+		}
+		defer er.Close()
+
+		metadata := make(map[string]string, len(opts.UserMetadata)+1)
+		for k, v := range opts.UserMetadata {
+			metadata[k] = v
+		}
+
+		metadata[crypt.MetadataKey] = c.encryptor.Type()
+		opts.UserMetadata = metadata
+		r = er
+	}
+
+	// only a segment or stream read as is has a known size
+	size := int64(-1)
+	if (src.segment || src.stream != nil) && c.encryptor == nil && !compress && len(src.filters) == 0 {
+		size = src.length
+	}
+
+	if size < 0 {
+		opts.PartSize = streamPartSize
+	}
+
+	info, err := c.client.PutObject(ctx, c.bucket, objName, r, size, opts)
+	if err != nil {
+		return info, fmt.Errorf("put failed: %w", err)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// User metadata keys describing compression of an object.
+const (
+	MetadataCompression        = "Compression"
+	MetadataCompressionSkipped = "Compression-Skipped"
+)
+
+const (
+	compressionGzip = "gzip"
+
+	// sampleSize is how much of a file is inspected to detect incompressible content.
+	sampleSize = 64 << 10
+	// maxEntropy is the Shannon entropy in bits per byte above which a sample
+	// is treated as already compressed or encrypted.
+	maxEntropy = 7.5
+	bitsByte   = 256
+)
+
+// magic numbers of formats that do not benefit from recompression.
+var magic = []struct {
+	name   string
+	offset int
+	prefix []byte
+}{
+	{"gzip", 0, []byte{0x1f, 0x8b}},
+	{"zstd", 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"bzip2", 0, []byte("BZh")},
+	{"lz4", 0, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{"zip", 0, []byte{'P', 'K', 0x03, 0x04}},
+	{"7z", 0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{"jpeg", 0, []byte{0xff, 0xd8, 0xff}},
+	{"png", 0, []byte{0x89, 'P', 'N', 'G'}},
+	{"gif", 0, []byte("GIF8")},
+	{"webp", 8, []byte("WEBP")},
+	{"mp4", 4, []byte("ftyp")},
+}
+
+// ValidCompression reports whether c is a supported compression.
+func ValidCompression(c string) bool {
+	return c == "" || c == "none" || c == compressionGzip
+}
+
+// incompressible returns why file should not be compressed, or an empty
+// string if it should.
+func incompressible(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	sample := make([]byte, sampleSize)
+
+	n, err := io.ReadFull(f, sample)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("unable to read %s: %w", file, err)
+	}
+
+	sample = sample[:n]
+
+	for _, m := range magic {
+		if len(sample) >= m.offset+len(m.prefix) && bytes.Equal(sample[m.offset:m.offset+len(m.prefix)], m.prefix) {
+			return m.name, nil
+		}
+	}
+
+	if entropy(sample) > maxEntropy {
+		return "entropy", nil
+	}
+
+	return "", nil
+}
+
+// entropy returns the Shannon entropy of b in bits per byte.
+func entropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	var counts [bitsByte]int
+	for _, c := range b {
+		counts[c]++
+	}
+
+	var e float64
+
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+
+		p := float64(c) / float64(len(b))
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+// gzipReader compresses src as it is read.
+func gzipReader(src io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		zw := gzip.NewWriter(pw)
+
+		if _, err := io.Copy(zw, src); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(zw.Close())
+	}()
+
+	return pr
+}
@@ -0,0 +1,200 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	mc "github.com/minio/minio-go/v7"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaseSize       = 5
+	leaseRetryDelay = 100 * time.Millisecond
+)
+
+type bucketState struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// coordinator limits the aggregate upload rate of every sidecar sharing a
+// bucket using a token bucket stored as an object. Tokens are leased in
+// batches and the object is updated with If-Match so concurrent writers retry
+// rather than overwrite each other. Coordination failures fail open. On a
+// versioned bucket every lease leaves a noncurrent version of the object,
+// which a lifecycle rule added by expireCoordinatorVersions expires.
+type coordinator struct {
+	c     *minioConfig
+	key   string
+	rate  float64
+	burst float64
+
+	mu    sync.Mutex
+	local int
+}
+
+func newCoordinator(c *minioConfig, key string, rate float64, burst int) *coordinator {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &coordinator{
+		c:     c,
+		key:   key,
+		rate:  rate,
+		burst: float64(burst),
+	}
+}
+
+func (r *coordinator) acquire(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.local == 0 {
+		n, wait, err := r.lease(ctx)
+		if err != nil {
+			klog.ErrorS(err, "unable to coordinate upload rate, continuing without it", "object", r.key)
+			return nil
+		}
+
+		r.local = n
+
+		if n == 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("waiting for rate limit: %w", ctx.Err())
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	r.local--
+
+	return nil
+}
+
+// lease takes up to leaseSize tokens from the shared bucket. When none are
+// available it returns how long to wait for the next token.
+func (r *coordinator) lease(ctx context.Context) (int, time.Duration, error) {
+	for {
+		state, etag, err := r.read(ctx)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		now := r.c.skew.now()
+		elapsed := math.Max(0, now.Sub(state.Updated).Seconds())
+		state.Tokens = math.Min(r.burst, state.Tokens+r.rate*elapsed)
+		state.Updated = now
+
+		take := int(math.Min(leaseSize, math.Floor(state.Tokens)))
+		if take < 1 {
+			return 0, time.Duration((1 - state.Tokens) / r.rate * float64(time.Second)), nil
+		}
+
+		state.Tokens -= float64(take)
+
+		err = r.write(ctx, state, etag)
+		if err == nil {
+			klog.V(4).InfoS("leased upload tokens", "tokens", take, "remaining", state.Tokens)
+			return take, 0, nil
+		}
+
+		var resp mc.ErrorResponse
+		if !errors.As(err, &resp) || resp.StatusCode != http.StatusPreconditionFailed {
+			return 0, 0, err
+		}
+
+		klog.V(4).InfoS("rate limit object changed concurrently, retrying", "object", r.key)
+
+		select {
+		case <-ctx.Done():
+			return 0, 0, fmt.Errorf("waiting for rate limit: %w", ctx.Err())
+		case <-time.After(leaseRetryDelay):
+		}
+	}
+}
+
+func (r *coordinator) read(ctx context.Context) (bucketState, string, error) {
+	state := bucketState{Tokens: r.burst, Updated: r.c.skew.now()}
+
+	obj, err := r.c.client.GetObject(ctx, r.c.bucket, r.key, mc.GetObjectOptions{ServerSideEncryption: r.c.readSSE()})
+	if err != nil {
+		return state, "", fmt.Errorf("unable to read rate limit object: %w", err)
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if mc.ToErrorResponse(err).Code == "NoSuchKey" {
+		return state, "", nil
+	} else if err != nil {
+		return state, "", fmt.Errorf("unable to read rate limit object: %w", err)
+	}
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return state, "", fmt.Errorf("unable to read rate limit object: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		klog.ErrorS(err, "invalid rate limit object, resetting", "object", r.key)
+		state = bucketState{Tokens: r.burst, Updated: r.c.skew.now()}
+	}
+
+	return state, info.ETag, nil
+}
+
+func (r *coordinator) write(ctx context.Context, state bucketState, etag string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("unable to encode rate limit state: %w", err)
+	}
+
+	opts := mc.PutObjectOptions{ContentType: "application/json", ServerSideEncryption: r.c.sse}
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+
+	_, err = r.c.client.PutObject(ctx, r.c.bucket, r.key, bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		return fmt.Errorf("unable to write rate limit object: %w", err)
+	}
+
+	return nil
+}
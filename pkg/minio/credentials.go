@@ -0,0 +1,382 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"k8s.io/klog/v2"
+)
+
+const (
+	credentialCheckInterval = 10 * time.Second // limits how often credential files are checked for changes
+	iamDialTimeout          = 2 * time.Second  // bounds connecting to the metadata endpoint, so a chain moves on quickly off the cloud
+	iamTimeout              = 10 * time.Second // bounds each metadata or STS request of iam in a chain
+)
+
+// DefaultCredentialChain is the order providers are tried in with auth-type
+// chain, unless configured otherwise.
+var DefaultCredentialChain = []string{"env", "file", "iam", "static"}
+
+// newCredentials returns the credentials selected by minio.auth-type.
+// Providers fetching credentials over the network use transport, so they
+// trust the same CA bundle, present the same client certificate and use the
+// same proxy as the client.
+func (c *minioConfig) newCredentials(transport *http.Transport) (*credentials.Credentials, error) {
+	creds := c.opts.Credentials
+
+	switch strings.ToLower(creds.Type) {
+	case "static", "":
+		if creds.AccessKeyIDFile != "" || creds.AccessKeySecretFile != "" {
+			return c.fileCredentials()
+		}
+
+		id, secret, err := c.staticKeys()
+		if err != nil {
+			return nil, err
+		}
+
+		return credentials.NewStaticV4(id, secret, ""), nil
+	case "assume-role":
+		return c.assumeRoleCredentials(transport)
+	case "iam":
+		// Also picks up IRSA from AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE.
+		return credentials.New(&credentials.IAM{
+			Client:   &http.Client{Transport: transport},
+			Endpoint: creds.IAMEndpoint,
+		}), nil
+	case "web-identity":
+		return c.webIdentityCredentials(transport)
+	case "chain":
+		return c.chainCredentials(transport)
+	default:
+		return nil, fmt.Errorf("unknown minio.auth-type %s", creds.Type)
+	}
+}
+
+// staticCredentials reports whether minio.auth-type selects keys set in the
+// configuration, which never change.
+func (c *minioConfig) staticCredentials() bool {
+	creds := c.opts.Credentials
+
+	switch strings.ToLower(creds.Type) {
+	case "static", "":
+		return creds.AccessKeyIDFile == "" && creds.AccessKeySecretFile == ""
+	default:
+		return false
+	}
+}
+
+// chainCredentials uses the first provider of the chain with credentials,
+// trying them again in order once those expire, so the same configuration
+// works with keys in the environment, in mounted files, or from the cloud.
+func (c *minioConfig) chainCredentials(transport *http.Transport) (*credentials.Credentials, error) {
+	names := c.opts.Credentials.Chain
+	if len(names) == 0 {
+		names = DefaultCredentialChain
+	}
+
+	var (
+		providers []credentials.Provider
+		used      atomic.Value
+	)
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		ps, err := c.chainProviders(name, transport)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range ps {
+			providers = append(providers, &namedProvider{Provider: p, name: name, used: &used})
+		}
+	}
+
+	creds := credentials.NewChainCredentials(providers)
+
+	v, err := creds.Get()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get credentials: %w", err)
+	}
+
+	if v.SignerType.IsAnonymous() {
+		return nil, fmt.Errorf("no credentials found by minio.credential-chain %s", strings.Join(names, ","))
+	}
+
+	klog.InfoS("using credentials", "provider", used.Load(), "endpoint", c.opts.Endpoint)
+
+	return creds, nil
+}
+
+// chainProviders returns the providers of a credential chain entry. Static
+// keys are skipped when they are not set.
+func (c *minioConfig) chainProviders(name string, transport *http.Transport) ([]credentials.Provider, error) {
+	opts := c.opts.Credentials
+
+	switch name {
+	case "env":
+		return []credentials.Provider{&credentials.EnvAWS{}, &credentials.EnvMinio{}}, nil
+	case "file":
+		providers := []credentials.Provider{&credentials.FileAWSCredentials{}}
+
+		if opts.AccessKeyIDFile != "" || opts.AccessKeySecretFile != "" {
+			if opts.AccessKeyIDFile == "" || opts.AccessKeySecretFile == "" {
+				return nil, errors.New("minio.access-key-id-file and minio.access-key-secret-file must be set together")
+			}
+
+			providers = append([]credentials.Provider{&fileProvider{
+				idFile:     opts.AccessKeyIDFile,
+				secretFile: opts.AccessKeySecretFile,
+			}}, providers...)
+		}
+
+		return providers, nil
+	case "iam":
+		transport := transport.Clone()
+		transport.DialContext = (&net.Dialer{Timeout: iamDialTimeout}).DialContext
+
+		// Also picks up IRSA from AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE.
+		return []credentials.Provider{&credentials.IAM{
+			Client:   &http.Client{Transport: transport, Timeout: iamTimeout},
+			Endpoint: opts.IAMEndpoint,
+		}}, nil
+	case "static":
+		if opts.AccessKeyID == "" || opts.AccessKeySecret == "" {
+			return nil, nil
+		}
+
+		return []credentials.Provider{&credentials.Static{Value: credentials.Value{
+			AccessKeyID:     opts.AccessKeyID,
+			SecretAccessKey: opts.AccessKeySecret,
+			SignerType:      credentials.SignatureV4,
+		}}}, nil
+	default:
+		return nil, fmt.Errorf("unknown minio.credential-chain provider %s", name)
+	}
+}
+
+// namedProvider records the name of the chain entry whose provider last
+// returned credentials, logging when it changes after the first.
+type namedProvider struct {
+	credentials.Provider
+
+	name string
+	used *atomic.Value
+}
+
+func (p *namedProvider) Retrieve() (credentials.Value, error) {
+	v, err := p.Provider.Retrieve()
+	if err != nil || v.AccessKeyID == "" {
+		return v, err
+	}
+
+	if prev := p.used.Swap(p.name); prev != nil && prev != p.name {
+		klog.InfoS("credential provider changed", "provider", p.name, "previous", prev)
+	}
+
+	return v, nil
+}
+
+// webIdentityCredentials exchanges a projected service account token for
+// temporary credentials. The token file is re-read on every refresh, since
+// the kubelet rotates it.
+func (c *minioConfig) webIdentityCredentials(transport *http.Transport) (*credentials.Credentials, error) {
+	opts := c.opts.Credentials.WebIdentity
+
+	tokenFile := opts.TokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+
+	if tokenFile == "" {
+		return nil, errors.New("minio.auth-type web-identity requires minio.web-identity.token-file")
+	}
+
+	roleARN := opts.RoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+
+	return credentials.New(&credentials.STSWebIdentity{
+		Client:      &http.Client{Transport: transport},
+		STSEndpoint: opts.STSEndpoint,
+		RoleARN:     roleARN,
+		GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+			token, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read web identity token: %w", err)
+			}
+
+			return &credentials.WebIdentityToken{Token: strings.TrimSpace(string(token))}, nil
+		},
+	}), nil
+}
+
+func (c *minioConfig) fileCredentials() (*credentials.Credentials, error) {
+	opts := c.opts.Credentials
+
+	if opts.AccessKeyIDFile == "" || opts.AccessKeySecretFile == "" {
+		return nil, errors.New("minio.access-key-id-file and minio.access-key-secret-file must be set together")
+	}
+
+	creds := credentials.New(&fileProvider{
+		idFile:     opts.AccessKeyIDFile,
+		secretFile: opts.AccessKeySecretFile,
+	})
+
+	if _, err := creds.Get(); err != nil {
+		return nil, fmt.Errorf("unable to read credential files: %w", err)
+	}
+
+	return creds, nil
+}
+
+func (c *minioConfig) staticKeys() (string, string, error) {
+	opts := c.opts.Credentials
+
+	if opts.AccessKeyID == "" {
+		klog.V(3).Info("minio.access-key-id not set")
+		return "", "", errors.New("minio.access-key-id must be set")
+	}
+
+	if opts.AccessKeySecret == "" {
+		klog.V(3).Info("minio.access-key-secret not set")
+		return "", "", errors.New("minio.access-key-secret must be set")
+	}
+
+	return opts.AccessKeyID, opts.AccessKeySecret, nil
+}
+
+// assumeRoleCredentials uses the static keys to assume a role through STS.
+// The session is refreshed automatically before it expires.
+func (c *minioConfig) assumeRoleCredentials(transport *http.Transport) (*credentials.Credentials, error) {
+	id, secret, err := c.staticKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := c.opts.Credentials.AssumeRole
+
+	endpoint := opts.STSEndpoint
+	if endpoint == "" {
+		// MinIO serves STS on the S3 endpoint.
+		endpoint = "http://" + c.opts.Endpoint
+		if c.opts.Secure {
+			endpoint = "https://" + c.opts.Endpoint
+		}
+	}
+
+	return credentials.New(&credentials.STSAssumeRole{
+		Client:      &http.Client{Transport: transport},
+		STSEndpoint: endpoint,
+		Options: credentials.STSAssumeRoleOptions{
+			AccessKey:       id,
+			SecretKey:       secret,
+			Location:        c.opts.Region,
+			DurationSeconds: opts.Duration,
+			RoleARN:         opts.RoleARN,
+			RoleSessionName: opts.SessionName,
+			ExternalID:      opts.ExternalID,
+		},
+	}), nil
+}
+
+// fileProvider reads static keys from files and reloads them when either file
+// changes, so rotated Kubernetes secrets are used without a restart.
+type fileProvider struct {
+	idFile     string
+	secretFile string
+
+	mu       sync.Mutex
+	modified time.Time
+	checked  time.Time
+}
+
+func (p *fileProvider) Retrieve() (credentials.Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id, err := os.ReadFile(p.idFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("unable to read access key id: %w", err)
+	}
+
+	secret, err := os.ReadFile(p.secretFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("unable to read access key secret: %w", err)
+	}
+
+	p.modified = p.modTime()
+
+	return credentials.Value{
+		AccessKeyID:     strings.TrimSpace(string(id)),
+		SecretAccessKey: strings.TrimSpace(string(secret)),
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// IsExpired reports whether either file changed since it was read, checking
+// at most once per credentialCheckInterval.
+func (p *fileProvider) IsExpired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.checked) < credentialCheckInterval {
+		return false
+	}
+
+	p.checked = time.Now()
+
+	if p.modTime().Equal(p.modified) {
+		return false
+	}
+
+	klog.InfoS("credential files changed, reloading", "access-key-id-file", p.idFile, "access-key-secret-file", p.secretFile)
+
+	return true
+}
+
+// modTime returns the latest modification time of both files, following
+// the symlinks Kubernetes swaps when a secret is updated.
+func (p *fileProvider) modTime() time.Time {
+	var latest time.Time
+
+	for _, f := range []string{p.idFile, p.secretFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
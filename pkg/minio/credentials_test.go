@@ -0,0 +1,69 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAssumeRoleUsesTLSOptions(t *testing.T) {
+	var requests atomic.Int32
+
+	sts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer sts.Close()
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: sts.Certificate().Raw}
+
+	if err := os.WriteFile(caCert, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	c := &minioConfig{opts: Options{
+		Endpoint: strings.TrimPrefix(sts.URL, "https://"),
+		Secure:   true,
+		Credentials: CredentialOptions{
+			Type:            "assume-role",
+			AccessKeyID:     "id",
+			AccessKeySecret: "secret",
+		},
+		TLS: TLSOptions{CACert: caCert},
+	}}
+
+	if err := c.newClient(); err != nil {
+		t.Fatalf("newClient: %v", err)
+	}
+
+	if _, err := c.creds.Get(); err == nil {
+		t.Fatal("Get succeeded against a denying STS endpoint")
+	}
+
+	if requests.Load() == 0 {
+		t.Error("STS endpoint was not reached with the configured CA bundle")
+	}
+}
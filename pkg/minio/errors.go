@@ -0,0 +1,151 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	mc "github.com/minio/minio-go/v7"
+)
+
+type ErrorClass int
+
+const (
+	ErrorTerminal   ErrorClass = iota // Will not succeed without a change to config or data
+	ErrorRetryable                    // Transient failure, retry with normal backoff
+	ErrorThrottled                    // Server asked us to slow down, retry with aggressive backoff
+	ErrorPermission                   // Credentials or policy problem, alert immediately
+)
+
+const (
+	retryBaseDelay    = time.Second
+	retryMaxDelay     = 30 * time.Second
+	throttleBaseDelay = 5 * time.Second
+	throttleMaxDelay  = 2 * time.Minute
+)
+
+var errorCodeClasses = map[string]ErrorClass{
+	"AccessDenied":          ErrorPermission,
+	"AccountProblem":        ErrorPermission,
+	"AllAccessDisabled":     ErrorPermission,
+	"InvalidAccessKeyId":    ErrorPermission,
+	"InvalidToken":          ErrorPermission,
+	"SignatureDoesNotMatch": ErrorPermission,
+	"SlowDown":              ErrorThrottled,
+	"Throttling":            ErrorThrottled,
+	"ThrottlingException":   ErrorThrottled,
+	"RequestLimitExceeded":  ErrorThrottled,
+	"RequestThrottled":      ErrorThrottled,
+	"TooManyRequests":       ErrorThrottled,
+	"ServiceUnavailable":    ErrorThrottled,
+	"ExpiredToken":          ErrorTerminal, // retryable when the credentials refresh, see minioConfig.classify
+	"ExpiredTokenException": ErrorTerminal,
+	"InternalError":         ErrorRetryable,
+	"RequestError":          ErrorRetryable,
+	"RequestTimeout":        ErrorRetryable,
+	"RequestTimeTooSkewed":  ErrorRetryable,
+	"NoSuchUpload":          ErrorRetryable, // a resumed multipart upload was aborted, retried from the start
+}
+
+func (e ErrorClass) String() string {
+	switch e {
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorThrottled:
+		return "throttled"
+	case ErrorPermission:
+		return "permission"
+	default:
+		return "terminal"
+	}
+}
+
+// Retryable reports whether an operation failing with this class should be retried.
+func (e ErrorClass) Retryable() bool {
+	return e == ErrorRetryable || e == ErrorThrottled
+}
+
+// Backoff returns the delay before retry attempt n (starting at 0).
+func (e ErrorClass) Backoff(n int) time.Duration {
+	base, limit := retryBaseDelay, retryMaxDelay
+	if e == ErrorThrottled {
+		base, limit = throttleBaseDelay, throttleMaxDelay
+	}
+
+	d := base << n
+	if d > limit || d <= 0 {
+		return limit
+	}
+
+	return d
+}
+
+// Classify maps an error returned by the minio client to an ErrorClass.
+func Classify(err error) ErrorClass {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, ErrFilterFailed) {
+		return ErrorTerminal
+	}
+
+	var resp mc.ErrorResponse
+	if !errors.As(err, &resp) {
+		// Network and transport errors
+		return ErrorRetryable
+	}
+
+	if class, ok := errorCodeClasses[resp.Code]; ok {
+		return class
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return ErrorThrottled
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return ErrorPermission
+	case resp.StatusCode >= http.StatusInternalServerError, resp.StatusCode == 0:
+		return ErrorRetryable
+	default:
+		return ErrorTerminal
+	}
+}
+
+// classify maps err to an ErrorClass like Classify. An expired token is
+// retryable when the credentials of c can refresh, which they are made to do
+// before the retry.
+func (c *minioConfig) classify(err error) ErrorClass {
+	class := Classify(err)
+
+	var resp mc.ErrorResponse
+	if class != ErrorTerminal || !c.refreshable || !errors.As(err, &resp) || (resp.Code != "ExpiredToken" && resp.Code != "ExpiredTokenException") {
+		return class
+	}
+
+	c.creds.Expire()
+
+	return ErrorRetryable
+}
+
+// IsNotFound reports whether err means the requested object does not exist.
+func IsNotFound(err error) bool {
+	var resp mc.ErrorResponse
+
+	return errors.As(err, &resp) && resp.Code == "NoSuchKey"
+}
@@ -0,0 +1,112 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	mc "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"nil", nil, ErrorTerminal},
+		{"canceled", fmt.Errorf("upload: %w", context.Canceled), ErrorTerminal},
+		{"missing file", os.ErrNotExist, ErrorTerminal},
+		{"unreadable file", os.ErrPermission, ErrorTerminal},
+		{"filter", ErrFilterFailed, ErrorTerminal},
+		{"network", errors.New("connection reset by peer"), ErrorRetryable},
+		{"deadline", context.DeadlineExceeded, ErrorRetryable},
+		{"access denied", mc.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, ErrorPermission},
+		{"slow down", mc.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}, ErrorThrottled},
+		{"service unavailable", mc.ErrorResponse{Code: "ServiceUnavailable", StatusCode: http.StatusServiceUnavailable}, ErrorThrottled},
+		{"expired token", mc.ErrorResponse{Code: "ExpiredToken", StatusCode: http.StatusBadRequest}, ErrorTerminal},
+		{"aborted upload", mc.ErrorResponse{Code: "NoSuchUpload", StatusCode: http.StatusNotFound}, ErrorRetryable},
+		{"wrapped code", fmt.Errorf("upload: %w", mc.ErrorResponse{Code: "SlowDown"}), ErrorThrottled},
+		{"too many requests", mc.ErrorResponse{Code: "Other", StatusCode: http.StatusTooManyRequests}, ErrorThrottled},
+		{"unauthorized", mc.ErrorResponse{Code: "Other", StatusCode: http.StatusUnauthorized}, ErrorPermission},
+		{"server error", mc.ErrorResponse{Code: "Other", StatusCode: http.StatusBadGateway}, ErrorRetryable},
+		{"no status", mc.ErrorResponse{Code: "Other"}, ErrorRetryable},
+		{"bad request", mc.ErrorResponse{Code: "InvalidArgument", StatusCode: http.StatusBadRequest}, ErrorTerminal},
+		{"missing bucket", mc.ErrorResponse{Code: "NoSuchBucket", StatusCode: http.StatusNotFound}, ErrorTerminal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyExpiredToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		refreshable bool
+		want        ErrorClass
+	}{
+		{"static keys", false, ErrorTerminal},
+		{"refreshing credentials", true, ErrorRetryable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &minioConfig{creds: credentials.NewStaticV4("access", "secret", ""), refreshable: tt.refreshable}
+			err := fmt.Errorf("put failed: %w", mc.ErrorResponse{Code: "ExpiredToken", StatusCode: http.StatusBadRequest})
+
+			if got := c.classify(err); got != tt.want {
+				t.Errorf("classify(%v) = %s, want %s", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		class ErrorClass
+		n     int
+		want  time.Duration
+	}{
+		{ErrorRetryable, 0, retryBaseDelay},
+		{ErrorRetryable, 3, 8 * retryBaseDelay},
+		{ErrorRetryable, 10, retryMaxDelay},
+		{ErrorRetryable, 64, retryMaxDelay},
+		{ErrorThrottled, 0, throttleBaseDelay},
+		{ErrorThrottled, 2, 4 * throttleBaseDelay},
+		{ErrorThrottled, 10, throttleMaxDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.class, tt.n), func(t *testing.T) {
+			if got := tt.class.Backoff(tt.n); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // ETags are MD5 sums
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	mc "github.com/minio/minio-go/v7"
+)
+
+// fakePollInterval is how often WaitFor checks for an object.
+const fakePollInterval = 10 * time.Millisecond
+
+// Fake is an in-memory MinioClient for tests of code that uploads or reads
+// objects. Objects are stored uncompressed and unencrypted under the keys a
+// server would store them at, with the metadata an upload would record.
+type Fake struct {
+	name string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	err     error
+	uploads int
+}
+
+type fakeObject struct {
+	data []byte
+	info mc.ObjectInfo
+}
+
+// NewFake returns an empty Fake named name.
+func NewFake(name string) *Fake {
+	return &Fake{name: name, objects: make(map[string]fakeObject)}
+}
+
+// FailUploads makes every upload fail with err until it is called with nil.
+func (f *Fake) FailUploads(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+// Uploads returns the number of successful uploads.
+func (f *Fake) Uploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.uploads
+}
+
+// Keys returns the key of every stored object in order.
+func (f *Fake) Keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+// Object returns the content and metadata of the object at key.
+func (f *Fake) Object(key string) ([]byte, mc.ObjectInfo, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	obj, ok := f.objects[fakeKey(key)]
+
+	return obj.data, obj.info, ok
+}
+
+// WaitFor returns the metadata of the object at key once it exists, or an
+// error when ctx is done first.
+func (f *Fake) WaitFor(ctx context.Context, key string) (mc.ObjectInfo, error) {
+	t := time.NewTicker(fakePollInterval)
+	defer t.Stop()
+
+	for {
+		if _, info, ok := f.Object(key); ok {
+			return info, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return mc.ObjectInfo{}, fmt.Errorf("waiting for %s: %w", key, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+func (f *Fake) Name() string {
+	return f.name
+}
+
+func (f *Fake) UploadFile(file string, ctx context.Context) error {
+	return f.UploadFileWithDestination(file, config.Destination{}, ctx)
+}
+
+func (f *Fake) UploadFileWithDestination(file string, dest config.Destination, ctx context.Context) error {
+	key := ObjectName(file, dest)
+
+	metadata, err := objectMetadata(file, dest, false, false)
+	if err != nil {
+		return fmt.Errorf("unable to put %s: %w", key, err)
+	}
+
+	if dest.ShardWidth > 0 {
+		metadata[MetadataLogicalKey] = LogicalName(file, dest)
+	}
+
+	if dest.Append {
+		addSegmentMetadata(metadata, file, dest)
+	}
+
+	data, err := readSource(ctx, sourceOf(file, dest))
+	if err != nil {
+		return fmt.Errorf("unable to put %s: %w", key, err)
+	}
+
+	if len(dest.Filters) > 0 {
+		metadata[MetadataFilters] = strings.Join(dest.Filters, " | ")
+	}
+
+	return f.put(ctx, key, data, mc.ObjectInfo{
+		ContentType:  dest.Type,
+		StorageClass: dest.StorageClass,
+		UserMetadata: metadata,
+		UserTags:     maps.Clone(dest.Tags),
+	})
+}
+
+// readSource returns the content of src.
+func readSource(ctx context.Context, src source) ([]byte, error) {
+	r, closeSrc, err := src.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSrc()
+
+	return io.ReadAll(r)
+}
+
+func (f *Fake) Upload(ctx context.Context, key string, r io.Reader, _ int64, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", key, err)
+	}
+
+	return f.put(ctx, key, data, mc.ObjectInfo{ContentType: contentType})
+}
+
+func (f *Fake) put(ctx context.Context, key string, data []byte, info mc.ObjectInfo) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("unable to put %s: %w", key, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return fmt.Errorf("unable to put %s: %w", key, f.err)
+	}
+
+	sum := md5.Sum(data) //nolint:gosec // ETags are MD5 sums
+
+	info.Key = fakeKey(key)
+	info.Size = int64(len(data))
+	info.ETag = hex.EncodeToString(sum[:])
+	info.LastModified = time.Now().UTC()
+
+	f.objects[info.Key] = fakeObject{data: slices.Clone(data), info: info}
+	f.uploads++
+
+	return nil
+}
+
+func (f *Fake) Stat(_ context.Context, key string) (mc.ObjectInfo, error) {
+	_, info, ok := f.Object(key)
+	if !ok {
+		return info, fmt.Errorf("unable to stat %s: %w", key, noSuchKey(key))
+	}
+
+	return info, nil
+}
+
+func (f *Fake) Download(ctx context.Context, key string) (io.ReadCloser, mc.ObjectInfo, error) {
+	data, info, ok := f.Object(key)
+	if !ok {
+		return nil, info, fmt.Errorf("unable to get %s: %w", key, noSuchKey(key))
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+// Walk calls fn for every object under prefix in key order. Metadata is only
+// included when withMetadata is set, as with a server listing.
+func (f *Fake) Walk(ctx context.Context, prefix string, withMetadata bool, fn func(mc.ObjectInfo) error) error {
+	prefix = fakeKey(prefix)
+
+	for _, key := range f.Keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("unable to list %s: %w", prefix, err)
+		}
+
+		_, info, ok := f.Object(key)
+		if !ok {
+			continue
+		}
+
+		if !withMetadata {
+			info.UserMetadata = nil
+			info.UserTags = nil
+		}
+
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *Fake) List(ctx context.Context, prefix string) ([]mc.ObjectInfo, error) {
+	return list(ctx, f, prefix)
+}
+
+func (f *Fake) Delete(_ context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.objects, fakeKey(key))
+
+	return nil
+}
+
+func (f *Fake) Copy(ctx context.Context, src, dst string) error {
+	data, info, ok := f.Object(src)
+	if !ok {
+		return fmt.Errorf("unable to copy %s: %w", src, noSuchKey(src))
+	}
+
+	return f.put(ctx, dst, data, info)
+}
+
+// RemoveObjects removes every key received from keys. Like a server, keys that
+// do not exist are counted as removed.
+func (f *Fake) RemoveObjects(ctx context.Context, keys <-chan string) (int, error) {
+	removed := 0
+
+	for k := range keys {
+		if err := f.Delete(ctx, k); err != nil {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (f *Fake) Unchanged(ctx context.Context, file string, dest config.Destination) (bool, error) {
+	if dest.SkipUnchanged == "" {
+		return false, nil
+	}
+
+	info, err := f.Stat(ctx, ObjectName(file, dest))
+	if IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return sameSource(info, file, dest.SkipUnchanged)
+}
+
+func (f *Fake) Verify(ctx context.Context, file string, dest config.Destination, size int64) error {
+	key := ObjectName(file, dest)
+
+	info, err := f.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return checkSize(key, info, size)
+}
+
+func (f *Fake) Select(_ context.Context, key string, _ mc.SelectObjectOptions) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("unable to query %s: %w", key, errors.ErrUnsupported)
+}
+
+// ApplyRetention does nothing, the fake keeps no lifecycle rules.
+func (f *Fake) ApplyRetention(_ context.Context, _ []string) error {
+	return nil
+}
+
+// AbortStaleUploads does nothing, the fake uploads every object at once.
+func (f *Fake) AbortStaleUploads(_ context.Context, _ []string) error {
+	return nil
+}
+
+// ApplyReplication does nothing, the fake keeps no replication rules.
+func (f *Fake) ApplyReplication(_ context.Context, _ []string) error {
+	return nil
+}
+
+// fakeKey drops the leading slash a server strips from object keys.
+func fakeKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+func noSuchKey(key string) error {
+	return mc.ErrorResponse{
+		Code:       "NoSuchKey",
+		Message:    "The specified key does not exist.",
+		Key:        key,
+		StatusCode: http.StatusNotFound,
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// MetadataFilters records the filter commands an object was written through.
+const MetadataFilters = "Filters"
+
+// ErrFilterFailed is returned when a filter exits with an error. Uploads are
+// not retried, since the filter would most likely fail again.
+var ErrFilterFailed = errors.New("filter failed")
+
+// filterStderrLimit is how many bytes of a failed filter's stderr are kept for its error.
+const filterStderrLimit = 4096
+
+// filterShell runs each filter, so filters are written as in a shell.
+var filterShell = []string{"sh", "-c"}
+
+// ValidFilters checks that filters can be run.
+func ValidFilters(filters []string) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	for _, f := range filters {
+		if strings.TrimSpace(f) == "" {
+			return errors.New("filters must not be empty")
+		}
+	}
+
+	if _, err := exec.LookPath(filterShell[0]); err != nil {
+		return fmt.Errorf("unable to run filters: %w", err)
+	}
+
+	return nil
+}
+
+// filterReader streams a reader through external commands, each reading the
+// output of the one before it.
+type filterReader struct {
+	out     io.ReadCloser
+	cmds    []*exec.Cmd
+	stderrs []*stderrTail
+	cancel  context.CancelFunc
+
+	once sync.Once
+	err  error
+}
+
+// newFilterReader starts filters reading r. Reading the result returns the
+// output of the last filter, and an error instead of io.EOF when any of them
+// failed, so a truncated stream is never stored as complete.
+func newFilterReader(ctx context.Context, r io.Reader, filters []string) (*filterReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &filterReader{cancel: cancel}
+
+	for _, filter := range filters {
+		cmd := exec.CommandContext(ctx, filterShell[0], append(filterShell[1:], filter)...) //nolint:gosec // filters are configured
+		cmd.Stdin = r
+
+		stderr := &stderrTail{limit: filterStderrLimit}
+		cmd.Stderr = stderr
+
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to run filter %q: %w", filter, err)
+		}
+
+		if err := cmd.Start(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to run filter %q: %w", filter, err)
+		}
+
+		f.cmds = append(f.cmds, cmd)
+		f.stderrs = append(f.stderrs, stderr)
+		f.out = out
+		r = out
+	}
+
+	return f, nil
+}
+
+func (f *filterReader) Read(p []byte) (int, error) {
+	n, err := f.out.Read(p)
+	if errors.Is(err, io.EOF) {
+		if werr := f.wait(); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+// wait returns the first failure of the filters, once they have all exited.
+func (f *filterReader) wait() error {
+	f.once.Do(func() {
+		var errs []error
+
+		for i, cmd := range f.cmds {
+			if err := cmd.Wait(); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %q: %w: %s", ErrFilterFailed, cmd.Args[len(cmd.Args)-1], err, f.stderrs[i]))
+			}
+		}
+
+		if len(errs) > 0 {
+			f.err = errs[0]
+		}
+
+		f.cancel()
+	})
+
+	return f.err
+}
+
+// Close stops any filter still running.
+func (f *filterReader) Close() error {
+	f.cancel()
+
+	if len(f.cmds) > 0 {
+		_ = f.wait()
+	}
+
+	return nil
+}
+
+// stderrTail keeps the last limit bytes written to it.
+type stderrTail struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+
+	return len(p), nil
+}
+
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return strings.TrimSpace(string(t.buf))
+}
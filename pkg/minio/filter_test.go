@@ -0,0 +1,63 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFilterReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []string
+		want    string
+		wantErr error
+	}{
+		{"single", []string{"tr a-z A-Z"}, "HELLO WORLD\n", nil},
+		{"chained", []string{"tr a-z A-Z", "sed s/O/0/g"}, "HELL0 W0RLD\n", nil},
+		{"failed", []string{"cat", "exit 3"}, "", ErrFilterFailed},
+		{"failed after output", []string{"cat; exit 1"}, "hello world\n", ErrFilterFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidFilters(tt.filters); err != nil {
+				t.Skipf("filters cannot run: %v", err)
+			}
+
+			f, err := newFilterReader(context.Background(), strings.NewReader("hello world\n"), tt.filters)
+			if err != nil {
+				t.Fatalf("newFilterReader: %v", err)
+			}
+			defer f.Close()
+
+			got, err := io.ReadAll(f)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("read error = %v, want %v", err, tt.wantErr)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	mc "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"k8s.io/klog/v2"
+)
+
+// lifecycleRuleID prefixes the IDs of rules managed by the sidecar, which end
+// in a hash of the rule prefix. Rules expiring old versions of the rate limit
+// object use coordinatorRuleID and are left alone by ApplyRetention.
+const (
+	lifecycleRuleID   = "minio-backup-sidecar-"
+	coordinatorRuleID = lifecycleRuleID + "rate-limit-"
+	ruleHashLength    = 12
+
+	// coordinatorVersionDays is how long versions of the rate limit object
+	// replaced by a lease are kept on a versioned bucket.
+	coordinatorVersionDays = 1
+)
+
+// ApplyRetention adds an expiration rule for each prefix to the bucket
+// lifecycle. Rules set by other tools, or by sidecars writing other
+// prefixes, are kept. Rules set by the sidecar under a given prefix that
+// are no longer wanted are removed, also once retention is turned off.
+func (c *minioConfig) ApplyRetention(ctx context.Context, prefixes []string) error {
+	if !c.opts.ManageLifecycle {
+		if c.opts.RetentionDays > 0 {
+			klog.Warningf("minio.retention is ignored for %s because minio.manage-lifecycle is disabled", c.Name())
+		}
+
+		return nil
+	}
+
+	days := c.opts.RetentionDays
+
+	lc, err := c.client.GetBucketLifecycle(ctx, c.bucket)
+	switch {
+	case err == nil:
+	case mc.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration":
+		if days <= 0 {
+			return nil
+		}
+
+		lc = lifecycle.NewConfiguration()
+	case days <= 0:
+		// nothing may have been set, so a sidecar without retention does not
+		// fail on buckets whose lifecycle it cannot read
+		klog.Warningf("unable to read lifecycle of %s to remove old retention policies: %v", c.bucket, err)
+		return nil
+	default:
+		return fmt.Errorf("unable to read lifecycle of %s: %w", c.bucket, err)
+	}
+
+	rules := make([]lifecycle.Rule, 0, len(prefixes))
+	ids := make([]string, 0, len(prefixes))
+	owned := make([]string, 0, len(prefixes))
+
+	for _, prefix := range prefixes {
+		prefix = strings.TrimPrefix(prefix, "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		owned = append(owned, prefix)
+
+		if days <= 0 {
+			continue
+		}
+
+		// a rule cannot exclude a subprefix, so one covering shared chunks
+		// would expire chunks still listed by newer indexes
+		if protected := c.protectedPrefix(prefix); protected != "" {
+			klog.Warningf("minio.retention is not applied to %s on %s because it would also expire %s, set destination.path to retain it", prefix, c.Name(), protected)
+			continue
+		}
+
+		rule := lifecycle.Rule{
+			ID:         lifecycleRuleID + ruleHash(prefix),
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: prefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(days)},
+		}
+
+		rules = append(rules, rule)
+		ids = append(ids, rule.ID)
+	}
+
+	removed := 0
+
+	lc.Rules = slices.DeleteFunc(lc.Rules, func(r lifecycle.Rule) bool {
+		if !strings.HasPrefix(r.ID, lifecycleRuleID) || strings.HasPrefix(r.ID, coordinatorRuleID) || !underAny(r.RuleFilter.Prefix, owned) {
+			return false
+		}
+
+		if !slices.Contains(ids, r.ID) {
+			klog.InfoS("removing stale retention policy", "target", c.Name(), "prefix", r.RuleFilter.Prefix)
+			removed++
+		}
+
+		return true
+	})
+
+	if len(rules) == 0 && removed == 0 {
+		return nil
+	}
+
+	lc.Rules = append(lc.Rules, rules...)
+
+	klog.V(4).InfoS("bucket lifecycle", "lifecycle.Configuration", lc)
+
+	if err := c.client.SetBucketLifecycle(ctx, c.bucket, lc); err != nil {
+		return fmt.Errorf("unable to set retention policy: %w", err)
+	}
+
+	if days > 0 {
+		klog.InfoS("set retention policy", "target", c.Name(), "days", days, "prefixes", prefixes)
+	}
+
+	return nil
+}
+
+// expireCoordinatorVersions adds a rule expiring the noncurrent versions of
+// the rate limit object. Every lease replaces the object, so on a versioned
+// bucket each one would otherwise leave a version behind forever. The object
+// itself stays versioned so leases keep using If-Match.
+func (c *minioConfig) expireCoordinatorVersions(ctx context.Context) error {
+	if c.rate == nil {
+		return nil
+	}
+
+	versioning, err := c.client.GetBucketVersioning(ctx, c.bucket)
+	if err != nil {
+		klog.Warningf("unable to check versioning of %s, old versions of %s may be kept: %v", c.bucket, c.rate.key, err)
+		return nil
+	}
+
+	if !versioning.Enabled() && !versioning.Suspended() {
+		return nil
+	}
+
+	if !c.opts.ManageLifecycle {
+		klog.Warningf("old versions of %s are kept on %s because minio.manage-lifecycle is disabled, expire them with a lifecycle rule", c.rate.key, c.bucket)
+		return nil
+	}
+
+	lc, err := c.client.GetBucketLifecycle(ctx, c.bucket)
+	if err != nil {
+		if mc.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("unable to read lifecycle of %s: %w", c.bucket, err)
+		}
+
+		lc = lifecycle.NewConfiguration()
+	}
+
+	id := coordinatorRuleID + ruleHash(c.rate.key)
+	if slices.ContainsFunc(lc.Rules, func(r lifecycle.Rule) bool { return r.ID == id }) {
+		return nil
+	}
+
+	lc.Rules = append(lc.Rules, lifecycle.Rule{
+		ID:                          id,
+		Status:                      "Enabled",
+		RuleFilter:                  lifecycle.Filter{Prefix: c.rate.key},
+		NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{NoncurrentDays: coordinatorVersionDays},
+	})
+
+	if err := c.client.SetBucketLifecycle(ctx, c.bucket, lc); err != nil {
+		return fmt.Errorf("unable to expire old versions of %s: %w", c.rate.key, err)
+	}
+
+	klog.InfoS("expiring old versions of rate limit object", "target", c.Name(), "object", c.rate.key, "days", coordinatorVersionDays)
+
+	return nil
+}
+
+// underAny reports whether prefix is one of prefixes or below one of them.
+func underAny(prefix string, prefixes []string) bool {
+	return slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(prefix, p) })
+}
+
+// protectedPrefix returns the internal or chunk prefix that prefix covers, if
+// any.
+func (c *minioConfig) protectedPrefix(prefix string) string {
+	for _, protected := range []string{InternalPrefix, strings.TrimPrefix(c.chunkPrefix(), "/") + "/"} {
+		if strings.HasPrefix(protected, prefix) {
+			return protected
+		}
+	}
+
+	return ""
+}
+
+// ruleHash keeps rule IDs short and stable for any prefix.
+func ruleHash(prefix string) string {
+	sum := sha256.Sum256([]byte(prefix))
+
+	return hex.EncodeToString(sum[:])[:ruleHashLength]
+}
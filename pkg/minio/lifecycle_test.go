@@ -0,0 +1,44 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import "testing"
+
+func TestUnderAny(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		prefixes []string
+		want     bool
+	}{
+		{"same prefix", "backups/", []string{"backups/"}, true},
+		{"subprefix", "backups/app/", []string{"backups/"}, true},
+		{"other profile", "other/", []string{"backups/"}, false},
+		{"sibling with shared start", "backups-old/", []string{"backups/"}, false},
+		{"bucket root", "anything/", []string{""}, true},
+		{"no prefixes", "backups/", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := underAny(tt.prefix, tt.prefixes); got != tt.want {
+				t.Errorf("underAny(%q, %v) = %v, want %v", tt.prefix, tt.prefixes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// limiter bounds concurrent uploads. The limit is halved whenever the server
+// throttles a request and grows by one after a full window of successes.
+// A free slot goes to an upload of the highest priority waiting for one.
+type limiter struct {
+	mu        sync.Mutex
+	max       int
+	limit     int
+	active    int
+	successes int
+	waiting   map[int]int // Uploads waiting for a slot by priority
+	wake      chan struct{}
+}
+
+func newLimiter(n int) *limiter {
+	if n < 1 {
+		n = 1
+	}
+
+	metrics.UploadConcurrencyLimit.Set(float64(n))
+
+	return &limiter{
+		max:     n,
+		limit:   n,
+		waiting: make(map[int]int),
+		wake:    make(chan struct{}),
+	}
+}
+
+func (l *limiter) acquire(ctx context.Context) error {
+	priority := priorityOf(ctx)
+	queued := false
+
+	for {
+		l.mu.Lock()
+		if l.active < l.limit && !l.waitingAbove(priority) {
+			l.active++
+
+			// uploads waiting behind this one may fit in another slot
+			if queued {
+				l.dequeue(priority)
+				l.broadcast()
+			}
+
+			l.mu.Unlock()
+
+			return nil
+		}
+
+		if !queued {
+			queued = true
+			l.waiting[priority]++
+			metrics.UploadQueueDepth.WithLabelValues(strconv.Itoa(priority)).Inc()
+		}
+
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.dequeue(priority)
+			l.broadcast()
+			l.mu.Unlock()
+
+			return fmt.Errorf("waiting for upload slot: %w", ctx.Err())
+		case <-wake:
+		}
+	}
+}
+
+// waitingAbove reports whether an upload of higher priority than priority
+// is waiting for a slot. l.mu must be held.
+func (l *limiter) waitingAbove(priority int) bool {
+	for p, n := range l.waiting {
+		if p > priority && n > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dequeue removes an upload of priority from the waiting uploads. l.mu must
+// be held.
+func (l *limiter) dequeue(priority int) {
+	l.waiting[priority]--
+	if l.waiting[priority] == 0 {
+		delete(l.waiting, priority)
+	}
+
+	metrics.UploadQueueDepth.WithLabelValues(strconv.Itoa(priority)).Dec()
+}
+
+// broadcast wakes every waiting upload to check for a slot again. l.mu must
+// be held.
+func (l *limiter) broadcast() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+func (l *limiter) release(class ErrorClass, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+
+	switch {
+	case failed && class == ErrorThrottled:
+		l.successes = 0
+
+		if l.limit > 1 {
+			l.limit /= 2
+			klog.V(2).InfoS("server throttling uploads, reducing concurrency", "limit", l.limit)
+		}
+	case !failed:
+		l.successes++
+
+		if l.limit < l.max && l.successes >= l.limit {
+			l.successes = 0
+			l.limit++
+			klog.V(3).InfoS("increasing upload concurrency", "limit", l.limit)
+		}
+	}
+
+	metrics.UploadConcurrencyLimit.Set(float64(l.limit))
+
+	l.broadcast()
+}
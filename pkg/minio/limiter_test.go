@@ -0,0 +1,173 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLimiterAIMD(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		outcomes string // s: success, t: throttled, e: other failure
+		want     int
+	}{
+		{"throttled halves", 8, "t", 4},
+		{"throttled twice", 8, "tt", 2},
+		{"floor of one", 2, "ttt", 1},
+		{"other failures keep limit", 4, "ee", 4},
+		{"grows after a window of successes", 8, "tssss", 5},
+		{"partial window", 8, "tsss", 4},
+		{"throttle restarts window", 8, "tssstss", 3},
+		{"never above max", 2, "sssss", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.max)
+
+			for _, o := range tt.outcomes {
+				if err := l.acquire(context.Background()); err != nil {
+					t.Fatalf("acquire: %v", err)
+				}
+
+				switch o {
+				case 's':
+					l.release(ErrorRetryable, false)
+				case 't':
+					l.release(ErrorThrottled, true)
+				default:
+					l.release(ErrorRetryable, true)
+				}
+			}
+
+			if l.limit != tt.want {
+				t.Errorf("limit after %s = %d, want %d", tt.outcomes, l.limit, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		want       []int
+	}{
+		{"highest first", []int{0, 5, 1}, []int{5, 1, 0}},
+		{"negative last", []int{-1, 0, 2}, []int{2, 0, -1}},
+		{"same priority", []int{3, 3}, []int{3, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			l := newLimiter(1)
+			if err := l.acquire(ctx); err != nil {
+				t.Fatalf("acquire: %v", err)
+			}
+
+			acquired := make(chan int)
+
+			for _, p := range tt.priorities {
+				go func() {
+					if err := l.acquire(WithPriority(ctx, p)); err == nil {
+						acquired <- p
+					}
+				}()
+			}
+
+			waitQueued(t, l, len(tt.priorities))
+
+			got := make([]int, 0, len(tt.priorities))
+
+			for range tt.priorities {
+				l.release(ErrorRetryable, false)
+
+				select {
+				case p := <-acquired:
+					got = append(got, p)
+				case <-ctx.Done():
+					t.Fatalf("slots went to %v, then none", got)
+				}
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("slots went to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(WithPriority(context.Background(), 1))
+	errs := make(chan error)
+
+	go func() { errs <- l.acquire(ctx) }()
+
+	waitQueued(t, l, 1)
+	cancel()
+
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire after cancel returned %v, want %v", err, context.Canceled)
+	}
+
+	// the canceled upload must not hold back lower priorities
+	l.release(ErrorRetryable, false)
+
+	if err := l.acquire(context.Background()); err != nil {
+		t.Errorf("acquire: %v", err)
+	}
+}
+
+// waitQueued waits until n uploads are waiting for a slot of l.
+func waitQueued(t *testing.T, l *limiter, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+
+		queued := 0
+		for _, w := range l.waiting {
+			queued += w
+		}
+
+		l.mu.Unlock()
+
+		if queued == n {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("%d uploads never waited for a slot", n)
+}
@@ -0,0 +1,165 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+const (
+	// MetadataLogicalKey records the unsharded object key of sharded uploads.
+	MetadataLogicalKey = "Logical-Key"
+	// MetadataTombstoneFor records the object a tombstone marks as removed.
+	MetadataTombstoneFor = "Tombstone-For"
+)
+
+// User metadata keys recorded when metadata-provenance is set. The mode,
+// ownership and mtime of the file and its directory are also recorded with
+// metadata-attributes.
+const (
+	MetadataSourcePath = "Source-Path"
+	MetadataMtime      = "Source-Mtime"
+	MetadataMode       = "Source-Mode"
+	MetadataUID        = "Source-Uid"
+	MetadataGID        = "Source-Gid"
+	MetadataDirMode    = "Source-Dir-Mode"
+	MetadataDirUID     = "Source-Dir-Uid"
+	MetadataDirGID     = "Source-Dir-Gid"
+	MetadataPodName    = "Pod-Name"
+	MetadataSHA256     = "Content-Sha256"
+)
+
+// ListedMetadata returns the user metadata value of key from a listing with
+// metadata, where servers keep the X-Amz-Meta- prefix on the keys.
+func ListedMetadata(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-"), key) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// objectMetadata returns the user metadata for file, adding its attributes
+// and provenance when enabled. Metadata set on dest takes precedence.
+func objectMetadata(file string, dest config.Destination, provenance, attributes bool) (map[string]string, error) {
+	metadata := map[string]string{}
+
+	if provenance || attributes {
+		if err := addAttributes(metadata, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if provenance {
+		hash, err := HashFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		source, err := filepath.Abs(file)
+		if err != nil {
+			source = file
+		}
+
+		metadata[MetadataSourcePath] = source
+		metadata[MetadataPodName] = config.PodName()
+		metadata[MetadataSHA256] = hash
+	}
+
+	if dest.SkipUnchanged != "" {
+		unchanged, err := unchangedMetadata(file, dest.SkipUnchanged)
+		if err != nil {
+			return nil, err
+		}
+
+		maps.Copy(metadata, unchanged)
+	}
+
+	maps.Copy(metadata, dest.Metadata)
+
+	return metadata, nil
+}
+
+// addAttributes records the mode, ownership and mtime of file, and the mode
+// and ownership of its directory, so restore can apply them again.
+func addAttributes(metadata map[string]string, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", file, err)
+	}
+
+	metadata[MetadataMtime] = info.ModTime().UTC().Format(time.RFC3339Nano)
+	metadata[MetadataMode] = strconv.FormatUint(uint64(info.Mode().Perm()), 8)
+
+	if uid, gid, ok := owner(info); ok {
+		metadata[MetadataUID] = strconv.FormatUint(uint64(uid), 10)
+		metadata[MetadataGID] = strconv.FormatUint(uint64(gid), 10)
+	}
+
+	dir, err := os.Stat(filepath.Dir(file))
+	if err != nil {
+		// the file was readable, so only its directory's attributes are lost
+		return nil
+	}
+
+	metadata[MetadataDirMode] = strconv.FormatUint(uint64(dir.Mode().Perm()), 8)
+
+	if uid, gid, ok := owner(dir); ok {
+		metadata[MetadataDirUID] = strconv.FormatUint(uint64(uid), 10)
+		metadata[MetadataDirGID] = strconv.FormatUint(uint64(gid), 10)
+	}
+
+	return nil
+}
+
+// HashFile returns the hex encoded sha256 of the contents of file.
+func HashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to hash %s: %w", file, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// shard returns the first width hex characters of the sha256 of key, spreading
+// keys evenly across 16^width prefixes.
+func shard(key string, width int) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])[:min(width, sha256.Size*2)]
+}
@@ -0,0 +1,346 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	"github.com/csfreak/minio-backup-sidecar/pkg/state"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"k8s.io/klog/v2"
+)
+
+// Plain files larger than resumablePartSize are uploaded in parts of at least
+// that size, recorded in the state so a retried or restarted upload only
+// sends the parts that are missing. Like other multipart uploads, an upload
+// holding one limiter slot sends up to partWorkers parts at once.
+const (
+	resumablePartSize = 16 << 20
+	maxParts          = 10000
+	partWorkers       = 4
+)
+
+// partSize returns the part size of a resumable upload of size bytes.
+func partSize(size int64) int64 {
+	part := int64(resumablePartSize)
+	for part*maxParts < size {
+		part *= 2
+	}
+
+	return part
+}
+
+// putResumable uploads file, size bytes long, in parts. The multipart upload
+// recorded for objName is continued when it was started for the file as it
+// is now, so parts already uploaded are not sent again.
+func (c *minioConfig) putResumable(ctx context.Context, objName, file string, info os.FileInfo, opts mc.PutObjectOptions) (mc.UploadInfo, error) {
+	core := mc.Core{Client: c.client}
+
+	upload, uploaded := c.resumable(ctx, core, objName, file, info)
+	if upload.UploadID == "" {
+		if opts.ContentType == "" {
+			opts.ContentType = mime.TypeByExtension(filepath.Ext(file))
+		}
+
+		if opts.ContentType == "" {
+			opts.ContentType = "application/octet-stream"
+		}
+
+		id, err := core.NewMultipartUpload(ctx, c.bucket, objName, opts)
+		if err != nil {
+			return mc.UploadInfo{}, fmt.Errorf("put failed: %w", err)
+		}
+
+		upload = state.Multipart{
+			Target:   c.Name(),
+			Bucket:   c.bucket,
+			Object:   objName,
+			UploadID: id,
+			File:     file,
+			Size:     info.Size(),
+			Mtime:    info.ModTime(),
+			PartSize: partSize(info.Size()),
+			Started:  time.Now(),
+		}
+
+		state.RecordMultipart(upload)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return mc.UploadInfo{}, fmt.Errorf("unable to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	// only customer keys are sent with every part
+	var partOpts mc.PutObjectPartOptions
+	if c.sse != nil && c.sse.Type() == encrypt.SSEC {
+		partOpts.SSE = c.sse
+	}
+
+	count := int((upload.Size + upload.PartSize - 1) / upload.PartSize)
+
+	parts, err := putParts(ctx, count, partWorkers, func(ctx context.Context, n int) (mc.ObjectPart, error) {
+		offset := int64(n-1) * upload.PartSize
+		length := min(upload.PartSize, upload.Size-offset)
+
+		if part := uploaded[n]; part.Size == length {
+			return part, nil
+		}
+
+		part, err := core.PutObjectPart(ctx, c.bucket, objName, upload.UploadID, n, io.NewSectionReader(f, offset, length), length, partOpts)
+		if err != nil {
+			return part, fmt.Errorf("put of part %d failed: %w", n, err)
+		}
+
+		return part, nil
+	})
+	if err != nil {
+		c.forgetIfAborted(upload, err)
+		return mc.UploadInfo{}, err
+	}
+
+	result, err := core.CompleteMultipartUpload(ctx, c.bucket, objName, upload.UploadID, parts, opts)
+	if err != nil {
+		c.forgetIfAborted(upload, err)
+		return result, fmt.Errorf("put failed: %w", err)
+	}
+
+	state.ForgetMultipart(upload.Target, upload.Bucket, upload.Object)
+
+	result.Size = upload.Size
+
+	return result, nil
+}
+
+// putParts puts parts 1 to count with put, up to workers at once, and
+// returns them in order. The first failure stops the parts not yet started.
+func putParts(ctx context.Context, count, workers int, put func(ctx context.Context, n int) (mc.ObjectPart, error)) ([]mc.CompletePart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		err   error
+		parts = make([]mc.CompletePart, count)
+		next  = make(chan int)
+	)
+
+	for range min(workers, count) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for n := range next {
+				part, perr := put(ctx, n)
+				if perr != nil {
+					once.Do(func() {
+						err = perr
+						cancel()
+					})
+
+					continue
+				}
+
+				parts[n-1] = mc.CompletePart{PartNumber: n, ETag: part.ETag}
+			}
+		}()
+	}
+
+feed:
+	for n := 1; n <= count; n++ {
+		select {
+		case next <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(next)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// canceled by the caller before every part was sent
+	for _, part := range parts {
+		if part.PartNumber == 0 {
+			return nil, fmt.Errorf("upload canceled: %w", ctx.Err())
+		}
+	}
+
+	return parts, nil
+}
+
+// resumable returns the upload recorded for objName and its uploaded parts by
+// number, when it was started for file as it is now. Uploads started for
+// other content are aborted.
+func (c *minioConfig) resumable(ctx context.Context, core mc.Core, objName, file string, info os.FileInfo) (state.Multipart, map[int]mc.ObjectPart) {
+	upload, ok := state.MultipartUpload(c.Name(), c.bucket, objName)
+	if !ok {
+		return state.Multipart{}, nil
+	}
+
+	if upload.File != file || upload.Size != info.Size() || !upload.Mtime.Equal(info.ModTime()) {
+		if err := c.abortUpload(ctx, core, upload, "file changed"); err != nil {
+			klog.ErrorS(err, "unable to abort upload", "object", objName)
+		}
+
+		return state.Multipart{}, nil
+	}
+
+	parts, err := listParts(ctx, core, upload)
+	if err != nil {
+		klog.V(2).InfoS("unable to resume upload, starting again", "object", objName, "err", err)
+
+		if err := c.abortUpload(ctx, core, upload, "parts unavailable"); err != nil {
+			klog.ErrorS(err, "unable to abort upload", "object", objName)
+		}
+
+		return state.Multipart{}, nil
+	}
+
+	klog.InfoS("resuming upload", "file", file, "object", objName, "parts", len(parts), "started", upload.Started)
+	metrics.MultipartResumed.Inc()
+
+	return upload, parts
+}
+
+// listParts returns the parts uploaded for upload by number.
+func listParts(ctx context.Context, core mc.Core, upload state.Multipart) (map[int]mc.ObjectPart, error) {
+	parts := make(map[int]mc.ObjectPart)
+
+	for marker := 0; ; {
+		result, err := core.ListObjectParts(ctx, upload.Bucket, upload.Object, upload.UploadID, marker, maxParts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, part := range result.ObjectParts {
+			parts[part.PartNumber] = part
+		}
+
+		if !result.IsTruncated || result.NextPartNumberMarker <= marker {
+			return parts, nil
+		}
+
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// forgetIfAborted forgets upload when err shows the bucket no longer holds
+// it, so it is started again.
+func (c *minioConfig) forgetIfAborted(upload state.Multipart, err error) {
+	if mc.ToErrorResponse(err).Code == "NoSuchUpload" {
+		forgetUpload(upload)
+	}
+}
+
+// forgetUpload removes upload from the state, unless another upload to the
+// same object was recorded since.
+func forgetUpload(upload state.Multipart) {
+	if m, ok := state.MultipartUpload(upload.Target, upload.Bucket, upload.Object); ok && m.UploadID == upload.UploadID {
+		state.ForgetMultipart(upload.Target, upload.Bucket, upload.Object)
+	}
+}
+
+// abortUpload aborts upload, releasing its parts, and forgets it.
+func (c *minioConfig) abortUpload(ctx context.Context, core mc.Core, upload state.Multipart, reason string) error {
+	err := core.AbortMultipartUpload(ctx, upload.Bucket, upload.Object, upload.UploadID)
+	if err != nil && mc.ToErrorResponse(err).Code != "NoSuchUpload" {
+		return fmt.Errorf("unable to abort upload of %s: %w", upload.Object, err)
+	}
+
+	forgetUpload(upload)
+
+	if err == nil {
+		klog.InfoS("aborted incomplete upload", "object", upload.Object, "bucket", upload.Bucket, "reason", reason)
+		metrics.MultipartAborted.Inc()
+	}
+
+	return nil
+}
+
+// AbortStaleUploads aborts recorded multipart uploads whose file changed or
+// was removed since they started, and every incomplete upload under prefixes
+// started more than MultipartMaxAge ago, whose parts the bucket would keep
+// otherwise. Other recorded uploads are resumed when their file is uploaded.
+func (c *minioConfig) AbortStaleUploads(ctx context.Context, prefixes []string) error {
+	core := mc.Core{Client: c.client}
+	cutoff := time.Now().Add(-c.opts.MultipartMaxAge)
+
+	var errs []error
+
+	for _, upload := range state.MultipartUploads(c.Name(), c.bucket) {
+		var reason string
+
+		info, err := os.Stat(upload.File)
+
+		switch {
+		case err != nil || info.Size() != upload.Size || !info.ModTime().Equal(upload.Mtime):
+			reason = "file changed"
+		case c.opts.MultipartMaxAge > 0 && upload.Started.Before(cutoff):
+			reason = "stale"
+		default:
+			continue
+		}
+
+		errs = append(errs, c.abortUpload(ctx, core, upload, reason))
+	}
+
+	if c.opts.MultipartMaxAge <= 0 {
+		return errors.Join(errs...)
+	}
+
+	for _, prefix := range prefixes {
+		// the bucket root holds uploads of other writers
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" {
+			continue
+		}
+
+		prefix += "/"
+
+		for u := range c.client.ListIncompleteUploads(ctx, c.bucket, prefix, true) {
+			if u.Err != nil {
+				errs = append(errs, fmt.Errorf("unable to list incomplete uploads under %s: %w", prefix, u.Err))
+				break
+			}
+
+			if u.Initiated.Before(cutoff) {
+				upload := state.Multipart{Target: c.Name(), Bucket: c.bucket, Object: u.Key, UploadID: u.UploadID}
+				errs = append(errs, c.abortUpload(ctx, core, upload, "stale"))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mc "github.com/minio/minio-go/v7"
+)
+
+func TestPutParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		fail    int // Part failing to upload, 0 for none
+		wantErr bool
+	}{
+		{"one part", 1, 0, false},
+		{"many parts", 20, 0, false},
+		{"failed part", 20, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu           sync.Mutex
+				active, peak int
+				after        atomic.Int32 // Parts started well after the failed part
+			)
+
+			parts, err := putParts(context.Background(), tt.count, partWorkers, func(ctx context.Context, n int) (mc.ObjectPart, error) {
+				mu.Lock()
+				active++
+				peak = max(peak, active)
+				mu.Unlock()
+
+				defer func() {
+					mu.Lock()
+					active--
+					mu.Unlock()
+				}()
+
+				if n == tt.fail {
+					return mc.ObjectPart{}, errors.New("unavailable")
+				}
+
+				if tt.fail > 0 && n > tt.fail+partWorkers && ctx.Err() == nil {
+					after.Add(1)
+				}
+
+				time.Sleep(time.Millisecond)
+
+				return mc.ObjectPart{PartNumber: n, ETag: strconv.Itoa(n)}, nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("putParts returned %v", err)
+			}
+
+			if peak > partWorkers || (tt.count > 1 && peak < 2) {
+				t.Errorf("%d parts were put at once, want between 2 and %d", peak, partWorkers)
+			}
+
+			if tt.wantErr {
+				if after.Load() > 0 {
+					t.Errorf("%d parts were started after part %d failed", after.Load(), tt.fail)
+				}
+
+				return
+			}
+
+			for i, part := range parts {
+				if part.PartNumber != i+1 || part.ETag != strconv.Itoa(i+1) {
+					t.Errorf("part %d is %+v", i+1, part)
+				}
+			}
+		})
+	}
+}
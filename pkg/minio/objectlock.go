@@ -0,0 +1,88 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mc "github.com/minio/minio-go/v7"
+)
+
+// objectLock is the retention applied to every upload.
+type objectLock struct {
+	mode     mc.RetentionMode
+	duration time.Duration
+}
+
+// newObjectLock returns the retention configured by opts, or nil when uploads
+// are not locked.
+func newObjectLock(opts ObjectLockOptions) (*objectLock, error) {
+	mode := strings.ToUpper(opts.Mode)
+	if mode == "" {
+		return nil, nil
+	}
+
+	l := &objectLock{
+		mode:     mc.RetentionMode(mode),
+		duration: opts.Duration,
+	}
+
+	if !l.mode.IsValid() {
+		return nil, fmt.Errorf("invalid minio.object-lock.mode %s, must be governance or compliance", mode)
+	}
+
+	if l.duration <= 0 {
+		return nil, errors.New("minio.object-lock.mode requires a positive minio.object-lock.duration")
+	}
+
+	return l, nil
+}
+
+// apply sets the retention for an upload starting now.
+func (l *objectLock) apply(opts *mc.PutObjectOptions) {
+	if l == nil {
+		return
+	}
+
+	opts.Mode = l.mode
+	opts.RetainUntilDate = time.Now().Add(l.duration).UTC()
+	opts.SendContentMd5 = true
+}
+
+// checkObjectLock fails when retention is configured for a bucket without
+// object locking, which the server would reject on every upload.
+func (c *minioConfig) checkObjectLock(ctx context.Context) error {
+	if c.lock == nil {
+		return nil
+	}
+
+	enabled, _, _, _, err := c.client.GetObjectLockConfig(ctx, c.bucket)
+	if err != nil {
+		return fmt.Errorf("unable to check object lock on %s: %w", c.bucket, err)
+	}
+
+	if enabled != "Enabled" {
+		return fmt.Errorf("minio.object-lock.mode requires object locking on bucket %s", c.bucket)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	mc "github.com/minio/minio-go/v7"
+)
+
+// Upload writes size bytes from r to the object at key. A size of -1 streams
+// r until EOF. The content is encrypted like files when an encryptor is
+// configured, and retried on failure only when r can be read again from its
+// start.
+func (c *minioConfig) Upload(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	opts := mc.PutObjectOptions{
+		ContentType:          contentType,
+		StorageClass:         c.opts.StorageClass,
+		ServerSideEncryption: c.sse,
+	}
+
+	if c.encryptor != nil && size >= 0 {
+		opts.UserMetadata = map[string]string{MetadataSize: strconv.FormatInt(size, 10)}
+	}
+
+	c.lock.apply(&opts)
+
+	if _, err := c.putObject(ctx, key, streamOf(key, r, size), opts); err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return err
+		}
+
+		return fmt.Errorf("unable to put %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Delete removes the object at key.
+func (c *minioConfig) Delete(ctx context.Context, key string) error {
+	if err := c.client.RemoveObject(ctx, c.bucket, key, mc.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("unable to remove %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Copy copies the object at src to dst within the bucket, on the server.
+// Objects over the 5 GiB a single copy allows are copied in parts.
+func (c *minioConfig) Copy(ctx context.Context, src, dst string) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+
+	_, err := c.client.ComposeObject(ctx,
+		mc.CopyDestOptions{Bucket: c.bucket, Object: dst, Encryption: c.sse},
+		mc.CopySrcOptions{Bucket: c.bucket, Object: src, Encryption: c.readSSE()},
+	)
+	c.breaker.record(err)
+
+	if err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %w", src, dst, err)
+	}
+
+	return nil
+}
+
+// Stat returns the metadata of the object at key.
+func (c *minioConfig) Stat(ctx context.Context, key string) (mc.ObjectInfo, error) {
+	info, err := c.client.StatObject(ctx, c.bucket, key, mc.StatObjectOptions{ServerSideEncryption: c.readSSE()})
+	if err != nil {
+		return info, fmt.Errorf("unable to stat %s: %w", key, err)
+	}
+
+	return info, nil
+}
+
+// List returns every object under prefix in key order, without metadata.
+func (c *minioConfig) List(ctx context.Context, prefix string) ([]mc.ObjectInfo, error) {
+	return list(ctx, c, prefix)
+}
+
+// list collects the objects walked by c under prefix.
+func list(ctx context.Context, c MinioClient, prefix string) ([]mc.ObjectInfo, error) {
+	var objects []mc.ObjectInfo
+
+	err := c.Walk(ctx, strings.TrimPrefix(prefix, "/"), false, func(obj mc.ObjectInfo) error {
+		objects = append(objects, obj)
+		return nil
+	})
+
+	return objects, err
+}
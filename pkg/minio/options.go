@@ -0,0 +1,148 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+)
+
+// Options configures a target. Settings left at their zero value keep the
+// minio-go or server defaults, or disable the feature.
+type Options struct {
+	Endpoint string // Host and optional port of the S3 endpoint
+	Bucket   string
+	Region   string
+	Secure   bool // Use TLS
+
+	CreateBucket bool // Create the bucket if it does not exist
+	CheckBucket  bool // Fail when the bucket does not exist, if not creating it
+	Versioning   bool // Enable bucket versioning
+
+	Credentials CredentialOptions
+	TLS         TLSOptions
+	Transport   TransportOptions
+
+	StorageClass    string // Default storage class of uploads
+	RetentionDays   int    // Expire objects under each uploaded prefix after this many days
+	ManageLifecycle bool   // Allow RetentionDays to be applied as a bucket lifecycle
+	ObjectLock      ObjectLockOptions
+	Replication     ReplicationOptions
+	SSE             SSEOptions
+	Encryptor       crypt.Encryptor // Client-side encryption applied before upload
+	HashKey         []byte          // Keys the hash naming chunks, required with Encryptor to chunk files
+	Provenance      bool            // Record source path, mtime, mode, ownership and hash as metadata
+	Attributes      bool            // Record mtime, mode and ownership of files and their directory as metadata
+	ChunkPrefix     string          // Prefix holding the chunks of chunked files (Defaults to DefaultChunkPrefix)
+	MultipartMaxAge time.Duration   // Abort incomplete multipart uploads started this long ago at startup (Defaults to 0, never)
+
+	MaxRetries     int // Retries of an upload failing with a retryable error
+	MaxConcurrency int // Uploads run concurrently, reduced automatically when throttled
+	ListPageSize   int // Objects requested per listing page
+	ClusterRate    ClusterRateOptions
+	CircuitBreaker CircuitBreakerOptions
+}
+
+// CredentialOptions selects how requests are signed.
+type CredentialOptions struct {
+	Type  string   // static, assume-role, iam, web-identity or chain (Defaults to static)
+	Chain []string // Providers tried in order with chain: env, file, iam, static (Defaults to DefaultCredentialChain)
+
+	AccessKeyID         string
+	AccessKeySecret     string
+	AccessKeyIDFile     string // Read AccessKeyID from a file, reloaded when it changes
+	AccessKeySecretFile string // Read AccessKeySecret from a file, reloaded when it changes
+
+	IAMEndpoint string // Custom metadata endpoint for iam
+
+	AssumeRole  AssumeRoleOptions
+	WebIdentity WebIdentityOptions
+}
+
+// AssumeRoleOptions configure assuming a role with the static keys.
+type AssumeRoleOptions struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	STSEndpoint string // Defaults to Endpoint
+	Duration    int    // Session duration in seconds
+}
+
+// WebIdentityOptions configure exchanging a web identity token for credentials.
+type WebIdentityOptions struct {
+	TokenFile   string // Defaults to AWS_WEB_IDENTITY_TOKEN_FILE
+	RoleARN     string // Defaults to AWS_ROLE_ARN
+	STSEndpoint string
+}
+
+// TLSOptions configure verification and client certificates.
+type TLSOptions struct {
+	CACert             string // PEM bundle trusted in addition to the system pool
+	ClientCert         string // PEM client certificate for mTLS
+	ClientKey          string // PEM client key for mTLS
+	InsecureSkipVerify bool
+}
+
+// TransportOptions tune the HTTP transport.
+type TransportOptions struct {
+	Proxy                 string // Proxy URL, or none to disable (Defaults to HTTPS_PROXY/HTTP_PROXY)
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	ResponseHeaderTimeout time.Duration
+	TLSHandshakeTimeout   time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+}
+
+// ObjectLockOptions configure retention of uploads.
+type ObjectLockOptions struct {
+	Enabled  bool          // Create the bucket with object locking
+	Mode     string        // governance or compliance, applied to every upload
+	Duration time.Duration // How long uploads are retained when Mode is set
+}
+
+// ReplicationOptions configure bucket replication of uploaded prefixes.
+type ReplicationOptions struct {
+	ARN             string // Remote target to replicate to, requires Versioning
+	StorageClass    string
+	DeleteMarkers   bool
+	ExistingObjects bool
+}
+
+// SSEOptions configure server-side encryption.
+type SSEOptions struct {
+	Type      string // sse-s3, sse-kms or sse-c
+	KMSKeyID  string // Key used with sse-kms
+	KeyFile   string // File with the 256 bit customer key used with sse-c
+	Preflight bool   // Check the sse-kms key can be used when the target is created
+}
+
+// ClusterRateOptions configure the upload rate shared by every sidecar using the bucket.
+type ClusterRateOptions struct {
+	Key   string  // Object used to coordinate the rate
+	Limit float64 // Uploads per second (0 disables)
+	Burst int
+}
+
+// CircuitBreakerOptions configure pausing uploads to an unreachable target.
+type CircuitBreakerOptions struct {
+	Failures      int // Consecutive failures to reach the target before pausing (0 disables)
+	ProbeInterval time.Duration
+}
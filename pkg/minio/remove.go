@@ -0,0 +1,68 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	mc "github.com/minio/minio-go/v7"
+	"k8s.io/klog/v2"
+)
+
+// RemoveObjects deletes every key received from keys using bulk delete
+// requests. It returns the number of objects removed and an error for each
+// object that was not.
+func (c *minioConfig) RemoveObjects(ctx context.Context, keys <-chan string) (int, error) {
+	objects := make(chan mc.ObjectInfo)
+
+	go func() {
+		defer close(objects)
+
+		for k := range keys {
+			select {
+			case objects <- mc.ObjectInfo{Key: k}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		removed int
+		errs    []error
+	)
+
+	for r := range c.client.RemoveObjectsWithResult(ctx, c.bucket, objects, mc.RemoveObjectsOptions{}) {
+		if r.Err != nil {
+			klog.V(2).ErrorS(r.Err, "unable to remove object", "object", r.ObjectName, "bucket", c.bucket)
+			errs = append(errs, fmt.Errorf("unable to remove %s: %w", r.ObjectName, r.Err))
+
+			continue
+		}
+
+		removed++
+	}
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("remove canceled: %w", err))
+	}
+
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,463 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	mc "github.com/minio/minio-go/v7"
+	"k8s.io/klog/v2"
+)
+
+// teeBufferSize is the size of the reads of a body teed to several targets.
+const teeBufferSize = 1 << 20
+
+// TargetStatus counts uploads to one target of a replicated client.
+type TargetStatus struct {
+	Uploads     int64      `json:"uploads"`
+	Failures    int64      `json:"failures"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+}
+
+// replicated fans out every upload to the primary and all targets. Reads are
+// served by the primary.
+type replicated struct {
+	clients []MinioClient
+
+	mu     sync.Mutex
+	status map[string]*TargetStatus
+}
+
+// NewReplicated returns a client uploading to primary and every target, or
+// just primary when there are no targets.
+func NewReplicated(primary MinioClient, targets ...MinioClient) MinioClient {
+	if len(targets) == 0 {
+		return primary
+	}
+
+	r := &replicated{
+		clients: append([]MinioClient{primary}, targets...),
+		status:  make(map[string]*TargetStatus, len(targets)+1),
+	}
+
+	for _, c := range r.clients {
+		r.status[c.Name()] = &TargetStatus{}
+	}
+
+	klog.InfoS("replicating uploads", "targets", len(r.clients))
+
+	return r
+}
+
+// ReplicationStatus returns upload counts per target, or nil if c does not
+// replicate uploads.
+func ReplicationStatus(c MinioClient) map[string]TargetStatus {
+	r, ok := c.(*replicated)
+	if !ok {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := make(map[string]TargetStatus, len(r.status))
+	for name, s := range r.status {
+		status[name] = *s
+	}
+
+	return status
+}
+
+func (r *replicated) UploadFile(file string, ctx context.Context) error {
+	filename := filepath.Base(file)
+	return r.UploadFileWithDestination(file, config.Destination{Name: filename}, ctx)
+}
+
+// OpenTargetsError reports the targets an upload was not made to because
+// their circuit is open. Every other target holds the upload.
+type OpenTargetsError struct {
+	Targets []string
+}
+
+func (e *OpenTargetsError) Error() string {
+	return fmt.Sprintf("%v on %s", ErrCircuitOpen, strings.Join(e.Targets, ", "))
+}
+
+func (e *OpenTargetsError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+type targetsKey struct{}
+
+// PendingTargets returns ctx limiting replicated uploads to the targets err
+// reports as unreachable, so an upload deferred until they recover is not
+// repeated on the targets already holding it. ctx is returned as is when err
+// names no targets.
+func PendingTargets(ctx context.Context, err error) context.Context {
+	var open *OpenTargetsError
+	if !errors.As(err, &open) {
+		return ctx
+	}
+
+	return context.WithValue(ctx, targetsKey{}, open.Targets)
+}
+
+// clientsFor returns the targets uploads with ctx go to.
+func (r *replicated) clientsFor(ctx context.Context) []MinioClient {
+	names, ok := ctx.Value(targetsKey{}).([]string)
+	if !ok {
+		return r.clients
+	}
+
+	clients := make([]MinioClient, 0, len(names))
+
+	for _, c := range r.clients {
+		if slices.Contains(names, c.Name()) {
+			clients = append(clients, c)
+		}
+	}
+
+	return clients
+}
+
+// UploadFileWithDestination uploads file to every target concurrently. It
+// fails if any target fails, so the file is kept for a later attempt. When
+// the only failures are open circuits, it returns an OpenTargetsError naming
+// those targets, so the upload is deferred for them alone.
+func (r *replicated) UploadFileWithDestination(file string, dest config.Destination, ctx context.Context) error {
+	var (
+		wg      sync.WaitGroup
+		clients = r.clientsFor(ctx)
+		errs    = make([]error, len(clients))
+	)
+
+	for i, c := range clients {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = c.UploadFileWithDestination(file, dest, ctx)
+			r.record(c.Name(), errs[i])
+		}()
+	}
+
+	wg.Wait()
+
+	var (
+		open   []string
+		failed []error
+	)
+
+	for i, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrCircuitOpen):
+			open = append(open, clients[i].Name())
+		default:
+			failed = append(failed, err)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		// the upload is retried on every target, open circuits do not defer it
+		if len(open) > 0 {
+			failed = append(failed, fmt.Errorf("unreachable: %s", strings.Join(open, ", ")))
+		}
+
+		return fmt.Errorf("unable to replicate %s: %w", file, errors.Join(failed...))
+	case len(open) > 0:
+		return &OpenTargetsError{Targets: open}
+	}
+
+	return nil
+}
+
+func (r *replicated) record(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.status[name]
+	s.Uploads++
+
+	if err != nil {
+		s.Failures++
+		s.LastError = err.Error()
+		now := time.Now()
+		s.LastFailure = &now
+
+		metrics.TargetUploadErrors.WithLabelValues(name).Inc()
+	}
+}
+
+func (r *replicated) Walk(ctx context.Context, prefix string, withMetadata bool, fn func(mc.ObjectInfo) error) error {
+	return r.clients[0].Walk(ctx, prefix, withMetadata, fn)
+}
+
+func (r *replicated) Download(ctx context.Context, key string) (io.ReadCloser, mc.ObjectInfo, error) {
+	return r.clients[0].Download(ctx, key)
+}
+
+func (r *replicated) Stat(ctx context.Context, key string) (mc.ObjectInfo, error) {
+	return r.clients[0].Stat(ctx, key)
+}
+
+func (r *replicated) List(ctx context.Context, prefix string) ([]mc.ObjectInfo, error) {
+	return r.clients[0].List(ctx, prefix)
+}
+
+func (r *replicated) Select(ctx context.Context, key string, opts mc.SelectObjectOptions) (io.ReadCloser, error) {
+	return r.clients[0].Select(ctx, key, opts)
+}
+
+// RemoveObjects removes keys from every target, returning the number removed
+// from the primary.
+func (r *replicated) RemoveObjects(ctx context.Context, keys <-chan string) (int, error) {
+	var (
+		wg      sync.WaitGroup
+		chans   = make([]chan string, len(r.clients))
+		removed = make([]int, len(r.clients))
+		errs    = make([]error, len(r.clients))
+	)
+
+	for i, c := range r.clients {
+		chans[i] = make(chan string)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			removed[i], errs[i] = c.RemoveObjects(ctx, chans[i])
+		}()
+	}
+
+feed:
+	for k := range keys {
+		for _, ch := range chans {
+			select {
+			case ch <- k:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+
+	for _, ch := range chans {
+		close(ch)
+	}
+
+	wg.Wait()
+
+	return removed[0], errors.Join(errs...)
+}
+
+// Upload writes the content of body to key on every target. A body of known
+// size that can be read at any offset is read by each target on its own, so
+// each can retry. Any other body is read once and teed to every target as it
+// is read, so a target failing does not stop the others.
+func (r *replicated) Upload(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	readers := make([]io.Reader, len(r.clients))
+
+	if ra, ok := body.(io.ReaderAt); ok && size >= 0 {
+		for i := range readers {
+			readers[i] = io.NewSectionReader(ra, 0, size)
+		}
+	} else {
+		writers := make([]*teeTarget, len(r.clients))
+
+		for i := range readers {
+			pr, pw := io.Pipe()
+			readers[i], writers[i] = pr, &teeTarget{w: pw}
+		}
+
+		go tee(body, writers)
+	}
+
+	errs := make([]error, len(r.clients))
+
+	var wg sync.WaitGroup
+
+	for i, c := range r.clients {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs[i] = c.Upload(ctx, key, readers[i], size, contentType)
+			r.record(c.Name(), errs[i])
+
+			// unblock the tee once this target stops reading
+			if pr, ok := readers[i].(*io.PipeReader); ok {
+				pr.CloseWithError(errTargetDone)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+var errTargetDone = errors.New("target finished reading")
+
+// teeTarget is the pipe to one target of a teed upload.
+type teeTarget struct {
+	w      *io.PipeWriter
+	failed bool
+}
+
+// tee copies body to every target still reading it, then closes the pipes
+// with the error reading body, if any.
+func tee(body io.Reader, targets []*teeTarget) {
+	buf := make([]byte, teeBufferSize)
+
+	var err error
+
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			live := 0
+
+			for _, t := range targets {
+				if t.failed {
+					continue
+				}
+
+				if _, werr := t.w.Write(buf[:n]); werr != nil {
+					t.failed = true
+					continue
+				}
+
+				live++
+			}
+
+			if live == 0 {
+				err = errTargetDone
+				break
+			}
+		}
+
+		if rerr != nil {
+			if !errors.Is(rerr, io.EOF) {
+				err = fmt.Errorf("unable to read upload: %w", rerr)
+			}
+
+			break
+		}
+	}
+
+	for _, t := range targets {
+		t.w.CloseWithError(err)
+	}
+}
+
+// Delete removes key from every target.
+func (r *replicated) Delete(ctx context.Context, key string) error {
+	errs := make([]error, 0, len(r.clients))
+
+	for _, c := range r.clients {
+		errs = append(errs, c.Delete(ctx, key))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Copy copies src to dst on every target.
+func (r *replicated) Copy(ctx context.Context, src, dst string) error {
+	errs := make([]error, 0, len(r.clients))
+
+	for _, c := range r.clients {
+		errs = append(errs, c.Copy(ctx, src, dst))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Unchanged reports whether file is unchanged on every target uploads with
+// ctx go to.
+func (r *replicated) Unchanged(ctx context.Context, file string, dest config.Destination) (bool, error) {
+	for _, c := range r.clientsFor(ctx) {
+		unchanged, err := c.Unchanged(ctx, file, dest)
+		if err != nil || !unchanged {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Verify confirms file was uploaded to every target.
+func (r *replicated) Verify(ctx context.Context, file string, dest config.Destination, size int64) error {
+	errs := make([]error, 0, len(r.clients))
+
+	for _, c := range r.clients {
+		errs = append(errs, c.Verify(ctx, file, dest, size))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyRetention sets the retention rules on every target.
+func (r *replicated) ApplyRetention(ctx context.Context, prefixes []string) error {
+	errs := make([]error, 0, len(r.clients))
+
+	for _, c := range r.clients {
+		errs = append(errs, c.ApplyRetention(ctx, prefixes))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyReplication sets the replication rules on every target that has them configured.
+func (r *replicated) ApplyReplication(ctx context.Context, prefixes []string) error {
+	errs := make([]error, 0, len(r.clients))
+
+	for _, c := range r.clients {
+		errs = append(errs, c.ApplyReplication(ctx, prefixes))
+	}
+
+	return errors.Join(errs...)
+}
+
+// AbortStaleUploads aborts stale multipart uploads on every target.
+func (r *replicated) AbortStaleUploads(ctx context.Context, prefixes []string) error {
+	errs := make([]error, 0, len(r.clients))
+
+	for _, c := range r.clients {
+		errs = append(errs, c.AbortStaleUploads(ctx, prefixes))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *replicated) Name() string {
+	return r.clients[0].Name()
+}
@@ -0,0 +1,141 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package minio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+)
+
+func TestReplicatedUpload(t *testing.T) {
+	content := strings.Repeat("dump line\n", teeBufferSize/4)
+
+	tests := []struct {
+		name   string
+		body   func() io.Reader
+		size   int64
+		failed int // target failing its uploads, -1 for none
+	}{
+		{"read at", func() io.Reader { return strings.NewReader(content) }, int64(len(content)), -1},
+		{"stream", func() io.Reader { return io.MultiReader(strings.NewReader(content)) }, -1, -1},
+		{"stream with a failed target", func() io.Reader { return io.MultiReader(strings.NewReader(content)) }, -1, 1},
+		{"stream with a failed primary", func() io.Reader { return io.MultiReader(strings.NewReader(content)) }, -1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := []*Fake{NewFake("primary"), NewFake("a"), NewFake("b")}
+			if tt.failed >= 0 {
+				fakes[tt.failed].FailUploads(errors.New("unavailable"))
+			}
+
+			r := NewReplicated(fakes[0], fakes[1], fakes[2])
+
+			err := r.Upload(context.Background(), "dumps/db.sql", tt.body(), tt.size, "application/sql")
+			if (err != nil) != (tt.failed >= 0) {
+				t.Fatalf("Upload returned %v", err)
+			}
+
+			for i, f := range fakes {
+				data, _, ok := f.Object("dumps/db.sql")
+				if i == tt.failed {
+					if ok {
+						t.Errorf("failed target %s stored the object", f.Name())
+					}
+
+					continue
+				}
+
+				if !ok || !bytes.Equal(data, []byte(content)) {
+					t.Errorf("target %s stored %d bytes, want %d", f.Name(), len(data), len(content))
+				}
+			}
+		})
+	}
+}
+
+func TestReplicatedOpenCircuit(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []error // Upload error of each target
+		wantOpen []string
+		wantErr  bool
+	}{
+		{"all reachable", []error{nil, nil, nil}, nil, false},
+		{"one open", []error{nil, ErrCircuitOpen, nil}, []string{"a"}, true},
+		{"open and failed", []error{nil, ErrCircuitOpen, errors.New("denied")}, nil, true},
+	}
+
+	file := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(file, []byte("dump"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := []*Fake{NewFake("primary"), NewFake("a"), NewFake("b")}
+			for i, err := range tt.failures {
+				fakes[i].FailUploads(err)
+			}
+
+			r := NewReplicated(fakes[0], fakes[1], fakes[2])
+			ctx := context.Background()
+
+			err := r.UploadFileWithDestination(file, config.Destination{}, ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UploadFileWithDestination returned %v", err)
+			}
+
+			if errors.Is(err, ErrCircuitOpen) != (tt.wantOpen != nil) {
+				t.Fatalf("UploadFileWithDestination returned %v, want an open circuit on %q", err, tt.wantOpen)
+			}
+
+			if tt.wantOpen == nil {
+				return
+			}
+
+			var open *OpenTargetsError
+			if !errors.As(err, &open) || !slices.Equal(open.Targets, tt.wantOpen) {
+				t.Fatalf("UploadFileWithDestination returned %v, want an open circuit on %q", err, tt.wantOpen)
+			}
+
+			// the deferred upload only goes to the targets that missed it
+			for _, f := range fakes {
+				f.FailUploads(nil)
+			}
+
+			if err := r.UploadFileWithDestination(file, config.Destination{}, PendingTargets(ctx, err)); err != nil {
+				t.Fatalf("deferred upload returned %v", err)
+			}
+
+			for _, f := range fakes {
+				if got := f.Uploads(); got != 1 {
+					t.Errorf("target %s uploaded %d times, want 1", f.Name(), got)
+				}
+			}
+		})
+	}
+}
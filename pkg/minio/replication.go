@@ -0,0 +1,121 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/replication"
+	"k8s.io/klog/v2"
+)
+
+// ApplyReplication adds a replication rule for each prefix to the remote
+// target set by replication.arn, which must already be registered on the
+// server (mc admin bucket remote add). Rules set by other tools, or by
+// sidecars writing other prefixes, are kept.
+func (c *minioConfig) ApplyReplication(ctx context.Context, prefixes []string) error {
+	arn := c.opts.Replication.ARN
+	if arn == "" {
+		return nil
+	}
+
+	if !c.opts.Versioning {
+		return fmt.Errorf("replication.arn requires minio.versioning on %s", c.Name())
+	}
+
+	// A bucket without replication returns an empty configuration.
+	cfg, err := c.client.GetBucketReplication(ctx, c.bucket)
+	if err != nil {
+		return fmt.Errorf("unable to read replication of %s: %w", c.bucket, err)
+	}
+
+	priorities := make(map[string]int, len(cfg.Rules))
+	next := 0
+
+	for _, r := range cfg.Rules {
+		priorities[r.ID] = r.Priority
+		next = max(next, r.Priority)
+	}
+
+	rules := make([]replication.Rule, 0, len(prefixes))
+	ids := make([]string, 0, len(prefixes))
+
+	for _, prefix := range prefixes {
+		prefix = strings.TrimPrefix(prefix, "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		id := lifecycleRuleID + ruleHash(prefix)
+
+		// MinIO requires a unique priority per rule, so existing rules keep theirs.
+		priority, ok := priorities[id]
+		if !ok {
+			next++
+			priority = next
+		}
+
+		rule := replication.Rule{
+			ID:       id,
+			Status:   replication.Enabled,
+			Priority: priority,
+			Filter:   replication.Filter{Prefix: prefix},
+			Destination: replication.Destination{
+				Bucket:       arn,
+				StorageClass: c.opts.Replication.StorageClass,
+			},
+			DeleteMarkerReplication:   replication.DeleteMarkerReplication{Status: replicationStatus(c.opts.Replication.DeleteMarkers)},
+			DeleteReplication:         replication.DeleteReplication{Status: replication.Disabled},
+			ExistingObjectReplication: replication.ExistingObjectReplication{Status: replicationStatus(c.opts.Replication.ExistingObjects)},
+			SourceSelectionCriteria: replication.SourceSelectionCriteria{
+				ReplicaModifications: replication.ReplicaModifications{Status: replication.Enabled},
+			},
+		}
+
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid replication rule for %s: %w", prefix, err)
+		}
+
+		rules = append(rules, rule)
+		ids = append(ids, rule.ID)
+	}
+
+	cfg.Rules = slices.DeleteFunc(cfg.Rules, func(r replication.Rule) bool { return slices.Contains(ids, r.ID) })
+	cfg.Rules = append(cfg.Rules, rules...)
+
+	klog.V(4).InfoS("bucket replication", "replication.Config", cfg)
+
+	if err := c.client.SetBucketReplication(ctx, c.bucket, cfg); err != nil {
+		return fmt.Errorf("unable to set replication: %w", err)
+	}
+
+	klog.InfoS("set replication", "target", c.Name(), "arn", arn, "prefixes", prefixes)
+
+	return nil
+}
+
+func replicationStatus(enabled bool) replication.Status {
+	if enabled {
+		return replication.Enabled
+	}
+
+	return replication.Disabled
+}
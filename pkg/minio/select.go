@@ -0,0 +1,54 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	mc "github.com/minio/minio-go/v7"
+)
+
+// Select runs an S3 Select query against key, where the server supports it.
+// Objects compressed by the sidecar are read as GZIP. Objects encrypted
+// client-side cannot be queried.
+func (c *minioConfig) Select(ctx context.Context, key string, opts mc.SelectObjectOptions) (io.ReadCloser, error) {
+	info, err := c.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.UserMetadata[crypt.MetadataKey] != "" {
+		return nil, fmt.Errorf("%s is encrypted client-side and cannot be queried", key)
+	}
+
+	if info.UserMetadata[MetadataCompression] == compressionGzip {
+		opts.InputSerialization.CompressionType = mc.SelectCompressionGZIP
+	}
+
+	opts.ServerSideEncryption = c.readSSE()
+
+	res, err := c.client.SelectObjectContent(ctx, c.bucket, key, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query %s: %w", key, err)
+	}
+
+	return res, nil
+}
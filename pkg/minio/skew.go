@@ -0,0 +1,350 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"k8s.io/klog/v2"
+)
+
+const (
+	signV4Algorithm   = "AWS4-HMAC-SHA256"
+	iso8601DateFormat = "20060102T150405Z"
+	yyyymmdd          = "20060102"
+	skewErrorCode     = "RequestTimeTooSkewed"
+	unsignedPayload   = "UNSIGNED-PAYLOAD"
+
+	// bodies signed chunk by chunk, which are sent unsigned once skewed, and
+	// unsigned chunks followed by a trailer, whose headers alone are signed
+	streamingPayload        = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingTrailerPayload = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// Credential=<access key>/<date>/<region>/<service>/aws4_request
+	credentialParts = 5
+)
+
+// skewTransport tracks the offset between the local clock and the server Date
+// header. Once the server has rejected a request as skewed, header-signed
+// requests are re-signed using the server's notion of time.
+//
+// Without TLS, minio-go signs the body of uploads, including every part of a
+// multipart upload, chunk by chunk from the original time. Those chunk
+// signatures cannot be recomputed, so such bodies are decoded and sent as an
+// UNSIGNED-PAYLOAD instead, as they are over TLS. Only bodies with a signed
+// trailer still fail until the local clock is fixed.
+type skewTransport struct {
+	base      http.RoundTripper
+	creds     *credentials.Credentials
+	offset    atomic.Int64
+	adjust    atomic.Bool
+	streaming sync.Once
+}
+
+func newSkewTransport(base http.RoundTripper, creds *credentials.Credentials) *skewTransport {
+	return &skewTransport{
+		base:  base,
+		creds: creds,
+	}
+}
+
+func (t *skewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.adjust.Load() {
+		req = t.resign(req)
+	}
+
+	sent := time.Now()
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return res, err //nolint:wrapcheck
+	}
+
+	t.observe(res, sent)
+
+	return res, nil
+}
+
+func (t *skewTransport) observe(res *http.Response, sent time.Time) {
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	// Date only has second resolution so the round trip time is ignored
+	offset := date.Sub(sent)
+
+	t.offset.Store(int64(offset))
+	metrics.ClockOffset.Set(offset.Seconds())
+}
+
+// handle enables time adjustment for subsequent requests if err was caused
+// by clock skew.
+func (t *skewTransport) handle(err error) {
+	var resp mc.ErrorResponse
+	if !errors.As(err, &resp) || resp.Code != skewErrorCode {
+		return
+	}
+
+	metrics.ClockSkewErrors.Inc()
+
+	klog.Warningf("server rejected request due to clock skew, local clock is off by %v, adjusting request time", time.Duration(t.offset.Load()))
+	t.adjust.Store(true)
+}
+
+func (t *skewTransport) now() time.Time {
+	return time.Now().Add(time.Duration(t.offset.Load())).UTC()
+}
+
+// resign recomputes a SigV4 Authorization header with the adjusted time.
+// Chunk-signed bodies are sent unsigned. Presigned, V2 and requests with a
+// signed trailer are returned unchanged since their signatures cannot be
+// recomputed here.
+func (t *skewTransport) resign(req *http.Request) *http.Request {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signV4Algorithm+" ") {
+		return req
+	}
+
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		return req
+	}
+
+	if strings.HasPrefix(payload, "STREAMING-") && payload != streamingPayload && payload != streamingTrailerPayload {
+		t.streaming.Do(func() {
+			klog.Warningf("unable to correct clock skew for chunk-signed uploads, uploads over http will fail until the local clock is fixed or minio.secure is enabled")
+		})
+
+		return req
+	}
+
+	fields := parseAuthorization(strings.TrimPrefix(auth, signV4Algorithm+" "))
+
+	scope := strings.Split(fields["Credential"], "/")
+	if len(scope) != credentialParts {
+		return req
+	}
+
+	accessKey, region, service := scope[0], scope[2], scope[3]
+
+	v, err := t.creds.Get()
+	if err != nil || v.AccessKeyID != accessKey {
+		klog.V(4).ErrorS(err, "unable to resign request")
+		return req
+	}
+
+	now := t.now()
+	r := req.Clone(req.Context())
+	r.Header.Set("X-Amz-Date", now.Format(iso8601DateFormat))
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	sort.Strings(signedHeaders)
+
+	if payload == streamingPayload {
+		if !unsignChunks(r) {
+			return req
+		}
+
+		payload = unsignedPayload
+		signedHeaders = slices.DeleteFunc(signedHeaders, func(h string) bool { return h == "x-amz-decoded-content-length" })
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3utils.EncodePath(r.URL.Path),
+		strings.ReplaceAll(r.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders(r, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payload,
+	}, "\n")
+
+	credScope := strings.Join([]string{now.Format(yyyymmdd), region, service, "aws4_request"}, "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		signV4Algorithm,
+		now.Format(iso8601DateFormat),
+		credScope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := sumHMAC([]byte("AWS4"+v.SecretAccessKey), []byte(now.Format(yyyymmdd)))
+	key = sumHMAC(key, []byte(region))
+	key = sumHMAC(key, []byte(service))
+	key = sumHMAC(key, []byte("aws4_request"))
+
+	r.Header.Set("Authorization", signV4Algorithm+" Credential="+accessKey+"/"+credScope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+
+		", Signature="+hex.EncodeToString(sumHMAC(key, []byte(stringToSign))))
+
+	return r
+}
+
+// unsignChunks replaces the chunk-signed body of req with its payload,
+// reporting whether its decoded length was known to do so.
+func unsignChunks(req *http.Request) bool {
+	size, err := strconv.ParseInt(req.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || req.Body == nil {
+		return false
+	}
+
+	req.Body = &chunkDecoder{r: bufio.NewReader(req.Body), body: req.Body}
+	req.GetBody = nil
+	req.ContentLength = size
+	req.Header.Del("X-Amz-Decoded-Content-Length")
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	return true
+}
+
+// chunkDecoder reads the payload of an aws-chunked body, dropping the chunk
+// headers and signatures.
+type chunkDecoder struct {
+	r    *bufio.Reader
+	body io.Closer
+	left int64 // Bytes left in the current chunk
+	done bool
+}
+
+func (d *chunkDecoder) Read(p []byte) (int, error) {
+	if d.done {
+		return 0, io.EOF
+	}
+
+	if d.left == 0 {
+		line, err := d.r.ReadString('\n')
+		if err != nil {
+			return 0, fmt.Errorf("unable to read chunk header: %w", unexpectedEOF(err))
+		}
+
+		hexSize, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+
+		size, err := strconv.ParseInt(hexSize, 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk header %q", strings.TrimSpace(line))
+		}
+
+		if size == 0 {
+			d.done = true
+			return 0, io.EOF
+		}
+
+		d.left = size
+	}
+
+	n, err := d.r.Read(p[:min(int64(len(p)), d.left)])
+	d.left -= int64(n)
+
+	if err == nil && d.left == 0 {
+		_, err = d.r.Discard(len("\r\n"))
+	}
+
+	if err != nil {
+		return n, fmt.Errorf("unable to read chunk: %w", unexpectedEOF(err))
+	}
+
+	return n, nil
+}
+
+func (d *chunkDecoder) Close() error {
+	return d.body.Close() //nolint:wrapcheck
+}
+
+// unexpectedEOF returns err, as io.ErrUnexpectedEOF if the body ended before
+// its last chunk.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func parseAuthorization(s string) map[string]string {
+	fields := make(map[string]string)
+
+	for _, f := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(f), "="); ok {
+			fields[k] = v
+		}
+	}
+
+	return fields
+}
+
+func canonicalHeaders(req *http.Request, names []string) string {
+	var b strings.Builder
+
+	for _, k := range names {
+		b.WriteString(k)
+		b.WriteByte(':')
+
+		if k == "host" {
+			b.WriteString(hostAddr(req))
+		} else {
+			for i, v := range req.Header.Values(k) {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+
+				b.WriteString(strings.Join(strings.Fields(v), " "))
+			}
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func hostAddr(req *http.Request) string {
+	if host := req.Header.Get("Host"); host != "" && req.Host != host {
+		return host
+	}
+
+	if req.Host != "" {
+		return req.Host
+	}
+
+	return req.URL.Host
+}
+
+func sumHMAC(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,86 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+type sha256Hasher struct {
+	hash.Hash
+}
+
+func (sha256Hasher) Close() {}
+
+func TestResignStreaming(t *testing.T) {
+	payload := strings.Repeat("dump line\n", 20000) // several 64 KiB chunks
+
+	req, err := http.NewRequest(http.MethodPut, "http://minio:9000/bucket/db.sql", io.NopCloser(strings.NewReader(payload)))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	skewed := time.Now().Add(-time.Hour).UTC()
+	req = signer.StreamingSignV4(req, "access", "secret", "", "us-east-1", int64(len(payload)), skewed, sha256Hasher{sha256.New()})
+
+	tr := newSkewTransport(http.DefaultTransport, credentials.NewStaticV4("access", "secret", ""))
+
+	r := tr.resign(req)
+	if r == req {
+		t.Fatal("streaming request was not re-signed")
+	}
+
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != unsignedPayload {
+		t.Errorf("X-Amz-Content-Sha256 = %s, want %s", got, unsignedPayload)
+	}
+
+	if date, err := time.Parse(iso8601DateFormat, r.Header.Get("X-Amz-Date")); err != nil || date.Before(skewed.Add(time.Minute)) {
+		t.Errorf("X-Amz-Date = %s, want the adjusted time", r.Header.Get("X-Amz-Date"))
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.Contains(auth, "x-amz-decoded-content-length") {
+		t.Errorf("Authorization %s signs the removed x-amz-decoded-content-length", auth)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+
+	if string(body) != payload || r.ContentLength != int64(len(payload)) {
+		t.Errorf("body holds %d of %d bytes, Content-Length %d", len(body), len(payload), r.ContentLength)
+	}
+}
+
+func TestChunkDecoderTruncated(t *testing.T) {
+	d := &chunkDecoder{r: bufio.NewReader(strings.NewReader("a;chunk-signature=00\r\nhello")), body: io.NopCloser(nil)}
+
+	if _, err := io.ReadAll(d); err == nil || !strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) {
+		t.Errorf("truncated body read with %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
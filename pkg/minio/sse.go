@@ -0,0 +1,157 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	"github.com/csfreak/minio-backup-sidecar/pkg/crypt"
+	mc "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/sse"
+	"k8s.io/klog/v2"
+)
+
+// kmsProbePrefix holds the objects written to check the KMS key at startup.
+const kmsProbePrefix = InternalPrefix + "kms-probe/"
+
+// kmsProbed holds the targets and keys whose probe passed, so replica targets
+// and profiles sharing a bucket and key probe it once.
+var kmsProbed sync.Map
+
+// newSSE returns the server-side encryption configured by opts, or nil when
+// objects are stored with the bucket default.
+func newSSE(opts SSEOptions, secure bool) (encrypt.ServerSide, error) {
+	switch strings.ToLower(opts.Type) {
+	case "", "none":
+		return nil, nil
+	case "sse-s3":
+		return encrypt.NewSSE(), nil
+	case "sse-kms":
+		if opts.KMSKeyID == "" {
+			return nil, errors.New("minio.sse.type sse-kms requires minio.sse.kms-key-id")
+		}
+
+		s, err := encrypt.NewSSEKMS(opts.KMSKeyID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sse-kms configuration: %w", err)
+		}
+
+		return s, nil
+	case "sse-c":
+		if !secure {
+			return nil, errors.New("minio.sse.type sse-c requires minio.secure")
+		}
+
+		if opts.KeyFile == "" {
+			return nil, errors.New("minio.sse.type sse-c requires minio.sse.key-file")
+		}
+
+		key, err := crypt.ReadKey(opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sse-c key: %w", err)
+		}
+
+		s, err := encrypt.NewSSEC(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sse-c key: %w", err)
+		}
+
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown minio.sse.type %s", opts.Type)
+	}
+}
+
+// probeKMS writes and removes a small object encrypted with the configured
+// KMS key, so a missing key or a key the credentials cannot use fails startup
+// instead of every upload. Only the sidecar probes, commands use Connect,
+// and each bucket and key is probed once.
+func (c *minioConfig) probeKMS(ctx context.Context) error {
+	if c.sse == nil || c.sse.Type() != encrypt.KMS || !c.opts.SSE.Preflight {
+		return nil
+	}
+
+	probed := c.Name() + "/" + c.opts.SSE.KMSKeyID
+	if _, ok := kmsProbed.Load(probed); ok {
+		return nil
+	}
+
+	key := kmsProbePrefix + config.PodName()
+	body := []byte("minio-backup-sidecar kms probe")
+
+	_, err := c.client.PutObject(ctx, c.bucket, key, bytes.NewReader(body), int64(len(body)), mc.PutObjectOptions{
+		ContentType:          "text/plain",
+		ServerSideEncryption: c.sse,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encrypt with kms key %s on %s, check the key exists and the credentials may use it: %w",
+			c.opts.SSE.KMSKeyID, c.Name(), err)
+	}
+
+	// a leftover probe is harmless, and bucket retention may prevent removal
+	if err := c.client.RemoveObject(ctx, c.bucket, key, mc.RemoveObjectOptions{}); err != nil {
+		klog.V(2).ErrorS(err, "unable to remove kms probe", "object", key, "bucket", c.bucket)
+	}
+
+	kmsProbed.Store(probed, struct{}{})
+	klog.V(2).InfoS("kms key usable", "target", c.Name(), "key", c.opts.SSE.KMSKeyID)
+
+	return nil
+}
+
+// readSSE returns the encryption needed to read objects, which is only sent for SSE-C.
+func (c *minioConfig) readSSE() encrypt.ServerSide {
+	if c.sse != nil && c.sse.Type() == encrypt.SSEC {
+		return c.sse
+	}
+
+	return nil
+}
+
+// setBucketEncryption makes SSE-S3 or SSE-KMS the default for a new bucket.
+func (c *minioConfig) setBucketEncryption(ctx context.Context, bucket string) error {
+	if c.sse == nil {
+		return nil
+	}
+
+	var cfg *sse.Configuration
+
+	switch c.sse.Type() {
+	case encrypt.S3:
+		cfg = sse.NewConfigurationSSES3()
+	case encrypt.KMS:
+		cfg = sse.NewConfigurationSSEKMS(c.opts.SSE.KMSKeyID)
+	default:
+		return nil
+	}
+
+	if err := c.client.SetBucketEncryption(ctx, bucket, cfg); err != nil {
+		return fmt.Errorf("unable to set bucket encryption: %w", err)
+	}
+
+	klog.Infof("set default encryption for bucket %s to %s", bucket, c.sse.Type())
+
+	return nil
+}
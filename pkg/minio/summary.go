@@ -0,0 +1,73 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"fmt"
+)
+
+// TargetSummary describes how objects are written to a target.
+type TargetSummary struct {
+	Name          string `json:"name"`
+	RetentionDays int    `json:"retentionDays,omitempty"`
+	StorageClass  string `json:"storageClass,omitempty"`
+	SSE           string `json:"sse,omitempty"`
+	Encrypted     bool   `json:"encrypted,omitempty"`
+	Versioning    bool   `json:"versioning,omitempty"`
+	ObjectLock    string `json:"objectLock,omitempty"`
+	Replication   string `json:"replication,omitempty"`
+}
+
+// Summary describes every target written by c.
+func Summary(c MinioClient) []TargetSummary {
+	if r, ok := c.(*replicated); ok {
+		summary := make([]TargetSummary, 0, len(r.clients))
+		for _, client := range r.clients {
+			summary = append(summary, Summary(client)...)
+		}
+
+		return summary
+	}
+
+	m, ok := c.(*minioConfig)
+	if !ok {
+		return []TargetSummary{{Name: c.Name()}}
+	}
+
+	s := TargetSummary{
+		Name:         m.Name(),
+		StorageClass: m.opts.StorageClass,
+		Encrypted:    m.encryptor != nil,
+		Versioning:   m.opts.Versioning,
+		Replication:  m.opts.Replication.ARN,
+	}
+
+	if m.opts.ManageLifecycle {
+		s.RetentionDays = m.opts.RetentionDays
+	}
+
+	if m.lock != nil {
+		s.ObjectLock = fmt.Sprintf("%s %s", m.lock.mode, m.lock.duration)
+	}
+
+	if m.sse != nil {
+		s.SSE = string(m.sse.Type())
+	}
+
+	return []TargetSummary{s}
+}
@@ -0,0 +1,80 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// configureTLS applies the CA bundle, client certificate and verification
+// settings of this target to transport.
+func (c *minioConfig) configureTLS(transport *http.Transport) error {
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	cfg := transport.TLSClientConfig
+	opts := c.opts.TLS
+
+	if file := opts.CACert; file != "" {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read minio.ca-cert: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", file)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := opts.ClientCert, opts.ClientKey
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("minio.client-cert and minio.client-key must be set together")
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load client certificate: %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.InsecureSkipVerify {
+		klog.Warning("TLS certificate verification is disabled for ", c.opts.Endpoint)
+
+		cfg.InsecureSkipVerify = true
+	}
+
+	return nil
+}
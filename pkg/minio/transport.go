@@ -0,0 +1,75 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// configureTransport applies the transport options to transport. Settings
+// that are not set keep the minio-go defaults.
+func (c *minioConfig) configureTransport(transport *http.Transport) error {
+	opts := c.opts.Transport
+
+	if opts.Proxy != "" {
+		switch opts.Proxy {
+		case "none":
+			transport.Proxy = nil
+		default:
+			u, err := url.Parse(opts.Proxy)
+			if err != nil {
+				return fmt.Errorf("invalid minio.transport.proxy: %w", err)
+			}
+
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+
+	if opts.DialTimeout != 0 || opts.KeepAlive != 0 {
+		dialer := &net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: opts.KeepAlive,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	if opts.ResponseHeaderTimeout != 0 {
+		transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	}
+
+	if opts.TLSHandshakeTimeout != 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+
+	if opts.IdleConnTimeout != 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+
+	if opts.MaxIdleConns != 0 {
+		transport.MaxIdleConns = opts.MaxIdleConns
+	}
+
+	if opts.MaxIdleConnsPerHost != 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	mc "github.com/minio/minio-go/v7"
+)
+
+// Modes for skipping uploads of files that match the remote object.
+const (
+	SkipSizeMtime = "size-mtime"
+	SkipChecksum  = "checksum"
+)
+
+// MetadataSize records the size of the source file, which differs from the
+// object size when it is compressed or encrypted.
+const MetadataSize = "Source-Size"
+
+// ValidSkipUnchanged reports whether mode is a supported skip-unchanged mode.
+func ValidSkipUnchanged(mode string) bool {
+	return mode == "" || mode == SkipSizeMtime || mode == SkipChecksum
+}
+
+// unchangedMetadata returns the metadata compared by Unchanged for file.
+func unchangedMetadata(file, mode string) (map[string]string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %w", file, err)
+	}
+
+	metadata := map[string]string{
+		MetadataSize:  strconv.FormatInt(info.Size(), 10),
+		MetadataMtime: info.ModTime().UTC().Format(time.RFC3339Nano),
+	}
+
+	if mode == SkipChecksum {
+		hash, err := HashFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		metadata[MetadataSHA256] = hash
+	}
+
+	return metadata, nil
+}
+
+// Unchanged reports whether the object for file was uploaded from identical
+// content, as decided by dest.SkipUnchanged. Objects written without the
+// metadata being compared are always considered changed.
+func (c *minioConfig) Unchanged(ctx context.Context, file string, dest config.Destination) (bool, error) {
+	if dest.SkipUnchanged == "" {
+		return false, nil
+	}
+
+	key := ObjectName(file, dest)
+
+	info, err := c.Stat(ctx, key)
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return sameSource(info, file, dest.SkipUnchanged)
+}
+
+// sameSource reports whether info carries the metadata of file compared by
+// mode.
+func sameSource(info mc.ObjectInfo, file, mode string) (bool, error) {
+	local, err := unchangedMetadata(file, mode)
+	if err != nil {
+		return false, err
+	}
+
+	compare := []string{MetadataSize, MetadataMtime}
+	if mode == SkipChecksum {
+		compare = []string{MetadataSize, MetadataSHA256}
+	}
+
+	for _, k := range compare {
+		if remote := info.UserMetadata[k]; remote == "" || remote != local[k] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
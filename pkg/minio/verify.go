@@ -0,0 +1,62 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/config"
+	mc "github.com/minio/minio-go/v7"
+)
+
+// Verify confirms the object for file exists and holds size bytes of source
+// content. Compressed and encrypted objects are checked against the source
+// size recorded when they were uploaded.
+func (c *minioConfig) Verify(ctx context.Context, file string, dest config.Destination, size int64) error {
+	key := ObjectName(file, dest)
+
+	info, err := c.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return checkSize(key, info, size)
+}
+
+// checkSize confirms the object at key, described by info, holds size bytes
+// of source content.
+func checkSize(key string, info mc.ObjectInfo, size int64) error {
+	got := info.Size
+
+	if recorded, ok := info.UserMetadata[MetadataSize]; ok {
+		n, err := strconv.ParseInt(recorded, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s on %s: %w", MetadataSize, key, err)
+		}
+
+		got = n
+	}
+
+	if got != size {
+		return fmt.Errorf("%s holds %d bytes, expected %d", key, got, size)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	flushMu sync.Mutex
+	flushFn func(ctx context.Context) (any, error)
+)
+
+func init() {
+	mux.HandleFunc("/flush", serveFlush)
+}
+
+// RegisterFlush sets the function run by POST /flush. The request returns
+// the result once the function does.
+func RegisterFlush(flush func(ctx context.Context) (any, error)) {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	flushFn = flush
+}
+
+// serveFlush runs the registered flush, bounded by the optional timeout
+// query parameter (e.g. ?timeout=30s).
+func serveFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	flushMu.Lock()
+	flush := flushFn
+	flushMu.Unlock()
+
+	if flush == nil {
+		http.Error(w, "flush not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel, err := requestTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	result, err := flush(ctx)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case err != nil:
+		klog.ErrorS(err, "flush did not complete")
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case anyFailed(result):
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.V(2).ErrorS(err, "unable to write flush result")
+	}
+}
+
+// anyFailed reports whether result records failed files, so callers see an
+// error status rather than having to parse the body.
+func anyFailed(result any) bool {
+	r, ok := result.(interface{ AnyFailed() bool })
+
+	return ok && r.AnyFailed()
+}
+
+// requestTimeout returns the context of r, bounded by its optional timeout
+// query parameter.
+func requestTimeout(r *http.Request) (context.Context, context.CancelFunc, error) {
+	t := r.URL.Query().Get("timeout")
+	if t == "" {
+		return r.Context(), func() {}, nil
+	}
+
+	timeout, err := time.ParseDuration(t)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+
+	return ctx, cancel, nil
+}
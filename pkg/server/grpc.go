@@ -0,0 +1,250 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	apiv1 "github.com/csfreak/minio-backup-sidecar/pkg/api/v1"
+	"github.com/csfreak/minio-backup-sidecar/pkg/history"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/klog/v2"
+)
+
+// eventBuffer bounds the events waiting to be sent to each StreamEvents call.
+const eventBuffer = 256
+
+var (
+	grpcMu    sync.Mutex
+	triggerFn func(ctx context.Context, target string, changedOnly bool) (any, error)
+	eventsFn  func(ctx context.Context, buffer int) <-chan history.Record
+)
+
+// RegisterTrigger sets the function run by TriggerBackup. The call returns
+// the result once the function does.
+func RegisterTrigger(trigger func(ctx context.Context, target string, changedOnly bool) (any, error)) {
+	grpcMu.Lock()
+	defer grpcMu.Unlock()
+
+	triggerFn = trigger
+}
+
+// RegisterEvents sets the function subscribing StreamEvents calls to the
+// outcome of every file processed, until ctx is done.
+func RegisterEvents(subscribe func(ctx context.Context, buffer int) <-chan history.Record) {
+	grpcMu.Lock()
+	defer grpcMu.Unlock()
+
+	eventsFn = subscribe
+}
+
+// StartGRPC serves the gRPC API on grpc.address until ctx is done, using the
+// functions registered for the HTTP endpoints. It is a no-op when
+// grpc.address is not set.
+func StartGRPC(ctx context.Context) error {
+	addr := viper.GetString("grpc.address")
+	if addr == "" {
+		klog.V(4).Info("grpc.address not set, not starting grpc server")
+		return nil
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+
+	srv := grpc.NewServer()
+	apiv1.RegisterSidecarServer(srv, &sidecar{})
+
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+
+	go func() {
+		klog.V(2).InfoS("starting grpc server", "address", addr)
+
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			klog.ErrorS(err, "grpc server failed", "address", addr)
+		}
+	}()
+
+	return nil
+}
+
+// sidecar implements the gRPC API. Results of the registered functions are
+// converted through their JSON encoding, which the messages mirror.
+type sidecar struct {
+	apiv1.UnimplementedSidecarServer
+}
+
+func (sidecar) TriggerBackup(ctx context.Context, req *apiv1.TriggerBackupRequest) (*apiv1.TriggerBackupResponse, error) {
+	grpcMu.Lock()
+	trigger := triggerFn
+	grpcMu.Unlock()
+
+	if trigger == nil {
+		return nil, status.Error(codes.Unavailable, "trigger not available")
+	}
+
+	result, err := trigger(ctx, req.GetTarget(), req.GetChangedOnly())
+	if err != nil {
+		return nil, callError(err, codes.FailedPrecondition)
+	}
+
+	resp := &apiv1.TriggerBackupResponse{Results: &apiv1.Results{}}
+
+	return resp, convert(result, resp.Results)
+}
+
+func (sidecar) Flush(ctx context.Context, _ *apiv1.FlushRequest) (*apiv1.FlushResponse, error) {
+	flushMu.Lock()
+	flush := flushFn
+	flushMu.Unlock()
+
+	if flush == nil {
+		return nil, status.Error(codes.Unavailable, "flush not available")
+	}
+
+	result, err := flush(ctx)
+	if err != nil {
+		klog.ErrorS(err, "flush did not complete")
+		return nil, callError(err, codes.FailedPrecondition)
+	}
+
+	resp := &apiv1.FlushResponse{Results: &apiv1.Results{}}
+
+	return resp, convert(result, resp.Results)
+}
+
+func (sidecar) Pause(_ context.Context, _ *apiv1.PauseRequest) (*apiv1.PauseResponse, error) {
+	state, err := pauseState(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.PauseResponse{State: state}, nil
+}
+
+func (sidecar) Resume(_ context.Context, _ *apiv1.ResumeRequest) (*apiv1.ResumeResponse, error) {
+	state, err := pauseState(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.ResumeResponse{State: state}, nil
+}
+
+// pauseState runs the registered pause or resume function and returns the
+// resulting state.
+func pauseState(pause bool) (*apiv1.PauseState, error) {
+	pauseMu.Lock()
+	fn := resumeFn
+	if pause {
+		fn = pauseFn
+	}
+	pauseMu.Unlock()
+
+	if fn == nil {
+		return nil, status.Error(codes.Unavailable, "pause not available")
+	}
+
+	state := &apiv1.PauseState{}
+
+	return state, convert(fn(), state)
+}
+
+func (sidecar) GetStatus(_ context.Context, req *apiv1.GetStatusRequest) (*apiv1.GetStatusResponse, error) {
+	statusMu.Lock()
+
+	sections := make(map[string]any, len(statusProviders))
+	for name, provider := range statusProviders {
+		if len(req.GetSections()) == 0 || slices.Contains(req.GetSections(), name) {
+			sections[name] = provider()
+		}
+	}
+
+	statusMu.Unlock()
+
+	s := &structpb.Struct{}
+
+	return &apiv1.GetStatusResponse{Status: s}, convert(sections, s)
+}
+
+func (sidecar) StreamEvents(req *apiv1.StreamEventsRequest, stream grpc.ServerStreamingServer[apiv1.Event]) error {
+	grpcMu.Lock()
+	subscribe := eventsFn
+	grpcMu.Unlock()
+
+	if subscribe == nil {
+		return status.Error(codes.Unavailable, "events not available")
+	}
+
+	ctx := stream.Context()
+
+	for r := range subscribe(ctx, eventBuffer) {
+		if (req.GetPath() != "" && r.Path != req.GetPath()) || (req.GetStatus() != "" && r.Status != req.GetStatus()) {
+			continue
+		}
+
+		e := &apiv1.Event{}
+		if err := convert(r, e); err != nil {
+			return err
+		}
+
+		if err := stream.Send(e); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// callError returns err as a status error, with code unless ctx ended the call.
+func callError(err error, code codes.Code) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// convert sets m from the JSON encoding of v, ignoring fields m lacks.
+func convert(v any, m proto.Message) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to encode %T: %v", v, err)
+	}
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, m); err != nil {
+		return status.Errorf(codes.Internal, "unable to convert %T: %v", v, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	apiv1 "github.com/csfreak/minio-backup-sidecar/pkg/api/v1"
+	"github.com/csfreak/minio-backup-sidecar/pkg/fs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1 << 20
+
+// dialSidecar serves the gRPC API in memory and returns a client for it.
+func dialSidecar(t *testing.T) apiv1.SidecarClient {
+	t.Helper()
+
+	lis := bufconn.Listen(bufSize)
+	srv := grpc.NewServer()
+	apiv1.RegisterSidecarServer(srv, &sidecar{})
+
+	go func() { _ = srv.Serve(lis) }()
+
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return apiv1.NewSidecarClient(conn)
+}
+
+func TestGRPCTriggerBackup(t *testing.T) {
+	tests := []struct {
+		name     string
+		trigger  func(ctx context.Context, target string, changedOnly bool) (any, error)
+		target   string
+		want     *apiv1.Results
+		wantCode codes.Code
+	}{
+		{
+			name: "results",
+			trigger: func(_ context.Context, target string, changedOnly bool) (any, error) {
+				if target != "/data" || !changedOnly {
+					return nil, errors.New("unexpected request")
+				}
+
+				return fs.Results{Uploaded: 2, Failed: 1, FailedFiles: []string{"/data/b"}}, nil
+			},
+			target: "/data",
+			want:   &apiv1.Results{Uploaded: 2, Failed: 1, FailedFiles: []string{"/data/b"}},
+		},
+		{
+			name:     "unknown target",
+			trigger:  func(context.Context, string, bool) (any, error) { return nil, errors.New("unknown path") },
+			target:   "/missing",
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "not registered",
+			target:   "/data",
+			wantCode: codes.Unavailable,
+		},
+	}
+
+	client := dialSidecar(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RegisterTrigger(tt.trigger)
+			t.Cleanup(func() { RegisterTrigger(nil) })
+
+			resp, err := client.TriggerBackup(context.Background(), &apiv1.TriggerBackupRequest{Target: tt.target, ChangedOnly: true})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("TriggerBackup code = %s (%v), want %s", code, err, tt.wantCode)
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			got := resp.GetResults()
+			if got.GetUploaded() != tt.want.GetUploaded() || got.GetFailed() != tt.want.GetFailed() || !slices.Equal(got.GetFailedFiles(), tt.want.GetFailedFiles()) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCGetStatus(t *testing.T) {
+	statusMu.Lock()
+	saved := statusProviders
+	statusProviders = map[string]func() any{
+		"paused":         func() any { return true },
+		"offlinePending": func() any { return 3 },
+	}
+	statusMu.Unlock()
+
+	t.Cleanup(func() {
+		statusMu.Lock()
+		statusProviders = saved
+		statusMu.Unlock()
+	})
+
+	tests := []struct {
+		name     string
+		sections []string
+		want     map[string]any
+	}{
+		{"every section", nil, map[string]any{"paused": true, "offlinePending": float64(3)}},
+		{"selected section", []string{"offlinePending"}, map[string]any{"offlinePending": float64(3)}},
+		{"unknown section", []string{"missing"}, map[string]any{}},
+	}
+
+	client := dialSidecar(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.GetStatus(context.Background(), &apiv1.GetStatusRequest{Sections: tt.sections})
+			if err != nil {
+				t.Fatalf("GetStatus: %v", err)
+			}
+
+			got := resp.GetStatus().AsMap()
+			if len(got) != len(tt.want) {
+				t.Fatalf("status = %v, want %v", got, tt.want)
+			}
+
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("status[%s] = %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
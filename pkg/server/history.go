@@ -0,0 +1,75 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	historyMu sync.Mutex
+	historyFn func(url.Values) (any, error)
+)
+
+func init() {
+	mux.HandleFunc("/history", serveHistory)
+}
+
+// RegisterHistory sets the function run by GET /history with the request's
+// query parameters. Its result is written as the response; an error is
+// reported as a bad request.
+func RegisterHistory(fn func(url.Values) (any, error)) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	historyFn = fn
+}
+
+func serveHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	historyMu.Lock()
+	fn := historyFn
+	historyMu.Unlock()
+
+	if fn == nil {
+		http.Error(w, "history not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	result, err := fn(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.V(2).ErrorS(err, "unable to write history")
+	}
+}
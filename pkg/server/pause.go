@@ -0,0 +1,73 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	pauseMu  sync.Mutex
+	pauseFn  func() any
+	resumeFn func() any
+)
+
+func init() {
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) { servePause(w, r, true) })
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) { servePause(w, r, false) })
+}
+
+// RegisterPause sets the functions run by POST /pause and POST /resume. Each
+// returns the resulting state, written as the response.
+func RegisterPause(pause, resume func() any) {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	pauseFn = pause
+	resumeFn = resume
+}
+
+func servePause(w http.ResponseWriter, r *http.Request, pause bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	pauseMu.Lock()
+	fn := resumeFn
+	if pause {
+		fn = pauseFn
+	}
+	pauseMu.Unlock()
+
+	if fn == nil {
+		http.Error(w, "pause not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(fn()); err != nil {
+		klog.V(2).ErrorS(err, "unable to write pause state")
+	}
+}
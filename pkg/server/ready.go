@@ -0,0 +1,78 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	readyMu     sync.Mutex
+	readyChecks = map[string]func() error{}
+)
+
+func init() {
+	mux.HandleFunc("/readyz", serveReady)
+}
+
+// RegisterReadiness adds a check that must pass for /readyz to report ready.
+func RegisterReadiness(name string, check func() error) {
+	readyMu.Lock()
+	defer readyMu.Unlock()
+
+	readyChecks[name] = check
+}
+
+func serveReady(w http.ResponseWriter, _ *http.Request) {
+	readyMu.Lock()
+
+	var failed []string
+
+	for name, check := range readyChecks {
+		if err := check(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	readyMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain")
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		for _, f := range failed {
+			if _, err := fmt.Fprintln(w, f); err != nil {
+				klog.V(2).ErrorS(err, "unable to write readiness")
+				return
+			}
+		}
+
+		return
+	}
+
+	if _, err := fmt.Fprintln(w, "ok"); err != nil {
+		klog.V(2).ErrorS(err, "unable to write readiness")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	retryMu sync.Mutex
+	retryFn func(ctx context.Context, target string) (any, error)
+)
+
+func init() {
+	mux.HandleFunc("/retry-failed", serveRetry)
+}
+
+// RegisterRetry sets the function run by POST /retry-failed with the
+// optional target query parameter. The request returns the result once the
+// function does.
+func RegisterRetry(retry func(ctx context.Context, target string) (any, error)) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+
+	retryFn = retry
+}
+
+// serveRetry runs the registered retry, bounded by the optional timeout
+// query parameter (e.g. ?timeout=30s).
+func serveRetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	retryMu.Lock()
+	retry := retryFn
+	retryMu.Unlock()
+
+	if retry == nil {
+		http.Error(w, "retry not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel, err := requestTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	result, err := retry(ctx, r.URL.Query().Get("target"))
+
+	// only a retry cut short by its timeout has a partial result
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		code := http.StatusConflict
+		if errors.Is(err, fs.ErrNotExist) {
+			code = http.StatusNotFound
+		}
+
+		http.Error(w, err.Error(), code)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		klog.ErrorS(err, "retry did not complete")
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.V(2).ErrorS(err, "unable to write retry result")
+	}
+}
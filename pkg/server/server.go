@@ -0,0 +1,72 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+var mux = http.NewServeMux()
+
+func init() {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// Start serves metrics and control endpoints on http.address until ctx is done.
+// It is a no-op when http.address is not set.
+func Start(ctx context.Context) {
+	addr := viper.GetString("http.address")
+	if addr == "" {
+		klog.V(4).Info("http.address not set, not starting http server")
+		return
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(sctx); err != nil {
+			klog.V(2).ErrorS(err, "unable to shutdown http server")
+		}
+	}()
+
+	go func() {
+		klog.V(2).InfoS("starting http server", "address", addr)
+
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.ErrorS(err, "http server failed", "address", addr)
+		}
+	}()
+}
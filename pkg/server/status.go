@@ -0,0 +1,60 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	statusMu        sync.Mutex
+	statusProviders = map[string]func() any{}
+)
+
+func init() {
+	mux.HandleFunc("/status", serveStatus)
+}
+
+// RegisterStatus adds a section to the /status response.
+func RegisterStatus(name string, provider func() any) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	statusProviders[name] = provider
+}
+
+func serveStatus(w http.ResponseWriter, _ *http.Request) {
+	statusMu.Lock()
+
+	status := make(map[string]any, len(statusProviders))
+	for name, provider := range statusProviders {
+		status[name] = provider()
+	}
+
+	statusMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		klog.V(2).ErrorS(err, "unable to write status")
+	}
+}
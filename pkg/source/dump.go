@@ -0,0 +1,225 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package source produces backups from commands such as database dump tools
+// and from the Kubernetes API, streaming them straight to the bucket without
+// staging them on disk.
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultNameTemplate = `{{.Database}}-{{.Time.Format "20060102T150405Z"}}`
+	stderrLimit         = 4096 // Bytes of a failed command's stderr kept for its error
+)
+
+var errNoDatabase = errors.New("database must be set")
+
+// Options configures where and when a source is backed up.
+type Options struct {
+	Name         string // Identifies the source in logs, events and object names
+	Schedule     string // Cron schedule of backups (Defaults to none, run once)
+	Path         string // Template for the object path relative to the bucket
+	NameTemplate string // Template for object names, extension is appended
+	Compress     bool   // Compress the output with gzip
+}
+
+type nameData struct {
+	Name     string    // Name of the source
+	Database string    // Database dumped
+	Time     time.Time // Start of the backup (UTC)
+}
+
+// namer names the objects of a source from its path and name templates.
+type namer struct {
+	opts Options
+	path *template.Template
+	name *template.Template
+}
+
+func newNamer(opts Options) (*namer, error) {
+	if opts.NameTemplate == "" {
+		opts.NameTemplate = defaultNameTemplate
+	}
+
+	name, err := template.New("name").Parse(opts.NameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name-template for %s: %w", opts.Name, err)
+	}
+
+	p, err := template.New("path").Parse(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path for %s: %w", opts.Name, err)
+	}
+
+	return &namer{opts: opts, path: p, name: name}, nil
+}
+
+// objectName returns the key of a backup of database started at t.
+func (n *namer) objectName(database, ext string, t time.Time) (string, error) {
+	key, err := n.prefix(database, t)
+	if err != nil {
+		return "", err
+	}
+
+	key += ext
+	if n.opts.Compress {
+		key += ".gz"
+	}
+
+	return key, nil
+}
+
+// prefix returns the key of a backup of database started at t without an
+// extension, for sources that upload several objects below it.
+func (n *namer) prefix(database string, t time.Time) (string, error) {
+	data := nameData{Name: n.opts.Name, Database: database, Time: t.UTC()}
+
+	var p, name bytes.Buffer
+
+	if err := n.path.Execute(&p, data); err != nil {
+		return "", fmt.Errorf("invalid path for %s: %w", n.opts.Name, err)
+	}
+
+	if err := n.name.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("invalid name-template for %s: %w", n.opts.Name, err)
+	}
+
+	return path.Join(p.String(), name.String()), nil
+}
+
+// stream runs cmd and uploads its stdout to key. The object is removed again
+// when cmd fails, so a partial dump is never mistaken for a backup.
+func stream(ctx context.Context, client minio.MinioClient, cmd *exec.Cmd, key, contentType string, compress bool) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("unable to run %s: %w", cmd.Path, err)
+	}
+
+	stderr := &tail{limit: stderrLimit}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to run %s: %w", cmd.Path, err)
+	}
+
+	var (
+		r  io.Reader = stdout
+		pr *io.PipeReader
+	)
+
+	if compress {
+		var pw *io.PipeWriter
+
+		pr, pw = io.Pipe()
+		r = pr
+		contentType = "application/gzip"
+
+		go func() {
+			zw := gzip.NewWriter(pw)
+
+			_, err := io.Copy(zw, stdout)
+			if err == nil {
+				err = zw.Close()
+			}
+
+			pw.CloseWithError(err)
+		}()
+	}
+
+	klog.V(2).InfoS("streaming backup", "command", cmd.Path, "object", key)
+
+	uploadErr := client.Upload(ctx, key, r, -1, contentType)
+	if uploadErr != nil {
+		// stop the command, which may be blocked writing output no longer read
+		if pr != nil {
+			pr.CloseWithError(uploadErr)
+		}
+
+		_ = cmd.Process.Kill()
+	}
+
+	waitErr := cmd.Wait()
+
+	switch {
+	case uploadErr != nil:
+		return uploadErr
+	case waitErr != nil:
+		if err := client.Delete(context.WithoutCancel(ctx), key); err != nil {
+			klog.ErrorS(err, "unable to remove incomplete backup", "object", key)
+		}
+
+		return fmt.Errorf("%s failed: %w: %s", path.Base(cmd.Path), waitErr, stderr)
+	}
+
+	klog.V(2).InfoS("backup uploaded", "object", key)
+
+	return nil
+}
+
+// tail keeps the last limit bytes written to it.
+type tail struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return strings.TrimSpace(string(t.buf))
+}
+
+// lookPath returns the full path of command, failing early when it is not
+// installed.
+func lookPath(command string) (string, error) {
+	p, err := exec.LookPath(command)
+	if err != nil {
+		return "", fmt.Errorf("unable to find %s: %w", command, err)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,355 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/kube"
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// Formats of exported Kubernetes objects.
+const (
+	KubernetesYAML = "yaml"
+	KubernetesJSON = "json"
+)
+
+// AllNamespaces selects objects in every namespace, and cluster scoped
+// resources such as namespaces or customresourcedefinitions.
+const AllNamespaces = "*"
+
+const (
+	kubernetesTimeout = 30 * time.Second
+	kubernetesPage    = 500        // Objects requested per list call
+	clusterScoped     = "_cluster" // Namespace directory of cluster scoped objects
+)
+
+// DefaultKubernetesResources are exported when no resources are configured.
+var DefaultKubernetesResources = []string{"configmaps", "secrets"}
+
+// metadataFields are set by the API server and removed from exported objects,
+// so they can be applied again to any cluster.
+var metadataFields = []string{"managedFields", "resourceVersion", "uid", "generation", "creationTimestamp", "selfLink"}
+
+// KubernetesOptions configures resources exported from the cluster the
+// sidecar runs in. The service account needs list on every resource in every
+// namespace selected.
+type KubernetesOptions struct {
+	Options
+
+	Resources     []string // Resources as plural name, version/name or group/version/name (Defaults to configmaps and secrets)
+	Namespaces    []string // Namespaces to export, * for all (Defaults to the pod namespace)
+	LabelSelector string   // Only export objects matching the selector
+	Format        string   // Object format (yaml, json) (Defaults to yaml)
+	Encrypted     bool     // Uploads are encrypted client-side
+}
+
+// Kubernetes exports objects from the Kubernetes API, one object per
+// resource and name below a prefix for every backup. Secrets are exported
+// as the API returns them and encrypted like every upload by the configured
+// client-side encryption, without which they are stored in plaintext.
+type Kubernetes struct {
+	opts      KubernetesOptions
+	resources []resource
+	client    *kube.Client
+	names     *namer
+}
+
+// resource is a resource type listed from the API.
+type resource struct {
+	group, version, name string
+}
+
+// NewKubernetes returns a source exporting the resources configured by opts
+// with the pod's service account.
+func NewKubernetes(opts KubernetesOptions) (*Kubernetes, error) {
+	if opts.Name == "" {
+		opts.Name = "kubernetes"
+	}
+
+	switch strings.ToLower(opts.Format) {
+	case "", "yml", KubernetesYAML:
+		opts.Format = KubernetesYAML
+	case KubernetesJSON:
+		opts.Format = KubernetesJSON
+	default:
+		return nil, fmt.Errorf("unknown kubernetes format %s", opts.Format)
+	}
+
+	if len(opts.Resources) == 0 {
+		opts.Resources = DefaultKubernetesResources
+	}
+
+	resources := make([]resource, 0, len(opts.Resources))
+
+	for _, r := range opts.Resources {
+		res, err := parseResource(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.group == "" && res.name == "secrets" && !opts.Encrypted {
+			klog.Warningf("source %s exports secrets without client-side encryption, set encryption.type to keep them out of the bucket in plaintext", opts.Name)
+		}
+
+		resources = append(resources, res)
+	}
+
+	client, err := kube.InCluster(kubernetesTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes source requires running in a cluster: %w", err)
+	}
+
+	if len(opts.Namespaces) == 0 {
+		opts.Namespaces = []string{client.Namespace()}
+	}
+
+	names, err := newNamer(opts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Kubernetes{opts: opts, resources: resources, client: client, names: names}, nil
+}
+
+func parseResource(r string) (resource, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(r)), "/")
+
+	for _, p := range parts {
+		if p == "" {
+			return resource{}, fmt.Errorf("invalid kubernetes resource %q", r)
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		return resource{version: "v1", name: parts[0]}, nil
+	case 2:
+		return resource{version: parts[0], name: parts[1]}, nil
+	case 3:
+		return resource{group: parts[0], version: parts[1], name: parts[2]}, nil
+	default:
+		return resource{}, fmt.Errorf("invalid kubernetes resource %q, expected group/version/resource", r)
+	}
+}
+
+// path returns the API path listing r in namespace, or in all namespaces
+// when namespace is AllNamespaces.
+func (r resource) path(namespace string) string {
+	p := "/api/" + r.version
+	if r.group != "" {
+		p = "/apis/" + r.group + "/" + r.version
+	}
+
+	if namespace != AllNamespaces {
+		p += "/namespaces/" + url.PathEscape(namespace)
+	}
+
+	return p + "/" + r.name
+}
+
+func (r resource) String() string {
+	if r.group == "" {
+		return r.name
+	}
+
+	return r.name + "." + r.group
+}
+
+func (k *Kubernetes) Name() string {
+	return k.opts.Name
+}
+
+func (k *Kubernetes) Schedule() string {
+	return k.opts.Schedule
+}
+
+// Backup exports every selected object to the bucket. Every resource and
+// namespace is attempted even when an earlier one fails.
+func (k *Kubernetes) Backup(ctx context.Context, client minio.MinioClient) error {
+	prefix, err := k.names.prefix(k.opts.Name, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs  []error
+		count int
+	)
+
+	for _, r := range k.resources {
+		for _, ns := range k.opts.Namespaces {
+			n, err := k.export(ctx, client, prefix, r, ns)
+			count += n
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("unable to export %s in %s: %w", r, ns, err))
+			}
+		}
+	}
+
+	klog.V(2).InfoS("kubernetes objects exported", "source", k.opts.Name, "prefix", prefix, "objects", count)
+
+	return errors.Join(errs...)
+}
+
+// objectList is the part of a list response used to export its items.
+type objectList struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []map[string]any `json:"items"`
+}
+
+// export uploads every object of r in namespace, a page at a time, and
+// returns the number uploaded.
+func (k *Kubernetes) export(ctx context.Context, client minio.MinioClient, prefix string, r resource, namespace string) (int, error) {
+	var (
+		count int
+		next  string
+	)
+
+	for {
+		query := url.Values{"limit": {fmt.Sprint(kubernetesPage)}}
+		if k.opts.LabelSelector != "" {
+			query.Set("labelSelector", k.opts.LabelSelector)
+		}
+
+		if next != "" {
+			query.Set("continue", next)
+		}
+
+		list, err := k.list(ctx, r.path(namespace)+"?"+query.Encode())
+		if err != nil {
+			return count, err
+		}
+
+		kind := strings.TrimSuffix(list.Kind, "List")
+
+		for _, item := range list.Items {
+			item["apiVersion"], item["kind"] = list.APIVersion, kind
+
+			if err := k.upload(ctx, client, prefix, r, item); err != nil {
+				return count, err
+			}
+
+			count++
+		}
+
+		if next = list.Metadata.Continue; next == "" {
+			return count, nil
+		}
+	}
+}
+
+func (k *Kubernetes) list(ctx context.Context, p string) (*objectList, error) {
+	resp, err := k.client.Do(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	list := &objectList{}
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", p, err)
+	}
+
+	return list, nil
+}
+
+// upload writes obj to prefix/namespace/resource/name.
+func (k *Kubernetes) upload(ctx context.Context, client minio.MinioClient, prefix string, r resource, obj map[string]any) error {
+	meta, _ := obj["metadata"].(map[string]any)
+	for _, f := range metadataFields {
+		delete(meta, f)
+	}
+
+	delete(obj, "status")
+
+	name, _ := meta["name"].(string)
+	if name == "" {
+		return fmt.Errorf("%s object without a name", r)
+	}
+
+	namespace, _ := meta["namespace"].(string)
+	if namespace == "" {
+		namespace = clusterScoped
+	}
+
+	var (
+		body        []byte
+		err         error
+		ext         = ".yaml"
+		contentType = "application/yaml"
+	)
+
+	if k.opts.Format == KubernetesJSON {
+		ext, contentType = ".json", "application/json"
+		body, err = json.MarshalIndent(obj, "", "  ")
+	} else {
+		var buf bytes.Buffer
+
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+
+		if err = enc.Encode(obj); err == nil {
+			err = enc.Close()
+		}
+
+		body = buf.Bytes()
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to encode %s %s/%s: %w", r, namespace, name, err)
+	}
+
+	key := path.Join(prefix, namespace, r.String(), name+ext)
+
+	if k.opts.Compress {
+		var buf bytes.Buffer
+
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		body, key, contentType = buf.Bytes(), key+".gz", "application/gzip"
+	}
+
+	klog.V(4).InfoS("exporting kubernetes object", "resource", r, "namespace", namespace, "name", name, "object", key)
+
+	return client.Upload(ctx, key, bytes.NewReader(body), int64(len(body)), contentType)
+}
@@ -0,0 +1,189 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+)
+
+// allDatabases names the dump of every database when none are listed.
+const allDatabases = "all"
+
+// MySQLOptions configures MySQL or MariaDB databases dumped with mysqldump.
+type MySQLOptions struct {
+	Options
+
+	Host         string   // Server host (Defaults to mysqldump's default)
+	Port         int      // Server port (Defaults to mysqldump's default)
+	User         string   // User to connect as
+	Databases    []string // Databases to dump, each to its own object (Defaults to all databases in one object)
+	PasswordFile string   // File holding the password, e.g. a mounted secret, read before every dump
+	Args         []string // Extra mysqldump arguments
+	Command      string   // mysqldump binary, e.g. mariadb-dump (Defaults to mysqldump)
+}
+
+// MySQL dumps MySQL or MariaDB databases.
+type MySQL struct {
+	opts    MySQLOptions
+	command string
+	names   *namer
+}
+
+// NewMySQL returns a source dumping the databases configured by opts.
+func NewMySQL(opts MySQLOptions) (*MySQL, error) {
+	if opts.Name == "" {
+		opts.Name = strings.Join(opts.Databases, ",")
+	}
+
+	if opts.Name == "" {
+		opts.Name = allDatabases
+	}
+
+	if opts.Command == "" {
+		opts.Command = "mysqldump"
+	}
+
+	command, err := lookPath(opts.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := newNamer(opts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MySQL{opts: opts, command: command, names: names}, nil
+}
+
+func (m *MySQL) Name() string {
+	return m.opts.Name
+}
+
+func (m *MySQL) Schedule() string {
+	return m.opts.Schedule
+}
+
+// Backup streams a dump of every database to the bucket, one after another.
+// Every database is attempted even when an earlier one fails.
+func (m *MySQL) Backup(ctx context.Context, client minio.MinioClient) error {
+	defaults, err := m.defaultsFile()
+	if err != nil {
+		return err
+	}
+
+	if defaults != "" {
+		defer os.Remove(defaults)
+	}
+
+	start := time.Now()
+
+	if len(m.opts.Databases) == 0 {
+		return m.dump(ctx, client, defaults, allDatabases, start, "--all-databases")
+	}
+
+	errs := make([]error, 0, len(m.opts.Databases))
+
+	for _, db := range m.opts.Databases {
+		errs = append(errs, m.dump(ctx, client, defaults, db, start, "--databases", db))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *MySQL) dump(ctx context.Context, client minio.MinioClient, defaults, database string, start time.Time, target ...string) error {
+	key, err := m.names.objectName(database, ".sql", start)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+
+	// must be the first argument
+	if defaults != "" {
+		args = append(args, "--defaults-extra-file="+defaults)
+	}
+
+	args = append(args, "--single-transaction")
+
+	if m.opts.Host != "" {
+		args = append(args, "--host="+m.opts.Host)
+	}
+
+	if m.opts.Port != 0 {
+		args = append(args, fmt.Sprintf("--port=%d", m.opts.Port))
+	}
+
+	if m.opts.User != "" {
+		args = append(args, "--user="+m.opts.User)
+	}
+
+	args = append(args, m.opts.Args...)
+	args = append(args, target...)
+
+	cmd := exec.CommandContext(ctx, m.command, args...) //nolint:gosec // the command and arguments are configured
+
+	if err := stream(ctx, client, cmd, key, "application/sql", m.opts.Compress); err != nil {
+		return fmt.Errorf("unable to dump %s: %w", database, err)
+	}
+
+	return nil
+}
+
+// defaultsFile writes the password to a temporary option file, which keeps
+// it out of the process list and environment. It returns "" when no
+// password-file is set.
+func (m *MySQL) defaultsFile() (string, error) {
+	if m.opts.PasswordFile == "" {
+		return "", nil
+	}
+
+	password, err := os.ReadFile(m.opts.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password-file: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "minio-backup-mysql-*.cnf")
+	if err != nil {
+		return "", fmt.Errorf("unable to write mysql options: %w", err)
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(strings.TrimRight(string(password), "\r\n"))
+
+	if _, err := fmt.Fprintf(f, "[client]\npassword=\"%s\"\n", escaped); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return "", fmt.Errorf("unable to write mysql options: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("unable to write mysql options: %w", err)
+	}
+
+	return f.Name(), nil
+}
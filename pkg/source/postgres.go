@@ -0,0 +1,150 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/minio"
+)
+
+// Formats of pg_dump output.
+const (
+	PostgresPlain  = "plain"
+	PostgresCustom = "custom"
+)
+
+// PostgresOptions configures a PostgreSQL database dumped with pg_dump.
+type PostgresOptions struct {
+	Options
+
+	Host         string   // Server host or socket directory (Defaults to pg_dump's default)
+	Port         int      // Server port (Defaults to pg_dump's default)
+	User         string   // Role to connect as
+	Database     string   // Database to dump
+	PasswordFile string   // File holding the password, e.g. a mounted secret, read before every dump
+	SSLMode      string   // libpq sslmode (Defaults to libpq's default)
+	Format       string   // Dump format (plain, custom) (Defaults to plain)
+	Args         []string // Extra pg_dump arguments
+	Command      string   // pg_dump binary (Defaults to pg_dump)
+}
+
+// Postgres dumps a PostgreSQL database.
+type Postgres struct {
+	opts    PostgresOptions
+	command string
+	names   *namer
+}
+
+// NewPostgres returns a source dumping the database configured by opts.
+func NewPostgres(opts PostgresOptions) (*Postgres, error) {
+	if opts.Database == "" {
+		return nil, errNoDatabase
+	}
+
+	if opts.Name == "" {
+		opts.Name = opts.Database
+	}
+
+	if opts.Command == "" {
+		opts.Command = "pg_dump"
+	}
+
+	switch strings.ToLower(opts.Format) {
+	case "", "p", PostgresPlain:
+		opts.Format = PostgresPlain
+	case "c", PostgresCustom:
+		opts.Format = PostgresCustom
+	default:
+		return nil, fmt.Errorf("unknown postgres format %s", opts.Format)
+	}
+
+	command, err := lookPath(opts.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := newNamer(opts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Postgres{opts: opts, command: command, names: names}, nil
+}
+
+func (p *Postgres) Name() string {
+	return p.opts.Name
+}
+
+func (p *Postgres) Schedule() string {
+	return p.opts.Schedule
+}
+
+// Backup streams a dump of the database to the bucket.
+func (p *Postgres) Backup(ctx context.Context, client minio.MinioClient) error {
+	ext, contentType := ".sql", "application/sql"
+	if p.opts.Format == PostgresCustom {
+		ext, contentType = ".dump", "application/octet-stream"
+	}
+
+	key, err := p.names.objectName(p.opts.Database, ext, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, p.command, p.args()...) //nolint:gosec // the command and arguments are configured
+	cmd.Env = os.Environ()
+
+	if p.opts.PasswordFile != "" {
+		password, err := os.ReadFile(p.opts.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read password-file: %w", err)
+		}
+
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+strings.TrimRight(string(password), "\r\n"))
+	}
+
+	if p.opts.SSLMode != "" {
+		cmd.Env = append(cmd.Env, "PGSSLMODE="+p.opts.SSLMode)
+	}
+
+	return stream(ctx, client, cmd, key, contentType, p.opts.Compress)
+}
+
+func (p *Postgres) args() []string {
+	args := []string{"--no-password", "--format=" + p.opts.Format, "--dbname=" + p.opts.Database}
+
+	if p.opts.Host != "" {
+		args = append(args, "--host="+p.opts.Host)
+	}
+
+	if p.opts.Port != 0 {
+		args = append(args, fmt.Sprintf("--port=%d", p.opts.Port))
+	}
+
+	if p.opts.User != "" {
+		args = append(args, "--username="+p.opts.User)
+	}
+
+	return append(args, p.opts.Args...)
+}
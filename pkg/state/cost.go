@@ -0,0 +1,75 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+const (
+	bytesPerGB   = 1 << 30
+	daysPerMonth = 30
+	requestUnit  = 1000
+)
+
+// Prices are the prices costs are estimated with.
+type Prices struct {
+	StoragePerGBMonth float64 // Storage price per GB-month
+	Per1000Requests   float64 // PUT request price per 1000 requests
+}
+
+// Cost is a monthly estimate derived from the last 7 days of uploads. Stored
+// volume assumes objects are kept for the retention of each target a path
+// uploads to, or a single month of uploads where no retention is configured.
+type Cost struct {
+	StoredGB        float64 `json:"storedGB"`
+	RequestsMonthly float64 `json:"requestsPerMonth"`
+	StorageCost     float64 `json:"storageCost"`
+	RequestCost     float64 `json:"requestCost"`
+	TotalCost       float64 `json:"totalCost"`
+}
+
+// Costs estimates monthly storage and request costs for every path at
+// prices. retention holds, for each configured path, the retention in days
+// of every target it uploads to; each target stores and is sent a copy. A
+// path without any is taken to upload to one target without retention.
+func Costs(prices Prices, retention map[string][]int) map[string]Cost {
+	costs := make(map[string]Cost)
+
+	for p, u := range UploadUsage() {
+		targets := retention[p]
+		if len(targets) == 0 {
+			targets = []int{0}
+		}
+
+		var c Cost
+
+		for _, days := range targets {
+			if days <= 0 {
+				days = daysPerMonth
+			}
+
+			c.StoredGB += float64(u.AverageBytes) * float64(days) / bytesPerGB
+			c.RequestsMonthly += u.AverageUploads * daysPerMonth
+		}
+
+		c.StorageCost = c.StoredGB * prices.StoragePerGBMonth
+		c.RequestCost = c.RequestsMonthly / requestUnit * prices.Per1000Requests
+		c.TotalCost = c.StorageCost + c.RequestCost
+
+		costs[p] = c
+	}
+
+	return costs
+}
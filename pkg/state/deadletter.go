@@ -0,0 +1,116 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package state
+
+import (
+	"slices"
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+)
+
+// DeadLetter is a failed upload of a file, or of a path or source as a whole,
+// kept until it is retried or a later upload of it succeeds or is skipped.
+type DeadLetter struct {
+	Path     string    `json:"path"`             // Configured path or source
+	File     string    `json:"file,omitempty"`   // Empty for a source
+	Object   string    `json:"object,omitempty"` // Destination object, when it was known
+	Error    string    `json:"error"`            // Error of the last attempt
+	Attempts int       `json:"attempts"`         // Failed uploads since it was recorded
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+func deadLetterKey(p, file string) string {
+	return p + "/" + file
+}
+
+// RecordDeadLetter records that the upload of file under the configured path
+// or source p failed with err after every retry. An object that is not known
+// keeps the one recorded by an earlier attempt.
+func RecordDeadLetter(p, file, object, err string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.DeadLetters == nil {
+		store.DeadLetters = make(map[string]DeadLetter)
+	}
+
+	now := time.Now()
+	key := deadLetterKey(p, file)
+
+	d, ok := store.DeadLetters[key]
+	if !ok {
+		d = DeadLetter{Path: p, File: file, First: now}
+	}
+
+	if object != "" {
+		d.Object = object
+	}
+
+	d.Error = err
+	d.Attempts++
+	d.Last = now
+
+	store.DeadLetters[key] = d
+	store.dirty = true
+	store.updateDeadLetterMetric(p)
+}
+
+// ForgetDeadLetter removes the failed upload of file under p, which no longer
+// needs retrying.
+func ForgetDeadLetter(p, file string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := deadLetterKey(p, file)
+	if _, ok := store.DeadLetters[key]; ok {
+		delete(store.DeadLetters, key)
+		store.dirty = true
+		store.updateDeadLetterMetric(p)
+	}
+}
+
+// DeadLetters returns every failed upload waiting to be retried, oldest
+// failure first.
+func DeadLetters() []DeadLetter {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(store.DeadLetters))
+	for _, d := range store.DeadLetters {
+		letters = append(letters, d)
+	}
+
+	slices.SortFunc(letters, func(a, b DeadLetter) int {
+		return a.First.Compare(b.First)
+	})
+
+	return letters
+}
+
+func (s *Store) updateDeadLetterMetric(p string) {
+	var count int
+
+	for _, d := range s.DeadLetters {
+		if d.Path == p {
+			count++
+		}
+	}
+
+	metrics.DeadLetters.WithLabelValues(p).Set(float64(count))
+}
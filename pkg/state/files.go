@@ -0,0 +1,95 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import "time"
+
+// FileState describes a file as it was when last uploaded.
+type FileState struct {
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Hash  string    `json:"hash,omitempty"` // sha256, when it was computed for the upload
+
+	Object   string `json:"object,omitempty"`   // Key the file was uploaded to
+	Sequence int64  `json:"sequence,omitempty"` // Snapshot sequence of the upload, or its last appended segment
+
+	Head     string `json:"head,omitempty"`     // sha256 of the first HeadSize bytes, identifying an appended file
+	HeadSize int64  `json:"headSize,omitempty"` // Bytes hashed for Head
+
+	Rotated string `json:"rotated,omitempty"` // sha256 of the decompressed content of a rotated log, identifying it under later names
+
+	Uploaded time.Time `json:"uploaded,omitempty"` // Time of the upload
+}
+
+// RecordFile stores the state of file, under the configured path p, as uploaded.
+func RecordFile(p, file string, fs FileState) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.path(p).Files[file] = fs
+	store.dirty = true
+}
+
+// ForgetFile removes the state of file, which no longer exists.
+func ForgetFile(p, file string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	ps, ok := store.Paths[p]
+	if !ok {
+		return
+	}
+
+	if _, ok := ps.Files[file]; ok {
+		delete(ps.Files, file)
+		store.dirty = true
+	}
+}
+
+// LastUpload returns the state of file when it was last uploaded.
+func LastUpload(p, file string) (FileState, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	ps, ok := store.Paths[p]
+	if !ok {
+		return FileState{}, false
+	}
+
+	fs, ok := ps.Files[file]
+
+	return fs, ok
+}
+
+// Files returns the state of every file recorded as uploaded under p.
+func Files(p string) map[string]FileState {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	ps, ok := store.Paths[p]
+	if !ok {
+		return nil
+	}
+
+	files := make(map[string]FileState, len(ps.Files))
+	for file, fs := range ps.Files {
+		files[file] = fs
+	}
+
+	return files
+}
@@ -0,0 +1,89 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package state
+
+import "time"
+
+// Multipart is a multipart upload of a file in progress, recorded so its
+// uploaded parts are kept when the upload is retried or the sidecar restarts.
+type Multipart struct {
+	Target   string    `json:"target"`
+	Bucket   string    `json:"bucket"`
+	Object   string    `json:"object"`
+	UploadID string    `json:"uploadId"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`     // Size of File when the upload started
+	Mtime    time.Time `json:"mtime"`    // Mtime of File when the upload started
+	PartSize int64     `json:"partSize"` // Bytes of every part but the last
+	Started  time.Time `json:"started"`
+}
+
+func multipartKey(target, bucket, object string) string {
+	return target + "/" + bucket + "/" + object
+}
+
+// RecordMultipart stores m as the upload in progress to its object.
+func RecordMultipart(m Multipart) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.Multipart == nil {
+		store.Multipart = make(map[string]Multipart)
+	}
+
+	store.Multipart[multipartKey(m.Target, m.Bucket, m.Object)] = m
+	store.dirty = true
+}
+
+// ForgetMultipart removes the upload in progress to object, which completed
+// or was aborted.
+func ForgetMultipart(target, bucket, object string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	key := multipartKey(target, bucket, object)
+	if _, ok := store.Multipart[key]; ok {
+		delete(store.Multipart, key)
+		store.dirty = true
+	}
+}
+
+// MultipartUpload returns the upload in progress to object.
+func MultipartUpload(target, bucket, object string) (Multipart, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	m, ok := store.Multipart[multipartKey(target, bucket, object)]
+
+	return m, ok
+}
+
+// MultipartUploads returns every upload in progress to bucket of target.
+func MultipartUploads(target, bucket string) []Multipart {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var uploads []Multipart
+
+	for _, m := range store.Multipart {
+		if m.Target == target && m.Bucket == bucket {
+			uploads = append(uploads, m)
+		}
+	}
+
+	return uploads
+}
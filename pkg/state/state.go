@@ -0,0 +1,207 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const flushInterval = 10 * time.Second
+
+// Store holds state that should survive restarts. It is kept in memory and
+// periodically written to a JSON file when one is configured.
+type Store struct {
+	mu    sync.Mutex
+	file  string
+	dirty bool
+
+	Paths       map[string]*PathState `json:"paths"`
+	Multipart   map[string]Multipart  `json:"multipart,omitempty"`   // Multipart uploads in progress by target, bucket and object
+	DeadLetters map[string]DeadLetter `json:"deadLetters,omitempty"` // Failed uploads by path or source and file
+}
+
+type PathState struct {
+	Daily   map[string]int64     `json:"daily"`           // Bytes uploaded by day (YYYY-MM-DD)
+	Uploads map[string]int64     `json:"uploads"`         // Objects uploaded by day (YYYY-MM-DD)
+	Files   map[string]FileState `json:"files,omitempty"` // Last upload by file
+
+	LastSuccess time.Time `json:"lastSuccess,omitempty"` // Time of the last successful upload
+}
+
+// legacyStore holds the state written under the snake_case keys used before
+// every key was camelCase.
+type legacyStore struct {
+	Paths map[string]struct {
+		LastSuccess time.Time `json:"last_success"`
+	} `json:"paths"`
+	DeadLetters map[string]DeadLetter `json:"dead_letters"`
+}
+
+var store = newStore("")
+
+func newStore(file string) *Store {
+	return &Store{
+		file:  file,
+		Paths: make(map[string]*PathState),
+	}
+}
+
+// Init loads state from file, which may not exist yet. An empty file keeps
+// state in memory only.
+func Init(file string) error {
+	s := newStore(file)
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			klog.V(2).InfoS("state file not found, starting fresh", "file", file)
+		case err != nil:
+			return fmt.Errorf("unable to read state file %s: %w", file, err)
+		default:
+			if err := json.Unmarshal(data, s); err != nil {
+				return fmt.Errorf("unable to parse state file %s: %w", file, err)
+			}
+
+			if err := s.readLegacy(data); err != nil {
+				return fmt.Errorf("unable to parse state file %s: %w", file, err)
+			}
+		}
+	}
+
+	store = s
+	store.refreshMetrics()
+
+	return nil
+}
+
+// readLegacy fills in state that data holds under legacy keys, so it is kept
+// once the file is next written.
+func (s *Store) readLegacy(data []byte) error {
+	var legacy legacyStore
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	if s.DeadLetters == nil {
+		s.DeadLetters = legacy.DeadLetters
+	}
+
+	for p, l := range legacy.Paths {
+		if ps, ok := s.Paths[p]; ok && ps.LastSuccess.IsZero() {
+			ps.LastSuccess = l.LastSuccess
+		}
+	}
+
+	return nil
+}
+
+// Run flushes state to disk and refreshes metrics periodically until ctx is done.
+func Run(ctx context.Context) {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := Flush(); err != nil {
+				klog.ErrorS(err, "unable to save state")
+			}
+
+			return
+		case <-t.C:
+			store.mu.Lock()
+			store.refreshMetrics()
+			store.mu.Unlock()
+
+			if err := Flush(); err != nil {
+				klog.ErrorS(err, "unable to save state")
+			}
+		}
+	}
+}
+
+// Flush writes state to disk if it changed.
+func Flush() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.file == "" || !store.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(store)
+	if err != nil {
+		return fmt.Errorf("unable to encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(store.file), ".state-*")
+	if err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), store.file); err != nil {
+		return fmt.Errorf("unable to save state: %w", err)
+	}
+
+	store.dirty = false
+
+	return nil
+}
+
+func (s *Store) path(p string) *PathState {
+	ps, ok := s.Paths[p]
+	if !ok {
+		ps = &PathState{}
+		s.Paths[p] = ps
+	}
+
+	if ps.Daily == nil {
+		ps.Daily = make(map[string]int64)
+	}
+
+	if ps.Uploads == nil {
+		ps.Uploads = make(map[string]int64)
+	}
+
+	if ps.Files == nil {
+		ps.Files = make(map[string]FileState)
+	}
+
+	return ps
+}
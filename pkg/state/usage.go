@@ -0,0 +1,135 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package state
+
+import (
+	"time"
+
+	"github.com/csfreak/minio-backup-sidecar/pkg/metrics"
+)
+
+const (
+	dayFormat     = "2006-01-02"
+	historyDays   = 30
+	averageWindow = 7
+)
+
+type Usage struct {
+	TodayBytes     int64            `json:"todayBytes"`
+	AverageBytes   int64            `json:"sevenDayAverageBytes"`
+	AverageUploads float64          `json:"sevenDayAverageUploads"`
+	Daily          map[string]int64 `json:"daily"`
+}
+
+// RecordUpload adds size to the bytes uploaded today for the configured path p.
+func RecordUpload(p string, size int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	ps := store.path(p)
+	day := time.Now().Format(dayFormat)
+	ps.Daily[day] += size
+	ps.Uploads[day]++
+	ps.LastSuccess = time.Now()
+	ps.prune()
+
+	store.dirty = true
+	store.updateMetrics(p)
+}
+
+// LastSuccess returns the time of the last successful upload for the
+// configured path p, or the zero time if it has none.
+func LastSuccess(p string) time.Time {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if ps, ok := store.Paths[p]; ok {
+		return ps.LastSuccess
+	}
+
+	return time.Time{}
+}
+
+// UploadUsage returns upload volume statistics for every path.
+func UploadUsage() map[string]Usage {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	u := make(map[string]Usage, len(store.Paths))
+	for p, ps := range store.Paths {
+		u[p] = ps.usage()
+	}
+
+	return u
+}
+
+func (ps *PathState) prune() {
+	cutoff := time.Now().AddDate(0, 0, -historyDays).Format(dayFormat)
+
+	for day := range ps.Daily {
+		if day < cutoff {
+			delete(ps.Daily, day)
+			delete(ps.Uploads, day)
+		}
+	}
+}
+
+func (ps *PathState) usage() Usage {
+	now := time.Now()
+	u := Usage{
+		TodayBytes: ps.Daily[now.Format(dayFormat)],
+		Daily:      make(map[string]int64, len(ps.Daily)),
+	}
+
+	var total, uploads int64
+
+	for i := 0; i < averageWindow; i++ {
+		day := now.AddDate(0, 0, -i).Format(dayFormat)
+		total += ps.Daily[day]
+		uploads += ps.Uploads[day]
+	}
+
+	u.AverageBytes = total / averageWindow
+	u.AverageUploads = float64(uploads) / averageWindow
+
+	for day, size := range ps.Daily {
+		u.Daily[day] = size
+	}
+
+	return u
+}
+
+func (s *Store) updateMetrics(p string) {
+	u := s.Paths[p].usage()
+	metrics.PathBytesToday.WithLabelValues(p).Set(float64(u.TodayBytes))
+	metrics.PathBytesAverage.WithLabelValues(p).Set(float64(u.AverageBytes))
+
+	if last := s.Paths[p].LastSuccess; !last.IsZero() {
+		metrics.PathLastSuccess.WithLabelValues(p).Set(float64(last.Unix()))
+	}
+}
+
+func (s *Store) refreshMetrics() {
+	for p := range s.Paths {
+		s.updateMetrics(p)
+	}
+
+	for _, d := range s.DeadLetters {
+		s.updateDeadLetterMetric(d.Path)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tracing records OpenTelemetry spans for the watch, debounce and
+// upload pipeline and exports them over OTLP when an endpoint is configured.
+// Without one, spans are not recorded.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+const instrumentation = "github.com/csfreak/minio-backup-sidecar"
+
+// Options configures trace export.
+type Options struct {
+	Endpoint    string  // OTLP/HTTP endpoint as host:port or full URL (Defaults to none, disabled)
+	Insecure    bool    // Export to host:port over plain HTTP
+	SampleRatio float64 // Fraction of traces recorded (Defaults to 1)
+	ServiceName string  // service.name of exported spans
+	PodName     string  // k8s.pod.name of exported spans
+}
+
+// Init exports spans as configured by opts. The returned function flushes
+// spans not yet exported and stops exporting.
+func Init(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, exportOptions(opts)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+		semconv.K8SPodName(opts.PodName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe trace resource: %w", err)
+	}
+
+	ratio := opts.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	klog.InfoS("exporting traces", "endpoint", opts.Endpoint, "sample-ratio", ratio)
+
+	return provider.Shutdown, nil
+}
+
+// exportOptions connects to the endpoint at its URL, or at host:port on the
+// default /v1/traces path.
+func exportOptions(opts Options) []otlptracehttp.Option {
+	if strings.Contains(opts.Endpoint, "://") {
+		return []otlptracehttp.Option{otlptracehttp.WithEndpointURL(opts.Endpoint)}
+	}
+
+	o := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		o = append(o, otlptracehttp.WithInsecure())
+	}
+
+	return o
+}
+
+// Start starts a span named name as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked starts a span named name linked to the spans that caused it,
+// such as the watch events coalesced into one upload.
+func StartLinked(ctx context.Context, name string, links []trace.Link, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithLinks(links...), trace.WithAttributes(attrs...))
+}
+
+// Fail records err on span, if any, and marks the span failed.
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
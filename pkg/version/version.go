@@ -0,0 +1,113 @@
+/*
+ * Minio Backup Sidecar
+ * Copyright 2023 Jason Ross.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+// Package version describes the build of the sidecar. Version, Commit and
+// Date are set with -ldflags "-X", and otherwise taken from the build info Go
+// embeds in the binary.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+const minioModule = "github.com/minio/minio-go/v7"
+
+var (
+	Version = "" // Semantic version of the release
+	Commit  = "" // Git commit built
+	Date    = "" // Time of the build or commit
+)
+
+// Info describes the build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+	MinioGo   string `json:"minioGo,omitempty"`
+}
+
+// Get returns the build info, falling back to what Go recorded for values
+// not set at link time.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+
+		dirty := false
+
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			case s.Key == "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+
+		if dirty && Commit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+
+		for _, dep := range bi.Deps {
+			if dep.Path == minioModule {
+				info.MinioGo = dep.Version
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+
+	return info
+}
+
+// String returns the version, commit and build date on one line.
+func (i Info) String() string {
+	s := i.Version
+
+	if i.Commit != "" {
+		s += " (" + i.Commit
+		if i.Date != "" {
+			s += ", " + i.Date
+		}
+
+		s += ")"
+	}
+
+	return s
+}
+
+// Details returns every field of i on its own line.
+func (i Info) Details() string {
+	return fmt.Sprintf("Version:    %s\nCommit:     %s\nBuild Date: %s\nGo Version: %s\nPlatform:   %s\nminio-go:   %s\n",
+		i.Version, i.Commit, i.Date, i.GoVersion, i.Platform, i.MinioGo)
+}